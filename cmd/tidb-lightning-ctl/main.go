@@ -27,6 +27,7 @@ import (
 	"github.com/pingcap/kvproto/pkg/import_sstpb"
 	"github.com/pingcap/tidb-lightning/lightning/config"
 	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
 	"github.com/pingcap/tidb-lightning/lightning/restore"
 	"github.com/satori/go.uuid"
 )
@@ -43,6 +44,7 @@ func run() error {
 		compact                                     *bool
 		mode, flagImportEngine, flagCleanupEngine   *string
 		cpRemove, cpErrIgnore, cpErrDestroy, cpDump *string
+		cpExport, cpImport                          *string
 
 		fsUsage func()
 	)
@@ -58,6 +60,8 @@ func run() error {
 		cpErrIgnore = fs.String("checkpoint-error-ignore", "", "ignore errors encoutered previously on the given table (value can be 'all' or '`db`.`table`'); may corrupt this table if used incorrectly")
 		cpErrDestroy = fs.String("checkpoint-error-destroy", "", "deletes imported data with table which has an error before (value can be 'all' or '`db`.`table`')")
 		cpDump = fs.String("checkpoint-dump", "", "dump the checkpoint information as two CSV files in the given folder")
+		cpExport = fs.String("checkpoint-export", "", "copy all checkpoints into the given file, which can be used as a checkpoint file or with -checkpoint-import")
+		cpImport = fs.String("checkpoint-import", "", "copy all checkpoints from the given checkpoint file into the configured checkpoint database")
 
 		fsUsage = fs.Usage
 	}))
@@ -69,6 +73,8 @@ func run() error {
 	if err := cfg.Adjust(); err != nil {
 		return err
 	}
+	kv.SetPDConcurrency(cfg.TiDB.PdMaxConcurrentRequests)
+	kv.SetPDRetry(cfg.TiDB.PdRetryCount, cfg.TiDB.PdRetryBackoff.Duration)
 
 	ctx := context.Background()
 
@@ -97,6 +103,12 @@ func run() error {
 	if len(*cpDump) != 0 {
 		return errors.Trace(checkpointDump(ctx, cfg, *cpDump))
 	}
+	if len(*cpExport) != 0 {
+		return errors.Trace(checkpointExport(ctx, cfg, *cpExport))
+	}
+	if len(*cpImport) != 0 {
+		return errors.Trace(checkpointImport(ctx, cfg, *cpImport))
+	}
 
 	fsUsage()
 	return nil
@@ -106,10 +118,10 @@ func compactCluster(ctx context.Context, cfg *config.Config) error {
 	return kv.ForAllStores(
 		ctx,
 		&http.Client{},
-		cfg.TiDB.PdAddr,
+		cfg.TiDB.PdURL,
 		kv.StoreStateDisconnected,
 		func(c context.Context, store *kv.Store) error {
-			return kv.Compact(c, store.Address, restore.FullLevelCompact)
+			return kv.Compact(c, store.Address, restore.FullLevelCompact, kv.GRPCDialOptions(cfg.GRPC)...)
 		},
 	)
 }
@@ -128,10 +140,10 @@ func switchMode(ctx context.Context, cfg *config.Config, mode string) error {
 	return kv.ForAllStores(
 		ctx,
 		&http.Client{},
-		cfg.TiDB.PdAddr,
+		cfg.TiDB.PdURL,
 		kv.StoreStateDisconnected,
 		func(c context.Context, store *kv.Store) error {
-			return kv.SwitchMode(c, store.Address, m)
+			return kv.SwitchMode(c, store.Address, m, kv.GRPCDialOptions(cfg.GRPC)...)
 		},
 	)
 }
@@ -169,7 +181,7 @@ func checkpointErrorDestroy(ctx context.Context, cfg *config.Config, tableName s
 	}
 	defer target.Close()
 
-	importer, err := kv.NewImporter(ctx, cfg.TikvImporter.Addr, cfg.TiDB.PdAddr)
+	importer, err := kv.NewImporter(ctx, cfg.TikvImporter.Addr, cfg.TiDB.PdAddr, cfg.TikvImporter.UploadChunkSize, kv.GRPCDialOptions(cfg.GRPC)...)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -272,7 +284,7 @@ func unsafeCloseEngine(ctx context.Context, importer kv.Backend, engine string)
 }
 
 func importEngine(ctx context.Context, cfg *config.Config, engine string) error {
-	importer, err := kv.NewImporter(ctx, cfg.TikvImporter.Addr, cfg.TiDB.PdAddr)
+	importer, err := kv.NewImporter(ctx, cfg.TikvImporter.Addr, cfg.TiDB.PdAddr, cfg.TikvImporter.UploadChunkSize, kv.GRPCDialOptions(cfg.GRPC)...)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -286,7 +298,7 @@ func importEngine(ctx context.Context, cfg *config.Config, engine string) error
 }
 
 func cleanupEngine(ctx context.Context, cfg *config.Config, engine string) error {
-	importer, err := kv.NewImporter(ctx, cfg.TikvImporter.Addr, cfg.TiDB.PdAddr)
+	importer, err := kv.NewImporter(ctx, cfg.TikvImporter.Addr, cfg.TiDB.PdAddr, cfg.TikvImporter.UploadChunkSize, kv.GRPCDialOptions(cfg.GRPC)...)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -298,3 +310,33 @@ func cleanupEngine(ctx context.Context, cfg *config.Config, engine string) error
 
 	return errors.Trace(ce.Cleanup(ctx))
 }
+
+func checkpointExport(ctx context.Context, cfg *config.Config, exportPath string) error {
+	cpdb, err := restore.OpenCheckpointsDB(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer cpdb.Close()
+
+	fileCpdb := checkpoints.NewFileCheckpointsDB(exportPath)
+	if err := checkpoints.TransferCheckpoints(ctx, cpdb, fileCpdb); err != nil {
+		fileCpdb.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(fileCpdb.Close())
+}
+
+func checkpointImport(ctx context.Context, cfg *config.Config, importPath string) error {
+	if _, err := os.Stat(importPath); err != nil {
+		return errors.Trace(err)
+	}
+
+	cpdb, err := restore.OpenCheckpointsDB(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer cpdb.Close()
+
+	fileCpdb := checkpoints.NewFileCheckpointsDB(importPath)
+	return errors.Trace(checkpoints.TransferCheckpoints(ctx, fileCpdb, cpdb))
+}
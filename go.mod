@@ -25,6 +25,7 @@ require (
 	go.uber.org/zap v1.10.0
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
 	golang.org/x/text v0.3.2
+	golang.org/x/time v0.0.0-20180412165947-fbb02b2291d2
 	google.golang.org/grpc v1.21.4
 	gopkg.in/stretchr/testify.v1 v1.4.0 // indirect
 	modernc.org/mathutil v1.0.0
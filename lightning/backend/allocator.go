@@ -19,7 +19,8 @@ import (
 	"github.com/pingcap/tidb/meta/autoid"
 )
 
-// PanickingAllocator is an ID allocator which panics on all operations except Rebase
+// PanickingAllocator is an ID allocator which panics on all operations except
+// Rebase, Alloc and Base
 type PanickingAllocator struct {
 	autoid.Allocator
 	base int64
@@ -45,6 +46,12 @@ func (alloc *PanickingAllocator) Rebase(tableID, newBase int64, allocIDs bool) e
 	return nil
 }
 
+// Alloc implements the autoid.Allocator interface. The IDs are allocated after
+// the largest one seen by Rebase or Alloc.
+func (alloc *PanickingAllocator) Alloc(tableID int64) (int64, error) {
+	return atomic.AddInt64(&alloc.base, 1), nil
+}
+
 // Base implements the autoid.Allocator interface
 func (alloc *PanickingAllocator) Base() int64 {
 	return atomic.LoadInt64(&alloc.base)
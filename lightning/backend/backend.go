@@ -16,11 +16,11 @@ package backend
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
-	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/tidb/table"
 	"github.com/pingcap/tidb/types"
 	uuid "github.com/satori/go.uuid"
@@ -36,6 +36,32 @@ const (
 	maxRetryTimes = 3 // tikv-importer has done retry internally. so we don't retry many times.
 )
 
+// openedEngines tracks the engines opened by this process, so that
+// `OpenEnginesGauge` is decreased exactly once whichever way the engine is
+// closed, and never for engines opened by a previous run.
+var openedEngines = struct {
+	sync.Mutex
+	uuids map[uuid.UUID]struct{}
+}{uuids: make(map[uuid.UUID]struct{})}
+
+func markEngineOpened(engineUUID uuid.UUID) {
+	openedEngines.Lock()
+	defer openedEngines.Unlock()
+	if _, ok := openedEngines.uuids[engineUUID]; !ok {
+		openedEngines.uuids[engineUUID] = struct{}{}
+		metric.OpenEnginesGauge.Inc()
+	}
+}
+
+func markEngineClosed(engineUUID uuid.UUID) {
+	openedEngines.Lock()
+	defer openedEngines.Unlock()
+	if _, ok := openedEngines.uuids[engineUUID]; ok {
+		delete(openedEngines.uuids, engineUUID)
+		metric.OpenEnginesGauge.Dec()
+	}
+}
+
 /*
 
 Usual workflow:
@@ -98,7 +124,7 @@ type AbstractBackend interface {
 	ShouldPostProcess() bool
 
 	// NewEncoder creates an encoder of a TiDB table.
-	NewEncoder(tbl table.Table, sqlMode mysql.SQLMode, timestamp int64) Encoder
+	NewEncoder(tbl table.Table, options *SessionOptions) Encoder
 
 	OpenEngine(ctx context.Context, engineUUID uuid.UUID) error
 
@@ -163,14 +189,18 @@ func (be Backend) MakeEmptyRows() Rows {
 	return be.abstract.MakeEmptyRows()
 }
 
-func (be Backend) NewEncoder(tbl table.Table, sqlMode mysql.SQLMode, timestamp int64) Encoder {
-	return be.abstract.NewEncoder(tbl, sqlMode, timestamp)
+func (be Backend) NewEncoder(tbl table.Table, options *SessionOptions) Encoder {
+	return be.abstract.NewEncoder(tbl, options)
 }
 
 func (be Backend) ShouldPostProcess() bool {
 	return be.abstract.ShouldPostProcess()
 }
 
+func (be Backend) RetryImportDelay() time.Duration {
+	return be.abstract.RetryImportDelay()
+}
+
 // OpenEngine opens an engine with the given table name and engine ID.
 func (be Backend) OpenEngine(ctx context.Context, tableName string, engineID int32) (*OpenedEngine, error) {
 	tag := makeTag(tableName, engineID)
@@ -183,6 +213,7 @@ func (be Backend) OpenEngine(ctx context.Context, tableName string, engineID int
 
 	openCounter := metric.ImporterEngineCounter.WithLabelValues("open")
 	openCounter.Inc()
+	markEngineOpened(engineUUID)
 
 	logger.Info("open engine")
 
@@ -269,6 +300,7 @@ func (en engine) unsafeClose(ctx context.Context) (*ClosedEngine, error) {
 	if err != nil {
 		return nil, err
 	}
+	markEngineClosed(en.uuid)
 	return &ClosedEngine{engine: en}, nil
 }
 
@@ -11,6 +11,7 @@ import (
 	uuid "github.com/satori/go.uuid"
 
 	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/metric"
 	"github.com/pingcap/tidb-lightning/mock"
 )
 
@@ -110,6 +111,30 @@ func (s *backendSuite) TestUnsafeCloseEngineWithUUID(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *backendSuite) TestOpenEnginesGauge(c *C) {
+	s.setUpTest(c)
+	defer s.tearDownTest()
+
+	ctx := context.Background()
+	s.mockBackend.EXPECT().OpenEngine(ctx, gomock.Any()).Return(nil)
+	s.mockBackend.EXPECT().CloseEngine(ctx, gomock.Any()).Return(nil).Times(2)
+
+	initial := metric.ReadGauge(metric.OpenEnginesGauge)
+	_, err := s.backend.OpenEngine(ctx, "`db`.`table`", -1)
+	c.Assert(err, IsNil)
+	c.Assert(metric.ReadGauge(metric.OpenEnginesGauge), Equals, initial+1)
+
+	// an engine opened by this process is counted as closed whichever way it is closed.
+	_, err = s.backend.UnsafeCloseEngine(ctx, "`db`.`table`", -1)
+	c.Assert(err, IsNil)
+	c.Assert(metric.ReadGauge(metric.OpenEnginesGauge), Equals, initial)
+
+	// an engine opened by a previous run was never counted.
+	_, err = s.backend.UnsafeCloseEngine(ctx, "`db`.`table`", 0)
+	c.Assert(err, IsNil)
+	c.Assert(metric.ReadGauge(metric.OpenEnginesGauge), Equals, initial)
+}
+
 func (s *backendSuite) TestWriteEngine(c *C) {
 	s.setUpTest(c)
 	defer s.tearDownTest()
@@ -321,7 +346,8 @@ func (s *backendSuite) TestNewEncoder(c *C) {
 	defer s.tearDownTest()
 
 	encoder := mock.NewMockEncoder(s.controller)
-	s.mockBackend.EXPECT().NewEncoder(nil, mysql.ModeANSIQuotes, int64(1234567890)).Return(encoder)
+	options := &kv.SessionOptions{SQLMode: mysql.ModeANSIQuotes, Timestamp: 1234567890}
+	s.mockBackend.EXPECT().NewEncoder(nil, options).Return(encoder)
 
-	c.Assert(s.mockBackend.NewEncoder(nil, mysql.ModeANSIQuotes, 1234567890), Equals, encoder)
+	c.Assert(s.mockBackend.NewEncoder(nil, options), Equals, encoder)
 }
@@ -20,39 +20,48 @@ import (
 
 	"github.com/pingcap/errors"
 	kv "github.com/pingcap/kvproto/pkg/import_kvpb"
-	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/tidb/table"
 	uuid "github.com/satori/go.uuid"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 
 	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/metric"
 )
 
 const (
 	defaultRetryBackoffTime = time.Second * 3
+	defaultMaxChunkSize     = 31 << 10
 )
 
 // importer represents a gRPC connection to tikv-importer. This type is
 // goroutine safe: you can share this instance and execute any method anywhere.
 type importer struct {
-	conn   *grpc.ClientConn
-	cli    kv.ImportKVClient
-	pdAddr string
+	conn         *grpc.ClientConn
+	cli          kv.ImportKVClient
+	pdAddr       string
+	maxChunkSize int
 }
 
 // NewImporter creates a new connection to tikv-importer. A single connection
-// per tidb-lightning instance is enough.
-func NewImporter(ctx context.Context, importServerAddr string, pdAddr string) (Backend, error) {
-	conn, err := grpc.DialContext(ctx, importServerAddr, grpc.WithInsecure())
+// per tidb-lightning instance is enough. The KV pairs are streamed to the
+// importer in batches of at most `uploadChunkSize` bytes.
+//
+// The connection is held for the whole task, so it is only counted in the
+// open file descriptors metric, but not taken from `lightning.max-open-files`.
+func NewImporter(ctx context.Context, importServerAddr string, pdAddr string, uploadChunkSize int64, opts ...grpc.DialOption) (Backend, error) {
+	opts = append([]grpc.DialOption{grpc.WithInsecure()}, opts...)
+	conn, err := grpc.DialContext(ctx, importServerAddr, opts...)
 	if err != nil {
 		return MakeBackend(nil), errors.Trace(err)
 	}
+	metric.OpenFileDescriptorsGauge.Inc()
 
 	return MakeBackend(&importer{
-		conn:   conn,
-		cli:    kv.NewImportKVClient(conn),
-		pdAddr: pdAddr,
+		conn:         conn,
+		cli:          kv.NewImportKVClient(conn),
+		pdAddr:       pdAddr,
+		maxChunkSize: int(uploadChunkSize),
 	}), nil
 }
 
@@ -61,9 +70,10 @@ func NewImporter(ctx context.Context, importServerAddr string, pdAddr string) (B
 // outside of tests.
 func NewMockImporter(cli kv.ImportKVClient, pdAddr string) Backend {
 	return MakeBackend(&importer{
-		conn:   nil,
-		cli:    cli,
-		pdAddr: pdAddr,
+		conn:         nil,
+		cli:          cli,
+		pdAddr:       pdAddr,
+		maxChunkSize: defaultMaxChunkSize,
 	})
 }
 
@@ -73,6 +83,7 @@ func (importer *importer) Close() {
 		if err := importer.conn.Close(); err != nil {
 			log.L().Warn("close importer gRPC connection failed", zap.Error(err))
 		}
+		metric.OpenFileDescriptorsGauge.Dec()
 	}
 }
 
@@ -80,9 +91,8 @@ func (*importer) RetryImportDelay() time.Duration {
 	return defaultRetryBackoffTime
 }
 
-func (*importer) MaxChunkSize() int {
-	// 31 MB. hardcoded by importer, so do we
-	return 31 << 10
+func (importer *importer) MaxChunkSize() int {
+	return importer.maxChunkSize
 }
 
 func (*importer) ShouldPostProcess() bool {
@@ -157,6 +167,8 @@ func (importer *importer) WriteRows(
 		return nil
 	}
 
+	// the round trip lasts until importer acknowledges the whole batch.
+	start := time.Now()
 	wstream, err := importer.cli.WriteEngine(ctx)
 	if err != nil {
 		return errors.Trace(err)
@@ -173,6 +185,9 @@ func (importer *importer) WriteRows(
 				logger.Warn("close write stream failed", log.ShortError(closeErr))
 			}
 		}
+		if finalErr == nil {
+			metric.UploadChunkSecondsHistogram.Observe(time.Since(start).Seconds())
+		}
 	}()
 
 	// Bind uuid for this write request
@@ -216,6 +231,6 @@ func (*importer) MakeEmptyRows() Rows {
 	return kvPairs(nil)
 }
 
-func (*importer) NewEncoder(tbl table.Table, sqlMode mysql.SQLMode, timestamp int64) Encoder {
-	return NewTableKVEncoder(tbl, sqlMode, timestamp)
+func (*importer) NewEncoder(tbl table.Table, options *SessionOptions) Encoder {
+	return NewTableKVEncoder(tbl, options)
 }
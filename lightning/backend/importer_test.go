@@ -2,6 +2,7 @@ package backend_test
 
 import (
 	"context"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/pingcap/check"
@@ -11,6 +12,7 @@ import (
 	uuid "github.com/satori/go.uuid"
 
 	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/metric"
 	"github.com/pingcap/tidb-lightning/mock"
 )
 
@@ -88,11 +90,17 @@ func (s *importerSuite) TestWriteRows(c *C) {
 		After(headSendCall)
 	s.mockWriter.EXPECT().
 		CloseAndRecv().
-		Return(nil, nil).
+		DoAndReturn(func() (*import_kvpb.WriteEngineResponse, error) {
+			time.Sleep(10 * time.Millisecond)
+			return nil, nil
+		}).
 		After(batchSendCall)
 
+	// the round trip includes waiting for the acknowledgement.
+	before := metric.ReadHistogramSum(metric.UploadChunkSecondsHistogram)
 	err := s.engine.WriteRows(s.ctx, nil, s.kvPairs)
 	c.Assert(err, IsNil)
+	c.Assert(metric.ReadHistogramSum(metric.UploadChunkSecondsHistogram)-before >= 0.01, IsTrue)
 }
 
 func (s *importerSuite) TestWriteHeadSendFailed(c *C) {
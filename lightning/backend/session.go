@@ -15,6 +15,7 @@ package backend
 
 import (
 	"strconv"
+	"time"
 
 	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/tidb/kv"
@@ -57,7 +58,31 @@ type session struct {
 	vars *variable.SessionVars
 }
 
-func newSession(sqlMode mysql.SQLMode, timestamp int64) *session {
+// SessionOptions is the initial configuration of the session used by the
+// encoders.
+type SessionOptions struct {
+	SQLMode   mysql.SQLMode
+	Timestamp int64
+	// PreserveAutoIncrement keeps the auto-increment values found in the source
+	// as-is, and allocates a new value from the table allocator for the rows
+	// where they are NULL or missing, instead of treating it as a bad NULL.
+	PreserveAutoIncrement bool
+	// OutOfRange is the action on integer values exceeding the range of the
+	// column, one of "error", "clamp" or "null". If empty, follows `SQLMode`.
+	OutOfRange string
+	// EnumSetFormat is how the string values of ENUM and SET columns are
+	// resolved, one of "auto", "label" or "index". If empty, same as "auto".
+	EnumSetFormat string
+	// TimeZone is the time zone evaluating the CURRENT_TIMESTAMP defaults and
+	// interpreting the TIMESTAMP values. If nil, the local time zone is used.
+	TimeZone *time.Location
+	// MaxRowSize is the maximum total size of the KV pairs encoded from a row.
+	// If positive, encoding a larger row fails with ErrRowTooLarge.
+	MaxRowSize int64
+}
+
+func newSession(options *SessionOptions) *session {
+	sqlMode := options.SQLMode
 	vars := variable.NewSessionVars()
 	vars.LightningMode = true
 	vars.SkipUTF8Check = true
@@ -67,8 +92,11 @@ func newSession(sqlMode mysql.SQLMode, timestamp int64) *session {
 	vars.StmtCtx.OverflowAsWarning = !sqlMode.HasStrictMode()
 	vars.StmtCtx.AllowInvalidDate = sqlMode.HasAllowInvalidDatesMode()
 	vars.StmtCtx.IgnoreZeroInDate = !sqlMode.HasStrictMode() || sqlMode.HasAllowInvalidDatesMode()
+	if options.TimeZone != nil {
+		vars.TimeZone = options.TimeZone
+	}
 	vars.StmtCtx.TimeZone = vars.Location()
-	vars.SetSystemVar("timestamp", strconv.FormatInt(timestamp, 10))
+	vars.SetSystemVar("timestamp", strconv.FormatInt(options.Timestamp, 10))
 	return &session{
 		txn:  transaction{},
 		vars: vars,
@@ -14,16 +14,26 @@
 package backend
 
 import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
 	"github.com/pingcap/errors"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/expression"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
 	"github.com/pingcap/tidb/table"
 	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/types/json"
+	"github.com/pingcap/tidb/util/chunk"
 	kvec "github.com/pingcap/tidb/util/kvencoder"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/pingcap/tidb-lightning/lightning/config"
 	"github.com/pingcap/tidb-lightning/lightning/log"
 	"github.com/pingcap/tidb-lightning/lightning/metric"
 	"github.com/pingcap/tidb-lightning/lightning/verification"
@@ -31,23 +41,109 @@ import (
 
 var extraHandleColumnInfo = model.NewExtraHandleColInfo()
 
+// ErrRowTooLarge is the cause of the encoding errors of the rows exceeding
+// SessionOptions.MaxRowSize.
+var ErrRowTooLarge = errors.New("row too large")
+
 type tableKVEncoder struct {
-	tbl         table.Table
-	se          *session
-	recordCache []types.Datum
+	tbl                   table.Table
+	se                    *session
+	recordCache           []types.Datum
+	preserveAutoIncrement bool
+	outOfRange            string
+	outOfRangeRows        int64
+	enumSetFormat         string
+	maxRowSize            int64
+	// the generated columns in the order of evaluation.
+	genCols    []generatedColumn
+	genColsErr error
 }
 
-func NewTableKVEncoder(tbl table.Table, sqlMode mysql.SQLMode, timestamp int64) Encoder {
+func NewTableKVEncoder(tbl table.Table, options *SessionOptions) Encoder {
 	metric.KvEncoderCounter.WithLabelValues("open").Inc()
 
+	se := newSession(options)
+	genCols, err := collectGeneratedColumns(se, tbl.Meta(), tbl.Cols())
 	return &tableKVEncoder{
-		tbl: tbl,
-		se:  newSession(sqlMode, timestamp),
+		tbl:                   tbl,
+		se:                    se,
+		preserveAutoIncrement: options.PreserveAutoIncrement,
+		outOfRange:            options.OutOfRange,
+		enumSetFormat:         options.EnumSetFormat,
+		maxRowSize:            options.MaxRowSize,
+		genCols:               genCols,
+		genColsErr:            err,
 	}
 }
 
+// generatedColumn is a generated column of the table, with its expression
+// compiled for evaluating over the record of a row.
+type generatedColumn struct {
+	index int
+	expr  expression.Expression
+}
+
+// collectGeneratedColumns compiles the expressions of the generated columns,
+// sorted such that every column comes after the generated columns it depends
+// on, since a generated column may refer to another one defined after it.
+func collectGeneratedColumns(se *session, meta *model.TableInfo, cols []*table.Column) ([]generatedColumn, error) {
+	indices := make(map[string]int, len(cols))
+	for i, col := range cols {
+		indices[col.Name.L] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := make([]int, len(cols))
+	var genCols []generatedColumn
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch states[i] {
+		case visited:
+			return nil
+		case visiting:
+			return errors.Errorf("circular dependency of generated column %s", cols[i].Name.O)
+		}
+		states[i] = visiting
+		for dep := range cols[i].Dependences {
+			if j, ok := indices[dep]; ok && cols[j].IsGenerated() {
+				if err := visit(j); err != nil {
+					return err
+				}
+			}
+		}
+		states[i] = visited
+
+		expr, err := expression.RewriteSimpleExprWithTableInfo(se, meta, cols[i].GeneratedExpr)
+		if err != nil {
+			return errors.Annotatef(err, "invalid expression of generated column %s", cols[i].Name.O)
+		}
+		genCols = append(genCols, generatedColumn{index: i, expr: expr})
+		return nil
+	}
+
+	for i, col := range cols {
+		if col.IsGenerated() {
+			if err := visit(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return genCols, nil
+}
+
 func (kvcodec *tableKVEncoder) Close() {
 	metric.KvEncoderCounter.WithLabelValues("closed").Inc()
+	if kvcodec.outOfRangeRows > 0 {
+		log.L().Warn("replaced out-of-range integer values",
+			zap.String("table", kvcodec.tbl.Meta().Name.O),
+			zap.String("action", kvcodec.outOfRange),
+			zap.Int64("rows", kvcodec.outOfRangeRows),
+		)
+	}
 }
 
 type rowArrayMarshaler []types.Datum
@@ -150,6 +246,9 @@ func (kvcodec *tableKVEncoder) Encode(
 	rowID int64,
 	columnPermutation []int,
 ) (Row, error) {
+	if kvcodec.genColsErr != nil {
+		return nil, kvcodec.genColsErr
+	}
 	cols := kvcodec.tbl.Cols()
 
 	var value types.Datum
@@ -162,17 +261,41 @@ func (kvcodec *tableKVEncoder) Encode(
 		record = make([]types.Datum, 0, len(cols)+1)
 	}
 
+	outOfRange := false
 	for i, col := range cols {
+		if col.IsGenerated() {
+			// evaluated below, after all other columns are known.
+			record = append(record, types.Datum{})
+			continue
+		}
 		j := columnPermutation[i]
 		isAutoIncCol := mysql.HasAutoIncrementFlag(col.Flag)
+		if isAutoIncCol && kvcodec.preserveAutoIncrement && j >= 0 && j < len(row) && row[j].IsNull() {
+			// the source leaves the value to be allocated, like what an
+			// `INSERT` with an explicit NULL does.
+			j = -1
+		}
 		if j >= 0 && j < len(row) {
-			value, err = table.CastValue(kvcodec.se, row[j], col.ToInfo())
+			err = kvcodec.checkDecimalLiteral(row[j], col.ToInfo())
+			if err == nil {
+				var adjusted bool
+				value, adjusted, err = kvcodec.castValue(row[j], col.ToInfo())
+				outOfRange = outOfRange || adjusted
+			}
 			if err == nil {
 				value, err = col.HandleBadNull(value, kvcodec.se.vars.StmtCtx)
 			}
 		} else if isAutoIncCol {
+			autoID := rowID
+			if kvcodec.preserveAutoIncrement {
+				// the row ordinal may collide with the explicit values in the
+				// source, so allocate after the largest one seen so far.
+				autoID, err = kvcodec.tbl.Allocator(kvcodec.se).Alloc(kvcodec.tbl.Meta().ID)
+			}
 			// we still need a conversion, e.g. to catch overflow with a TINYINT column.
-			value, err = table.CastValue(kvcodec.se, types.NewIntDatum(rowID), col.ToInfo())
+			if err == nil {
+				value, err = table.CastValue(kvcodec.se, types.NewIntDatum(autoID), col.ToInfo())
+			}
 		} else {
 			value, err = table.GetColDefaultValue(kvcodec.se, col.ToInfo())
 		}
@@ -185,6 +308,30 @@ func (kvcodec *tableKVEncoder) Encode(
 		}
 	}
 
+	if len(kvcodec.genCols) > 0 {
+		mutRow := chunk.MutRowFromDatums(record)
+		for _, gc := range kvcodec.genCols {
+			col := cols[gc.index]
+			value, err = gc.expr.Eval(mutRow.ToRow())
+			if err == nil {
+				value, err = table.CastValue(kvcodec.se, value, col.ToInfo())
+			}
+			if err == nil {
+				value, err = col.HandleBadNull(value, kvcodec.se.vars.StmtCtx)
+			}
+			if err != nil {
+				logger.Error("evaluate generated column failed",
+					zap.String("colName", col.Name.O),
+					zap.Stringer("colType", &col.FieldType),
+					log.ShortError(err),
+				)
+				return nil, errors.Annotatef(err, "failed to evaluate generated column `%s`", col.Name.O)
+			}
+			record[gc.index] = value
+			mutRow.SetDatum(gc.index, value)
+		}
+	}
+
 	if !kvcodec.tbl.Meta().PKIsHandle {
 		j := columnPermutation[len(cols)]
 		if j >= 0 && j < len(row) {
@@ -199,6 +346,11 @@ func (kvcodec *tableKVEncoder) Encode(
 		kvcodec.tbl.RebaseAutoID(kvcodec.se, value.GetInt64(), false)
 	}
 
+	if outOfRange {
+		kvcodec.outOfRangeRows++
+		metric.OutOfRangeRowsCounter.WithLabelValues(kvcodec.outOfRange).Inc()
+	}
+
 	_, err = kvcodec.tbl.AddRecord(kvcodec.se, record)
 	if err != nil {
 		logger.Error("kv encode failed",
@@ -212,9 +364,287 @@ func (kvcodec *tableKVEncoder) Encode(
 	pairs := kvcodec.se.takeKvPairs()
 	kvcodec.recordCache = record[:0]
 
+	if kvcodec.maxRowSize > 0 {
+		var size int64
+		for _, pair := range pairs {
+			size += int64(len(pair.Key) + len(pair.Val))
+		}
+		if size > kvcodec.maxRowSize {
+			return nil, errors.Annotatef(ErrRowTooLarge, "%s is encoded into %d bytes, exceeding the max row size %d",
+				kvcodec.describeRow(record), size, kvcodec.maxRowSize)
+		}
+	}
+
 	return kvPairs(pairs), nil
 }
 
+// describeRow identifies the encoded record by the values of its primary key,
+// or by the hidden _tidb_rowid appended after the columns if the table has no
+// primary key.
+func (kvcodec *tableKVEncoder) describeRow(record []types.Datum) string {
+	meta := kvcodec.tbl.Meta()
+	var pkCols []*model.ColumnInfo
+	if meta.PKIsHandle {
+		if col := meta.GetPkColInfo(); col != nil {
+			pkCols = append(pkCols, col)
+		}
+	} else {
+		for _, idx := range meta.Indices {
+			if idx.Primary {
+				for _, idxCol := range idx.Columns {
+					pkCols = append(pkCols, meta.Columns[idxCol.Offset])
+				}
+				break
+			}
+		}
+	}
+	if len(pkCols) == 0 {
+		return fmt.Sprintf("the row with _tidb_rowid %d", record[len(record)-1].GetInt64())
+	}
+
+	names := make([]string, 0, len(pkCols))
+	values := make([]string, 0, len(pkCols))
+	for _, col := range pkCols {
+		names = append(names, "`"+col.Name.O+"`")
+		value, err := record[col.Offset].ToString()
+		if err != nil {
+			value = "?"
+		}
+		values = append(values, value)
+	}
+	return fmt.Sprintf("the row with primary key (%s) = (%s)", strings.Join(names, ", "), strings.Join(values, ", "))
+}
+
+// castValue casts the value for the column. If the column is an integer and
+// the value is out of its range, the value is handled according to the
+// configured action instead of the SQL mode, and `adjusted` is set when the
+// value was replaced.
+func (kvcodec *tableKVEncoder) castValue(value types.Datum, col *model.ColumnInfo) (casted types.Datum, adjusted bool, err error) {
+	if col.Tp == mysql.TypeEnum || col.Tp == mysql.TypeSet {
+		casted, err = kvcodec.castEnumSet(value, col)
+		return
+	}
+	if col.Tp == mysql.TypeJSON {
+		casted, err = castJSON(kvcodec.se, value, col)
+		return
+	}
+	if len(kvcodec.outOfRange) == 0 || !isIntegerType(col.Tp) {
+		casted, err = table.CastValue(kvcodec.se, value, col)
+		return
+	}
+
+	// in non-strict mode the overflow is only recorded as a warning, so we
+	// need a clean list to tell if it happened during this cast.
+	sc := kvcodec.se.vars.StmtCtx
+	sc.SetWarnings(nil)
+	casted, err = table.CastValue(kvcodec.se, value, col)
+	overflowErr := err
+	if err == nil {
+		overflowErr = overflowWarning(sc)
+	}
+	if !types.ErrOverflow.Equal(overflowErr) {
+		return
+	}
+
+	switch kvcodec.outOfRange {
+	case config.OutOfRangeClamp:
+		// the value returned with the overflow error is not always clamped
+		// (e.g. for strings cast to UNSIGNED), so compute the bound ourselves.
+		return clampInteger(value, col), true, nil
+	case config.OutOfRangeNull:
+		return types.Datum{}, true, nil
+	default:
+		return casted, false, overflowErr
+	}
+}
+
+func isIntegerType(tp byte) bool {
+	switch tp {
+	case mysql.TypeTiny, mysql.TypeShort, mysql.TypeInt24, mysql.TypeLong, mysql.TypeLonglong:
+		return true
+	default:
+		return false
+	}
+}
+
+// clampInteger returns the minimum or maximum value of the integer column,
+// depending on the sign of the out-of-range value.
+func clampInteger(value types.Datum, col *model.ColumnInfo) types.Datum {
+	var negative bool
+	switch value.Kind() {
+	case types.KindInt64:
+		negative = value.GetInt64() < 0
+	case types.KindFloat32, types.KindFloat64:
+		negative = value.GetFloat64() < 0
+	case types.KindMysqlDecimal:
+		negative = value.GetMysqlDecimal().IsNegative()
+	case types.KindString, types.KindBytes:
+		negative = strings.HasPrefix(strings.TrimSpace(value.GetString()), "-")
+	}
+
+	switch {
+	case mysql.HasUnsignedFlag(col.Flag) && negative:
+		return types.NewUintDatum(0)
+	case mysql.HasUnsignedFlag(col.Flag):
+		return types.NewUintDatum(types.IntergerUnsignedUpperBound(col.Tp))
+	case negative:
+		return types.NewIntDatum(types.IntergerSignedLowerBound(col.Tp))
+	default:
+		return types.NewIntDatum(types.IntergerSignedUpperBound(col.Tp))
+	}
+}
+
+func overflowWarning(sc *stmtctx.StatementContext) error {
+	if sc.WarningCount() == 0 {
+		return nil
+	}
+	for _, warn := range sc.GetWarnings() {
+		if types.ErrOverflow.Equal(warn.Err) {
+			return warn.Err
+		}
+	}
+	return nil
+}
+
+// castEnumSet casts the value for an ENUM or SET column. String values are
+// resolved according to the configured format:
+//
+//   - "label" only accepts the declared element names (a comma-separated list
+//     for SET), even if the string looks like a number.
+//   - "index" only accepts the numeric index (the bit mask for SET).
+//   - "auto" tries the label first, then the index, like TiDB does.
+//
+// An unresolved value is a truncation error, which is reported or turned into
+// the empty value following the SQL mode.
+func (kvcodec *tableKVEncoder) castEnumSet(value types.Datum, col *model.ColumnInfo) (types.Datum, error) {
+	switch value.Kind() {
+	case types.KindString, types.KindBytes:
+	default:
+		return table.CastValue(kvcodec.se, value, col)
+	}
+
+	str := value.GetString()
+	switch kvcodec.enumSetFormat {
+	case config.EnumSetFormatLabel:
+		if col.Tp == mysql.TypeEnum {
+			if e, ok := parseEnumLabel(col.Elems, str); ok {
+				return types.NewMysqlEnumDatum(e), nil
+			}
+		} else if s, ok := parseSetLabels(col.Elems, str); ok {
+			var d types.Datum
+			d.SetMysqlSet(s)
+			return d, nil
+		}
+	case config.EnumSetFormatIndex:
+		if num, err := strconv.ParseUint(strings.TrimSpace(str), 10, 64); err == nil {
+			return table.CastValue(kvcodec.se, types.NewUintDatum(num), col)
+		}
+	default:
+		return table.CastValue(kvcodec.se, value, col)
+	}
+
+	tp := "ENUM"
+	if col.Tp == mysql.TypeSet {
+		tp = "SET"
+	}
+	err := types.ErrTruncatedWrongVal.GenWithStackByArgs(tp, str)
+	if err = kvcodec.se.vars.StmtCtx.HandleTruncate(err); err != nil {
+		return types.Datum{}, err
+	}
+	if col.Tp == mysql.TypeEnum {
+		return types.NewMysqlEnumDatum(types.Enum{}), nil
+	}
+	var d types.Datum
+	d.SetMysqlSet(types.Set{})
+	return d, nil
+}
+
+// castJSON parses the string value for a JSON column into the binary JSON
+// format. Invalid JSON text is always an error regardless of the SQL mode, like
+// MySQL does, instead of silently becoming NULL in non-strict mode.
+func castJSON(se *session, value types.Datum, col *model.ColumnInfo) (types.Datum, error) {
+	switch value.Kind() {
+	case types.KindString, types.KindBytes:
+	default:
+		return table.CastValue(se, value, col)
+	}
+
+	j, err := json.ParseBinaryFromString(value.GetString())
+	if err != nil {
+		return types.Datum{}, errors.Trace(err)
+	}
+	var casted types.Datum
+	casted.SetMysqlJSON(j)
+	return casted, nil
+}
+
+// parseEnumLabel finds the ENUM element by its name, case-insensitively.
+func parseEnumLabel(elems []string, name string) (types.Enum, bool) {
+	for i, n := range elems {
+		if strings.EqualFold(n, name) {
+			return types.Enum{Name: n, Value: uint64(i) + 1}, true
+		}
+	}
+	return types.Enum{}, false
+}
+
+// parseSetLabels finds the SET elements by a comma-separated list of names,
+// case-insensitively. The empty string is the empty set.
+func parseSetLabels(elems []string, names string) (types.Set, bool) {
+	if len(names) == 0 {
+		return types.Set{}, true
+	}
+
+	var value uint64
+	for _, name := range strings.Split(names, ",") {
+		found := false
+		for i, n := range elems {
+			if strings.EqualFold(n, name) {
+				value |= 1 << uint64(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return types.Set{}, false
+		}
+	}
+
+	// rebuild the name from the element list to get the canonical order.
+	items := make([]string, 0, len(elems))
+	for i, n := range elems {
+		if value&(1<<uint64(i)) != 0 {
+			items = append(items, n)
+		}
+	}
+	return types.Set{Name: strings.Join(items, ","), Value: value}, true
+}
+
+// decimalLiteralRegexp matches a complete decimal number, optionally in
+// scientific notation.
+var decimalLiteralRegexp = regexp.MustCompile(`^\s*[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?\s*$`)
+
+// checkDecimalLiteral rejects strings which are not valid numbers before they
+// are casted into a DECIMAL column. The cast parses the digits directly without
+// losing precision, but silently ignores any trailing garbage (e.g. "12.3.4"
+// becomes 12.3), which should be reported as truncation instead.
+func (kvcodec *tableKVEncoder) checkDecimalLiteral(value types.Datum, col *model.ColumnInfo) error {
+	if col.Tp != mysql.TypeNewDecimal {
+		return nil
+	}
+	switch value.Kind() {
+	case types.KindString, types.KindBytes:
+	default:
+		return nil
+	}
+	literal := value.GetString()
+	if decimalLiteralRegexp.MatchString(literal) {
+		return nil
+	}
+	err := types.ErrTruncatedWrongVal.GenWithStackByArgs("DECIMAL", literal)
+	return kvcodec.se.vars.StmtCtx.HandleTruncate(err)
+}
+
 func (kvs kvPairs) ClassifyAndAppend(
 	data *Rows,
 	dataChecksum *verification.KVChecksum,
@@ -15,6 +15,8 @@ package backend
 
 import (
 	"errors"
+	"strings"
+	"time"
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/parser/model"
@@ -22,11 +24,14 @@ import (
 	"github.com/pingcap/tidb/sessionctx"
 	"github.com/pingcap/tidb/table"
 	"github.com/pingcap/tidb/table/tables"
+	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/types/json"
 	kvenc "github.com/pingcap/tidb/util/kvencoder"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/pingcap/tidb-lightning/lightning/config"
 	"github.com/pingcap/tidb-lightning/lightning/log"
 	"github.com/pingcap/tidb-lightning/lightning/verification"
 )
@@ -76,7 +81,7 @@ func (s *kvSuite) TestEncode(c *C) {
 	}
 
 	// Strict mode
-	strictMode := NewTableKVEncoder(tbl, mysql.ModeStrictAllTables, 1234567890)
+	strictMode := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeStrictAllTables, Timestamp: 1234567890})
 	pairs, err := strictMode.Encode(logger, rows, 1, []int{0, 1})
 	c.Assert(err, ErrorMatches, "failed to cast `10000000` as tinyint\\(4\\) for column `c1` \\(#1\\):.*overflows tinyint")
 	c.Assert(pairs, IsNil)
@@ -103,12 +108,12 @@ func (s *kvSuite) TestEncode(c *C) {
 
 	// Mock add record error
 	mockTbl := &mockTable{Table: tbl}
-	mockMode := NewTableKVEncoder(mockTbl, mysql.ModeStrictAllTables, 1234567891)
+	mockMode := NewTableKVEncoder(mockTbl, &SessionOptions{SQLMode: mysql.ModeStrictAllTables, Timestamp: 1234567891})
 	pairs, err = mockMode.Encode(logger, rowsWithPk2, 2, []int{0, 1})
 	c.Assert(err, ErrorMatches, "mock error")
 
 	// Non-strict mode
-	noneMode := NewTableKVEncoder(tbl, mysql.ModeNone, 1234567892)
+	noneMode := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeNone, Timestamp: 1234567892})
 	pairs, err = noneMode.Encode(logger, rows, 1, []int{0, 1})
 	c.Assert(err, IsNil)
 	c.Assert(pairs, DeepEquals, kvPairs([]kvenc.KvPair{
@@ -119,6 +124,458 @@ func (s *kvSuite) TestEncode(c *C) {
 	}))
 }
 
+func (s *kvSuite) TestEncodeDecimal(c *C) {
+	ty := *types.NewFieldType(mysql.TypeNewDecimal)
+	ty.Flen = 65
+	ty.Decimal = 30
+	c1 := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("c1"), State: model.StatePublic, Offset: 0, FieldType: ty}
+	cols := []*model.ColumnInfo{c1}
+	tblInfo := &model.TableInfo{ID: 1, Columns: cols, PKIsHandle: false, State: model.StatePublic}
+	tbl, err := tables.TableFromMeta(NewPanickingAllocator(0), tblInfo)
+	c.Assert(err, IsNil)
+
+	logger := log.Logger{Logger: zap.NewNop()}
+	encoder := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeStrictAllTables, Timestamp: 1234567890})
+
+	literals := []string{
+		"12345678901234567890123456789012345.123456789012345678901234567891",
+		"-98765432109876543210987654321098765.000000000000000000000000000001",
+		"0.100000000000000000000000000001",
+	}
+	for _, literal := range literals {
+		comment := Commentf("literal = %s", literal)
+
+		// the parsers always produce strings for non-integer numbers.
+		pairs, err := encoder.Encode(logger, []types.Datum{types.NewStringDatum(literal)}, 1, []int{0, -1})
+		c.Assert(err, IsNil, comment)
+
+		dec := new(types.MyDecimal)
+		c.Assert(dec.FromString([]byte(literal)), IsNil, comment)
+		expected, err := encoder.Encode(logger, []types.Datum{types.NewDecimalDatum(dec)}, 1, []int{0, -1})
+		c.Assert(err, IsNil, comment)
+		c.Assert(pairs, DeepEquals, expected, comment)
+
+		// going through float64 would have lost precision.
+		f, err := dec.ToFloat64()
+		c.Assert(err, IsNil, comment)
+		lossy, err := encoder.Encode(logger, []types.Datum{types.NewFloat64Datum(f)}, 1, []int{0, -1})
+		c.Assert(err, IsNil, comment)
+		c.Assert(pairs, Not(DeepEquals), lossy, comment)
+	}
+
+	// malformed and out-of-range decimals are rejected in strict mode.
+	_, err = encoder.Encode(logger, []types.Datum{types.NewStringDatum("12.3.4")}, 1, []int{0, -1})
+	c.Assert(err, ErrorMatches, "failed to cast `12.3.4` as decimal\\(65,30\\) for column `c1` \\(#1\\):.*")
+	_, err = encoder.Encode(logger, []types.Datum{types.NewStringDatum("1e100")}, 1, []int{0, -1})
+	c.Assert(err, ErrorMatches, "failed to cast `1e100` as decimal\\(65,30\\) for column `c1` \\(#1\\):.*")
+
+	// in non-strict mode, the valid prefix is kept like what TiDB does.
+	noneMode := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeNone, Timestamp: 1234567890})
+	pairs, err := noneMode.Encode(logger, []types.Datum{types.NewStringDatum("12.3.4")}, 1, []int{0, -1})
+	c.Assert(err, IsNil)
+	expected, err := noneMode.Encode(logger, []types.Datum{types.NewStringDatum("12.3")}, 1, []int{0, -1})
+	c.Assert(err, IsNil)
+	c.Assert(pairs, DeepEquals, expected)
+}
+
+func (s *kvSuite) TestEncodeOutOfRange(c *C) {
+	cases := []struct {
+		tp       byte
+		unsigned bool
+		input    types.Datum
+		clamped  types.Datum
+	}{
+		{mysql.TypeTiny, false, types.NewStringDatum("300"), types.NewIntDatum(127)},
+		{mysql.TypeTiny, false, types.NewIntDatum(-300), types.NewIntDatum(-128)},
+		{mysql.TypeTiny, true, types.NewStringDatum("300"), types.NewUintDatum(255)},
+		{mysql.TypeTiny, true, types.NewIntDatum(-1), types.NewUintDatum(0)},
+		{mysql.TypeShort, false, types.NewStringDatum("70000"), types.NewIntDatum(32767)},
+		{mysql.TypeInt24, true, types.NewIntDatum(16777216), types.NewUintDatum(16777215)},
+		{mysql.TypeLong, true, types.NewStringDatum("5000000000"), types.NewUintDatum(4294967295)},
+		{mysql.TypeLonglong, false, types.NewStringDatum("-99999999999999999999"), types.NewIntDatum(-9223372036854775808)},
+	}
+
+	logger := log.Logger{Logger: zap.NewNop()}
+	for _, tc := range cases {
+		ty := *types.NewFieldType(tc.tp)
+		if tc.unsigned {
+			ty.Flag |= mysql.UnsignedFlag
+		}
+		comment := Commentf("type = %s, input = %v", &ty, tc.input.GetValue())
+		c1 := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("c1"), State: model.StatePublic, Offset: 0, FieldType: ty}
+		tblInfo := &model.TableInfo{ID: 1, Name: model.NewCIStr("t"), Columns: []*model.ColumnInfo{c1}, PKIsHandle: false, State: model.StatePublic}
+		tbl, err := tables.TableFromMeta(NewPanickingAllocator(0), tblInfo)
+		c.Assert(err, IsNil, comment)
+
+		encode := func(sqlMode mysql.SQLMode, outOfRange string, value types.Datum) (Row, error) {
+			encoder := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: sqlMode, Timestamp: 1234567890, OutOfRange: outOfRange})
+			defer encoder.Close()
+			return encoder.Encode(logger, []types.Datum{value}, 1, []int{0, -1})
+		}
+
+		// by default, the value is rejected in strict mode.
+		_, err = encode(mysql.ModeStrictAllTables, "", tc.input)
+		c.Assert(err, ErrorMatches, "failed to cast .* for column `c1` \\(#1\\): \\[types:1690\\].*", comment)
+		_, err = encode(mysql.ModeNone, config.OutOfRangeError, tc.input)
+		c.Assert(err, ErrorMatches, "failed to cast .* for column `c1` \\(#1\\): \\[types:1690\\].*", comment)
+
+		expected, err := encode(mysql.ModeStrictAllTables, "", tc.clamped)
+		c.Assert(err, IsNil, comment)
+		for _, sqlMode := range []mysql.SQLMode{mysql.ModeStrictAllTables, mysql.ModeNone} {
+			pairs, err := encode(sqlMode, config.OutOfRangeClamp, tc.input)
+			c.Assert(err, IsNil, comment)
+			c.Assert(pairs, DeepEquals, expected, comment)
+		}
+
+		expected, err = encode(mysql.ModeStrictAllTables, "", types.Datum{})
+		c.Assert(err, IsNil, comment)
+		pairs, err := encode(mysql.ModeStrictAllTables, config.OutOfRangeNull, tc.input)
+		c.Assert(err, IsNil, comment)
+		c.Assert(pairs, DeepEquals, expected, comment)
+	}
+}
+
+func (s *kvSuite) TestEncodeOutOfRangeCount(c *C) {
+	ty := *types.NewFieldType(mysql.TypeTiny)
+	c1 := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("c1"), State: model.StatePublic, Offset: 0, FieldType: ty}
+	c2 := &model.ColumnInfo{ID: 2, Name: model.NewCIStr("c2"), State: model.StatePublic, Offset: 1, FieldType: ty}
+	tblInfo := &model.TableInfo{ID: 1, Name: model.NewCIStr("t"), Columns: []*model.ColumnInfo{c1, c2}, PKIsHandle: false, State: model.StatePublic}
+	tbl, err := tables.TableFromMeta(NewPanickingAllocator(0), tblInfo)
+	c.Assert(err, IsNil)
+
+	logger := log.Logger{Logger: zap.NewNop()}
+	encoder := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeStrictAllTables, OutOfRange: config.OutOfRangeClamp})
+	defer encoder.Close()
+	rows := [][]types.Datum{
+		{types.NewIntDatum(1), types.NewIntDatum(2)},
+		{types.NewIntDatum(300), types.NewIntDatum(-300)},
+		{types.NewIntDatum(3), types.NewIntDatum(400)},
+	}
+	for i, row := range rows {
+		_, err := encoder.Encode(logger, row, int64(i+1), []int{0, 1, -1})
+		c.Assert(err, IsNil)
+	}
+	// rows are counted once no matter how many values are replaced.
+	c.Assert(encoder.(*tableKVEncoder).outOfRangeRows, Equals, int64(2))
+}
+
+func (s *kvSuite) TestEncodeEnumLabel(c *C) {
+	ty := *types.NewFieldType(mysql.TypeEnum)
+	ty.Elems = []string{"a", "b", "c", "1"}
+	c1 := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("c1"), State: model.StatePublic, Offset: 0, FieldType: ty}
+	tblInfo := &model.TableInfo{ID: 1, Name: model.NewCIStr("t"), Columns: []*model.ColumnInfo{c1}, PKIsHandle: false, State: model.StatePublic}
+	tbl, err := tables.TableFromMeta(NewPanickingAllocator(0), tblInfo)
+	c.Assert(err, IsNil)
+
+	logger := log.Logger{Logger: zap.NewNop()}
+	encode := func(sqlMode mysql.SQLMode, format string, value types.Datum) (Row, error) {
+		encoder := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: sqlMode, Timestamp: 1234567890, EnumSetFormat: format})
+		defer encoder.Close()
+		return encoder.Encode(logger, []types.Datum{value}, 1, []int{0, -1})
+	}
+	byIndex := func(index uint64) Row {
+		pairs, err := encode(mysql.ModeStrictAllTables, "", types.NewUintDatum(index))
+		c.Assert(err, IsNil)
+		return pairs
+	}
+
+	cases := []struct {
+		format string
+		input  string
+		index  uint64
+	}{
+		{config.EnumSetFormatAuto, "b", 2},
+		{config.EnumSetFormatAuto, "C", 3},
+		{config.EnumSetFormatAuto, "2", 2},
+		{config.EnumSetFormatAuto, "1", 4},
+		{config.EnumSetFormatLabel, "b", 2},
+		{config.EnumSetFormatLabel, "1", 4},
+		{config.EnumSetFormatIndex, "1", 1},
+		{config.EnumSetFormatIndex, "3", 3},
+	}
+	for _, tc := range cases {
+		comment := Commentf("format = %s, input = %s", tc.format, tc.input)
+		pairs, err := encode(mysql.ModeStrictAllTables, tc.format, types.NewStringDatum(tc.input))
+		c.Assert(err, IsNil, comment)
+		c.Assert(pairs, DeepEquals, byIndex(tc.index), comment)
+	}
+
+	// an unknown label is an error in strict mode, and the empty value otherwise.
+	for _, tc := range []struct{ format, input string }{
+		{config.EnumSetFormatLabel, "2"},
+		{config.EnumSetFormatLabel, "d"},
+		{config.EnumSetFormatIndex, "b"},
+		{config.EnumSetFormatIndex, "5"},
+	} {
+		comment := Commentf("format = %s, input = %s", tc.format, tc.input)
+		_, err = encode(mysql.ModeStrictAllTables, tc.format, types.NewStringDatum(tc.input))
+		c.Assert(err, ErrorMatches, "failed to cast .* for column `c1` \\(#1\\): .*", comment)
+	}
+	expected, err := encode(mysql.ModeNone, config.EnumSetFormatAuto, types.NewStringDatum("d"))
+	c.Assert(err, IsNil)
+	pairs, err := encode(mysql.ModeNone, config.EnumSetFormatLabel, types.NewStringDatum("d"))
+	c.Assert(err, IsNil)
+	c.Assert(pairs, DeepEquals, expected)
+}
+
+func (s *kvSuite) TestEncodeSetLabels(c *C) {
+	ty := *types.NewFieldType(mysql.TypeSet)
+	ty.Elems = []string{"a", "b", "c"}
+	c1 := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("c1"), State: model.StatePublic, Offset: 0, FieldType: ty}
+	tblInfo := &model.TableInfo{ID: 1, Name: model.NewCIStr("t"), Columns: []*model.ColumnInfo{c1}, PKIsHandle: false, State: model.StatePublic}
+	tbl, err := tables.TableFromMeta(NewPanickingAllocator(0), tblInfo)
+	c.Assert(err, IsNil)
+
+	logger := log.Logger{Logger: zap.NewNop()}
+	encode := func(format string, value types.Datum) (Row, error) {
+		encoder := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeStrictAllTables, Timestamp: 1234567890, EnumSetFormat: format})
+		defer encoder.Close()
+		return encoder.Encode(logger, []types.Datum{value}, 1, []int{0, -1})
+	}
+
+	expected, err := encode("", types.NewUintDatum(5))
+	c.Assert(err, IsNil)
+	for _, tc := range []struct{ format, input string }{
+		{config.EnumSetFormatAuto, "a,c"},
+		{config.EnumSetFormatAuto, "5"},
+		{config.EnumSetFormatLabel, "c,A"},
+		{config.EnumSetFormatIndex, "5"},
+	} {
+		comment := Commentf("format = %s, input = %s", tc.format, tc.input)
+		pairs, err := encode(tc.format, types.NewStringDatum(tc.input))
+		c.Assert(err, IsNil, comment)
+		c.Assert(pairs, DeepEquals, expected, comment)
+	}
+
+	_, err = encode(config.EnumSetFormatLabel, types.NewStringDatum("a,d"))
+	c.Assert(err, ErrorMatches, "failed to cast .* for column `c1` \\(#1\\): .*")
+	_, err = encode(config.EnumSetFormatIndex, types.NewStringDatum("a,c"))
+	c.Assert(err, ErrorMatches, "failed to cast .* for column `c1` \\(#1\\): .*")
+}
+
+func (s *kvSuite) TestEncodeJSON(c *C) {
+	ty := *types.NewFieldType(mysql.TypeJSON)
+	c1 := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("c1"), State: model.StatePublic, Offset: 0, FieldType: ty}
+	tblInfo := &model.TableInfo{ID: 1, Name: model.NewCIStr("t"), Columns: []*model.ColumnInfo{c1}, PKIsHandle: false, State: model.StatePublic}
+	tbl, err := tables.TableFromMeta(NewPanickingAllocator(0), tblInfo)
+	c.Assert(err, IsNil)
+
+	logger := log.Logger{Logger: zap.NewNop()}
+	for _, sqlMode := range []mysql.SQLMode{mysql.ModeStrictAllTables, mysql.ModeNone} {
+		encoder := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: sqlMode, Timestamp: 1234567890})
+
+		for _, input := range []types.Datum{
+			types.NewStringDatum(`{"a": {"b": [1, 2.5, null]}, "c": "d"}`),
+			types.NewBytesDatum([]byte(`[1, "x", {"y": true}, []]`)),
+		} {
+			comment := Commentf("sql mode = %v, input = %s", sqlMode, input.GetString())
+			pairs, err := encoder.Encode(logger, []types.Datum{input}, 1, []int{0, -1})
+			c.Assert(err, IsNil, comment)
+			kvs := pairs.(kvPairs)
+			c.Assert(kvs, HasLen, 1, comment)
+
+			row, err := tablecodec.DecodeRow(kvs[0].Val, map[int64]*types.FieldType{1: &ty}, time.UTC)
+			c.Assert(err, IsNil, comment)
+			decoded := row[1]
+			c.Assert(decoded.Kind(), Equals, types.KindMysqlJSON, comment)
+			expected, err := json.ParseBinaryFromString(input.GetString())
+			c.Assert(err, IsNil, comment)
+			c.Assert(json.CompareBinary(decoded.GetMysqlJSON(), expected), Equals, 0, comment)
+		}
+
+		_, err = encoder.Encode(logger, []types.Datum{types.NewStringDatum(`{"a":`)}, 1, []int{0, -1})
+		c.Assert(err, ErrorMatches, "failed to cast .* for column `c1` \\(#1\\): \\[json:3140\\]Invalid JSON text.*")
+		encoder.Close()
+	}
+}
+
+func (s *kvSuite) TestEncodeRowTooLarge(c *C) {
+	idTy := *types.NewFieldType(mysql.TypeLong)
+	idTy.Flag |= mysql.PriKeyFlag | mysql.NotNullFlag
+	blobTy := *types.NewFieldType(mysql.TypeLongBlob)
+	blobTy.Charset = "binary"
+	blobTy.Collate = "binary"
+	tblInfo := &model.TableInfo{ID: 1, Name: model.NewCIStr("t"), Columns: []*model.ColumnInfo{
+		{ID: 1, Name: model.NewCIStr("id"), State: model.StatePublic, Offset: 0, FieldType: idTy},
+		{ID: 2, Name: model.NewCIStr("data"), State: model.StatePublic, Offset: 1, FieldType: blobTy},
+	}, PKIsHandle: true, State: model.StatePublic}
+	tbl, err := tables.TableFromMeta(NewPanickingAllocator(0), tblInfo)
+	c.Assert(err, IsNil)
+
+	logger := log.Logger{Logger: zap.NewNop()}
+	encoder := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeStrictAllTables, Timestamp: 1234567890, MaxRowSize: 1024})
+	defer encoder.Close()
+
+	_, err = encoder.Encode(logger, []types.Datum{types.NewIntDatum(7), types.NewBytesDatum(make([]byte, 900))}, 1, []int{0, 1, -1})
+	c.Assert(err, IsNil)
+
+	_, err = encoder.Encode(logger, []types.Datum{types.NewIntDatum(8), types.NewBytesDatum(make([]byte, 1024))}, 2, []int{0, 1, -1})
+	c.Assert(err, ErrorMatches, "the row with primary key \\(`id`\\) = \\(8\\) is encoded into \\d+ bytes, exceeding the max row size 1024: row too large")
+}
+
+func (s *kvSuite) TestEncodeRowTooLargeWithoutHandle(c *C) {
+	nameTy := *types.NewFieldType(mysql.TypeVarchar)
+	nameTy.Flag |= mysql.PriKeyFlag | mysql.NotNullFlag
+	nameTy.Flen = 64
+	nameTy.Charset = "utf8mb4"
+	nameTy.Collate = "utf8mb4_bin"
+	dataTy := *types.NewFieldType(mysql.TypeVarchar)
+	dataTy.Flen = 1024
+	dataTy.Charset = "utf8mb4"
+	dataTy.Collate = "utf8mb4_bin"
+	nameCol := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("name"), State: model.StatePublic, Offset: 0, FieldType: nameTy}
+	dataCol := &model.ColumnInfo{ID: 2, Name: model.NewCIStr("data"), State: model.StatePublic, Offset: 1, FieldType: dataTy}
+	tblInfo := &model.TableInfo{
+		ID:      1,
+		Name:    model.NewCIStr("t"),
+		Columns: []*model.ColumnInfo{nameCol, dataCol},
+		Indices: []*model.IndexInfo{{
+			ID:      1,
+			Name:    model.NewCIStr("primary"),
+			Columns: []*model.IndexColumn{{Name: nameCol.Name, Offset: 0, Length: types.UnspecifiedLength}},
+			Unique:  true,
+			Primary: true,
+			State:   model.StatePublic,
+		}},
+		State: model.StatePublic,
+	}
+	tbl, err := tables.TableFromMeta(NewPanickingAllocator(0), tblInfo)
+	c.Assert(err, IsNil)
+
+	logger := log.Logger{Logger: zap.NewNop()}
+	encoder := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeStrictAllTables, Timestamp: 1234567890, MaxRowSize: 1024})
+	defer encoder.Close()
+
+	data := strings.Repeat("x", 900)
+	pairs, err := encoder.Encode(logger, []types.Datum{types.NewStringDatum("a"), types.NewStringDatum(data)}, 1, []int{0, 1, -1})
+	c.Assert(err, IsNil)
+	c.Assert(pairs.(kvPairs), HasLen, 2)
+
+	// no KV pair exceeds the max row size, but the index entry of the primary
+	// key carrying the long key does in total.
+	row := []types.Datum{types.NewStringDatum(strings.Repeat("k", 60)), types.NewStringDatum(data)}
+	unlimited := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeStrictAllTables, Timestamp: 1234567890})
+	defer unlimited.Close()
+	pairs, err = unlimited.Encode(logger, row, 2, []int{0, 1, -1})
+	c.Assert(err, IsNil)
+	for _, pair := range pairs.(kvPairs) {
+		c.Assert(len(pair.Key)+len(pair.Val), Less, 1024)
+	}
+
+	_, err = encoder.Encode(logger, row, 2, []int{0, 1, -1})
+	c.Assert(err, ErrorMatches, "the row with primary key \\(`name`\\) = \\(k{60}\\) is encoded into \\d+ bytes, exceeding the max row size 1024: row too large")
+}
+
+func (s *kvSuite) TestEncodePreserveAutoIncrement(c *C) {
+	ty := *types.NewFieldType(mysql.TypeLonglong)
+	ty.Flag |= mysql.PriKeyFlag | mysql.NotNullFlag | mysql.AutoIncrementFlag
+	c1 := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("id"), State: model.StatePublic, Offset: 0, FieldType: ty}
+	cols := []*model.ColumnInfo{c1}
+	tblInfo := &model.TableInfo{ID: 1, Columns: cols, PKIsHandle: true, State: model.StatePublic}
+	alloc := NewPanickingAllocator(0)
+	tbl, err := tables.TableFromMeta(alloc, tblInfo)
+	c.Assert(err, IsNil)
+
+	logger := log.Logger{Logger: zap.NewNop()}
+	nullDatum := types.Datum{}
+	nullDatum.SetNull()
+
+	// Without preserving, a NULL auto-increment value is rejected in strict mode.
+	strictMode := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeStrictAllTables, Timestamp: 1234567890})
+	_, err = strictMode.Encode(logger, []types.Datum{nullDatum}, 1, []int{0, -1})
+	c.Assert(err, ErrorMatches, ".*cannot be null.*")
+
+	encoder := NewTableKVEncoder(tbl, &SessionOptions{
+		SQLMode:               mysql.ModeStrictAllTables,
+		Timestamp:             1234567890,
+		PreserveAutoIncrement: true,
+	})
+
+	// The explicit source ID is used as the handle.
+	pairs, err := encoder.Encode(logger, []types.Datum{types.NewIntDatum(100)}, 1, []int{0, -1})
+	c.Assert(err, IsNil)
+	c.Assert(pairs, DeepEquals, kvPairs([]kvenc.KvPair{
+		{
+			Key: []uint8{0x74, 0x80, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x5f, 0x72, 0x80, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x64},
+			Val: []uint8{0x0},
+		},
+	}))
+	c.Assert(alloc.Base(), Equals, int64(100))
+
+	// A row without the ID is allocated one after the largest explicit ID,
+	// rather than its ordinal 2.
+	pairs, err = encoder.Encode(logger, []types.Datum{nullDatum}, 2, []int{0, -1})
+	c.Assert(err, IsNil)
+	c.Assert(pairs, DeepEquals, kvPairs([]kvenc.KvPair{
+		{
+			Key: []uint8{0x74, 0x80, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x5f, 0x72, 0x80, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x65},
+			Val: []uint8{0x0},
+		},
+	}))
+	c.Assert(alloc.Base(), Equals, int64(101))
+
+	// Smaller IDs never move the base backwards, so after the import the
+	// allocator can be rebased to max+1.
+	_, err = encoder.Encode(logger, []types.Datum{types.NewIntDatum(50)}, 3, []int{0, -1})
+	c.Assert(err, IsNil)
+	c.Assert(alloc.Base(), Equals, int64(101))
+	_, err = encoder.Encode(logger, []types.Datum{types.NewIntDatum(250)}, 4, []int{0, -1})
+	c.Assert(err, IsNil)
+	c.Assert(alloc.Base(), Equals, int64(250))
+}
+
+func (s *kvSuite) TestEncodePreserveAutoIncrementMixed(c *C) {
+	ty := *types.NewFieldType(mysql.TypeLonglong)
+	ty.Flag |= mysql.PriKeyFlag | mysql.NotNullFlag | mysql.AutoIncrementFlag
+	c1 := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("id"), State: model.StatePublic, Offset: 0, FieldType: ty}
+	tblInfo := &model.TableInfo{ID: 1, Columns: []*model.ColumnInfo{c1}, PKIsHandle: true, State: model.StatePublic}
+	alloc := NewPanickingAllocator(0)
+	tbl, err := tables.TableFromMeta(alloc, tblInfo)
+	c.Assert(err, IsNil)
+
+	logger := log.Logger{Logger: zap.NewNop()}
+	nullDatum := types.Datum{}
+	nullDatum.SetNull()
+	encoder := NewTableKVEncoder(tbl, &SessionOptions{
+		SQLMode:               mysql.ModeStrictAllTables,
+		Timestamp:             1234567890,
+		PreserveAutoIncrement: true,
+	})
+
+	// the explicit IDs 2 and 3 are the ordinals of the rows with NULL IDs,
+	// none of the rows may share a handle.
+	rows := []types.Datum{
+		types.NewIntDatum(10),
+		nullDatum,
+		nullDatum,
+		types.NewIntDatum(2),
+		types.NewIntDatum(3),
+		types.NewIntDatum(20),
+		nullDatum,
+	}
+	handles := make(map[int64]int64, len(rows))
+	for i, row := range rows {
+		pairs, err := encoder.Encode(logger, []types.Datum{row}, int64(i+1), []int{0, -1})
+		c.Assert(err, IsNil)
+		kvs := pairs.(kvPairs)
+		c.Assert(kvs, HasLen, 1)
+		_, handle, err := tablecodec.DecodeRecordKey(kvs[0].Key)
+		c.Assert(err, IsNil)
+		prev, ok := handles[handle]
+		c.Assert(ok, IsFalse, Commentf("rows %d and %d share the handle %d", prev, i+1, handle))
+		handles[handle] = int64(i + 1)
+	}
+	c.Assert(handles, DeepEquals, map[int64]int64{10: 1, 11: 2, 12: 3, 2: 4, 3: 5, 20: 6, 21: 7})
+
+	// a row without the column is allocated an ID as well.
+	pairs, err := encoder.Encode(logger, []types.Datum{}, 8, []int{-1, -1})
+	c.Assert(err, IsNil)
+	_, handle, err := tablecodec.DecodeRecordKey(pairs.(kvPairs)[0].Key)
+	c.Assert(err, IsNil)
+	c.Assert(handle, Equals, int64(22))
+	c.Assert(alloc.Base(), Equals, int64(22))
+}
+
 func (s *kvSuite) TestEncodeTimestamp(c *C) {
 	ty := *types.NewFieldType(mysql.TypeDatetime)
 	ty.Flag |= mysql.NotNullFlag
@@ -138,7 +595,7 @@ func (s *kvSuite) TestEncodeTimestamp(c *C) {
 
 	logger := log.Logger{Logger: zap.NewNop()}
 
-	encoder := NewTableKVEncoder(tbl, mysql.ModeStrictAllTables, 1234567893)
+	encoder := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeStrictAllTables, Timestamp: 1234567893})
 	pairs, err := encoder.Encode(logger, nil, 70, []int{-1, 1})
 	c.Assert(err, IsNil)
 	c.Assert(pairs, DeepEquals, kvPairs([]kvenc.KvPair{
@@ -149,6 +606,98 @@ func (s *kvSuite) TestEncodeTimestamp(c *C) {
 	}))
 }
 
+func (s *kvSuite) TestEncodeTimestampTimeZone(c *C) {
+	tblWithDefault := func(tp byte) table.Table {
+		ty := *types.NewFieldType(tp)
+		ty.Flag |= mysql.NotNullFlag
+		tblInfo := &model.TableInfo{ID: 1, Columns: []*model.ColumnInfo{{
+			ID:           1,
+			Name:         model.NewCIStr("c1"),
+			State:        model.StatePublic,
+			FieldType:    ty,
+			DefaultValue: "CURRENT_TIMESTAMP",
+			Version:      1,
+		}}, State: model.StatePublic}
+		tbl, err := tables.TableFromMeta(NewPanickingAllocator(0), tblInfo)
+		c.Assert(err, IsNil)
+		return tbl
+	}
+	logger := log.Logger{Logger: zap.NewNop()}
+	utc8 := time.FixedZone("+08:00", 8*3600)
+	encode := func(tbl table.Table, tz *time.Location, row []types.Datum) kvPairs {
+		encoder := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeStrictAllTables, Timestamp: 1234567890, TimeZone: tz})
+		permutation := []int{-1, 1}
+		if len(row) > 0 {
+			permutation = []int{0, 1}
+		}
+		pairs, err := encoder.Encode(logger, row, 70, permutation)
+		c.Assert(err, IsNil)
+		return pairs.(kvPairs)
+	}
+
+	// the DATETIME default is the wall clock in the configured time zone,
+	// regardless of the local time zone.
+	datetimeTbl := tblWithDefault(mysql.TypeDatetime)
+	c.Assert(encode(datetimeTbl, time.UTC, nil), DeepEquals,
+		encode(datetimeTbl, time.UTC, []types.Datum{types.NewStringDatum("2009-02-13 23:31:30")}))
+	c.Assert(encode(datetimeTbl, utc8, nil), DeepEquals,
+		encode(datetimeTbl, time.UTC, []types.Datum{types.NewStringDatum("2009-02-14 07:31:30")}))
+
+	// the TIMESTAMP default is the same instant in every time zone, while the
+	// values in the data file are interpreted in the configured time zone.
+	timestampTbl := tblWithDefault(mysql.TypeTimestamp)
+	c.Assert(encode(timestampTbl, utc8, nil), DeepEquals, encode(timestampTbl, time.UTC, nil))
+	c.Assert(encode(timestampTbl, utc8, []types.Datum{types.NewStringDatum("2009-02-14 07:31:30")}), DeepEquals,
+		encode(timestampTbl, time.UTC, nil))
+}
+
+func (s *kvSuite) TestEncodeGeneratedColumns(c *C) {
+	intType := *types.NewFieldType(mysql.TypeLonglong)
+	makeTable := func(cExpr, cDep, bExpr, bDep string) table.Table {
+		tblInfo := &model.TableInfo{
+			ID:   1,
+			Name: model.NewCIStr("t"),
+			Columns: []*model.ColumnInfo{
+				{ID: 1, Name: model.NewCIStr("col_a"), State: model.StatePublic, Offset: 0, FieldType: intType},
+				// col_c refers to col_b, which is defined after it.
+				{
+					ID: 2, Name: model.NewCIStr("col_c"), State: model.StatePublic, Offset: 1, FieldType: intType,
+					GeneratedExprString: cExpr, GeneratedStored: true, Dependences: map[string]struct{}{cDep: {}},
+				},
+				{
+					ID: 3, Name: model.NewCIStr("col_b"), State: model.StatePublic, Offset: 2, FieldType: intType,
+					GeneratedExprString: bExpr, GeneratedStored: true, Dependences: map[string]struct{}{bDep: {}},
+				},
+			},
+			State: model.StatePublic,
+		}
+		tbl, err := tables.TableFromMeta(NewPanickingAllocator(0), tblInfo)
+		c.Assert(err, IsNil)
+		return tbl
+	}
+	logger := log.Logger{Logger: zap.NewNop()}
+
+	tbl := makeTable("`col_b` * 10", "col_b", "`col_a` + 1", "col_a")
+	encoder := NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeStrictAllTables})
+	// the values of generated columns in the data file are ignored.
+	pairs, err := encoder.Encode(logger, []types.Datum{types.NewIntDatum(5), types.NewIntDatum(999)}, 1, []int{0, 1, -1, -1})
+	c.Assert(err, IsNil)
+	kvs := pairs.(kvPairs)
+	c.Assert(kvs, HasLen, 1)
+	decoded, err := tablecodec.DecodeRow(kvs[0].Val, map[int64]*types.FieldType{1: &intType, 2: &intType, 3: &intType}, time.UTC)
+	c.Assert(err, IsNil)
+	c.Assert(decoded, DeepEquals, map[int64]types.Datum{
+		1: types.NewIntDatum(5),
+		2: types.NewIntDatum(60),
+		3: types.NewIntDatum(6),
+	})
+
+	tbl = makeTable("`col_b` * 10", "col_b", "`col_c` + 1", "col_c")
+	encoder = NewTableKVEncoder(tbl, &SessionOptions{SQLMode: mysql.ModeStrictAllTables})
+	_, err = encoder.Encode(logger, []types.Datum{types.NewIntDatum(5)}, 1, []int{0, -1, -1, -1})
+	c.Assert(err, ErrorMatches, "circular dependency of generated column col_.")
+}
+
 func (s *kvSuite) TestSplitIntoChunks(c *C) {
 	pairs := []kvenc.KvPair{
 		{
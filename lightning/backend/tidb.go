@@ -21,6 +21,8 @@ import (
 	"strings"
 	"time"
 
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/tidb/table"
@@ -34,6 +36,10 @@ import (
 	"github.com/pingcap/tidb-lightning/lightning/verification"
 )
 
+// tidbStatementSize is the maximum size of the VALUES of a single INSERT
+// statement. A transaction may consist of multiple statements.
+const tidbStatementSize = 1048576
+
 type tidbRow string
 
 type tidbRows []tidbRow
@@ -45,20 +51,28 @@ type tidbEncoder struct {
 type tidbBackend struct {
 	db          *sql.DB
 	onDuplicate string
+	txnSize     int
 }
 
 // NewTiDBBackend creates a new TiDB backend using the given database.
 //
+// The rows are inserted in transactions of about `txnSize` bytes. A non-positive
+// `txnSize` is replaced by the maximum size of a single statement, so that
+// every statement is committed in its own transaction.
+//
 // The backend does not take ownership of `db`. Caller should close `db`
 // manually after the backend expired.
-func NewTiDBBackend(db *sql.DB, onDuplicate string) Backend {
+func NewTiDBBackend(db *sql.DB, onDuplicate string, txnSize int) Backend {
 	switch onDuplicate {
 	case config.ReplaceOnDup, config.IgnoreOnDup, config.ErrorOnDup:
 	default:
 		log.L().Warn("unsupported action on duplicate, overwrite with `replace`")
 		onDuplicate = config.ReplaceOnDup
 	}
-	return MakeBackend(&tidbBackend{db: db, onDuplicate: onDuplicate})
+	if txnSize <= 0 {
+		txnSize = tidbStatementSize
+	}
+	return MakeBackend(&tidbBackend{db: db, onDuplicate: onDuplicate, txnSize: txnSize})
 }
 
 func (row tidbRow) ClassifyAndAppend(data *Rows, checksum *verification.KVChecksum, _ *Rows, _ *verification.KVChecksum) {
@@ -241,15 +255,15 @@ func (be *tidbBackend) MaxChunkSize() int {
 	failpoint.Inject("FailIfImportedSomeRows", func() {
 		failpoint.Return(1)
 	})
-	return 1048576
+	return be.txnSize
 }
 
 func (be *tidbBackend) ShouldPostProcess() bool {
 	return false
 }
 
-func (be *tidbBackend) NewEncoder(_ table.Table, mode mysql.SQLMode, timestamp int64) Encoder {
-	return tidbEncoder{mode: mode}
+func (be *tidbBackend) NewEncoder(_ table.Table, options *SessionOptions) Encoder {
+	return tidbEncoder{mode: options.SQLMode}
 }
 
 func (be *tidbBackend) OpenEngine(context.Context, uuid.UUID) error {
@@ -297,20 +311,70 @@ func (be *tidbBackend) WriteRows(ctx context.Context, _ uuid.UUID, tableName str
 	}
 	insertStmt.WriteString(" VALUES")
 
+	err := be.writeRowsInTxn(ctx, tableName, insertStmt.String(), rows)
+	failpoint.Inject("FailIfImportedSomeRows", func() {
+		panic("forcing failure due to FailIfImportedSomeRows, before saving checkpoint")
+	})
+	return err
+}
+
+// writeRowsInTxn inserts the rows in a single transaction. If TiDB rejects the
+// transaction as too large, the rows are split into two halves and each half
+// is committed separately.
+//
+// When the second half fails, the caller retries all the rows, including the
+// committed first half. This is harmless with `REPLACE` and `INSERT IGNORE`,
+// but would fail on the duplicated rows with a plain `INSERT`, so the rows are
+// never split when `on-duplicate` is "error".
+func (be *tidbBackend) writeRowsInTxn(ctx context.Context, tableName string, stmtPrefix string, rows tidbRows) error {
+	err := be.execInTxn(ctx, stmtPrefix, rows)
+	if !isTxnTooLargeError(err) || len(rows) <= 1 || be.onDuplicate == config.ErrorOnDup {
+		return err
+	}
+
+	half := len(rows) / 2
+	log.L().Warn("transaction too large, split and retry",
+		zap.String("table", tableName),
+		zap.Int("rows", len(rows)),
+		zap.Int("splitRows", half),
+	)
+	if err := be.writeRowsInTxn(ctx, tableName, stmtPrefix, rows[:half]); err != nil {
+		return err
+	}
+	return be.writeRowsInTxn(ctx, tableName, stmtPrefix, rows[half:])
+}
+
+func (be *tidbBackend) execInTxn(ctx context.Context, stmtPrefix string, rows tidbRows) error {
+	txn, err := be.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
 	// Note: we are not going to do interpolation (prepared statements) to avoid
 	// complication arised from data length overflow of BIT and BINARY columns
 
-	for i, row := range rows {
-		if i != 0 {
-			insertStmt.WriteByte(',')
+	for _, stmtRows := range rows.SplitIntoChunks(tidbStatementSize) {
+		var insertStmt strings.Builder
+		insertStmt.WriteString(stmtPrefix)
+		for i, row := range stmtRows.(tidbRows) {
+			if i != 0 {
+				insertStmt.WriteByte(',')
+			}
+			insertStmt.WriteString(string(row))
+		}
+
+		// Retry will be done externally, so we're not going to retry here.
+		if _, err := txn.ExecContext(ctx, insertStmt.String()); err != nil {
+			if rerr := txn.Rollback(); rerr != nil {
+				log.L().Error("rollback transaction failed", log.ShortError(rerr))
+			}
+			return err
 		}
-		insertStmt.WriteString(string(row))
 	}
+	return txn.Commit()
+}
 
-	// Retry will be done externally, so we're not going to retry here.
-	_, err := be.db.ExecContext(ctx, insertStmt.String())
-	failpoint.Inject("FailIfImportedSomeRows", func() {
-		panic("forcing failure due to FailIfImportedSomeRows, before saving checkpoint")
-	})
-	return err
+func isTxnTooLargeError(err error) bool {
+	merr, ok := errors.Cause(err).(*gomysql.MySQLError)
+	return ok && merr.Number == mysql.ErrTxnTooLarge
 }
@@ -18,7 +18,9 @@ import (
 	"database/sql"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	gomysql "github.com/go-sql-driver/mysql"
 	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/tidb/types"
 
 	kv "github.com/pingcap/tidb-lightning/lightning/backend"
@@ -41,7 +43,7 @@ func (s *mysqlSuite) SetUpTest(c *C) {
 
 	s.dbHandle = db
 	s.mockDB = mock
-	s.backend = kv.NewTiDBBackend(db, config.ReplaceOnDup)
+	s.backend = kv.NewTiDBBackend(db, config.ReplaceOnDup, 0)
 }
 
 func (s *mysqlSuite) TearDownTest(c *C) {
@@ -50,9 +52,11 @@ func (s *mysqlSuite) TearDownTest(c *C) {
 }
 
 func (s *mysqlSuite) TestWriteRowsReplaceOnDup(c *C) {
+	s.mockDB.ExpectBegin()
 	s.mockDB.
 		ExpectExec("\\QREPLACE INTO `foo`.`bar`(`a`,`b`,`c`,`d`,`e`,`f`,`g`,`h`,`i`,`j`,`k`,`l`,`m`,`n`,`o`) VALUES(18446744073709551615,-9223372036854775808,0,NULL,7.5,5e-324,1.7976931348623157e+308,0,'甲乙丙\\r\\n\\0\\Z''\"\\\\`',0x000000abcdef,2557891634,'12.5',51)\\E").
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.ExpectCommit()
 
 	ctx := context.Background()
 	logger := log.L()
@@ -65,7 +69,7 @@ func (s *mysqlSuite) TestWriteRowsReplaceOnDup(c *C) {
 	indexRows := s.backend.MakeEmptyRows()
 	indexChecksum := verification.MakeKVChecksum(0, 0, 0)
 
-	encoder := s.backend.NewEncoder(nil, 0, 1234567890)
+	encoder := s.backend.NewEncoder(nil, &kv.SessionOptions{SQLMode: mysql.ModeNone, Timestamp: 1234567890})
 	row, err := encoder.Encode(logger, []types.Datum{
 		types.NewUintDatum(18446744073709551615),
 		types.NewIntDatum(-9223372036854775808),
@@ -89,14 +93,16 @@ func (s *mysqlSuite) TestWriteRowsReplaceOnDup(c *C) {
 }
 
 func (s *mysqlSuite) TestWriteRowsIgnoreOnDup(c *C) {
+	s.mockDB.ExpectBegin()
 	s.mockDB.
 		ExpectExec("\\QINSERT IGNORE INTO `foo`.`bar`(`a`) VALUES(1)\\E").
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.ExpectCommit()
 
 	ctx := context.Background()
 	logger := log.L()
 
-	ignoreBackend := kv.NewTiDBBackend(s.dbHandle, config.IgnoreOnDup)
+	ignoreBackend := kv.NewTiDBBackend(s.dbHandle, config.IgnoreOnDup, 0)
 	engine, err := ignoreBackend.OpenEngine(ctx, "`foo`.`bar`", 1)
 	c.Assert(err, IsNil)
 
@@ -105,7 +111,7 @@ func (s *mysqlSuite) TestWriteRowsIgnoreOnDup(c *C) {
 	indexRows := ignoreBackend.MakeEmptyRows()
 	indexChecksum := verification.MakeKVChecksum(0, 0, 0)
 
-	encoder := ignoreBackend.NewEncoder(nil, 0, 0)
+	encoder := ignoreBackend.NewEncoder(nil, &kv.SessionOptions{SQLMode: mysql.ModeNone, Timestamp: 0})
 	row, err := encoder.Encode(logger, []types.Datum{
 		types.NewIntDatum(1),
 	}, 1, nil)
@@ -117,14 +123,16 @@ func (s *mysqlSuite) TestWriteRowsIgnoreOnDup(c *C) {
 }
 
 func (s *mysqlSuite) TestWriteRowsErrorOnDup(c *C) {
+	s.mockDB.ExpectBegin()
 	s.mockDB.
 		ExpectExec("\\QINSERT INTO `foo`.`bar`(`a`) VALUES(1)\\E").
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.ExpectCommit()
 
 	ctx := context.Background()
 	logger := log.L()
 
-	ignoreBackend := kv.NewTiDBBackend(s.dbHandle, config.ErrorOnDup)
+	ignoreBackend := kv.NewTiDBBackend(s.dbHandle, config.ErrorOnDup, 0)
 	engine, err := ignoreBackend.OpenEngine(ctx, "`foo`.`bar`", 1)
 	c.Assert(err, IsNil)
 
@@ -133,7 +141,7 @@ func (s *mysqlSuite) TestWriteRowsErrorOnDup(c *C) {
 	indexRows := ignoreBackend.MakeEmptyRows()
 	indexChecksum := verification.MakeKVChecksum(0, 0, 0)
 
-	encoder := ignoreBackend.NewEncoder(nil, 0, 0)
+	encoder := ignoreBackend.NewEncoder(nil, &kv.SessionOptions{SQLMode: mysql.ModeNone, Timestamp: 0})
 	row, err := encoder.Encode(logger, []types.Datum{
 		types.NewIntDatum(1),
 	}, 1, nil)
@@ -143,3 +151,89 @@ func (s *mysqlSuite) TestWriteRowsErrorOnDup(c *C) {
 	err = engine.WriteRows(ctx, []string{"a"}, dataRows)
 	c.Assert(err, IsNil)
 }
+
+func (s *mysqlSuite) writeIntRows(c *C, backend kv.Backend, values ...int64) error {
+	ctx := context.Background()
+	engine, err := backend.OpenEngine(ctx, "`foo`.`bar`", 1)
+	c.Assert(err, IsNil)
+
+	dataRows := backend.MakeEmptyRows()
+	dataChecksum := verification.MakeKVChecksum(0, 0, 0)
+	indexRows := backend.MakeEmptyRows()
+	indexChecksum := verification.MakeKVChecksum(0, 0, 0)
+
+	encoder := backend.NewEncoder(nil, &kv.SessionOptions{SQLMode: mysql.ModeNone, Timestamp: 0})
+	for i, value := range values {
+		row, err := encoder.Encode(log.L(), []types.Datum{types.NewIntDatum(value)}, int64(i+1), nil)
+		c.Assert(err, IsNil)
+		row.ClassifyAndAppend(&dataRows, &dataChecksum, &indexRows, &indexChecksum)
+	}
+
+	return engine.WriteRows(ctx, []string{"a"}, dataRows)
+}
+
+func (s *mysqlSuite) TestWriteRowsTxnSize(c *C) {
+	// every encoded row "(n)" is 3 bytes, so a transaction holds 2 rows.
+	s.mockDB.ExpectBegin()
+	s.mockDB.
+		ExpectExec("\\QREPLACE INTO `foo`.`bar`(`a`) VALUES(1),(2)\\E").
+		WillReturnResult(sqlmock.NewResult(2, 2))
+	s.mockDB.ExpectCommit()
+	s.mockDB.ExpectBegin()
+	s.mockDB.
+		ExpectExec("\\QREPLACE INTO `foo`.`bar`(`a`) VALUES(3)\\E").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.ExpectCommit()
+
+	backend := kv.NewTiDBBackend(s.dbHandle, config.ReplaceOnDup, 6)
+	c.Assert(s.writeIntRows(c, backend, 1, 2, 3), IsNil)
+}
+
+func (s *mysqlSuite) TestWriteRowsSplitTxnTooLarge(c *C) {
+	txnTooLarge := &gomysql.MySQLError{Number: mysql.ErrTxnTooLarge, Message: "Transaction is too large"}
+
+	s.mockDB.ExpectBegin()
+	s.mockDB.
+		ExpectExec("\\QREPLACE INTO `foo`.`bar`(`a`) VALUES(1),(2),(3)\\E").
+		WillReturnError(txnTooLarge)
+	s.mockDB.ExpectRollback()
+	s.mockDB.ExpectBegin()
+	s.mockDB.
+		ExpectExec("\\QREPLACE INTO `foo`.`bar`(`a`) VALUES(1)\\E").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.ExpectCommit()
+	s.mockDB.ExpectBegin()
+	s.mockDB.
+		ExpectExec("\\QREPLACE INTO `foo`.`bar`(`a`) VALUES(2),(3)\\E").
+		WillReturnResult(sqlmock.NewResult(2, 2))
+	s.mockDB.ExpectCommit()
+
+	c.Assert(s.writeIntRows(c, s.backend, 1, 2, 3), IsNil)
+}
+
+func (s *mysqlSuite) TestWriteRowsNoSplitErrorOnDup(c *C) {
+	txnTooLarge := &gomysql.MySQLError{Number: mysql.ErrTxnTooLarge, Message: "Transaction is too large"}
+
+	// a retry after committing only one half would insert it again, so the
+	// transaction is not split.
+	s.mockDB.ExpectBegin()
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`bar`(`a`) VALUES(1),(2),(3)\\E").
+		WillReturnError(txnTooLarge)
+	s.mockDB.ExpectRollback()
+
+	backend := kv.NewTiDBBackend(s.dbHandle, config.ErrorOnDup, 0)
+	c.Assert(s.writeIntRows(c, backend, 1, 2, 3), Equals, txnTooLarge)
+}
+
+func (s *mysqlSuite) TestWriteRowsSingleRowTxnTooLarge(c *C) {
+	txnTooLarge := &gomysql.MySQLError{Number: mysql.ErrTxnTooLarge, Message: "Transaction is too large"}
+
+	s.mockDB.ExpectBegin()
+	s.mockDB.
+		ExpectExec("\\QREPLACE INTO `foo`.`bar`(`a`) VALUES(1)\\E").
+		WillReturnError(txnTooLarge)
+	s.mockDB.ExpectRollback()
+
+	c.Assert(s.writeIntRows(c, s.backend, 1), Equals, txnTooLarge)
+}
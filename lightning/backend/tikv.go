@@ -14,18 +14,32 @@
 package backend
 
 import (
+	"bytes"
 	"context"
-	"fmt"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/import_sstpb"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 
 	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
 	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/metric"
 )
 
 // StoreState is the state of a TiKV store. The numerical value is sorted by
@@ -60,6 +74,24 @@ var jsonToStoreState = map[string]StoreState{
 	`"Tombstone"`:    StoreStateTombstone,
 }
 
+// String implements the fmt.Stringer interface.
+func (ss StoreState) String() string {
+	switch ss {
+	case StoreStateUp:
+		return "Up"
+	case StoreStateOffline:
+		return "Offline"
+	case StoreStateDisconnected:
+		return "Disconnected"
+	case StoreStateDown:
+		return "Down"
+	case StoreStateTombstone:
+		return "Tombstone"
+	default:
+		return "Unknown"
+	}
+}
+
 // UnmarshalJSON implements the json.Unmarshaler interface.
 func (ss *StoreState) UnmarshalJSON(content []byte) error {
 	if state, ok := jsonToStoreState[string(content)]; ok {
@@ -71,16 +103,121 @@ func (ss *StoreState) UnmarshalJSON(content []byte) error {
 
 // Store contains metadata about a TiKV store.
 type Store struct {
+	ID      uint64 `json:"id"`
 	Address string
 	Version string
 	State   StoreState `json:"state_name"`
+
+	// Capacity and Available are the disk space (in bytes) of the store, as
+	// reported in the store status. They are zero if not reported.
+	Capacity  uint64 `json:"-"`
+	Available uint64 `json:"-"`
+}
+
+// AvailableRatio returns the fraction of the disk capacity still available
+// on the store. Returns 1 if the capacity is unknown.
+func (s *Store) AvailableRatio() float64 {
+	if s.Capacity == 0 {
+		return 1
+	}
+	return float64(s.Available) / float64(s.Capacity)
+}
+
+// storeInfo is a TiKV store reported by PD.
+type storeInfo struct {
+	Store  Store
+	Status storeStatus
 }
 
-func withTiKVConnection(ctx context.Context, tikvAddr string, action func(import_sstpb.ImportSSTClient) error) error {
+func (info *storeInfo) toStore() *Store {
+	s := info.Store
+	s.Capacity = uint64(info.Status.Capacity)
+	s.Available = uint64(info.Status.Available)
+	return &s
+}
+
+// storeStatus is the status of a TiKV store reported by PD.
+type storeStatus struct {
+	Capacity  ByteSize `json:"capacity"`
+	Available ByteSize `json:"available"`
+}
+
+// ByteSize is a size in bytes, which PD reports in the human-readable form
+// like "1.5TiB".
+type ByteSize uint64
+
+var byteSizeUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+	"PIB": 1 << 50,
+	"EIB": 1 << 60,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"PB":  1e15,
+	"EB":  1e18,
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. Both plain numbers
+// and strings with units are accepted.
+func (b *ByteSize) UnmarshalJSON(content []byte) error {
+	text := strings.TrimSpace(string(content))
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		text = strings.TrimSpace(unquoted)
+	}
+
+	i := strings.IndexFunc(text, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r == '.')
+	})
+	if i < 0 {
+		i = len(text)
+	}
+	number, err := strconv.ParseFloat(text[:i], 64)
+	if err != nil {
+		return errors.Errorf("invalid byte size %s", content)
+	}
+	unit, ok := byteSizeUnits[strings.ToUpper(strings.TrimSpace(text[i:]))]
+	if !ok {
+		return errors.Errorf("invalid byte size %s", content)
+	}
+	*b = ByteSize(number * unit)
+	return nil
+}
+
+// GRPCDialOptions returns the options for dialing the gRPC connections to
+// TiKV and tikv-importer, which keep the connections alive and compress every
+// RPC on them according to the configuration.
+func GRPCDialOptions(cfg config.GRPC) []grpc.DialOption {
+	opts := []grpc.DialOption{
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                cfg.KeepaliveTime.Duration,
+			Timeout:             cfg.KeepaliveTimeout.Duration,
+			PermitWithoutStream: cfg.KeepalivePermitWithoutStream,
+		}),
+	}
+	if cfg.Compression == config.GRPCCompressionGzip {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)))
+	}
+	return opts
+}
+
+func withTiKVConnection(ctx context.Context, tikvAddr string, opts []grpc.DialOption, action func(import_sstpb.ImportSSTClient) error) error {
 	// Connect to the ImportSST service on the given TiKV node.
 	// The connection is needed for executing `action` and will be tear down
 	// when this function exits.
-	conn, err := grpc.DialContext(ctx, tikvAddr, grpc.WithInsecure())
+	fdBudget := common.GlobalFDBudget()
+	if err := fdBudget.Acquire(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	defer fdBudget.Release()
+
+	opts = append([]grpc.DialOption{grpc.WithInsecure()}, opts...)
+	conn, err := grpc.DialContext(ctx, tikvAddr, opts...)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -90,9 +227,152 @@ func withTiKVConnection(ctx context.Context, tikvAddr string, action func(import
 	return action(client)
 }
 
+var (
+	pdLimiterLock sync.RWMutex
+	pdLimiter     = make(chan struct{}, config.PDMaxConcurrentRequests)
+)
+
+// SetPDConcurrency changes the maximum number of HTTP requests sent to PD at
+// the same time by GetPDJSON.
+func SetPDConcurrency(n int) {
+	if n <= 0 {
+		n = config.PDMaxConcurrentRequests
+	}
+	pdLimiterLock.Lock()
+	pdLimiter = make(chan struct{}, n)
+	pdLimiterLock.Unlock()
+}
+
+var (
+	pdRetryLock    sync.RWMutex
+	pdRetryCount   = maxRetryTimes
+	pdRetryBackoff time.Duration
+)
+
+// SetPDRetry changes how many times a request to the PD leader is sent by
+// ForAllStores before giving up, and the delay before the first retry, which
+// is doubled after every retry.
+func SetPDRetry(count int, backoff time.Duration) {
+	if count <= 0 {
+		count = maxRetryTimes
+	}
+	pdRetryLock.Lock()
+	pdRetryCount = count
+	pdRetryBackoff = backoff
+	pdRetryLock.Unlock()
+}
+
+// GetPDJSON fetches a page from the PD HTTP API and parses it as JSON, like
+// common.GetJSONWithContext. The number of concurrent requests is capped to
+// protect PD from bursts of control-plane calls, and this function blocks until
+// the request can be sent or the context is done.
+func GetPDJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	release, err := acquirePDLimiter(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return common.GetJSONWithContext(ctx, client, url, v)
+}
+
+func acquirePDLimiter(ctx context.Context) (release func(), err error) {
+	pdLimiterLock.RLock()
+	limiter := pdLimiter
+	pdLimiterLock.RUnlock()
+
+	select {
+	case limiter <- struct{}{}:
+	case <-ctx.Done():
+		return nil, errors.Trace(ctx.Err())
+	}
+	metric.PDInflightRequestsGauge.Inc()
+	return func() {
+		metric.PDInflightRequestsGauge.Dec()
+		<-limiter
+	}, nil
+}
+
+// EstimatePDClockSkew compares the local clock with the clock of the PD
+// server at the base URL `pdURL`, using the `Date` header of its HTTP response.
+// Returns how far the local clock is ahead of PD (negative if behind). Since
+// the header has only one second precision and the request takes time, the
+// result is the smallest skew consistent with the observation, and is zero if
+// the clocks may be in sync.
+func EstimatePDClockSkew(ctx context.Context, client *http.Client, pdURL string) (time.Duration, error) {
+	release, err := acquirePDLimiter(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	url := pdURL + "/pd/api/v1/version"
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	sent := time.Now()
+	resp, err := client.Do(req.WithContext(ctx))
+	received := time.Now()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	resp.Body.Close()
+
+	remote, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, errors.Annotatef(err, "invalid Date header from %s", url)
+	}
+
+	// PD's clock was within [remote, remote+1s) at some moment between `sent`
+	// and `received`.
+	if lower := sent.Sub(remote.Add(time.Second)); lower > 0 {
+		return lower, nil
+	}
+	if upper := received.Sub(remote); upper < 0 {
+		return upper, nil
+	}
+	return 0, nil
+}
+
+// ResolvePDLeader returns the base URL (scheme and host) of the current PD
+// leader, by asking the PD member at the base URL `pdURL`.
+func ResolvePDLeader(ctx context.Context, client *http.Client, pdURL string) (string, error) {
+	url := pdURL + "/pd/api/v1/members"
+
+	var members struct {
+		Leader struct {
+			ClientURLs []string `json:"client_urls"`
+		} `json:"leader"`
+	}
+	if err := GetPDJSON(ctx, client, url, &members); err != nil {
+		return "", errors.Trace(err)
+	}
+	if len(members.Leader.ClientURLs) == 0 {
+		return "", errors.Errorf("PD at %s did not report a leader", pdURL)
+	}
+
+	leaderURL, err := neturl.Parse(members.Leader.ClientURLs[0])
+	if err != nil {
+		return "", errors.Annotatef(err, "invalid PD leader URL %s", members.Leader.ClientURLs[0])
+	}
+	if len(leaderURL.Host) == 0 {
+		return "", errors.Errorf("invalid PD leader URL %s", members.Leader.ClientURLs[0])
+	}
+	scheme := leaderURL.Scheme
+	if len(scheme) == 0 {
+		scheme = "http"
+	}
+	return scheme + "://" + leaderURL.Host, nil
+}
+
 // ForAllStores executes `action` in parallel for all TiKV stores connected to
 // the given PD server.
 //
+// The `pdURL` is the base URL of any PD member, e.g. "http://127.0.0.1:2379".
+// The store list is fetched from the current PD leader, which is re-resolved
+// before every retry if the request fails (see getPDLeaderJSON).
+//
 // Returns the first non-nil error returned in all `action` calls. If all
 // `action` returns nil, this method would return nil as well.
 //
@@ -101,39 +381,245 @@ func withTiKVConnection(ctx context.Context, tikvAddr string, action func(import
 func ForAllStores(
 	ctx context.Context,
 	client *http.Client,
-	pdAddr string,
+	pdURL string,
 	minState StoreState,
 	action func(c context.Context, store *Store) error,
 ) error {
-	// Go through the HTTP interface instead of gRPC so we don't need to keep
-	// track of the cluster ID.
-	url := fmt.Sprintf("http://%s/pd/api/v1/stores", pdAddr)
+	return ForAllStoresWithJitter(ctx, client, pdURL, minState, StoreJitter{}, action)
+}
+
+// StoreJitter describes the delays before running the action on each store in
+// ForAllStoresWithJitter. The random delay avoids hitting every store at the
+// same instant.
+type StoreJitter struct {
+	// Window is the upper bound (exclusive) of the random delay. Zero disables
+	// the delay.
+	Window time.Duration
+	// Seed initializes the random generator. If zero, the current time is
+	// used. Tests should set a fixed seed to get deterministic delays.
+	Seed int64
+	// StateGrace is how long to wait before checking again a store excluded by
+	// `minState`, which may be only transitioning between states, e.g. during
+	// a rolling restart. The store is included if it has reached `minState`
+	// by then. Tombstone stores are never checked again. Zero disables the
+	// re-check.
+	StateGrace time.Duration
+}
 
+// Delays returns the delays of `n` stores, each within [0, Window).
+func (j StoreJitter) Delays(n int) []time.Duration {
+	delays := make([]time.Duration, n)
+	if j.Window <= 0 {
+		return delays
+	}
+	seed := j.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	for i := range delays {
+		delays[i] = time.Duration(rng.Int63n(int64(j.Window)))
+	}
+	return delays
+}
+
+// ForAllStoresWithJitter is like ForAllStores, but each action is delayed by a
+// random duration given by `jitter`.
+func ForAllStoresWithJitter(
+	ctx context.Context,
+	client *http.Client,
+	pdURL string,
+	minState StoreState,
+	jitter StoreJitter,
+	action func(c context.Context, store *Store) error,
+) error {
 	var stores struct {
-		Stores []struct {
-			Store Store
+		Stores []storeInfo
+	}
+	if err := getPDLeaderJSON(ctx, client, pdURL, "/pd/api/v1/stores", &stores); err != nil {
+		return err
+	}
+
+	// the delays are generated up front, in the order of the store list, so
+	// they are not affected by the scheduling of the goroutines.
+	delays := jitter.Delays(len(stores.Stores))
+	eg, c := errgroup.WithContext(ctx)
+	for i, store := range stores.Stores {
+		s := *store.toStore()
+		delay := delays[i]
+		switch {
+		case s.State >= minState:
+			eg.Go(func() error {
+				if err := sleepContext(c, delay); err != nil {
+					return err
+				}
+				return action(c, &s)
+			})
+		case jitter.StateGrace > 0 && s.State != StoreStateTombstone:
+			eg.Go(func() error {
+				if err := sleepContext(c, jitter.StateGrace); err != nil {
+					return err
+				}
+				// the leader is resolved again, since it may have changed
+				// during the grace period.
+				var info storeInfo
+				err := getPDLeaderJSON(c, client, pdURL, storePath(s.ID), &info)
+				if err != nil {
+					log.L().Warn("cannot check the store again, skipped",
+						zap.String("store", s.Address),
+						log.ShortError(err),
+					)
+					return nil
+				}
+				rechecked := info.toStore()
+				if rechecked.State < minState {
+					log.L().Info("store is still excluded after the grace period",
+						zap.String("store", s.Address),
+						zap.Stringer("state", rechecked.State),
+					)
+					return nil
+				}
+				log.L().Info("store is included after the grace period",
+					zap.String("store", s.Address),
+					zap.Stringer("previousState", s.State),
+					zap.Stringer("state", rechecked.State),
+				)
+				if err := sleepContext(c, delay); err != nil {
+					return err
+				}
+				return action(c, rechecked)
+			})
 		}
 	}
+	return eg.Wait()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	err := common.GetJSON(client, url, &stores)
+// GetStore fetches the information of a single TiKV store from the PD server
+// at the base URL `pdURL`.
+func GetStore(ctx context.Context, client *http.Client, pdURL string, storeID uint64) (*Store, error) {
+	var info storeInfo
+	if err := GetPDJSON(ctx, client, pdURL+storePath(storeID), &info); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return info.toStore(), nil
+}
+
+// ScatterRegion asks the PD server at the base URL `pdURL` to scatter a region
+// once, by adding a "scatter-region" operator which moves the peers and the
+// leader of the region to other stores. The operator is removed by PD when it
+// finishes, or when it is replaced by another operator on the same region.
+func ScatterRegion(ctx context.Context, client *http.Client, pdURL string, regionID uint64) error {
+	release, err := acquirePDLimiter(ctx)
 	if err != nil {
 		return err
 	}
+	defer release()
 
-	eg, c := errgroup.WithContext(ctx)
-	for _, store := range stores.Stores {
-		if store.Store.State >= minState {
-			s := store.Store
-			eg.Go(func() error { return action(c, &s) })
+	input, err := json.Marshal(map[string]interface{}{
+		"name":      "scatter-region",
+		"region_id": regionID,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	url := pdURL + "/pd/api/v1/operators"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(input))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return errors.Trace(err)
 		}
+		return errors.Errorf("post %s http status code != 200, message %s", url, string(body))
 	}
-	return eg.Wait()
+	return nil
+}
+
+func storePath(storeID uint64) string {
+	return "/pd/api/v1/store/" + strconv.FormatUint(storeID, 10)
+}
+
+// getPDLeaderJSON fetches a page from the current PD leader, like GetPDJSON.
+// The `pdURL` is the base URL of any PD member, and `path` is the path of the
+// page, e.g. "/pd/api/v1/stores". The leader is resolved again before every
+// retry, in case it has changed in between, and the retries are delayed with
+// an exponential backoff configured by SetPDRetry. If the base URL contains a
+// path prefix, PD is assumed to be behind a proxy, and the requests are always
+// sent to the base URL instead, since the leader's client URL is not reachable
+// from here.
+func getPDLeaderJSON(ctx context.Context, client *http.Client, pdURL string, path string, v interface{}) error {
+	proxied := false
+	if u, err := neturl.Parse(pdURL); err == nil && len(u.Path) > 0 {
+		proxied = true
+	}
+
+	pdRetryLock.RLock()
+	count, backoff := pdRetryCount, pdRetryBackoff
+	pdRetryLock.RUnlock()
+
+	var err error
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			if err := sleepContext(ctx, backoff); err != nil {
+				return errors.Trace(err)
+			}
+			backoff *= 2
+		}
+
+		leaderURL := pdURL
+		if !proxied {
+			var resolveErr error
+			leaderURL, resolveErr = ResolvePDLeader(ctx, client, pdURL)
+			if resolveErr != nil {
+				log.L().Warn("cannot resolve PD leader, using the given PD address",
+					zap.String("pdURL", pdURL),
+					log.ShortError(resolveErr),
+				)
+				leaderURL = pdURL
+			}
+		}
+
+		// Go through the HTTP interface instead of gRPC so we don't need to keep
+		// track of the cluster ID.
+		err = GetPDJSON(ctx, client, leaderURL+path, v)
+		if err == nil || common.IsContextCanceledError(err) {
+			return err
+		}
+		log.L().Warn("failed to request PD leader, retrying",
+			zap.String("leader", leaderURL),
+			zap.String("path", path),
+			zap.Int("retry", i),
+			log.ShortError(err),
+		)
+	}
+	return err
 }
 
 // SwitchMode changes the TiKV node at the given address to a particular mode.
-func SwitchMode(ctx context.Context, tikvAddr string, mode import_sstpb.SwitchMode) error {
+func SwitchMode(ctx context.Context, tikvAddr string, mode import_sstpb.SwitchMode, opts ...grpc.DialOption) error {
 	task := log.With(zap.Stringer("mode", mode)).Begin(zap.DebugLevel, "switch mode")
-	err := withTiKVConnection(ctx, tikvAddr, func(client import_sstpb.ImportSSTClient) error {
+	err := withTiKVConnection(ctx, tikvAddr, opts, func(client import_sstpb.ImportSSTClient) error {
 		_, err := client.SwitchMode(ctx, &import_sstpb.SwitchModeRequest{
 			Mode: mode,
 		})
@@ -144,14 +630,26 @@ func SwitchMode(ctx context.Context, tikvAddr string, mode import_sstpb.SwitchMo
 }
 
 // Compact performs a leveled compaction with the given minimum level.
-func Compact(ctx context.Context, tikvAddr string, level int32) error {
+//
+// If the TiKV store does not implement the Compact RPC, the returned error
+// satisfies `IsCompactUnsupportedError`.
+func Compact(ctx context.Context, tikvAddr string, level int32, opts ...grpc.DialOption) error {
 	task := log.With(zap.Int32("level", level)).Begin(zap.InfoLevel, "compact cluster")
-	err := withTiKVConnection(ctx, tikvAddr, func(client import_sstpb.ImportSSTClient) error {
+	err := withTiKVConnection(ctx, tikvAddr, opts, func(client import_sstpb.ImportSSTClient) error {
 		_, err := client.Compact(ctx, &import_sstpb.CompactRequest{
 			OutputLevel: level,
 		})
+		if IsCompactUnsupportedError(err) {
+			return errors.Annotatef(err, "TiKV at %s does not support import-side compaction", tikvAddr)
+		}
 		return errors.Trace(err)
 	})
 	task.End(zap.ErrorLevel, err)
 	return err
 }
+
+// IsCompactUnsupportedError returns whether the error from `Compact` is
+// caused by the TiKV store not implementing the Compact RPC.
+func IsCompactUnsupportedError(err error) bool {
+	return err != nil && status.Code(errors.Cause(err)) == codes.Unimplemented
+}
@@ -2,15 +2,23 @@ package backend_test
 
 import (
 	"context"
+	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
 	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/config"
 )
 
 type tikvSuite struct{}
@@ -74,15 +82,12 @@ func (s *tikvSuite) TestForAllStores(c *C) {
 	}))
 	defer server.Close()
 
-	serverURL, err := url.Parse(server.URL)
-	c.Assert(err, IsNil)
-
 	ctx := context.Background()
 	var (
 		allStoresLock sync.Mutex
 		allStores     []*kv.Store
 	)
-	err = kv.ForAllStores(ctx, server.Client(), serverURL.Host, kv.StoreStateDown, func(c2 context.Context, store *kv.Store) error {
+	err := kv.ForAllStores(ctx, server.Client(), server.URL, kv.StoreStateDown, func(c2 context.Context, store *kv.Store) error {
 		allStoresLock.Lock()
 		allStores = append(allStores, store)
 		allStoresLock.Unlock()
@@ -94,24 +99,540 @@ func (s *tikvSuite) TestForAllStores(c *C) {
 
 	c.Assert(allStores, DeepEquals, []*kv.Store{
 		{
+			ID:      1,
 			Address: "127.0.0.1:20160",
 			Version: "3.0.0-beta.1",
 			State:   kv.StoreStateUp,
 		},
 		{
+			ID:      2,
 			Address: "127.0.0.1:20161",
 			Version: "3.0.0-rc.1",
 			State:   kv.StoreStateDown,
 		},
 		{
+			ID:      3,
 			Address: "127.0.0.1:20162",
 			Version: "3.0.0-rc.2",
 			State:   kv.StoreStateDisconnected,
 		},
 		{
+			ID:      5,
 			Address: "127.0.0.1:20164",
 			Version: "3.0.1",
 			State:   kv.StoreStateOffline,
 		},
 	})
 }
+
+func (s *tikvSuite) TestForAllStoresUsesPDLeader(c *C) {
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Assert(req.URL.Path, Equals, "/pd/api/v1/stores")
+		w.Write([]byte(`{"stores":[{"store":{"address":"127.0.0.1:20160","version":"3.0.0","state_name":"Up"}}]}`))
+	}))
+	defer leader.Close()
+
+	// the first resolution points to a stale leader, simulating a leader change.
+	staleLeader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer staleLeader.Close()
+
+	resolveCount := 0
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/pd/api/v1/members":
+			leaderURL := leader.URL
+			if resolveCount == 0 {
+				leaderURL = staleLeader.URL
+			}
+			resolveCount++
+			w.Write([]byte(`{"leader":{"name":"pd1","client_urls":["` + leaderURL + `"]}}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer follower.Close()
+
+	var stores []*kv.Store
+	err := kv.ForAllStores(context.Background(), follower.Client(), follower.URL, kv.StoreStateOffline, func(c2 context.Context, store *kv.Store) error {
+		stores = append(stores, store)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(resolveCount, Equals, 2)
+	c.Assert(stores, DeepEquals, []*kv.Store{
+		{Address: "127.0.0.1:20160", Version: "3.0.0", State: kv.StoreStateUp},
+	})
+}
+
+func (s *tikvSuite) TestResolvePDLeader(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Assert(req.URL.Path, Equals, "/pd/api/v1/members")
+		w.Write([]byte(`{"leader":{"name":"pd2","client_urls":["http://10.0.0.2:2379","http://10.0.0.3:2379"]}}`))
+	}))
+	defer server.Close()
+
+	leader, err := kv.ResolvePDLeader(context.Background(), server.Client(), server.URL)
+	c.Assert(err, IsNil)
+	c.Assert(leader, Equals, "http://10.0.0.2:2379")
+}
+
+func (s *tikvSuite) TestGetPDJSONConcurrency(c *C) {
+	kv.SetPDConcurrency(1)
+	defer kv.SetPDConcurrency(0)
+
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		entered <- struct{}{}
+		<-release
+		w.Write([]byte(`"3.0.0"`))
+	}))
+	defer server.Close()
+
+	errCh := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			var version string
+			errCh <- kv.GetPDJSON(context.Background(), server.Client(), server.URL, &version)
+		}()
+	}
+
+	// only one request reaches PD at a time.
+	<-entered
+	select {
+	case <-entered:
+		c.Fatal("second request sent while the first one is in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// a waiting request can be canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var version string
+	err := kv.GetPDJSON(ctx, server.Client(), server.URL, &version)
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+
+	close(release)
+	c.Assert(<-errCh, IsNil)
+	c.Assert(<-errCh, IsNil)
+}
+
+func (s *tikvSuite) TestGetPDJSONCanceled(c *C) {
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		entered <- struct{}{}
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		var version string
+		errCh <- kv.GetPDJSON(ctx, server.Client(), server.URL, &version)
+	}()
+
+	// canceling stops the request hanging on PD.
+	<-entered
+	cancel()
+	select {
+	case err := <-errCh:
+		c.Assert(errors.Cause(err), Equals, context.Canceled)
+	case <-time.After(5 * time.Second):
+		c.Fatal("request not stopped after the context is canceled")
+	}
+}
+
+func (s *tikvSuite) TestForAllStoresBehindProxy(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the leader must not be resolved, since its client URL is unreachable
+		// from outside the proxy.
+		c.Assert(req.URL.Path, Equals, "/proxy/pd-1/pd/api/v1/stores")
+		w.Write([]byte(`{"stores":[{"store":{"address":"127.0.0.1:20160","version":"3.0.0","state_name":"Up"}}]}`))
+	}))
+	defer server.Close()
+
+	var stores []*kv.Store
+	err := kv.ForAllStores(context.Background(), server.Client(), server.URL+"/proxy/pd-1", kv.StoreStateOffline, func(c2 context.Context, store *kv.Store) error {
+		stores = append(stores, store)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(stores, DeepEquals, []*kv.Store{
+		{Address: "127.0.0.1:20160", Version: "3.0.0", State: kv.StoreStateUp},
+	})
+}
+
+func (s *tikvSuite) TestCompactUnimplemented(c *C) {
+	// a gRPC server without any registered services answers every RPC with
+	// the Unimplemented status.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	server := grpc.NewServer()
+	go server.Serve(listener)
+	defer server.Stop()
+
+	addr := listener.Addr().String()
+	err = kv.Compact(context.Background(), addr, -1)
+	c.Assert(err, ErrorMatches, "TiKV at "+addr+" does not support import-side compaction.*Unimplemented.*")
+	c.Assert(kv.IsCompactUnsupportedError(err), IsTrue)
+
+	c.Assert(kv.IsCompactUnsupportedError(nil), IsFalse)
+	c.Assert(kv.IsCompactUnsupportedError(context.Canceled), IsFalse)
+}
+
+func (s *tikvSuite) TestGRPCDialOptionsCompression(c *C) {
+	// records the compression of every RPC, which are all unimplemented.
+	var mu sync.Mutex
+	var compressions []string
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	server := grpc.NewServer(grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		transportStream := grpc.ServerTransportStreamFromContext(stream.Context())
+		mu.Lock()
+		compressions = append(compressions, transportStream.(interface{ RecvCompress() string }).RecvCompress())
+		mu.Unlock()
+		return status.Error(codes.Unimplemented, "unimplemented")
+	}))
+	go server.Serve(listener)
+	defer server.Stop()
+
+	addr := listener.Addr().String()
+	cfg := config.NewConfig().GRPC
+	c.Assert(cfg.Compression, Equals, config.GRPCCompressionNone)
+	err = kv.Compact(context.Background(), addr, -1, kv.GRPCDialOptions(cfg)...)
+	c.Assert(kv.IsCompactUnsupportedError(err), IsTrue)
+
+	cfg.Compression = config.GRPCCompressionGzip
+	err = kv.Compact(context.Background(), addr, -1, kv.GRPCDialOptions(cfg)...)
+	c.Assert(kv.IsCompactUnsupportedError(err), IsTrue)
+
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(compressions, DeepEquals, []string{"", "gzip"})
+}
+
+func (s *tikvSuite) TestStoreJitterDelays(c *C) {
+	c.Assert(kv.StoreJitter{}.Delays(3), DeepEquals, []time.Duration{0, 0, 0})
+
+	jitter := kv.StoreJitter{Window: time.Second, Seed: 42}
+	delays := jitter.Delays(100)
+	c.Assert(delays, HasLen, 100)
+	c.Assert(jitter.Delays(100), DeepEquals, delays)
+	distinct := make(map[time.Duration]struct{})
+	for _, d := range delays {
+		c.Assert(d >= 0 && d < time.Second, IsTrue, Commentf("delay = %s", d))
+		distinct[d] = struct{}{}
+	}
+	c.Assert(len(distinct), Greater, 1)
+
+	c.Assert(kv.StoreJitter{Window: time.Second, Seed: 43}.Delays(100), Not(DeepEquals), delays)
+}
+
+func (s *tikvSuite) TestForAllStoresWithJitter(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"count":2,"stores":[
+			{"store":{"id":1,"address":"127.0.0.1:20160","state_name":"Up"}},
+			{"store":{"id":2,"address":"127.0.0.1:20161","state_name":"Up"}}
+		]}`))
+	}))
+	defer server.Close()
+
+	jitter := kv.StoreJitter{Window: 50 * time.Millisecond, Seed: 1}
+	delays := jitter.Delays(2)
+
+	var (
+		lock    sync.Mutex
+		elapsed = make(map[string]time.Duration)
+	)
+	start := time.Now()
+	err := kv.ForAllStoresWithJitter(context.Background(), server.Client(), server.URL, kv.StoreStateOffline, jitter, func(c2 context.Context, store *kv.Store) error {
+		lock.Lock()
+		elapsed[store.Address] = time.Since(start)
+		lock.Unlock()
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(elapsed, HasLen, 2)
+	c.Assert(elapsed["127.0.0.1:20160"] >= delays[0], IsTrue)
+	c.Assert(elapsed["127.0.0.1:20161"] >= delays[1], IsTrue)
+
+	// the delay is interrupted when the context is canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	called := false
+	err = kv.ForAllStoresWithJitter(ctx, server.Client(), server.URL, kv.StoreStateOffline, kv.StoreJitter{Window: time.Hour, Seed: 1}, func(c2 context.Context, store *kv.Store) error {
+		called = true
+		return nil
+	})
+	c.Assert(errors.Cause(err), Equals, context.DeadlineExceeded)
+	c.Assert(called, IsFalse)
+}
+
+func (s *tikvSuite) TestForAllStoresStateGrace(c *C) {
+	var rechecked int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/pd/api/v1/stores":
+			w.Write([]byte(`{"count":4,"stores":[
+				{"store":{"id":1,"address":"127.0.0.1:20160","state_name":"Up"}},
+				{"store":{"id":2,"address":"127.0.0.1:20161","state_name":"Disconnected"}},
+				{"store":{"id":3,"address":"127.0.0.1:20162","state_name":"Down"}},
+				{"store":{"id":4,"address":"127.0.0.1:20163","state_name":"Tombstone"}}
+			]}`))
+		case "/pd/api/v1/store/2":
+			atomic.AddInt32(&rechecked, 1)
+			w.Write([]byte(`{"store":{"id":2,"address":"127.0.0.1:20161","state_name":"Up"}}`))
+		case "/pd/api/v1/store/3":
+			atomic.AddInt32(&rechecked, 1)
+			w.Write([]byte(`{"store":{"id":3,"address":"127.0.0.1:20162","state_name":"Down"}}`))
+		case "/pd/api/v1/members":
+			// no leader, so the given PD address is used.
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			c.Errorf("unexpected request %s", req.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	run := func(grace time.Duration) []string {
+		var (
+			lock   sync.Mutex
+			stores []string
+		)
+		err := kv.ForAllStoresWithJitter(context.Background(), server.Client(), server.URL, kv.StoreStateOffline, kv.StoreJitter{StateGrace: grace}, func(c2 context.Context, store *kv.Store) error {
+			lock.Lock()
+			stores = append(stores, store.Address)
+			lock.Unlock()
+			return nil
+		})
+		c.Assert(err, IsNil)
+		sort.Strings(stores)
+		return stores
+	}
+
+	// without the grace period, the disconnected store is skipped immediately.
+	c.Assert(run(0), DeepEquals, []string{"127.0.0.1:20160"})
+	c.Assert(atomic.LoadInt32(&rechecked), Equals, int32(0))
+
+	// the disconnected store has recovered when checked again, the down store
+	// has not, and the tombstone store is never checked.
+	c.Assert(run(time.Millisecond), DeepEquals, []string{"127.0.0.1:20160", "127.0.0.1:20161"})
+	c.Assert(atomic.LoadInt32(&rechecked), Equals, int32(2))
+}
+
+func (s *tikvSuite) TestForAllStoresRetryBackoff(c *C) {
+	kv.SetPDRetry(3, 20*time.Millisecond)
+	defer kv.SetPDRetry(0, 0)
+
+	// the requests are handled in the server goroutines.
+	var mu sync.Mutex
+	var requests []time.Time
+	failures := 2
+	getRequests := func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/pd/api/v1/members":
+			w.WriteHeader(http.StatusNotFound)
+		case "/pd/api/v1/stores":
+			mu.Lock()
+			requests = append(requests, time.Now())
+			failed := len(requests) <= failures
+			mu.Unlock()
+			if failed {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"stores":[{"store":{"address":"127.0.0.1:20160","state_name":"Up"}}]}`))
+		}
+	}))
+	defer server.Close()
+
+	action := func(c2 context.Context, store *kv.Store) error { return nil }
+	err := kv.ForAllStores(context.Background(), server.Client(), server.URL, kv.StoreStateOffline, action)
+	c.Assert(err, IsNil)
+	times := getRequests()
+	c.Assert(times, HasLen, 3)
+	// the delay is doubled after every retry.
+	c.Assert(times[1].Sub(times[0]), GreaterEqual, 20*time.Millisecond)
+	c.Assert(times[2].Sub(times[1]), GreaterEqual, 40*time.Millisecond)
+
+	// gives up after the configured number of requests.
+	mu.Lock()
+	requests = nil
+	failures = 3
+	mu.Unlock()
+	err = kv.ForAllStores(context.Background(), server.Client(), server.URL, kv.StoreStateOffline, action)
+	c.Assert(err, ErrorMatches, ".*http status code != 200.*")
+	c.Assert(getRequests(), HasLen, 3)
+}
+
+func (s *tikvSuite) TestForAllStoresCanceledWhileRetrying(c *C) {
+	kv.SetPDRetry(5, 0)
+	defer kv.SetPDRetry(0, 0)
+
+	entered := make(chan struct{}, 5)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/pd/api/v1/members":
+			w.WriteHeader(http.StatusNotFound)
+		case "/pd/api/v1/stores":
+			entered <- struct{}{}
+			<-req.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- kv.ForAllStores(ctx, server.Client(), server.URL, kv.StoreStateOffline, func(c2 context.Context, store *kv.Store) error {
+			return nil
+		})
+	}()
+
+	// a canceled request is not retried.
+	<-entered
+	cancel()
+	select {
+	case err := <-errCh:
+		c.Assert(errors.Cause(err), Equals, context.Canceled)
+	case <-time.After(5 * time.Second):
+		c.Fatal("ForAllStores not stopped after the context is canceled")
+	}
+	c.Assert(entered, HasLen, 0)
+}
+
+func (s *tikvSuite) TestForAllStoresStateGraceLeaderChange(c *C) {
+	var leaderURL atomic.Value
+	newLeader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Assert(req.URL.Path, Equals, "/pd/api/v1/store/2")
+		w.Write([]byte(`{"store":{"id":2,"address":"127.0.0.1:20161","state_name":"Up"}}`))
+	}))
+	defer newLeader.Close()
+
+	// the old leader steps down after listing the stores.
+	oldLeader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/pd/api/v1/stores" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"stores":[
+			{"store":{"id":1,"address":"127.0.0.1:20160","state_name":"Up"}},
+			{"store":{"id":2,"address":"127.0.0.1:20161","state_name":"Disconnected"}}
+		]}`))
+		leaderURL.Store(newLeader.URL)
+	}))
+	defer oldLeader.Close()
+	leaderURL.Store(oldLeader.URL)
+
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Assert(req.URL.Path, Equals, "/pd/api/v1/members")
+		w.Write([]byte(`{"leader":{"client_urls":["` + leaderURL.Load().(string) + `"]}}`))
+	}))
+	defer follower.Close()
+
+	var (
+		lock   sync.Mutex
+		stores []string
+	)
+	err := kv.ForAllStoresWithJitter(context.Background(), follower.Client(), follower.URL, kv.StoreStateOffline, kv.StoreJitter{StateGrace: time.Millisecond}, func(c2 context.Context, store *kv.Store) error {
+		lock.Lock()
+		stores = append(stores, store.Address)
+		lock.Unlock()
+		return nil
+	})
+	c.Assert(err, IsNil)
+	sort.Strings(stores)
+	c.Assert(stores, DeepEquals, []string{"127.0.0.1:20160", "127.0.0.1:20161"})
+}
+
+func (s *tikvSuite) TestByteSizeUnmarshal(c *C) {
+	cases := []struct {
+		input    string
+		expected kv.ByteSize
+	}{
+		{`"1.5TiB"`, 3 << 39},
+		{`"500GiB"`, 500 << 30},
+		{`"64 MiB"`, 64 << 20},
+		{`"2KB"`, 2000},
+		{`"123B"`, 123},
+		{`"0B"`, 0},
+		{`4096`, 4096},
+	}
+	for _, tc := range cases {
+		var b kv.ByteSize
+		c.Assert(json.Unmarshal([]byte(tc.input), &b), IsNil, Commentf("input = %s", tc.input))
+		c.Assert(b, Equals, tc.expected, Commentf("input = %s", tc.input))
+	}
+
+	var b kv.ByteSize
+	c.Assert(json.Unmarshal([]byte(`"12 parsecs"`), &b), ErrorMatches, `invalid byte size "12 parsecs"`)
+	c.Assert(json.Unmarshal([]byte(`"GiB"`), &b), ErrorMatches, `invalid byte size "GiB"`)
+}
+
+func (s *tikvSuite) TestForAllStoresDiskStatus(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"count":2,"stores":[
+			{"store":{"id":1,"address":"127.0.0.1:20160","state_name":"Up"},"status":{"capacity":"100GiB","available":"25GiB"}},
+			{"store":{"id":2,"address":"127.0.0.1:20161","state_name":"Up"},"status":{}}
+		]}`))
+	}))
+	defer server.Close()
+
+	var (
+		lock   sync.Mutex
+		ratios = make(map[string]float64)
+	)
+	err := kv.ForAllStores(context.Background(), server.Client(), server.URL, kv.StoreStateOffline, func(c2 context.Context, store *kv.Store) error {
+		lock.Lock()
+		ratios[store.Address] = store.AvailableRatio()
+		lock.Unlock()
+		if store.Address == "127.0.0.1:20160" {
+			c.Assert(store.Capacity, Equals, uint64(100<<30))
+			c.Assert(store.Available, Equals, uint64(25<<30))
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(ratios, DeepEquals, map[string]float64{
+		"127.0.0.1:20160": 0.25,
+		"127.0.0.1:20161": 1,
+	})
+}
+
+func (s *tikvSuite) TestScatterRegion(c *C) {
+	var input map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Assert(req.Method, Equals, http.MethodPost)
+		c.Assert(req.URL.Path, Equals, "/pd/api/v1/operators")
+		c.Assert(json.NewDecoder(req.Body).Decode(&input), IsNil)
+		if input["region_id"] == float64(404) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("region 404 not found"))
+		}
+	}))
+	defer server.Close()
+
+	err := kv.ScatterRegion(context.Background(), server.Client(), server.URL, 12)
+	c.Assert(err, IsNil)
+	c.Assert(input, DeepEquals, map[string]interface{}{
+		"name":      "scatter-region",
+		"region_id": float64(12),
+	})
+
+	err = kv.ScatterRegion(context.Background(), server.Client(), server.URL, 404)
+	c.Assert(err, ErrorMatches, "post .*/pd/api/v1/operators http status code != 200, message region 404 not found")
+}
@@ -0,0 +1,260 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/expression"
+	"github.com/pingcap/tidb/sessionctx/variable"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/chunk"
+)
+
+const (
+	transformMaxAllowedPacket = 64 * 1024 * 1024
+	// the maximum number of AST nodes in a single transform, which bounds the
+	// work done to evaluate it for each row.
+	transformMaxNodes = 256
+)
+
+// functions which may block or have side effects outside of the row, and thus
+// are not allowed in a transform.
+var forbiddenTransformFuncs = map[string]struct{}{
+	ast.Sleep:           {},
+	ast.Benchmark:       {},
+	ast.GetLock:         {},
+	ast.ReleaseLock:     {},
+	ast.ReleaseAllLocks: {},
+	ast.IsFreeLock:      {},
+	ast.IsUsedLock:      {},
+	ast.MasterPosWait:   {},
+	ast.GetVar:          {},
+	ast.SetVar:          {},
+	ast.LastInsertId:    {},
+	ast.RowCount:        {},
+	ast.FoundRows:       {},
+	ast.LoadFile:        {},
+	ast.TiDBIsDDLOwner:  {},
+}
+
+// functions whose result does not only depend on their arguments. The row
+// would get a different value every time it is imported, e.g. when a chunk is
+// restored again after resuming from a checkpoint.
+var nondeterministicTransformFuncs = map[string]struct{}{
+	ast.Rand:             {},
+	ast.UUID:             {},
+	ast.UUIDShort:        {},
+	ast.RandomBytes:      {},
+	ast.Now:              {},
+	ast.CurrentTimestamp: {},
+	ast.Curdate:          {},
+	ast.CurrentDate:      {},
+	ast.Curtime:          {},
+	ast.CurrentTime:      {},
+	ast.Sysdate:          {},
+	ast.UTCDate:          {},
+	ast.UTCTime:          {},
+	ast.UTCTimestamp:     {},
+	ast.LocalTime:        {},
+	ast.LocalTimestamp:   {},
+	ast.ConnectionID:     {},
+	ast.Database:         {},
+	ast.Schema:           {},
+	ast.User:             {},
+	ast.CurrentUser:      {},
+	ast.SessionUser:      {},
+	ast.SystemUser:       {},
+	ast.CurrentRole:      {},
+}
+
+type transformChecker struct {
+	nodes int
+	err   error
+}
+
+func (tc *transformChecker) Enter(in ast.Node) (ast.Node, bool) {
+	tc.nodes++
+	if tc.nodes > transformMaxNodes {
+		tc.err = errors.Errorf("transform is too complex, at most %d nodes are allowed", transformMaxNodes)
+		return in, true
+	}
+	switch node := in.(type) {
+	case *ast.FuncCallExpr:
+		if _, ok := forbiddenTransformFuncs[node.FnName.L]; ok {
+			tc.err = errors.Errorf("function %s is not allowed in a transform since it may block or have side effects", node.FnName.O)
+			return in, true
+		}
+		_, ok := nondeterministicTransformFuncs[node.FnName.L]
+		// UNIX_TIMESTAMP() is only the current time without an argument.
+		if ok || (node.FnName.L == ast.UnixTimestamp && len(node.Args) == 0) {
+			tc.err = errors.Errorf("function %s is not allowed in a transform since it is not deterministic", node.FnName.O)
+			return in, true
+		}
+	case *ast.AggregateFuncExpr:
+		tc.err = errors.New("aggregate functions are not allowed in a transform")
+		return in, true
+	case *ast.SubqueryExpr, *ast.VariableExpr, ast.ParamMarkerExpr, *ast.DefaultExpr, *ast.ValuesExpr:
+		tc.err = errors.New("only column names, literals and functions are allowed in a transform")
+		return in, true
+	}
+	return in, false
+}
+
+func (tc *transformChecker) Leave(in ast.Node) (ast.Node, bool) {
+	return in, tc.err == nil
+}
+
+type columnTransform struct {
+	offset int
+	expr   expression.Expression
+}
+
+// RowTransformer rewrites some fields of the parsed rows by SQL expressions
+// before they are encoded. The expressions see the raw fields of the row as
+// strings, by the name of their columns. A nil transformer leaves the rows
+// unchanged.
+type RowTransformer struct {
+	se         *session
+	columns    int
+	transforms []columnTransform
+
+	// reused by every call of Transform, thus a transformer must not be shared
+	// by several goroutines.
+	input   *chunk.Chunk
+	results []types.Datum
+}
+
+// NewRowTransformer compiles the transforms, which map a column name to the
+// SQL expression computing its new value. Returns nil if there are no
+// transforms.
+//
+// Only deterministic functions of the row are allowed, so that evaluating a
+// transform always terminates without side effects, and gives the same value
+// when a chunk is imported again. The size of each expression is bounded by
+// transformMaxNodes, and the results of string functions by
+// max_allowed_packet.
+func NewRowTransformer(tbl *model.TableInfo, transforms map[string]string, options *SessionOptions) (*RowTransformer, error) {
+	if len(transforms) == 0 {
+		return nil, nil
+	}
+
+	// every column is presented to the expressions as the raw string field.
+	rawTbl := tbl.Clone()
+	inputTypes := make([]*types.FieldType, 0, len(rawTbl.Columns))
+	for _, col := range rawTbl.Columns {
+		col.FieldType = *types.NewFieldType(mysql.TypeVarString)
+		col.Flen = mysql.MaxBlobWidth
+		col.Charset = mysql.UTF8MB4Charset
+		col.Collate = mysql.UTF8MB4DefaultCollation
+		col.State = model.StatePublic
+		inputTypes = append(inputTypes, &col.FieldType)
+	}
+
+	se := newSession(options)
+	// caps the results of string functions like REPEAT().
+	se.vars.SetSystemVar(variable.MaxAllowedPacket, strconv.Itoa(transformMaxAllowedPacket))
+	rt := &RowTransformer{
+		se:         se,
+		columns:    len(rawTbl.Columns),
+		transforms: make([]columnTransform, 0, len(transforms)),
+		input:      chunk.NewChunkWithCapacity(inputTypes, 1),
+	}
+	p := parser.New()
+	for colName, exprStr := range transforms {
+		col := model.FindColumnInfo(tbl.Columns, colName)
+		if col == nil {
+			return nil, errors.Errorf("unknown column %s in transform", colName)
+		}
+		stmt, err := p.ParseOneStmt("SELECT "+exprStr, "", "")
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid transform of column %s", colName)
+		}
+		fields := stmt.(*ast.SelectStmt).Fields.Fields
+		if len(fields) != 1 || fields[0].Expr == nil {
+			return nil, errors.Errorf("invalid transform of column %s: must be a single expression", colName)
+		}
+		checker := transformChecker{}
+		fields[0].Expr.Accept(&checker)
+		if checker.err != nil {
+			return nil, errors.Annotatef(checker.err, "invalid transform of column %s", colName)
+		}
+		expr, err := expression.RewriteSimpleExprWithTableInfo(rt.se, rawTbl, fields[0].Expr)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid transform of column %s", colName)
+		}
+		rt.transforms = append(rt.transforms, columnTransform{offset: col.Offset, expr: expr})
+	}
+	sort.Slice(rt.transforms, func(i, j int) bool {
+		return rt.transforms[i].offset < rt.transforms[j].offset
+	})
+	rt.results = make([]types.Datum, len(rt.transforms))
+	return rt, nil
+}
+
+// Transform rewrites the fields of the row in-place. The row is in the order
+// of the data file, and `columnPermutation` maps each column of the table to
+// its field, like in Encoder.Encode. Transforms of the columns missing from the
+// data file are skipped. All transforms see the fields before any of them is
+// applied.
+func (rt *RowTransformer) Transform(row []types.Datum, columnPermutation []int) error {
+	if rt == nil {
+		return nil
+	}
+
+	input := rt.input
+	input.Reset()
+	for i := 0; i < rt.columns; i++ {
+		field := -1
+		if i < len(columnPermutation) {
+			field = columnPermutation[i]
+		}
+		if field < 0 || field >= len(row) || row[field].IsNull() {
+			input.AppendNull(i)
+			continue
+		}
+		s, err := row[field].ToString()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		input.AppendString(i, s)
+	}
+	inputRow := input.GetRow(0)
+
+	results := rt.results
+	// truncations in non-strict mode are only warnings, which are not needed.
+	defer rt.se.vars.StmtCtx.SetWarnings(nil)
+	for i, transform := range rt.transforms {
+		value, err := transform.expr.Eval(inputRow)
+		if err != nil {
+			return errors.Annotatef(err, "failed to transform column #%d", transform.offset+1)
+		}
+		// the datum may point into the chunk, so copy it out.
+		results[i] = *value.Copy()
+	}
+	for i, transform := range rt.transforms {
+		if transform.offset < len(columnPermutation) {
+			if field := columnPermutation[transform.offset]; field >= 0 && field < len(row) {
+				row[field] = results[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"strings"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *kvSuite) transformTableInfo() *model.TableInfo {
+	intType := *types.NewFieldType(mysql.TypeLong)
+	strType := *types.NewFieldType(mysql.TypeVarchar)
+	return &model.TableInfo{
+		ID:   1,
+		Name: model.NewCIStr("t"),
+		Columns: []*model.ColumnInfo{
+			{ID: 1, Name: model.NewCIStr("id"), State: model.StatePublic, Offset: 0, FieldType: intType},
+			{ID: 2, Name: model.NewCIStr("name"), State: model.StatePublic, Offset: 1, FieldType: strType},
+			{ID: 3, Name: model.NewCIStr("code"), State: model.StatePublic, Offset: 2, FieldType: intType},
+		},
+		State: model.StatePublic,
+	}
+}
+
+func (s *kvSuite) TestRowTransformer(c *C) {
+	tblInfo := s.transformTableInfo()
+	rt, err := NewRowTransformer(tblInfo, map[string]string{
+		"Name": "CONCAT(TRIM(name), '#', id)",
+		"code": "IF(code = 'Y', 1, 0)",
+	}, &SessionOptions{SQLMode: mysql.ModeStrictAllTables})
+	c.Assert(err, IsNil)
+
+	// the data file has the columns in the order (code, name, id).
+	row := []types.Datum{types.NewStringDatum("Y"), types.NewStringDatum("  abc "), types.NewIntDatum(7)}
+	c.Assert(rt.Transform(row, []int{2, 1, 0, -1}), IsNil)
+	c.Assert(row[0].GetInt64(), Equals, int64(1))
+	c.Assert(row[1].GetString(), Equals, "abc#7")
+	c.Assert(row[2].GetInt64(), Equals, int64(7))
+
+	// missing columns are NULL to the expressions, and are not transformed.
+	row = []types.Datum{types.NewStringDatum(" x ")}
+	c.Assert(rt.Transform(row, []int{-1, 0, -1, -1}), IsNil)
+	c.Assert(row[0].IsNull(), IsTrue)
+
+	// a nil transformer does nothing.
+	rt, err = NewRowTransformer(tblInfo, nil, &SessionOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(rt, IsNil)
+	row = []types.Datum{types.NewStringDatum("Y")}
+	c.Assert(rt.Transform(row, []int{0, -1, -1, -1}), IsNil)
+	c.Assert(row[0].GetString(), Equals, "Y")
+}
+
+func (s *kvSuite) TestRowTransformerInvalid(c *C) {
+	tblInfo := s.transformTableInfo()
+	for _, tc := range []struct {
+		transforms map[string]string
+		err        string
+	}{
+		{map[string]string{"missing": "1"}, "unknown column missing in transform"},
+		{map[string]string{"name": "TRIM("}, "invalid transform of column name.*"},
+		{map[string]string{"name": "SLEEP(100)"}, ".*function SLEEP is not allowed in a transform since it may block or have side effects"},
+		{map[string]string{"name": "CONCAT(name, LOAD_FILE('/etc/passwd'))"}, ".*function LOAD_FILE is not allowed in a transform since it may block or have side effects"},
+		{map[string]string{"name": "CONCAT(name, RAND())"}, ".*function RAND is not allowed in a transform since it is not deterministic"},
+		{map[string]string{"name": "UUID()"}, ".*function UUID is not allowed in a transform since it is not deterministic"},
+		{map[string]string{"name": "NOW()"}, ".*function NOW is not allowed in a transform since it is not deterministic"},
+		{map[string]string{"name": "CURRENT_TIMESTAMP"}, ".*function CURRENT_TIMESTAMP is not allowed in a transform since it is not deterministic"},
+		{map[string]string{"name": "LOCALTIME(3)"}, ".*function LOCALTIME is not allowed in a transform since it is not deterministic"},
+		{map[string]string{"name": "UNIX_TIMESTAMP()"}, ".*function UNIX_TIMESTAMP is not allowed in a transform since it is not deterministic"},
+		{map[string]string{"name": "CONNECTION_ID()"}, ".*function CONNECTION_ID is not allowed in a transform since it is not deterministic"},
+		{map[string]string{"name": "MAX(name)"}, ".*aggregate functions are not allowed in a transform"},
+		{map[string]string{"name": strings.Repeat("CONCAT(", 300) + "name" + strings.Repeat(")", 300)}, ".*transform is too complex, at most 256 nodes are allowed"},
+		{map[string]string{"name": "CONCAT(name, @var)"}, ".*only column names, literals and functions are allowed in a transform"},
+		{map[string]string{"name": "(SELECT 1)"}, ".*only column names, literals and functions are allowed in a transform"},
+		{map[string]string{"name": "other"}, "invalid transform of column name.*"},
+	} {
+		_, err := NewRowTransformer(tblInfo, tc.transforms, &SessionOptions{})
+		c.Assert(err, ErrorMatches, tc.err, Commentf("transforms = %v", tc.transforms))
+	}
+}
+
+func (s *kvSuite) TestRowTransformerDeterministic(c *C) {
+	tblInfo := s.transformTableInfo()
+	rt, err := NewRowTransformer(tblInfo, map[string]string{
+		"name": "DATE_FORMAT(FROM_UNIXTIME(UNIX_TIMESTAMP(name)), '%Y')",
+	}, &SessionOptions{})
+	c.Assert(err, IsNil)
+
+	// the chunk holding the fields is reused, which must not leak values
+	// between the rows.
+	for _, tc := range []struct {
+		field    types.Datum
+		expected string
+	}{
+		{types.NewStringDatum("2019-10-01 12:00:00"), "2019"},
+		{types.NewDatum(nil), ""},
+		{types.NewStringDatum("2001-01-01 00:00:00"), "2001"},
+	} {
+		row := []types.Datum{types.NewIntDatum(1), tc.field}
+		c.Assert(rt.Transform(row, []int{0, 1, -1, -1}), IsNil)
+		if tc.expected == "" {
+			c.Assert(row[1].IsNull(), IsTrue)
+		} else {
+			c.Assert(row[1].GetString(), Equals, tc.expected)
+		}
+	}
+}
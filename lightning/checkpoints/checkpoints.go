@@ -26,8 +26,10 @@ import (
 	"strings"
 	"sync"
 
+	gomysql "github.com/go-sql-driver/mysql"
 	"github.com/joho/sqltocsv"
 	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/mysql"
 	"go.uber.org/zap"
 	"modernc.org/mathutil"
 
@@ -132,6 +134,9 @@ func (ccp *ChunkCheckpoint) DeepCopy() *ChunkCheckpoint {
 type EngineCheckpoint struct {
 	Status CheckpointStatus
 	Chunks []*ChunkCheckpoint // a sorted array
+	// Backend is the name of the backend which writes the engine. It is empty
+	// for checkpoints created by older versions of Lightning.
+	Backend string
 }
 
 func (engine *EngineCheckpoint) DeepCopy() *EngineCheckpoint {
@@ -140,8 +145,9 @@ func (engine *EngineCheckpoint) DeepCopy() *EngineCheckpoint {
 		chunks = append(chunks, chunk.DeepCopy())
 	}
 	return &EngineCheckpoint{
-		Status: engine.Status,
-		Chunks: chunks,
+		Status:  engine.Status,
+		Chunks:  chunks,
+		Backend: engine.Backend,
 	}
 }
 
@@ -149,6 +155,10 @@ type TableCheckpoint struct {
 	Status    CheckpointStatus
 	AllocBase int64
 	Engines   map[int32]*EngineCheckpoint
+	// Hash is the `TableSchemaHash` of the table when the checkpoint was
+	// created. It is empty or all zeros for checkpoints created by older
+	// versions of Lightning.
+	Hash []byte
 }
 
 func (cp *TableCheckpoint) DeepCopy() *TableCheckpoint {
@@ -160,6 +170,7 @@ func (cp *TableCheckpoint) DeepCopy() *TableCheckpoint {
 		Status:    cp.Status,
 		AllocBase: cp.AllocBase,
 		Engines:   engines,
+		Hash:      append([]byte(nil), cp.Hash...),
 	}
 }
 func (cp *TableCheckpoint) CountChunks() int {
@@ -337,6 +348,31 @@ type CheckpointsDB interface {
 	DumpTables(ctx context.Context, csv io.Writer) error
 	DumpEngines(ctx context.Context, csv io.Writer) error
 	DumpChunks(ctx context.Context, csv io.Writer) error
+	// ListTables returns the names of all tables having a checkpoint, sorted.
+	ListTables(ctx context.Context) ([]string, error)
+	// PutCheckpoint replaces the entire checkpoint of a table, including all
+	// engines and chunks, by the given one.
+	PutCheckpoint(ctx context.Context, tableName string, cp *TableCheckpoint) error
+}
+
+// TransferCheckpoints copies the checkpoints of all tables from one database
+// into another, e.g. from MySQL into a file. Checkpoints of the same table
+// already in the destination are replaced.
+func TransferCheckpoints(ctx context.Context, from CheckpointsDB, to CheckpointsDB) error {
+	tableNames, err := from.ListTables(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, tableName := range tableNames {
+		cp, err := from.Get(ctx, tableName)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := to.PutCheckpoint(ctx, tableName, cp); err != nil {
+			return errors.Annotatef(err, "failed to transfer checkpoint of %s", tableName)
+		}
+	}
+	return nil
 }
 
 // NullCheckpointsDB is a checkpoints database with no checkpoints.
@@ -370,13 +406,35 @@ type MySQLCheckpointsDB struct {
 	db     *sql.DB
 	schema string
 	taskID int64
+
+	// names of the checkpoint tables, prefixed by the namespace if any.
+	namespace string
+	tableTbl  string
+	engineTbl string
+	chunkTbl  string
 }
 
-func NewMySQLCheckpointsDB(ctx context.Context, db *sql.DB, schemaName string, taskID int64) (*MySQLCheckpointsDB, error) {
+// NewMySQLCheckpointsDB opens the checkpoints stored in the given schema.
+//
+// If `namespace` is not empty, the checkpoint tables are prefixed by it, so
+// that multiple imports can share the same schema without seeing or modifying
+// the checkpoints of each other. The namespace must consist of only ASCII
+// letters, digits and underscores.
+func NewMySQLCheckpointsDB(ctx context.Context, db *sql.DB, schemaName string, namespace string, taskID int64) (*MySQLCheckpointsDB, error) {
 	var escapedSchemaName strings.Builder
 	common.WriteMySQLIdentifier(&escapedSchemaName, schemaName)
 	schema := escapedSchemaName.String()
 
+	cpdb := &MySQLCheckpointsDB{
+		db:        db,
+		schema:    schema,
+		taskID:    taskID,
+		namespace: namespace,
+		tableTbl:  namespacedTableName(namespace, checkpointTableNameTable),
+		engineTbl: namespacedTableName(namespace, checkpointTableNameEngine),
+		chunkTbl:  namespacedTableName(namespace, checkpointTableNameChunk),
+	}
+
 	sql := common.SQLWithRetry{
 		DB:           db,
 		Logger:       log.With(zap.String("schema", schemaName)),
@@ -400,7 +458,7 @@ func NewMySQLCheckpointsDB(ctx context.Context, db *sql.DB, schemaName string, t
 			update_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 			INDEX(task_id)
 		);
-	`, schema, checkpointTableNameTable))
+	`, schema, cpdb.tableTbl))
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -414,11 +472,21 @@ func NewMySQLCheckpointsDB(ctx context.Context, db *sql.DB, schemaName string, t
 			update_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 			PRIMARY KEY(table_name, engine_id DESC)
 		);
-	`, schema, checkpointTableNameEngine))
+	`, schema, cpdb.engineTbl))
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
+	// the `backend` column is added separately so that the engine checkpoints
+	// written by older versions remain readable. these rows have an empty
+	// backend, which is treated as unknown.
+	err = sql.Exec(ctx, "add backend column to engine checkpoints table", fmt.Sprintf(`
+		ALTER TABLE %s.%s ADD COLUMN backend varchar(16) NOT NULL DEFAULT '';
+	`, schema, cpdb.engineTbl))
+	if err != nil && !isDupFieldNameError(err) {
+		return nil, errors.Trace(err)
+	}
+
 	err = sql.Exec(ctx, "create chunks checkpoints table", fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS %s.%s (
 			table_name varchar(261) NOT NULL,
@@ -438,16 +506,25 @@ func NewMySQLCheckpointsDB(ctx context.Context, db *sql.DB, schemaName string, t
 			update_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 			PRIMARY KEY(table_name, engine_id, path(500), offset)
 		);
-	`, schema, checkpointTableNameChunk))
+	`, schema, cpdb.chunkTbl))
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	return &MySQLCheckpointsDB{
-		db:     db,
-		schema: schema,
-		taskID: taskID,
-	}, nil
+	return cpdb, nil
+}
+
+// isDupFieldNameError returns whether the column to be added already exists.
+func isDupFieldNameError(err error) bool {
+	merr, ok := errors.Cause(err).(*gomysql.MySQLError)
+	return ok && merr.Number == mysql.ErrDupFieldName
+}
+
+func namespacedTableName(namespace string, name string) string {
+	if len(namespace) == 0 {
+		return name
+	}
+	return namespace + "_" + name
 }
 
 func (cpdb *MySQLCheckpointsDB) Initialize(ctx context.Context, dbInfo map[string]*TidbDBInfo) error {
@@ -456,19 +533,13 @@ func (cpdb *MySQLCheckpointsDB) Initialize(ctx context.Context, dbInfo map[strin
 
 	s := common.SQLWithRetry{DB: cpdb.db, Logger: log.L()}
 	err := s.Transact(ctx, "insert checkpoints", func(c context.Context, tx *sql.Tx) error {
-		// If `hash` is not the same but the `table_name` duplicates,
-		// the CASE expression will return NULL, which can be used to violate
-		// the NOT NULL requirement of `task_id` column, and caused this INSERT
-		// statement to fail with an irrecoverable error.
-		// We do need to capture the error is display a user friendly message
-		// (multiple nodes cannot import the same table) though.
+		// If the `table_name` duplicates, the `hash` recorded when the
+		// checkpoint was created is kept, so that the caller can compare it
+		// with the current schema to detect whether the table has been altered.
 		stmt, err := tx.PrepareContext(c, fmt.Sprintf(`
 			INSERT INTO %s.%s (task_id, table_name, hash) VALUES (?, ?, ?)
-			ON DUPLICATE KEY UPDATE task_id = CASE
-				WHEN hash = VALUES(hash)
-				THEN VALUES(task_id)
-			END;
-		`, cpdb.schema, checkpointTableNameTable))
+			ON DUPLICATE KEY UPDATE task_id = VALUES(task_id);
+		`, cpdb.schema, cpdb.tableTbl))
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -477,7 +548,11 @@ func (cpdb *MySQLCheckpointsDB) Initialize(ctx context.Context, dbInfo map[strin
 		for _, db := range dbInfo {
 			for _, table := range db.Tables {
 				tableName := common.UniqueTable(db.Name, table.Name)
-				_, err = stmt.ExecContext(c, cpdb.taskID, tableName, 0)
+				hash := TableSchemaHash(table.Core)
+				if hash == nil {
+					hash = []byte{}
+				}
+				_, err = stmt.ExecContext(c, cpdb.taskID, tableName, hash)
 				if err != nil {
 					return errors.Trace(err)
 				}
@@ -510,8 +585,8 @@ func (cpdb *MySQLCheckpointsDB) Get(ctx context.Context, tableName string) (*Tab
 		// 1. Populate the engines.
 
 		engineQuery := fmt.Sprintf(`
-			SELECT engine_id, status FROM %s.%s WHERE table_name = ? ORDER BY engine_id DESC;
-		`, cpdb.schema, checkpointTableNameEngine)
+			SELECT engine_id, status, backend FROM %s.%s WHERE table_name = ? ORDER BY engine_id DESC;
+		`, cpdb.schema, cpdb.engineTbl)
 		engineRows, err := tx.QueryContext(c, engineQuery, tableName)
 		if err != nil {
 			return errors.Trace(err)
@@ -521,12 +596,14 @@ func (cpdb *MySQLCheckpointsDB) Get(ctx context.Context, tableName string) (*Tab
 			var (
 				engineID int32
 				status   uint8
+				backend  string
 			)
-			if err := engineRows.Scan(&engineID, &status); err != nil {
+			if err := engineRows.Scan(&engineID, &status, &backend); err != nil {
 				return errors.Trace(err)
 			}
 			cp.Engines[engineID] = &EngineCheckpoint{
-				Status: CheckpointStatus(status),
+				Status:  CheckpointStatus(status),
+				Backend: backend,
 			}
 		}
 		if err := engineRows.Err(); err != nil {
@@ -542,7 +619,7 @@ func (cpdb *MySQLCheckpointsDB) Get(ctx context.Context, tableName string) (*Tab
 				kvc_bytes, kvc_kvs, kvc_checksum, unix_timestamp(create_time)
 			FROM %s.%s WHERE table_name = ?
 			ORDER BY engine_id, path, offset;
-		`, cpdb.schema, checkpointTableNameChunk)
+		`, cpdb.schema, cpdb.chunkTbl)
 		chunkRows, err := tx.QueryContext(c, chunkQuery, tableName)
 		if err != nil {
 			return errors.Trace(err)
@@ -577,12 +654,12 @@ func (cpdb *MySQLCheckpointsDB) Get(ctx context.Context, tableName string) (*Tab
 		// 3. Fill in the remaining table info
 
 		tableQuery := fmt.Sprintf(`
-			SELECT status, alloc_base FROM %s.%s WHERE table_name = ?
-		`, cpdb.schema, checkpointTableNameTable)
+			SELECT status, alloc_base, hash FROM %s.%s WHERE table_name = ?
+		`, cpdb.schema, cpdb.tableTbl)
 		tableRow := tx.QueryRowContext(c, tableQuery, tableName)
 
 		var status uint8
-		if err := tableRow.Scan(&status, &cp.AllocBase); err != nil {
+		if err := tableRow.Scan(&status, &cp.AllocBase, &cp.Hash); err != nil {
 			return errors.Trace(err)
 		}
 		cp.Status = CheckpointStatus(status)
@@ -602,8 +679,8 @@ func (cpdb *MySQLCheckpointsDB) InsertEngineCheckpoints(ctx context.Context, tab
 	}
 	err := s.Transact(ctx, "update engine checkpoints", func(c context.Context, tx *sql.Tx) error {
 		engineStmt, err := tx.PrepareContext(c, fmt.Sprintf(`
-			REPLACE INTO %s.%s (table_name, engine_id, status) VALUES (?, ?, ?);
-		`, cpdb.schema, checkpointTableNameEngine))
+			REPLACE INTO %s.%s (table_name, engine_id, status, backend) VALUES (?, ?, ?, ?);
+		`, cpdb.schema, cpdb.engineTbl))
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -621,14 +698,14 @@ func (cpdb *MySQLCheckpointsDB) InsertEngineCheckpoints(ctx context.Context, tab
 				?, ?, ?, ?,
 				0, 0, 0, from_unixtime(?)
 			);
-		`, cpdb.schema, checkpointTableNameChunk))
+		`, cpdb.schema, cpdb.chunkTbl))
 		if err != nil {
 			return errors.Trace(err)
 		}
 		defer chunkStmt.Close()
 
 		for engineID, engine := range checkpoints {
-			_, err = engineStmt.ExecContext(c, tableName, engineID, engine.Status)
+			_, err = engineStmt.ExecContext(c, tableName, engineID, engine.Status, engine.Backend)
 			if err != nil {
 				return errors.Trace(err)
 			}
@@ -658,16 +735,16 @@ func (cpdb *MySQLCheckpointsDB) Update(checkpointDiffs map[string]*TableCheckpoi
 	chunkQuery := fmt.Sprintf(`
 		UPDATE %s.%s SET pos = ?, prev_rowid_max = ?, kvc_bytes = ?, kvc_kvs = ?, kvc_checksum = ?
 		WHERE (table_name, engine_id, path, offset) = (?, ?, ?, ?);
-	`, cpdb.schema, checkpointTableNameChunk)
+	`, cpdb.schema, cpdb.chunkTbl)
 	rebaseQuery := fmt.Sprintf(`
 		UPDATE %s.%s SET alloc_base = GREATEST(?, alloc_base) WHERE table_name = ?;
-	`, cpdb.schema, checkpointTableNameTable)
+	`, cpdb.schema, cpdb.tableTbl)
 	tableStatusQuery := fmt.Sprintf(`
 		UPDATE %s.%s SET status = ? WHERE table_name = ?;
-	`, cpdb.schema, checkpointTableNameTable)
+	`, cpdb.schema, cpdb.tableTbl)
 	engineStatusQuery := fmt.Sprintf(`
 		UPDATE %s.%s SET status = ? WHERE (table_name, engine_id) = (?, ?);
-	`, cpdb.schema, checkpointTableNameEngine)
+	`, cpdb.schema, cpdb.engineTbl)
 
 	s := common.SQLWithRetry{DB: cpdb.db, Logger: log.L()}
 	err := s.Transact(context.Background(), "update checkpoints", func(c context.Context, tx *sql.Tx) error {
@@ -796,11 +873,11 @@ func (cpdb *FileCheckpointsDB) Initialize(ctx context.Context, dbInfo map[string
 			tableName := common.UniqueTable(db.Name, table.Name)
 			if _, ok := cpdb.checkpoints.Checkpoints[tableName]; !ok {
 				cpdb.checkpoints.Checkpoints[tableName] = &TableCheckpointModel{
+					Hash:    TableSchemaHash(table.Core),
 					Status:  uint32(CheckpointStatusLoaded),
 					Engines: map[int32]*EngineCheckpointModel{},
 				}
 			}
-			// TODO check if hash matches
 		}
 	}
 
@@ -827,12 +904,14 @@ func (cpdb *FileCheckpointsDB) Get(_ context.Context, tableName string) (*TableC
 		Status:    CheckpointStatus(tableModel.Status),
 		AllocBase: tableModel.AllocBase,
 		Engines:   make(map[int32]*EngineCheckpoint, len(tableModel.Engines)),
+		Hash:      tableModel.Hash,
 	}
 
 	for engineID, engineModel := range tableModel.Engines {
 		engine := &EngineCheckpoint{
-			Status: CheckpointStatus(engineModel.Status),
-			Chunks: make([]*ChunkCheckpoint, 0, len(engineModel.Chunks)),
+			Status:  CheckpointStatus(engineModel.Status),
+			Chunks:  make([]*ChunkCheckpoint, 0, len(engineModel.Chunks)),
+			Backend: engineModel.Backend,
 		}
 
 		for _, chunkModel := range engineModel.Chunks {
@@ -874,8 +953,9 @@ func (cpdb *FileCheckpointsDB) InsertEngineCheckpoints(_ context.Context, tableN
 	tableModel := cpdb.checkpoints.Checkpoints[tableName]
 	for engineID, engine := range checkpoints {
 		engineModel := &EngineCheckpointModel{
-			Status: uint32(CheckpointStatusLoaded),
-			Chunks: make(map[string]*ChunkCheckpointModel),
+			Status:  uint32(CheckpointStatusLoaded),
+			Chunks:  make(map[string]*ChunkCheckpointModel),
+			Backend: engine.Backend,
 		}
 		for _, value := range engine.Chunks {
 			key := value.Key.String()
@@ -959,6 +1039,13 @@ func (*NullCheckpointsDB) DumpChunks(context.Context, io.Writer) error {
 	return errors.Trace(cannotManageNullDB)
 }
 
+func (*NullCheckpointsDB) ListTables(context.Context) ([]string, error) {
+	return nil, errors.Trace(cannotManageNullDB)
+}
+func (*NullCheckpointsDB) PutCheckpoint(context.Context, string, *TableCheckpoint) error {
+	return errors.Trace(cannotManageNullDB)
+}
+
 func (cpdb *MySQLCheckpointsDB) RemoveCheckpoint(ctx context.Context, tableName string) error {
 	s := common.SQLWithRetry{
 		DB:     cpdb.db,
@@ -966,12 +1053,21 @@ func (cpdb *MySQLCheckpointsDB) RemoveCheckpoint(ctx context.Context, tableName
 	}
 
 	if tableName == "all" {
-		return s.Exec(ctx, "remove all checkpoints", "DROP SCHEMA "+cpdb.schema)
+		if len(cpdb.namespace) == 0 {
+			return s.Exec(ctx, "remove all checkpoints", "DROP SCHEMA "+cpdb.schema)
+		}
+		// the schema may be shared with other namespaces, so only drop the
+		// tables belonging to this one.
+		dropQuery := fmt.Sprintf(
+			"DROP TABLE IF EXISTS %[1]s.%[2]s, %[1]s.%[3]s, %[1]s.%[4]s",
+			cpdb.schema, cpdb.chunkTbl, cpdb.engineTbl, cpdb.tableTbl,
+		)
+		return s.Exec(ctx, "remove all checkpoints", dropQuery)
 	}
 
-	deleteChunkQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE table_name = ?", cpdb.schema, checkpointTableNameChunk)
-	deleteEngineQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE table_name = ?", cpdb.schema, checkpointTableNameEngine)
-	deleteTableQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE table_name = ?", cpdb.schema, checkpointTableNameTable)
+	deleteChunkQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE table_name = ?", cpdb.schema, cpdb.chunkTbl)
+	deleteEngineQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE table_name = ?", cpdb.schema, cpdb.engineTbl)
+	deleteTableQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE table_name = ?", cpdb.schema, cpdb.tableTbl)
 
 	return s.Transact(ctx, "remove checkpoints", func(c context.Context, tx *sql.Tx) error {
 		if _, e := tx.ExecContext(c, deleteChunkQuery, tableName); e != nil {
@@ -998,9 +1094,9 @@ func (cpdb *MySQLCheckpointsDB) MoveCheckpoints(ctx context.Context, taskID int6
 	}
 
 	createSchemaQuery := "CREATE SCHEMA IF NOT EXISTS " + newSchema
-	moveChunkQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, checkpointTableNameChunk)
-	moveEngineQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, checkpointTableNameEngine)
-	moveTableQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, checkpointTableNameTable)
+	moveChunkQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, cpdb.chunkTbl)
+	moveEngineQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, cpdb.engineTbl)
+	moveTableQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, cpdb.tableTbl)
 
 	if e := s.Exec(ctx, "create backup checkpoints schema", createSchemaQuery); e != nil {
 		return e
@@ -1029,10 +1125,10 @@ func (cpdb *MySQLCheckpointsDB) IgnoreErrorCheckpoint(ctx context.Context, table
 
 	engineQuery := fmt.Sprintf(`
 		UPDATE %s.%s SET status = %d WHERE %s = ? AND status <= %d;
-	`, cpdb.schema, checkpointTableNameEngine, CheckpointStatusLoaded, colName, CheckpointStatusMaxInvalid)
+	`, cpdb.schema, cpdb.engineTbl, CheckpointStatusLoaded, colName, CheckpointStatusMaxInvalid)
 	tableQuery := fmt.Sprintf(`
 		UPDATE %s.%s SET status = %d WHERE %s = ? AND status <= %d;
-	`, cpdb.schema, checkpointTableNameTable, CheckpointStatusLoaded, colName, CheckpointStatusMaxInvalid)
+	`, cpdb.schema, cpdb.tableTbl, CheckpointStatusLoaded, colName, CheckpointStatusMaxInvalid)
 
 	s := common.SQLWithRetry{
 		DB:     cpdb.db,
@@ -1072,16 +1168,16 @@ func (cpdb *MySQLCheckpointsDB) DestroyErrorCheckpoint(ctx context.Context, tabl
 		LEFT JOIN %[1]s.%[5]s e ON t.table_name = e.table_name
 		WHERE %[2]s = ? AND t.status <= %[3]d
 		GROUP BY t.table_name;
-	`, cpdb.schema, aliasedColName, CheckpointStatusMaxInvalid, checkpointTableNameTable, checkpointTableNameEngine)
+	`, cpdb.schema, aliasedColName, CheckpointStatusMaxInvalid, cpdb.tableTbl, cpdb.engineTbl)
 	deleteChunkQuery := fmt.Sprintf(`
 		DELETE FROM %[1]s.%[4]s WHERE table_name IN (SELECT table_name FROM %[1]s.%[5]s WHERE %[2]s = ? AND status <= %[3]d)
-	`, cpdb.schema, colName, CheckpointStatusMaxInvalid, checkpointTableNameChunk, checkpointTableNameTable)
+	`, cpdb.schema, colName, CheckpointStatusMaxInvalid, cpdb.chunkTbl, cpdb.tableTbl)
 	deleteEngineQuery := fmt.Sprintf(`
 		DELETE FROM %[1]s.%[4]s WHERE table_name IN (SELECT table_name FROM %[1]s.%[5]s WHERE %[2]s = ? AND status <= %[3]d)
-	`, cpdb.schema, colName, CheckpointStatusMaxInvalid, checkpointTableNameEngine, checkpointTableNameTable)
+	`, cpdb.schema, colName, CheckpointStatusMaxInvalid, cpdb.engineTbl, cpdb.tableTbl)
 	deleteTableQuery := fmt.Sprintf(`
 		DELETE FROM %s.%s WHERE %s = ? AND status <= %d
-	`, cpdb.schema, checkpointTableNameTable, colName, CheckpointStatusMaxInvalid)
+	`, cpdb.schema, cpdb.tableTbl, colName, CheckpointStatusMaxInvalid)
 
 	var targetTables []DestroyedTableCheckpoint
 
@@ -1138,7 +1234,7 @@ func (cpdb *MySQLCheckpointsDB) DumpTables(ctx context.Context, writer io.Writer
 			create_time,
 			update_time
 		FROM %s.%s;
-	`, cpdb.schema, checkpointTableNameTable))
+	`, cpdb.schema, cpdb.tableTbl))
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -1153,10 +1249,11 @@ func (cpdb *MySQLCheckpointsDB) DumpEngines(ctx context.Context, writer io.Write
 			table_name,
 			engine_id,
 			status,
+			backend,
 			create_time,
 			update_time
 		FROM %s.%s;
-	`, cpdb.schema, checkpointTableNameEngine))
+	`, cpdb.schema, cpdb.engineTbl))
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -1182,7 +1279,7 @@ func (cpdb *MySQLCheckpointsDB) DumpChunks(ctx context.Context, writer io.Writer
 			create_time,
 			update_time
 		FROM %s.%s;
-	`, cpdb.schema, checkpointTableNameChunk))
+	`, cpdb.schema, cpdb.chunkTbl))
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -1191,6 +1288,105 @@ func (cpdb *MySQLCheckpointsDB) DumpChunks(ctx context.Context, writer io.Writer
 	return errors.Trace(sqltocsv.Write(writer, rows))
 }
 
+func (cpdb *MySQLCheckpointsDB) ListTables(ctx context.Context) ([]string, error) {
+	rows, err := cpdb.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT table_name FROM %s.%s ORDER BY table_name;
+	`, cpdb.schema, cpdb.tableTbl))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer rows.Close()
+
+	var tableNames []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, errors.Trace(err)
+		}
+		tableNames = append(tableNames, tableName)
+	}
+	return tableNames, errors.Trace(rows.Err())
+}
+
+func (cpdb *MySQLCheckpointsDB) PutCheckpoint(ctx context.Context, tableName string, cp *TableCheckpoint) error {
+	s := common.SQLWithRetry{
+		DB:     cpdb.db,
+		Logger: log.With(zap.String("table", tableName)),
+	}
+	return s.Transact(ctx, "put checkpoint", func(c context.Context, tx *sql.Tx) error {
+		deleteChunkQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE table_name = ?", cpdb.schema, cpdb.chunkTbl)
+		if _, err := tx.ExecContext(c, deleteChunkQuery, tableName); err != nil {
+			return errors.Trace(err)
+		}
+		deleteEngineQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE table_name = ?", cpdb.schema, cpdb.engineTbl)
+		if _, err := tx.ExecContext(c, deleteEngineQuery, tableName); err != nil {
+			return errors.Trace(err)
+		}
+
+		hash := cp.Hash
+		if hash == nil {
+			hash = []byte{}
+		}
+		tableQuery := fmt.Sprintf(`
+			REPLACE INTO %s.%s (task_id, table_name, hash, status, alloc_base) VALUES (?, ?, ?, ?, ?);
+		`, cpdb.schema, cpdb.tableTbl)
+		if _, err := tx.ExecContext(c, tableQuery, cpdb.taskID, tableName, hash, cp.Status, cp.AllocBase); err != nil {
+			return errors.Trace(err)
+		}
+
+		engineStmt, err := tx.PrepareContext(c, fmt.Sprintf(`
+			INSERT INTO %s.%s (table_name, engine_id, status, backend) VALUES (?, ?, ?, ?);
+		`, cpdb.schema, cpdb.engineTbl))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer engineStmt.Close()
+
+		chunkStmt, err := tx.PrepareContext(c, fmt.Sprintf(`
+			INSERT INTO %s.%s (
+				table_name, engine_id,
+				path, offset, columns, should_include_row_id,
+				pos, end_offset, prev_rowid_max, rowid_max,
+				kvc_bytes, kvc_kvs, kvc_checksum, create_time
+			) VALUES (
+				?, ?,
+				?, ?, ?, FALSE,
+				?, ?, ?, ?,
+				?, ?, ?, from_unixtime(?)
+			);
+		`, cpdb.schema, cpdb.chunkTbl))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer chunkStmt.Close()
+
+		for engineID, engine := range cp.Engines {
+			if _, err := engineStmt.ExecContext(c, tableName, engineID, engine.Status, engine.Backend); err != nil {
+				return errors.Trace(err)
+			}
+			for _, value := range engine.Chunks {
+				columnPerm := value.ColumnPermutation
+				if columnPerm == nil {
+					columnPerm = []int{}
+				}
+				columns, err := json.Marshal(columnPerm)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				if _, err := chunkStmt.ExecContext(
+					c, tableName, engineID,
+					value.Key.Path, value.Key.Offset, columns,
+					value.Chunk.Offset, value.Chunk.EndOffset, value.Chunk.PrevRowIDMax, value.Chunk.RowIDMax,
+					value.Checksum.SumSize(), value.Checksum.SumKVS(), value.Checksum.Sum(), value.Timestamp,
+				); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
 func (cpdb *FileCheckpointsDB) RemoveCheckpoint(_ context.Context, tableName string) error {
 	cpdb.lock.Lock()
 	defer cpdb.lock.Unlock()
@@ -1284,3 +1480,57 @@ func (cpdb *FileCheckpointsDB) DumpEngines(context.Context, io.Writer) error {
 func (cpdb *FileCheckpointsDB) DumpChunks(context.Context, io.Writer) error {
 	return errors.Errorf("dumping file checkpoint into CSV not unsupported, you may copy %s instead", cpdb.path)
 }
+
+func (cpdb *FileCheckpointsDB) ListTables(context.Context) ([]string, error) {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	tableNames := make([]string, 0, len(cpdb.checkpoints.Checkpoints))
+	for tableName := range cpdb.checkpoints.Checkpoints {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	return tableNames, nil
+}
+
+func (cpdb *FileCheckpointsDB) PutCheckpoint(_ context.Context, tableName string, cp *TableCheckpoint) error {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	tableModel := &TableCheckpointModel{
+		Hash:      cp.Hash,
+		Status:    uint32(cp.Status),
+		AllocBase: cp.AllocBase,
+		Engines:   make(map[int32]*EngineCheckpointModel, len(cp.Engines)),
+	}
+	for engineID, engine := range cp.Engines {
+		engineModel := &EngineCheckpointModel{
+			Status:  uint32(engine.Status),
+			Chunks:  make(map[string]*ChunkCheckpointModel, len(engine.Chunks)),
+			Backend: engine.Backend,
+		}
+		for _, value := range engine.Chunks {
+			colPerm := make([]int32, 0, len(value.ColumnPermutation))
+			for _, c := range value.ColumnPermutation {
+				colPerm = append(colPerm, int32(c))
+			}
+			engineModel.Chunks[value.Key.String()] = &ChunkCheckpointModel{
+				Path:              value.Key.Path,
+				Offset:            value.Key.Offset,
+				ColumnPermutation: colPerm,
+				Pos:               value.Chunk.Offset,
+				EndOffset:         value.Chunk.EndOffset,
+				PrevRowidMax:      value.Chunk.PrevRowIDMax,
+				RowidMax:          value.Chunk.RowIDMax,
+				KvcBytes:          value.Checksum.SumSize(),
+				KvcKvs:            value.Checksum.SumKVS(),
+				KvcChecksum:       value.Checksum.Sum(),
+				Timestamp:         value.Timestamp,
+			}
+		}
+		tableModel.Engines[engineID] = engineModel
+	}
+	cpdb.checkpoints.Checkpoints[tableName] = tableModel
+
+	return errors.Trace(cpdb.save())
+}
@@ -53,7 +53,8 @@ func (s *cpFileSuite) SetUpTest(c *C) {
 
 	err = cpdb.InsertEngineCheckpoints(ctx, "`db1`.`t2`", map[int32]*checkpoints.EngineCheckpoint{
 		0: {
-			Status: checkpoints.CheckpointStatusLoaded,
+			Status:  checkpoints.CheckpointStatusLoaded,
+			Backend: "importer",
 			Chunks: []*checkpoints.ChunkCheckpoint{{
 				Key: checkpoints.ChunkCheckpointKey{
 					Path:   "/tmp/path/1.sql",
@@ -146,7 +147,8 @@ func (s *cpFileSuite) TestGet(c *C) {
 				Chunks: []*checkpoints.ChunkCheckpoint{},
 			},
 			0: {
-				Status: checkpoints.CheckpointStatusImported,
+				Status:  checkpoints.CheckpointStatusImported,
+				Backend: "importer",
 				Chunks: []*checkpoints.ChunkCheckpoint{{
 					Key: checkpoints.ChunkCheckpointKey{
 						Path:   "/tmp/path/1.sql",
@@ -184,6 +186,30 @@ func (s *cpFileSuite) TestGet(c *C) {
 	})
 }
 
+func (s *cpFileSuite) TestTransferCheckpoints(c *C) {
+	ctx := context.Background()
+
+	tableNames, err := s.cpdb.ListTables(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tableNames, DeepEquals, []string{"`db1`.`t1`", "`db1`.`t2`", "`db2`.`t3`"})
+
+	exportPath := path.Join(c.MkDir(), "exported.pb")
+	exported := checkpoints.NewFileCheckpointsDB(exportPath)
+	c.Assert(checkpoints.TransferCheckpoints(ctx, s.cpdb, exported), IsNil)
+	c.Assert(exported.Close(), IsNil)
+
+	// the exported file can be opened as a checkpoint file directly.
+	reopened := checkpoints.NewFileCheckpointsDB(exportPath)
+	defer reopened.Close()
+	for _, tableName := range tableNames {
+		expected, err := s.cpdb.Get(ctx, tableName)
+		c.Assert(err, IsNil)
+		actual, err := reopened.Get(ctx, tableName)
+		c.Assert(err, IsNil)
+		c.Assert(actual, DeepEquals, expected)
+	}
+}
+
 func (s *cpFileSuite) TestRemoveAllCheckpoints(c *C) {
 	ctx := context.Background()
 
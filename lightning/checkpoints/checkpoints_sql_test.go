@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
 	. "github.com/pingcap/check"
+	tmysql "github.com/pingcap/parser/mysql"
 	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
 	"github.com/pingcap/tidb-lightning/lightning/mydump"
 	"github.com/pingcap/tidb-lightning/lightning/verification"
@@ -38,11 +40,14 @@ func (s *cpSQLSuite) SetUpTest(c *C) {
 	s.mock.
 		ExpectExec("CREATE TABLE IF NOT EXISTS `mock-schema`\\.engine_v\\d+ .+").
 		WillReturnResult(sqlmock.NewResult(3, 1))
+	s.mock.
+		ExpectExec("ALTER TABLE `mock-schema`\\.engine_v\\d+ ADD COLUMN backend .+").
+		WillReturnResult(sqlmock.NewResult(0, 0))
 	s.mock.
 		ExpectExec("CREATE TABLE IF NOT EXISTS `mock-schema`\\.chunk_v\\d+ .+").
 		WillReturnResult(sqlmock.NewResult(4, 1))
 
-	cpdb, err := checkpoints.NewMySQLCheckpointsDB(context.Background(), s.db, "mock-schema", 1234)
+	cpdb, err := checkpoints.NewMySQLCheckpointsDB(context.Background(), s.db, "mock-schema", "", 1234)
 	c.Assert(err, IsNil)
 	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
 	s.cpdb = cpdb
@@ -101,11 +106,11 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 		ExpectPrepare("REPLACE INTO `mock-schema`\\.engine_v\\d+ .+")
 	insertEngineStmt.
 		ExpectExec().
-		WithArgs("`db1`.`t2`", 0, 30).
+		WithArgs("`db1`.`t2`", 0, 30, "importer").
 		WillReturnResult(sqlmock.NewResult(8, 1))
 	insertEngineStmt.
 		ExpectExec().
-		WithArgs("`db1`.`t2`", -1, 30).
+		WithArgs("`db1`.`t2`", -1, 30, "importer").
 		WillReturnResult(sqlmock.NewResult(9, 1))
 	insertChunkStmt := s.mock.
 		ExpectPrepare("REPLACE INTO `mock-schema`\\.chunk_v\\d+ .+")
@@ -118,7 +123,8 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 	s.mock.MatchExpectationsInOrder(false)
 	err = cpdb.InsertEngineCheckpoints(ctx, "`db1`.`t2`", map[int32]*checkpoints.EngineCheckpoint{
 		0: {
-			Status: checkpoints.CheckpointStatusLoaded,
+			Status:  checkpoints.CheckpointStatusLoaded,
+			Backend: "importer",
 			Chunks: []*checkpoints.ChunkCheckpoint{{
 				Key: checkpoints.ChunkCheckpointKey{
 					Path:   "/tmp/path/1.sql",
@@ -134,8 +140,9 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 			}},
 		},
 		-1: {
-			Status: checkpoints.CheckpointStatusLoaded,
-			Chunks: nil,
+			Status:  checkpoints.CheckpointStatusLoaded,
+			Chunks:  nil,
+			Backend: "importer",
 		},
 	})
 	s.mock.MatchExpectationsInOrder(true)
@@ -206,9 +213,9 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 		ExpectQuery("SELECT .+ FROM `mock-schema`\\.engine_v\\d+").
 		WithArgs("`db1`.`t2`").
 		WillReturnRows(
-			sqlmock.NewRows([]string{"engine_id", "status"}).
-				AddRow(0, 120).
-				AddRow(-1, 30),
+			sqlmock.NewRows([]string{"engine_id", "status", "backend"}).
+				AddRow(0, 120, "importer").
+				AddRow(-1, 30, "importer"),
 		)
 	s.mock.
 		ExpectQuery("SELECT (?s:.+) FROM `mock-schema`\\.chunk_v\\d+").
@@ -229,8 +236,8 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 		ExpectQuery("SELECT .+ FROM `mock-schema`\\.table_v\\d+").
 		WithArgs("`db1`.`t2`").
 		WillReturnRows(
-			sqlmock.NewRows([]string{"status", "alloc_base"}).
-				AddRow(60, 132861),
+			sqlmock.NewRows([]string{"status", "alloc_base", "hash"}).
+				AddRow(60, 132861, []byte("0123456789abcdef0123456789abcdef")),
 		)
 	s.mock.ExpectCommit()
 
@@ -239,10 +246,12 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 	c.Assert(cp, DeepEquals, &checkpoints.TableCheckpoint{
 		Status:    checkpoints.CheckpointStatusAllWritten,
 		AllocBase: 132861,
+		Hash:      []byte("0123456789abcdef0123456789abcdef"),
 		Engines: map[int32]*checkpoints.EngineCheckpoint{
-			-1: {Status: checkpoints.CheckpointStatusLoaded},
+			-1: {Status: checkpoints.CheckpointStatusLoaded, Backend: "importer"},
 			0: {
-				Status: checkpoints.CheckpointStatusImported,
+				Status:  checkpoints.CheckpointStatusImported,
+				Backend: "importer",
 				Chunks: []*checkpoints.ChunkCheckpoint{{
 					Key: checkpoints.ChunkCheckpointKey{
 						Path:   "/tmp/path/1.sql",
@@ -271,6 +280,54 @@ func (s *cpSQLSuite) TestRemoveAllCheckpoints(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *cpSQLSuite) TestNamespace(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+
+	mock.
+		ExpectExec("CREATE DATABASE IF NOT EXISTS `mock-schema`").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.
+		ExpectExec("CREATE TABLE IF NOT EXISTS `mock-schema`\\.team_a_table_v\\d+ .+").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.
+		ExpectExec("CREATE TABLE IF NOT EXISTS `mock-schema`\\.team_a_engine_v\\d+ .+").
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	// the backend column already exists, which is not an error.
+	mock.
+		ExpectExec("ALTER TABLE `mock-schema`\\.team_a_engine_v\\d+ ADD COLUMN backend .+").
+		WillReturnError(&mysql.MySQLError{Number: tmysql.ErrDupFieldName, Message: "Duplicate column name 'backend'"})
+	mock.
+		ExpectExec("CREATE TABLE IF NOT EXISTS `mock-schema`\\.team_a_chunk_v\\d+ .+").
+		WillReturnResult(sqlmock.NewResult(4, 1))
+
+	cpdb, err := checkpoints.NewMySQLCheckpointsDB(context.Background(), db, "mock-schema", "team_a", 1234)
+	c.Assert(err, IsNil)
+
+	// every query only touches the tables of the namespace.
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("UPDATE `mock-schema`\\.team_a_engine_v\\d+ SET status = 30 WHERE table_name = \\? AND status <= 25").
+		WithArgs("`db1`.`t2`").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("UPDATE `mock-schema`\\.team_a_table_v\\d+ SET status = 30 WHERE table_name = \\? AND status <= 25").
+		WithArgs("`db1`.`t2`").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	c.Assert(cpdb.IgnoreErrorCheckpoint(context.Background(), "`db1`.`t2`"), IsNil)
+
+	// removing all checkpoints must not drop the schema shared with others.
+	mock.
+		ExpectExec("DROP TABLE IF EXISTS `mock-schema`\\.team_a_chunk_v\\d+, `mock-schema`\\.team_a_engine_v\\d+, `mock-schema`\\.team_a_table_v\\d+").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	c.Assert(cpdb.RemoveCheckpoint(context.Background(), "all"), IsNil)
+
+	mock.ExpectClose()
+	c.Assert(cpdb.Close(), IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
 func (s *cpSQLSuite) TestRemoveOneCheckpoint(c *C) {
 	s.mock.ExpectBegin()
 	s.mock.
@@ -418,18 +475,18 @@ func (s *cpSQLSuite) TestDump(c *C) {
 	s.mock.
 		ExpectQuery("SELECT .+ FROM `mock-schema`\\.engine_v\\d+").
 		WillReturnRows(
-			sqlmock.NewRows([]string{"table_name", "engine_id", "status", "create_time", "update_time"}).
-				AddRow("`db1`.`t2`", -1, 30, t, t).
-				AddRow("`db1`.`t2`", 0, 120, t, t),
+			sqlmock.NewRows([]string{"table_name", "engine_id", "status", "backend", "create_time", "update_time"}).
+				AddRow("`db1`.`t2`", -1, 30, "importer", t, t).
+				AddRow("`db1`.`t2`", 0, 120, "importer", t, t),
 		)
 
 	csvBuilder.Reset()
 	err = s.cpdb.DumpEngines(ctx, &csvBuilder)
 	c.Assert(err, IsNil)
 	c.Assert(csvBuilder.String(), Equals,
-		"table_name,engine_id,status,create_time,update_time\n"+
-			"`db1`.`t2`,-1,30,2019-04-18 02:45:55 +0000 UTC,2019-04-18 02:45:55 +0000 UTC\n"+
-			"`db1`.`t2`,0,120,2019-04-18 02:45:55 +0000 UTC,2019-04-18 02:45:55 +0000 UTC\n",
+		"table_name,engine_id,status,backend,create_time,update_time\n"+
+			"`db1`.`t2`,-1,30,importer,2019-04-18 02:45:55 +0000 UTC,2019-04-18 02:45:55 +0000 UTC\n"+
+			"`db1`.`t2`,0,120,importer,2019-04-18 02:45:55 +0000 UTC,2019-04-18 02:45:55 +0000 UTC\n",
 	)
 
 	s.mock.
@@ -467,3 +524,69 @@ func (s *cpSQLSuite) TestMoveCheckpoints(c *C) {
 	err := s.cpdb.MoveCheckpoints(ctx, 12345678)
 	c.Assert(err, IsNil)
 }
+
+func (s *cpSQLSuite) TestListTables(c *C) {
+	s.mock.
+		ExpectQuery("SELECT table_name FROM `mock-schema`\\.table_v\\d+ ORDER BY table_name").
+		WillReturnRows(sqlmock.NewRows([]string{"table_name"}).AddRow("`db1`.`t1`").AddRow("`db1`.`t2`"))
+
+	tableNames, err := s.cpdb.ListTables(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(tableNames, DeepEquals, []string{"`db1`.`t1`", "`db1`.`t2`"})
+}
+
+func (s *cpSQLSuite) TestPutCheckpoint(c *C) {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectExec("DELETE FROM `mock-schema`\\.chunk_v\\d+ WHERE table_name = \\?").
+		WithArgs("`db1`.`t2`").
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	s.mock.
+		ExpectExec("DELETE FROM `mock-schema`\\.engine_v\\d+ WHERE table_name = \\?").
+		WithArgs("`db1`.`t2`").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	s.mock.
+		ExpectExec("REPLACE INTO `mock-schema`\\.table_v\\d+ .+").
+		WithArgs(1234, "`db1`.`t2`", []byte{1, 2}, 60, 132861).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	engineStmt := s.mock.ExpectPrepare("INSERT INTO `mock-schema`\\.engine_v\\d+ .+")
+	chunkStmt := s.mock.ExpectPrepare("INSERT INTO `mock-schema`\\.chunk_v\\d+ .+")
+	engineStmt.ExpectExec().
+		WithArgs("`db1`.`t2`", 0, 120, "tidb").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	chunkStmt.ExpectExec().
+		WithArgs(
+			"`db1`.`t2`", 0,
+			"/tmp/path/1.sql", 0, []byte("[0,-1]"),
+			55904, 102400, 681, 5000,
+			4491, 586, 486070148917, 1234567894,
+		).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	err := s.cpdb.PutCheckpoint(context.Background(), "`db1`.`t2`", &checkpoints.TableCheckpoint{
+		Status:    checkpoints.CheckpointStatusAllWritten,
+		AllocBase: 132861,
+		Hash:      []byte{1, 2},
+		Engines: map[int32]*checkpoints.EngineCheckpoint{
+			0: {
+				Status:  checkpoints.CheckpointStatusImported,
+				Backend: "tidb",
+				Chunks: []*checkpoints.ChunkCheckpoint{{
+					Key:               checkpoints.ChunkCheckpointKey{Path: "/tmp/path/1.sql", Offset: 0},
+					ColumnPermutation: []int{0, -1},
+					Chunk: mydump.Chunk{
+						Offset:       55904,
+						EndOffset:    102400,
+						PrevRowIDMax: 681,
+						RowIDMax:     5000,
+					},
+					Checksum:  verification.MakeKVChecksum(4491, 586, 486070148917),
+					Timestamp: 1234567894,
+				}},
+			},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
+}
@@ -1,10 +1,15 @@
 package checkpoints
 
 import (
+	"fmt"
 	"path/filepath"
 	"testing"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/types"
+
 	"github.com/pingcap/tidb-lightning/lightning/mydump"
 	"github.com/pingcap/tidb-lightning/lightning/verification"
 )
@@ -302,4 +307,28 @@ func (s *checkpointSuite) TestCheckpointMarshallUnmarshall(c *C) {
 	fileChkp2 := NewFileCheckpointsDB(path)
 	// if not recover empty map explicitly, it will become nil
 	c.Assert(fileChkp2.checkpoints.Checkpoints["a"].Engines, NotNil)
-}
\ No newline at end of file
+}
+
+func (s *checkpointSuite) TestTableSchemaHash(c *C) {
+	c.Assert(TableSchemaHash(nil), IsNil)
+
+	newTableInfo := func(colName string, flen int) *model.TableInfo {
+		ft := types.NewFieldType(mysql.TypeLong)
+		ft.Flen = flen
+		return &model.TableInfo{
+			ID:      42,
+			Name:    model.NewCIStr("t"),
+			Columns: []*model.ColumnInfo{{ID: 1, Name: model.NewCIStr(colName), FieldType: *ft}},
+		}
+	}
+
+	// the hash must not change across versions for the same schema.
+	hash := TableSchemaHash(newTableInfo("a", 11))
+	c.Assert(fmt.Sprintf("%x", hash), Equals, "af60c5ab7db927404bdb037c80c849c469fa752a18c155eb39165e969535aeb9")
+
+	sameSchema := newTableInfo("a", 11)
+	sameSchema.ID = 43
+	c.Assert(TableSchemaHash(sameSchema), DeepEquals, hash)
+	c.Assert(TableSchemaHash(newTableInfo("b", 11)), Not(DeepEquals), hash)
+	c.Assert(TableSchemaHash(newTableInfo("a", 20)), Not(DeepEquals), hash)
+}
@@ -108,9 +108,11 @@ var xxx_messageInfo_TableCheckpointModel proto.InternalMessageInfo
 type EngineCheckpointModel struct {
 	Status uint32 `protobuf:"varint,1,opt,name=status,proto3" json:"status,omitempty"`
 	// key is "$path:$offset"
-	Chunks               map[string]*ChunkCheckpointModel `protobuf:"bytes,2,rep,name=chunks" json:"chunks,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value"`
-	XXX_NoUnkeyedLiteral struct{}                         `json:"-"`
-	XXX_sizecache        int32                            `json:"-"`
+	Chunks map[string]*ChunkCheckpointModel `protobuf:"bytes,2,rep,name=chunks" json:"chunks,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value"`
+	// the backend which has written the engine
+	Backend              string   `protobuf:"bytes,3,opt,name=backend,proto3" json:"backend,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *EngineCheckpointModel) Reset()         { *m = EngineCheckpointModel{} }
@@ -359,6 +361,12 @@ func (m *EngineCheckpointModel) MarshalTo(dAtA []byte) (int, error) {
 			}
 		}
 	}
+	if len(m.Backend) > 0 {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(len(m.Backend)))
+		i += copy(dAtA[i:], m.Backend)
+	}
 	return i, nil
 }
 
@@ -527,6 +535,10 @@ func (m *EngineCheckpointModel) Size() (n int) {
 			n += mapEntrySize + 1 + sovFileCheckpoints(uint64(mapEntrySize))
 		}
 	}
+	l = len(m.Backend)
+	if l > 0 {
+		n += 1 + l + sovFileCheckpoints(uint64(l))
+	}
 	return n
 }
 
@@ -1165,6 +1177,35 @@ func (m *EngineCheckpointModel) Unmarshal(dAtA []byte) error {
 			}
 			m.Chunks[mapkey] = mapvalue
 			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Backend", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Backend = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFileCheckpoints(dAtA[iNdEx:])
@@ -1590,41 +1631,41 @@ func init() {
 }
 
 var fileDescriptor_file_checkpoints_c68fff0014a5169d = []byte{
-	// 564 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x53, 0x4d, 0x6e, 0xd3, 0x40,
-	0x14, 0xee, 0xc4, 0x6d, 0x9a, 0x3c, 0xa7, 0x28, 0x1d, 0xb5, 0x65, 0x14, 0x20, 0x72, 0x2b, 0x16,
-	0x96, 0x68, 0x1d, 0xa9, 0x6c, 0x50, 0xc5, 0xaa, 0xa5, 0x2b, 0x54, 0x51, 0x8d, 0x60, 0xc3, 0xc6,
-	0x1a, 0x3b, 0x13, 0xdb, 0xf2, 0xcf, 0x58, 0x9e, 0xb1, 0x69, 0x6f, 0xc1, 0x4d, 0x38, 0x01, 0xfb,
-	0x2e, 0x39, 0x00, 0x0b, 0x28, 0x47, 0xe0, 0x02, 0xc8, 0x63, 0xa3, 0x38, 0x28, 0xaa, 0xd8, 0xbd,
-	0xf7, 0x7d, 0xdf, 0xfb, 0x66, 0x3e, 0x3d, 0x3d, 0x38, 0x4e, 0xa2, 0x20, 0x54, 0x59, 0x94, 0x05,
-	0x33, 0x3f, 0xe4, 0x7e, 0x9c, 0x8b, 0x28, 0x53, 0x72, 0xb6, 0x88, 0x12, 0xee, 0x76, 0x00, 0x27,
-	0x2f, 0x84, 0x12, 0x93, 0x93, 0x20, 0x52, 0x61, 0xe9, 0x39, 0xbe, 0x48, 0x67, 0x81, 0x08, 0xc4,
-	0x4c, 0xc3, 0x5e, 0xb9, 0xd0, 0x9d, 0x6e, 0x74, 0xd5, 0xc8, 0x8f, 0xbe, 0x20, 0x18, 0x5f, 0x2c,
-	0x4d, 0xae, 0xc4, 0x9c, 0x27, 0xf8, 0x0d, 0x98, 0x1d, 0x63, 0x82, 0x2c, 0xc3, 0x36, 0x4f, 0x8f,
-	0x9c, 0x7f, 0x75, 0x5d, 0xe0, 0x32, 0x53, 0xc5, 0x2d, 0xed, 0x8e, 0x4d, 0x3e, 0xac, 0x38, 0x6b,
-	0x01, 0x1e, 0x83, 0x11, 0xf3, 0x5b, 0x82, 0x2c, 0x64, 0x0f, 0x69, 0x5d, 0xe2, 0x17, 0xb0, 0x55,
-	0xb1, 0xa4, 0xe4, 0xa4, 0x67, 0x21, 0xdb, 0x3c, 0xdd, 0x77, 0xde, 0x33, 0x2f, 0xe1, 0xcb, 0x41,
-	0xfd, 0x12, 0x6d, 0x34, 0x67, 0xbd, 0x57, 0xe8, 0xe8, 0x37, 0x82, 0xbd, 0x75, 0x1a, 0x8c, 0x61,
-	0x33, 0x64, 0x32, 0xd4, 0xe6, 0x23, 0xaa, 0x6b, 0x7c, 0x00, 0x7d, 0xa9, 0x98, 0x2a, 0x25, 0x31,
-	0x2c, 0x64, 0xef, 0xd0, 0xb6, 0xc3, 0xcf, 0x00, 0x58, 0x92, 0x08, 0xdf, 0xf5, 0x98, 0xe4, 0x64,
-	0xd3, 0x42, 0xb6, 0x41, 0x87, 0x1a, 0x39, 0x67, 0x92, 0xe3, 0xd7, 0xb0, 0xcd, 0xb3, 0x20, 0xca,
-	0xb8, 0x24, 0x83, 0x36, 0xfc, 0xba, 0x27, 0x9d, 0xcb, 0x46, 0xd4, 0x84, 0xff, 0x3b, 0x32, 0xa1,
-	0x30, 0xea, 0x12, 0xdd, 0xd0, 0xbb, 0x4d, 0xe8, 0xe3, 0xd5, 0xd0, 0x07, 0xad, 0xd1, 0x03, 0xa9,
-	0xbf, 0x22, 0xd8, 0x5f, 0x2b, 0xea, 0x44, 0x44, 0x2b, 0x11, 0xcf, 0xa0, 0xef, 0x87, 0x65, 0x16,
-	0x4b, 0xd2, 0x6b, 0x23, 0xac, 0x9d, 0x77, 0x2e, 0xb4, 0xa8, 0x89, 0xd0, 0x4e, 0x4c, 0xae, 0xc1,
-	0xec, 0xc0, 0xff, 0xb3, 0x35, 0x2d, 0x7f, 0xe0, 0xff, 0xdf, 0x7b, 0xb0, 0xb7, 0x4e, 0x53, 0x6f,
-	0x2d, 0x67, 0x2a, 0x6c, 0xcd, 0x75, 0x5d, 0x47, 0x12, 0x8b, 0x85, 0xe4, 0x4a, 0xdb, 0x1b, 0xb4,
-	0xed, 0xea, 0xad, 0xf1, 0x6c, 0xee, 0xb6, 0xdc, 0x56, 0xb3, 0x35, 0x9e, 0xcd, 0xdf, 0x35, 0xf4,
-	0x18, 0x8c, 0x5c, 0x48, 0xd2, 0xd7, 0x78, 0x5d, 0xe2, 0xe7, 0xf0, 0x28, 0x2f, 0x78, 0xe5, 0x16,
-	0xe2, 0x53, 0x34, 0x77, 0x53, 0x76, 0x43, 0xb6, 0x35, 0x39, 0xaa, 0x51, 0x5a, 0x83, 0x57, 0xec,
-	0x06, 0x3f, 0x81, 0xe1, 0x52, 0x30, 0xd0, 0x82, 0x41, 0xd1, 0x21, 0xe3, 0xca, 0x77, 0xbd, 0x5b,
-	0xc5, 0x25, 0x19, 0x5a, 0xc8, 0xde, 0xa4, 0x83, 0xb8, 0xf2, 0xcf, 0xeb, 0x1e, 0x3f, 0x86, 0xed,
-	0x9a, 0x8c, 0x2b, 0x49, 0x40, 0x53, 0xfd, 0xb8, 0xf2, 0xdf, 0x56, 0x12, 0x1f, 0xc2, 0xa8, 0x26,
-	0xf4, 0x39, 0xc8, 0x32, 0x25, 0xa6, 0x85, 0xec, 0x3e, 0x35, 0xe3, 0xca, 0xbf, 0x68, 0x21, 0x7c,
-	0x02, 0xd8, 0x17, 0x49, 0x99, 0x66, 0x6e, 0xce, 0x8b, 0xb4, 0x54, 0x4c, 0x45, 0x22, 0x23, 0x23,
-	0xcb, 0xb0, 0xb7, 0xe8, 0x6e, 0xc3, 0x5c, 0x2f, 0x09, 0xfc, 0x14, 0x86, 0x2a, 0x4a, 0xb9, 0x54,
-	0x2c, 0xcd, 0xc9, 0x8e, 0x85, 0xec, 0x31, 0x5d, 0x02, 0xe7, 0x87, 0x77, 0x3f, 0xa7, 0x1b, 0x77,
-	0xf7, 0x53, 0xf4, 0xed, 0x7e, 0x8a, 0x7e, 0xdc, 0x4f, 0xd1, 0xe7, 0x5f, 0xd3, 0x8d, 0x8f, 0xdd,
-	0x73, 0xf4, 0xfa, 0xfa, 0xe0, 0x5f, 0xfe, 0x09, 0x00, 0x00, 0xff, 0xff, 0x60, 0x93, 0xe9, 0xcc,
-	0x4f, 0x04, 0x00, 0x00,
+	// 575 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x94, 0xcf, 0x6e, 0xd3, 0x40,
+	0x10, 0xc6, 0xbb, 0x71, 0x9b, 0x3f, 0x93, 0x14, 0xa5, 0xab, 0xb6, 0xac, 0x02, 0x44, 0x6e, 0xc5,
+	0xc1, 0x12, 0x6d, 0x22, 0x95, 0x0b, 0xaa, 0x38, 0xb5, 0xf4, 0x84, 0x2a, 0xaa, 0x15, 0x5c, 0xb8,
+	0x58, 0x6b, 0x67, 0x13, 0x5b, 0xb6, 0x77, 0x2d, 0xef, 0xda, 0xb4, 0x6f, 0xc1, 0x9b, 0xf0, 0x1a,
+	0x3d, 0x72, 0xe3, 0xc2, 0x01, 0xc2, 0x23, 0xf0, 0x02, 0xc8, 0x6b, 0x57, 0x71, 0xab, 0xa8, 0xe2,
+	0x36, 0xf3, 0x7d, 0xdf, 0x8c, 0xf5, 0xcb, 0x68, 0x03, 0x47, 0x71, 0xb8, 0x08, 0xb4, 0x08, 0xc5,
+	0x62, 0xea, 0x07, 0xdc, 0x8f, 0x52, 0x19, 0x0a, 0xad, 0xa6, 0xf3, 0x30, 0xe6, 0x6e, 0x43, 0x98,
+	0xa4, 0x99, 0xd4, 0x72, 0x74, 0xbc, 0x08, 0x75, 0x90, 0x7b, 0x13, 0x5f, 0x26, 0xd3, 0x85, 0x5c,
+	0xc8, 0xa9, 0x91, 0xbd, 0x7c, 0x6e, 0x3a, 0xd3, 0x98, 0xaa, 0x8a, 0x1f, 0x7e, 0x43, 0x30, 0x3c,
+	0x5f, 0x2d, 0xb9, 0x94, 0x33, 0x1e, 0xe3, 0x77, 0xd0, 0x6f, 0x2c, 0x26, 0xc8, 0xb6, 0x9c, 0xfe,
+	0xc9, 0xe1, 0xe4, 0x61, 0xae, 0x29, 0x5c, 0x08, 0x9d, 0xdd, 0xd0, 0xe6, 0xd8, 0xe8, 0x13, 0x0c,
+	0x1f, 0x06, 0xf0, 0x10, 0xac, 0x88, 0xdf, 0x10, 0x64, 0x23, 0xa7, 0x47, 0xcb, 0x12, 0xbf, 0x82,
+	0xad, 0x82, 0xc5, 0x39, 0x27, 0x2d, 0x1b, 0x39, 0xfd, 0x93, 0xbd, 0xc9, 0x47, 0xe6, 0xc5, 0x7c,
+	0x35, 0x68, 0xbe, 0x44, 0xab, 0xcc, 0x69, 0xeb, 0x0d, 0x3a, 0xfc, 0x8b, 0x60, 0x77, 0x5d, 0x06,
+	0x63, 0xd8, 0x0c, 0x98, 0x0a, 0xcc, 0xf2, 0x01, 0x35, 0x35, 0xde, 0x87, 0xb6, 0xd2, 0x4c, 0xe7,
+	0x8a, 0x58, 0x36, 0x72, 0xb6, 0x69, 0xdd, 0xe1, 0x17, 0x00, 0x2c, 0x8e, 0xa5, 0xef, 0x7a, 0x4c,
+	0x71, 0xb2, 0x69, 0x23, 0xc7, 0xa2, 0x3d, 0xa3, 0x9c, 0x31, 0xc5, 0xf1, 0x5b, 0xe8, 0x70, 0xb1,
+	0x08, 0x05, 0x57, 0xa4, 0x5b, 0xc3, 0xaf, 0xfb, 0xe4, 0xe4, 0xa2, 0x0a, 0x55, 0xf0, 0x77, 0x23,
+	0x23, 0x0a, 0x83, 0xa6, 0xd1, 0x84, 0xde, 0xa9, 0xa0, 0x8f, 0xee, 0x43, 0xef, 0xd7, 0x8b, 0x1e,
+	0xa1, 0xfe, 0x81, 0x60, 0x6f, 0x6d, 0xa8, 0x81, 0x88, 0xee, 0x21, 0x9e, 0x42, 0xdb, 0x0f, 0x72,
+	0x11, 0x29, 0xd2, 0xaa, 0x11, 0xd6, 0xce, 0x4f, 0xce, 0x4d, 0xa8, 0x42, 0xa8, 0x27, 0x30, 0x81,
+	0x8e, 0xc7, 0xfc, 0x88, 0x8b, 0x99, 0xf9, 0xdd, 0x7a, 0xf4, 0xae, 0x1d, 0x5d, 0x41, 0xbf, 0x31,
+	0xf0, 0x3f, 0xf7, 0x34, 0xf1, 0x47, 0xc8, 0x7e, 0xb6, 0x60, 0x77, 0x5d, 0xa6, 0xbc, 0x67, 0xca,
+	0x74, 0x50, 0x2f, 0x37, 0x75, 0x09, 0x2b, 0xe7, 0x73, 0xc5, 0xb5, 0x59, 0x6f, 0xd1, 0xba, 0x2b,
+	0xef, 0xc9, 0xc5, 0xcc, 0xad, 0xbd, 0xad, 0xea, 0x9e, 0x5c, 0xcc, 0x3e, 0x54, 0xf6, 0x10, 0xac,
+	0x54, 0x2a, 0xd2, 0x36, 0x7a, 0x59, 0xe2, 0x97, 0xf0, 0x24, 0xcd, 0x78, 0xe1, 0x66, 0xf2, 0x4b,
+	0x38, 0x73, 0x13, 0x76, 0x4d, 0x3a, 0xc6, 0x1c, 0x94, 0x2a, 0x2d, 0xc5, 0x4b, 0x76, 0x8d, 0x9f,
+	0x41, 0x6f, 0x15, 0xe8, 0x9a, 0x40, 0x37, 0x6b, 0x98, 0x51, 0xe1, 0xbb, 0xde, 0x8d, 0xe6, 0x8a,
+	0xf4, 0x6c, 0xe4, 0x6c, 0xd2, 0x6e, 0x54, 0xf8, 0x67, 0x65, 0x8f, 0x9f, 0x42, 0xa7, 0x34, 0xa3,
+	0x42, 0x11, 0x30, 0x56, 0x3b, 0x2a, 0xfc, 0xf7, 0x85, 0xc2, 0x07, 0x30, 0x28, 0x0d, 0xf3, 0x50,
+	0x54, 0x9e, 0x90, 0xbe, 0x8d, 0x9c, 0x36, 0xed, 0x47, 0x85, 0x7f, 0x5e, 0x4b, 0xf8, 0x18, 0xb0,
+	0x2f, 0xe3, 0x3c, 0x11, 0x6e, 0xca, 0xb3, 0x24, 0xd7, 0x4c, 0x87, 0x52, 0x90, 0x81, 0x6d, 0x39,
+	0x5b, 0x74, 0xa7, 0x72, 0xae, 0x56, 0x06, 0x7e, 0x0e, 0x3d, 0x1d, 0x26, 0x5c, 0x69, 0x96, 0xa4,
+	0x64, 0xdb, 0x46, 0xce, 0x90, 0xae, 0x84, 0xb3, 0x83, 0xdb, 0xdf, 0xe3, 0x8d, 0xdb, 0xe5, 0x18,
+	0x7d, 0x5f, 0x8e, 0xd1, 0xaf, 0xe5, 0x18, 0x7d, 0xfd, 0x33, 0xde, 0xf8, 0xdc, 0x7c, 0xa8, 0x5e,
+	0xdb, 0xfc, 0x15, 0xbc, 0xfe, 0x37, 0x00, 0xe1, 0x0f, 0xa9, 0x28, 0x69, 0x04, 0x00, 0x00,
 }
@@ -14,6 +14,10 @@
 package checkpoints
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
 	"github.com/pingcap/parser/model"
 )
 
@@ -29,3 +33,35 @@ type TidbTableInfo struct {
 	Indices int
 	Core    *model.TableInfo
 }
+
+// TableSchemaHash computes a SHA-256 digest of the parts of the table schema
+// which affect how rows are encoded, i.e. the columns (names, types and
+// attributes in order) and the indices. The digest only depends on the
+// schema itself and not on the internal IDs or the JSON representation of
+// `model.TableInfo`, so it stays the same across Lightning versions for the
+// same schema. Returns nil if `tableInfo` is nil.
+func TableSchemaHash(tableInfo *model.TableInfo) []byte {
+	if tableInfo == nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "pk_is_handle=%v\n", tableInfo.PKIsHandle)
+	for _, col := range tableInfo.Columns {
+		ft := &col.FieldType
+		fmt.Fprintf(&buf, "column %q tp=%d flag=%d flen=%d decimal=%d charset=%q collate=%q elems=%q generated=%q stored=%v\n",
+			col.Name.L, ft.Tp, ft.Flag, ft.Flen, ft.Decimal, ft.Charset, ft.Collate, ft.Elems,
+			col.GeneratedExprString, col.GeneratedStored,
+		)
+	}
+	for _, index := range tableInfo.Indices {
+		fmt.Fprintf(&buf, "index %q unique=%v primary=%v", index.Name.L, index.Unique, index.Primary)
+		for _, col := range index.Columns {
+			fmt.Fprintf(&buf, " %q:%d", col.Name.L, col.Length)
+		}
+		buf.WriteByte('\n')
+	}
+
+	hash := sha256.Sum256(buf.Bytes())
+	return hash[:]
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/tidb-lightning/lightning/metric"
+)
+
+// FDBudget limits the number of file descriptors (source files and gRPC
+// connections) held open at the same time. Acquiring from an exhausted budget
+// blocks until another descriptor is released, or the context is done.
+//
+// Descriptors held for the whole task, like the connection to tikv-importer,
+// should not be taken from the budget, otherwise a small limit may leave no
+// descriptor to the readers at all.
+//
+// A nil *FDBudget imposes no limit.
+type FDBudget struct {
+	slots chan struct{}
+}
+
+// NewFDBudget creates a budget allowing `limit` file descriptors to be held.
+// Returns nil if `limit` is not positive.
+func NewFDBudget(limit int) *FDBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &FDBudget{slots: make(chan struct{}, limit)}
+}
+
+// Acquire takes one file descriptor from the budget, blocking until one is
+// available. Returns the error of the context if it is done before that.
+func (b *FDBudget) Acquire(ctx context.Context) error {
+	if b != nil {
+		select {
+		case b.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	metric.OpenFileDescriptorsGauge.Inc()
+	return nil
+}
+
+// Release returns a file descriptor obtained by Acquire to the budget.
+func (b *FDBudget) Release() {
+	metric.OpenFileDescriptorsGauge.Dec()
+	if b == nil {
+		return
+	}
+	<-b.slots
+}
+
+// InUse returns the number of file descriptors currently acquired.
+func (b *FDBudget) InUse() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.slots)
+}
+
+var (
+	globalFDBudgetLock sync.RWMutex
+	globalFDBudget     *FDBudget
+)
+
+// SetFDBudget replaces the process-wide file descriptor budget. Passing nil
+// removes the limit. Descriptors must be released to the same budget they
+// were acquired from, so callers should keep the result of GlobalFDBudget.
+func SetFDBudget(b *FDBudget) {
+	globalFDBudgetLock.Lock()
+	globalFDBudget = b
+	globalFDBudgetLock.Unlock()
+}
+
+// GlobalFDBudget returns the process-wide file descriptor budget.
+func GlobalFDBudget() *FDBudget {
+	globalFDBudgetLock.RLock()
+	defer globalFDBudgetLock.RUnlock()
+	return globalFDBudget
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+)
+
+var _ = Suite(&fdBudgetSuite{})
+
+type fdBudgetSuite struct{}
+
+func (s *fdBudgetSuite) TestDisabled(c *C) {
+	b := common.NewFDBudget(0)
+	c.Assert(b, IsNil)
+	for i := 0; i < 10; i++ {
+		c.Assert(b.Acquire(context.Background()), IsNil)
+	}
+	c.Assert(b.InUse(), Equals, 0)
+}
+
+func (s *fdBudgetSuite) TestBlockWhenExhausted(c *C) {
+	b := common.NewFDBudget(2)
+	c.Assert(b.Acquire(context.Background()), IsNil)
+	c.Assert(b.Acquire(context.Background()), IsNil)
+	c.Assert(b.InUse(), Equals, 2)
+
+	acquired := make(chan struct{})
+	go func() {
+		b.Acquire(context.Background())
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		c.Fatal("acquired from an exhausted budget")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	b.Release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		c.Fatal("still blocked after a descriptor is released")
+	}
+	c.Assert(b.InUse(), Equals, 2)
+}
+
+func (s *fdBudgetSuite) TestAcquireCancelled(c *C) {
+	b := common.NewFDBudget(1)
+	c.Assert(b.Acquire(context.Background()), IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- b.Acquire(ctx)
+	}()
+	cancel()
+	select {
+	case err := <-result:
+		c.Assert(err, Equals, context.Canceled)
+	case <-time.After(time.Second):
+		c.Fatal("still blocked after the context is cancelled")
+	}
+	c.Assert(b.InUse(), Equals, 1)
+}
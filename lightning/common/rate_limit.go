@@ -0,0 +1,67 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter is a token bucket limiting the number of bytes per second shared
+// by all goroutines using it. Requests are served in the order they arrive, so
+// a large consumer cannot starve the others.
+//
+// A nil *RateLimiter imposes no limit.
+type RateLimiter struct {
+	limiter *rate.Limiter
+	burst   int
+}
+
+// NewRateLimiter creates a rate limiter allowing `bytesPerSec` bytes to pass
+// every second. Returns nil if `bytesPerSec` is not positive.
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	burst := int(bytesPerSec)
+	return &RateLimiter{
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSec), burst),
+		burst:   burst,
+	}
+}
+
+// WaitN blocks until `n` bytes are allowed to pass, or the context is done.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	// the underlying limiter refuses requests larger than the burst size, so
+	// we split them into smaller pieces.
+	for n > 0 {
+		m := n
+		if m > l.burst {
+			m = l.burst
+		}
+		if err := l.limiter.WaitN(ctx, m); err != nil {
+			if ctx.Err() != nil {
+				return errors.Trace(ctx.Err())
+			}
+			return errors.Trace(err)
+		}
+		n -= m
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+)
+
+var _ = Suite(&rateLimitSuite{})
+
+type rateLimitSuite struct{}
+
+func (s *rateLimitSuite) TestDisabled(c *C) {
+	l := common.NewRateLimiter(0)
+	c.Assert(l, IsNil)
+	c.Assert(l.WaitN(context.Background(), 1<<30), IsNil)
+}
+
+func (s *rateLimitSuite) TestSharedLimit(c *C) {
+	l := common.NewRateLimiter(1000)
+	ctx := context.Background()
+
+	// consume the initial burst.
+	c.Assert(l.WaitN(ctx, 1000), IsNil)
+
+	// two consumers sharing 1000 B/s, each asking for 250 bytes, should take
+	// about 0.5s in total.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			c.Assert(l.WaitN(ctx, 250), IsNil)
+		}()
+	}
+	c.Assert(&wg, unblocksBetween, 400*time.Millisecond, 800*time.Millisecond)
+}
+
+func (s *rateLimitSuite) TestLargerThanBurst(c *C) {
+	l := common.NewRateLimiter(1000)
+
+	start := time.Now()
+	c.Assert(l.WaitN(context.Background(), 1500), IsNil)
+	c.Assert(time.Since(start), GreaterEqual, 400*time.Millisecond)
+
+	// requests which cannot be fulfilled before the deadline fail immediately.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	c.Assert(l.WaitN(ctx, 5000), NotNil)
+}
@@ -15,6 +15,8 @@ package common
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"encoding/json"
 	stderrors "errors"
@@ -23,6 +25,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"reflect"
 	"regexp"
@@ -46,12 +49,18 @@ const (
 	defaultMaxRetry = 3
 )
 
-func ToDSN(host string, port int, user string, psw string, sqlMode string, maxAllowedPacket uint64) string {
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/?charset=utf8&sql_mode='%s'&maxAllowedPacket=%d", user, psw, host, port, sqlMode, maxAllowedPacket)
+// ToDSN returns the DSN connecting to TiDB. The `time_zone` of the sessions is
+// only set if `timeZone` is not empty.
+func ToDSN(host string, port int, user string, psw string, sqlMode string, maxAllowedPacket uint64, timeZone string) string {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/?charset=utf8&sql_mode='%s'&maxAllowedPacket=%d", user, psw, host, port, sqlMode, maxAllowedPacket)
+	if len(timeZone) > 0 {
+		dsn += "&time_zone=" + url.QueryEscape("'"+timeZone+"'")
+	}
+	return dsn
 }
 
-func ConnectDB(host string, port int, user string, psw string, sqlMode string, maxAllowedPacket uint64) (*sql.DB, error) {
-	dbDSN := ToDSN(host, port, user, psw, sqlMode, maxAllowedPacket)
+func ConnectDB(host string, port int, user string, psw string, sqlMode string, maxAllowedPacket uint64, timeZone string) (*sql.DB, error) {
+	dbDSN := ToDSN(host, port, user, psw, sqlMode, maxAllowedPacket, timeZone)
 	db, err := sql.Open("mysql", dbDSN)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -247,10 +256,25 @@ func WriteMySQLIdentifier(builder *strings.Builder, identifier string) {
 //	}
 //	fmt.Println(resp.IP)
 func GetJSON(client *http.Client, url string, v interface{}) error {
-	resp, err := client.Get(url)
+	return GetJSONWithContext(context.Background(), client, url, v)
+}
+
+// GetJSONWithContext is like GetJSON, but the request is canceled when the
+// context is done.
+func GetJSONWithContext(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		// the error wraps the cancellation, which the callers must tell apart
+		// from the other failures to stop retrying.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return errors.Trace(ctxErr)
+		}
+		return errors.Trace(err)
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
@@ -264,6 +288,34 @@ func GetJSON(client *http.Client, url string, v interface{}) error {
 	return errors.Trace(json.NewDecoder(resp.Body).Decode(v))
 }
 
+// ToTLSConfig loads the CA certificate verifying the server, and the client
+// certificate and key if the server requires them. Returns nil if neither is
+// given, i.e. the system CA is used without a client certificate.
+func ToTLSConfig(caPath, certPath, keyPath string) (*tls.Config, error) {
+	if len(caPath) == 0 && len(certPath) == 0 {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{}
+	if len(caPath) > 0 {
+		ca, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, errors.Annotate(err, "cannot read the CA certificate")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("invalid CA certificate %s", caPath)
+		}
+	}
+	if len(certPath) > 0 {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, errors.Annotate(err, "cannot load the client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
 // KillMySelf sends sigint to current process, used in integration test only
 func KillMySelf() error {
 	return errors.Trace(syscall.Kill(syscall.Getpid(), syscall.SIGINT))
@@ -18,9 +18,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
@@ -83,6 +85,24 @@ func (s *utilSuite) TestGetJSON(c *C) {
 	c.Assert(err, ErrorMatches, ".*http status code != 200.*")
 }
 
+func (s *utilSuite) TestToTLSConfig(c *C) {
+	tlsConfig, err := common.ToTLSConfig("", "", "")
+	c.Assert(err, IsNil)
+	c.Assert(tlsConfig, IsNil)
+
+	dir := c.MkDir()
+	_, err = common.ToTLSConfig(filepath.Join(dir, "missing.pem"), "", "")
+	c.Assert(err, ErrorMatches, "cannot read the CA certificate.*")
+
+	caPath := filepath.Join(dir, "ca.pem")
+	c.Assert(ioutil.WriteFile(caPath, []byte("not a certificate"), 0644), IsNil)
+	_, err = common.ToTLSConfig(caPath, "", "")
+	c.Assert(err, ErrorMatches, "invalid CA certificate .*")
+
+	_, err = common.ToTLSConfig("", filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	c.Assert(err, ErrorMatches, "cannot load the client certificate.*")
+}
+
 func (s *utilSuite) TestIsRetryableError(c *C) {
 	c.Assert(common.IsRetryableError(context.Canceled), IsFalse)
 	c.Assert(common.IsRetryableError(context.DeadlineExceeded), IsFalse)
@@ -121,8 +141,14 @@ func (s *utilSuite) TestIsRetryableError(c *C) {
 }
 
 func (s *utilSuite) TestToDSN(c *C) {
-	dsn := common.ToDSN("127.0.0.1", 4000, "root", "123456", "strict", 1234)
+	dsn := common.ToDSN("127.0.0.1", 4000, "root", "123456", "strict", 1234, "")
 	c.Assert(dsn, Equals, "root:123456@tcp(127.0.0.1:4000)/?charset=utf8&sql_mode='strict'&maxAllowedPacket=1234")
+
+	dsn = common.ToDSN("127.0.0.1", 4000, "root", "123456", "strict", 1234, "+08:00")
+	c.Assert(dsn, Equals, "root:123456@tcp(127.0.0.1:4000)/?charset=utf8&sql_mode='strict'&maxAllowedPacket=1234&time_zone=%27%2B08%3A00%27")
+	dsnConfig, err := mysql.ParseDSN(dsn)
+	c.Assert(err, IsNil)
+	c.Assert(dsnConfig.Params["time_zone"], Equals, "'+08:00'")
 }
 
 func (s *utilSuite) TestIsContextCanceledError(c *C) {
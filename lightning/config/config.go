@@ -16,13 +16,19 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/charset"
 	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/tidb-lightning/lightning/common"
 	"github.com/pingcap/tidb-lightning/lightning/log"
@@ -54,10 +60,130 @@ const (
 	IgnoreOnDup = "ignore"
 	// ErrorOnDup indicates using INSERT INTO to insert data, which would violate PK or UNIQUE constraint
 	ErrorOnDup = "error"
+
+	// KVKindAll indicates writing both the row data and index KV pairs
+	KVKindAll = "all"
+	// KVKindData indicates writing only the row data KV pairs
+	KVKindData = "data"
+	// KVKindIndex indicates writing only the index KV pairs
+	KVKindIndex = "index"
+
+	// TrailingSepStrict indicates a separator ending a CSV row starts an empty last field
+	TrailingSepStrict = "strict"
+	// TrailingSepIgnore indicates dropping a single separator ending a CSV row
+	TrailingSepIgnore = "ignore"
+
+	// NulByteKeep indicates importing the NUL bytes in CSV fields as is
+	NulByteKeep = "keep"
+	// NulByteError indicates failing the import if a CSV field going into a non-binary string column contains a NUL byte
+	NulByteError = "error"
+
+	// OversizedRowError indicates failing the import at a row exceeding `tikv-importer.max-row-size`
+	OversizedRowError = "error"
+	// OversizedRowSkip indicates skipping and logging the rows exceeding `tikv-importer.max-row-size`
+	OversizedRowSkip = "skip"
+
+	// FileOrderPath indicates importing the data files of a table in the lexicographical order of their paths
+	FileOrderPath = "path"
+	// FileOrderName indicates importing the data files of a table in the lexicographical order of their names
+	FileOrderName = "name"
+	// FileOrderNumeric indicates importing the data files of a table in the numerical order of their part numbers
+	FileOrderNumeric = "numeric"
+
+	// EventTableStarted is published when a table starts to be imported
+	EventTableStarted = "started"
+	// EventChunkProgress is published when the progress of a chunk is saved
+	EventChunkProgress = "chunk-progress"
+	// EventTableCompleted is published when a table is imported successfully
+	EventTableCompleted = "completed"
+	// EventTableFailed is published when a table fails to be imported
+	EventTableFailed = "failed"
+
+	// SchemaChangeError indicates failing the import if a table is altered after its checkpoint is created
+	SchemaChangeError = "error"
+	// SchemaChangeReimport indicates dropping and importing again a table altered after its checkpoint is created
+	SchemaChangeReimport = "reimport"
+
+	// BackendChangeError indicates failing the import if a table is partially imported by another backend
+	BackendChangeError = "error"
+	// BackendChangeReimport indicates dropping and importing again a table partially imported by another backend
+	BackendChangeReimport = "reimport"
+
+	// OutOfRangeError indicates failing the import on an out-of-range integer value
+	OutOfRangeError = "error"
+	// OutOfRangeClamp indicates replacing an out-of-range integer value by the column's min or max value
+	OutOfRangeClamp = "clamp"
+	// OutOfRangeNull indicates replacing an out-of-range integer value by NULL
+	OutOfRangeNull = "null"
+
+	// EnumSetFormatAuto indicates resolving an ENUM or SET value by its label,
+	// falling back to the numeric index if there is no such label
+	EnumSetFormatAuto = "auto"
+	// EnumSetFormatLabel indicates an ENUM or SET value must be its label(s)
+	EnumSetFormatLabel = "label"
+	// EnumSetFormatIndex indicates an ENUM or SET value must be its numeric index
+	EnumSetFormatIndex = "index"
+
+	// DataInvalidCharError indicates failing the import on a byte sequence
+	// invalid in `mydumper.data-character-set`
+	DataInvalidCharError = "error"
+	// DataInvalidCharReplace indicates replacing an invalid byte sequence by U+FFFD
+	DataInvalidCharReplace = "replace"
+
+	// EmptyFileIgnore indicates importing an empty or header-only data file
+	// as zero rows
+	EmptyFileIgnore = "ignore"
+	// EmptyFileError indicates failing the import on an empty or header-only
+	// data file
+	EmptyFileError = "error"
+
+	// ChecksumFallbackNone indicates failing the import when ADMIN CHECKSUM
+	// is unavailable
+	ChecksumFallbackNone = "none"
+	// ChecksumFallbackCount indicates only comparing the row count when
+	// ADMIN CHECKSUM is unavailable
+	ChecksumFallbackCount = "count"
+	// ChecksumFallbackWarn indicates logging a warning and leaving the table
+	// unverified when ADMIN CHECKSUM is unavailable
+	ChecksumFallbackWarn = "warn"
+
+	// InsertTriggerWarn indicates logging a warning for a table with
+	// BEFORE INSERT triggers, which are not run by the import
+	InsertTriggerWarn = "warn"
+	// InsertTriggerError indicates failing the import of a table with
+	// BEFORE INSERT triggers
+	InsertTriggerError = "error"
+
+	// ClockSkewWarn indicates logging a warning when the clock skew exceeds
+	// `lightning.max-clock-skew`
+	ClockSkewWarn = "warn"
+	// ClockSkewError indicates failing the requirement check when the clock
+	// skew exceeds `lightning.max-clock-skew`
+	ClockSkewError = "error"
+
+	// GRPCCompressionNone indicates the gRPC messages are sent uncompressed
+	GRPCCompressionNone = "none"
+	// GRPCCompressionGzip indicates the gRPC messages are compressed by gzip
+	GRPCCompressionGzip = "gzip"
+
+	// SummaryFormatJSON writes the summary report as JSON
+	SummaryFormatJSON = "json"
+	// SummaryFormatText writes the summary report as a human-readable table
+	SummaryFormatText = "text"
+
+	// StdinSourceDir is the `mydumper.data-source-dir` reading a single data
+	// file from the standard input.
+	StdinSourceDir = "-"
+	// StdinTypeCSV indicates the standard input contains CSV data
+	StdinTypeCSV = "csv"
+	// StdinTypeSQL indicates the standard input contains INSERT statements
+	StdinTypeSQL = "sql"
 )
 
 var defaultConfigPaths = []string{"tidb-lightning.toml", "conf/tidb-lightning.toml"}
 
+var checkpointNamespaceRegexp = regexp.MustCompile(fmt.Sprintf(`^[0-9A-Za-z_]{0,%d}$`, maxCheckpointNamespaceLen))
+
 type DBStore struct {
 	Host       string `toml:"host" json:"host"`
 	Port       int    `toml:"port" json:"port"`
@@ -65,10 +191,24 @@ type DBStore struct {
 	Psw        string `toml:"password" json:"-"`
 	StatusPort int    `toml:"status-port" json:"status-port"`
 	PdAddr     string `toml:"pd-addr" json:"pd-addr"`
+	PdURL      string `toml:"pd-url" json:"pd-url"`
 	StrSQLMode string `toml:"sql-mode" json:"sql-mode"`
+	OutOfRange string `toml:"out-of-range" json:"out-of-range"`
+	TimeZone   string `toml:"time-zone" json:"time-zone"`
+
+	PdMaxConcurrentRequests int      `toml:"pd-max-concurrent-requests" json:"pd-max-concurrent-requests"`
+	StoreJitter             Duration `toml:"store-jitter" json:"store-jitter"`
+	StoreStateGrace         Duration `toml:"store-state-grace" json:"store-state-grace"`
+	PdRetryCount            int      `toml:"pd-retry-count" json:"pd-retry-count"`
+	PdRetryBackoff          Duration `toml:"pd-retry-backoff" json:"pd-retry-backoff"`
+
+	AutoCreateDatabase bool   `toml:"auto-create-database" json:"auto-create-database"`
+	DatabaseCharset    string `toml:"database-charset" json:"database-charset"`
+	DatabaseCollation  string `toml:"database-collation" json:"database-collation"`
 
-	SQLMode          mysql.SQLMode `toml:"-" json:"-"`
-	MaxAllowedPacket uint64        `toml:"max-allowed-packet" json:"max-allowed-packet"`
+	SQLMode          mysql.SQLMode  `toml:"-" json:"-"`
+	Location         *time.Location `toml:"-" json:"-"`
+	MaxAllowedPacket uint64         `toml:"max-allowed-packet" json:"max-allowed-packet"`
 
 	DistSQLScanConcurrency     int `toml:"distsql-scan-concurrency" json:"distsql-scan-concurrency"`
 	BuildStatsConcurrency      int `toml:"build-stats-concurrency" json:"build-stats-concurrency"`
@@ -88,7 +228,10 @@ type Config struct {
 	TikvImporter TikvImporter        `toml:"tikv-importer" json:"tikv-importer"`
 	PostRestore  PostRestore         `toml:"post-restore" json:"post-restore"`
 	Cron         Cron                `toml:"cron" json:"cron"`
+	GRPC         GRPC                `toml:"grpc" json:"grpc"`
+	Events       Events              `toml:"events" json:"events"`
 	Routes       []*router.TableRule `toml:"routes" json:"routes"`
+	TableConfigs []*TableConfig      `toml:"table-config" json:"table-config"`
 }
 
 func (c *Config) String() string {
@@ -104,15 +247,43 @@ type Lightning struct {
 	IndexConcurrency  int  `toml:"index-concurrency" json:"index-concurrency"`
 	RegionConcurrency int  `toml:"region-concurrency" json:"region-concurrency"`
 	IOConcurrency     int  `toml:"io-concurrency" json:"io-concurrency"`
+	MaxOpenFiles      int  `toml:"max-open-files" json:"max-open-files"`
 	CheckRequirements bool `toml:"check-requirements" json:"check-requirements"`
+
+	MaxClockSkew Duration `toml:"max-clock-skew" json:"max-clock-skew"`
+	OnClockSkew  string   `toml:"on-clock-skew" json:"on-clock-skew"`
+
+	RevertModeOnExit bool `toml:"revert-mode-on-exit" json:"revert-mode-on-exit"`
+
+	MaxTableRetry          int  `toml:"max-table-retry" json:"max-table-retry"`
+	ContinueOnTableFailure bool `toml:"continue-on-table-failure" json:"continue-on-table-failure"`
+	// MaxSchemaErrors is the number of tables allowed to fail being created,
+	// which are skipped instead of stopping the whole import.
+	MaxSchemaErrors int `toml:"max-schema-errors" json:"max-schema-errors"`
+
+	SummaryFile   string `toml:"summary-file" json:"summary-file"`
+	SummaryFormat string `toml:"summary-format" json:"summary-format"`
+	// SummaryKeyRanges adds the key ranges of every table into the JSON
+	// summary report.
+	SummaryKeyRanges bool `toml:"summary-key-ranges" json:"summary-key-ranges"`
 }
 
 // PostRestore has some options which will be executed after kv restored.
 type PostRestore struct {
-	Level1Compact bool `toml:"level-1-compact" json:"level-1-compact"`
-	Compact       bool `toml:"compact" json:"compact"`
-	Checksum      bool `toml:"checksum" json:"checksum"`
-	Analyze       bool `toml:"analyze" json:"analyze"`
+	Level1Compact   bool `toml:"level-1-compact" json:"level-1-compact"`
+	Compact         bool `toml:"compact" json:"compact"`
+	CompactOptional bool `toml:"compact-optional" json:"compact-optional"`
+	Checksum        bool `toml:"checksum" json:"checksum"`
+	Analyze         bool `toml:"analyze" json:"analyze"`
+	Scatter         bool `toml:"scatter" json:"scatter"`
+
+	AlterAutoIncrement  bool   `toml:"alter-auto-increment" json:"alter-auto-increment"`
+	ChecksumConcurrency int    `toml:"checksum-concurrency" json:"checksum-concurrency"`
+	ChecksumFallback    string `toml:"checksum-fallback" json:"checksum-fallback"`
+
+	ChecksumStoreConcurrency int `toml:"checksum-store-concurrency" json:"checksum-store-concurrency"`
+
+	AnalyzeMinRows int64 `toml:"analyze-min-rows" json:"analyze-min-rows"`
 }
 
 type CSVConfig struct {
@@ -120,39 +291,115 @@ type CSVConfig struct {
 	Delimiter       string `toml:"delimiter" json:"delimiter"`
 	Header          bool   `toml:"header" json:"header"`
 	TrimLastSep     bool   `toml:"trim-last-separator" json:"trim-last-separator"`
+	TrailingSep     string `toml:"trailing-separator" json:"trailing-separator"`
+	OnNulByte       string `toml:"on-nul-byte" json:"on-nul-byte"`
 	NotNull         bool   `toml:"not-null" json:"not-null"`
 	Null            string `toml:"null" json:"null"`
 	BackslashEscape bool   `toml:"backslash-escape" json:"backslash-escape"`
+	Terminator      string `toml:"terminator" json:"terminator"`
 }
 
 type MydumperRuntime struct {
 	ReadBlockSize    int64     `toml:"read-block-size" json:"read-block-size"`
+	MaxRegionSize    int64     `toml:"max-region-size" json:"max-region-size"`
 	BatchSize        int64     `toml:"batch-size" json:"batch-size"`
 	BatchImportRatio float64   `toml:"batch-import-ratio" json:"batch-import-ratio"`
+	MaxEngines       int       `toml:"max-engines-per-table" json:"max-engines-per-table"`
 	SourceDir        string    `toml:"data-source-dir" json:"data-source-dir"`
 	NoSchema         bool      `toml:"no-schema" json:"no-schema"`
 	CharacterSet     string    `toml:"character-set" json:"character-set"`
+	DataCharacterSet string    `toml:"data-character-set" json:"data-character-set"`
+	DataInvalidChar  string    `toml:"data-invalid-char" json:"data-invalid-char"`
 	CSV              CSVConfig `toml:"csv" json:"csv"`
 	CaseSensitive    bool      `toml:"case-sensitive" json:"case-sensitive"`
+	Stdin            Stdin     `toml:"stdin" json:"stdin"`
+
+	HTTP HTTPSource `toml:"http" json:"http"`
+
+	PreserveAutoIncrement bool   `toml:"preserve-auto-increment" json:"preserve-auto-increment"`
+	EnumSetFormat         string `toml:"enum-set-format" json:"enum-set-format"`
+	OnEmptyFile           string `toml:"on-empty-file" json:"on-empty-file"`
+	FileOrder             string `toml:"file-order" json:"file-order"`
+	OnInsertTrigger       string `toml:"on-insert-trigger" json:"on-insert-trigger"`
+	PreserveBOM           bool   `toml:"preserve-bom" json:"preserve-bom"`
+
+	MetadataOutput string `toml:"metadata-output" json:"metadata-output"`
+}
+
+// Stdin names the target table of the data piped through the standard input,
+// since there is no file name to infer it from.
+type Stdin struct {
+	Schema string `toml:"schema" json:"schema"`
+	Table  string `toml:"table" json:"table"`
+	Type   string `toml:"type" json:"type"`
+}
+
+// HTTPSource configures the requests reading the source files when the data
+// source is an HTTP(S) URL.
+type HTTPSource struct {
+	CAPath   string `toml:"ca-path" json:"ca-path"`
+	CertPath string `toml:"cert-path" json:"cert-path"`
+	KeyPath  string `toml:"key-path" json:"key-path"`
+	// Headers are added to every request, e.g. for authorization. They are
+	// hidden from the JSON output since they may contain credentials.
+	Headers map[string]string `toml:"headers" json:"-"`
 }
 
 type TikvImporter struct {
-	Addr        string `toml:"addr" json:"addr"`
-	Backend     string `toml:"backend" json:"backend"`
-	OnDuplicate string `toml:"on-duplicate" json:"on-duplicate"`
+	Addr            string `toml:"addr" json:"addr"`
+	Backend         string `toml:"backend" json:"backend"`
+	OnDuplicate     string `toml:"on-duplicate" json:"on-duplicate"`
+	MaxWriteSpeed   int64  `toml:"max-write-speed" json:"max-write-speed"`
+	TxnSize         int64  `toml:"txn-size" json:"txn-size"`
+	UploadChunkSize int64  `toml:"upload-chunk-size" json:"upload-chunk-size"`
+	KVKind          string `toml:"kv-kind" json:"kv-kind"`
+	MaxRowSize      int64  `toml:"max-row-size" json:"max-row-size"`
+	OnOversizedRow  string `toml:"on-oversized-row" json:"on-oversized-row"`
+
+	IncrementalImport bool `toml:"incremental-import" json:"incremental-import"`
+	DisableSwitchMode bool `toml:"disable-switch-mode" json:"disable-switch-mode"`
+
+	MinStoreAvailableRatio float64 `toml:"min-store-available-ratio" json:"min-store-available-ratio"`
 }
 
 type Checkpoint struct {
-	Enable           bool   `toml:"enable" json:"enable"`
-	Schema           string `toml:"schema" json:"schema"`
-	DSN              string `toml:"dsn" json:"-"` // DSN may contain password, don't expose this to JSON.
-	Driver           string `toml:"driver" json:"driver"`
-	KeepAfterSuccess bool   `toml:"keep-after-success" json:"keep-after-success"`
+	Enable           bool     `toml:"enable" json:"enable"`
+	Schema           string   `toml:"schema" json:"schema"`
+	DSN              string   `toml:"dsn" json:"-"` // DSN may contain password, don't expose this to JSON.
+	Driver           string   `toml:"driver" json:"driver"`
+	KeepAfterSuccess bool     `toml:"keep-after-success" json:"keep-after-success"`
+	OnSchemaChange   string   `toml:"on-schema-change" json:"on-schema-change"`
+	OnBackendChange  string   `toml:"on-backend-change" json:"on-backend-change"`
+	Namespace        string   `toml:"namespace" json:"namespace"`
+	FlushInterval    Duration `toml:"flush-interval" json:"flush-interval"`
 }
 
 type Cron struct {
-	SwitchMode  Duration `toml:"switch-mode" json:"switch-mode"`
-	LogProgress Duration `toml:"log-progress" json:"log-progress"`
+	SwitchMode     Duration `toml:"switch-mode" json:"switch-mode"`
+	LogProgress    Duration `toml:"log-progress" json:"log-progress"`
+	CheckStoreDisk Duration `toml:"check-store-disk" json:"check-store-disk"`
+	CheckStall     Duration `toml:"check-stall" json:"check-stall"`
+}
+
+// Events configures publishing the lifecycle events of the tables to an
+// external event sink.
+type Events struct {
+	Sink    string   `toml:"sink" json:"sink"`
+	Types   []string `toml:"types" json:"types"`
+	Timeout Duration `toml:"timeout" json:"timeout"`
+	// the TLS of an HTTPS sink, like `mydumper.http`.
+	CAPath   string `toml:"ca-path" json:"ca-path"`
+	CertPath string `toml:"cert-path" json:"cert-path"`
+	KeyPath  string `toml:"key-path" json:"key-path"`
+}
+
+// GRPC controls the keepalive and compression of the gRPC connections to TiKV
+// and tikv-importer.
+type GRPC struct {
+	KeepaliveTime                Duration `toml:"keepalive-time" json:"keepalive-time"`
+	KeepaliveTimeout             Duration `toml:"keepalive-timeout" json:"keepalive-timeout"`
+	KeepalivePermitWithoutStream bool     `toml:"keepalive-permit-without-stream" json:"keepalive-permit-without-stream"`
+	Compression                  string   `toml:"compression" json:"compression"`
 }
 
 // A duration which can be deserialized from a TOML string.
@@ -179,6 +426,8 @@ func NewConfig() *Config {
 			IndexConcurrency:  0,
 			IOConcurrency:     5,
 			CheckRequirements: true,
+			MaxClockSkew:      Duration{Duration: 10 * time.Second},
+			RevertModeOnExit:  true,
 		},
 		TiDB: DBStore{
 			Host:                       "127.0.0.1",
@@ -190,24 +439,41 @@ func NewConfig() *Config {
 			DistSQLScanConcurrency:     100,
 			IndexSerialScanConcurrency: 20,
 			ChecksumTableConcurrency:   16,
+			PdRetryBackoff:             Duration{Duration: time.Second},
 		},
 		Cron: Cron{
-			SwitchMode:  Duration{Duration: 5 * time.Minute},
-			LogProgress: Duration{Duration: 5 * time.Minute},
+			SwitchMode:     Duration{Duration: 5 * time.Minute},
+			LogProgress:    Duration{Duration: 5 * time.Minute},
+			CheckStoreDisk: Duration{Duration: time.Minute},
+			CheckStall:     Duration{Duration: 15 * time.Minute},
+		},
+		GRPC: GRPC{
+			KeepaliveTime:                Duration{Duration: defaultKeepaliveTime},
+			KeepaliveTimeout:             Duration{Duration: defaultKeepaliveTimeout},
+			KeepalivePermitWithoutStream: true,
+			Compression:                  GRPCCompressionNone,
+		},
+		Events: Events{
+			Timeout: Duration{Duration: 5 * time.Second},
 		},
 		Mydumper: MydumperRuntime{
 			ReadBlockSize: ReadBlockSize,
+			MaxRegionSize: MaxRegionSize,
 			CSV: CSVConfig{
 				Separator: ",",
 				Delimiter: `"`,
 			},
 		},
 		TikvImporter: TikvImporter{
-			Backend:     BackendImporter,
-			OnDuplicate: ReplaceOnDup,
+			Backend:         BackendImporter,
+			OnDuplicate:     ReplaceOnDup,
+			UploadChunkSize: UploadChunkSize,
+			KVKind:          KVKindAll,
 		},
 		PostRestore: PostRestore{
-			Checksum: true,
+			Checksum:            true,
+			AlterAutoIncrement:  true,
+			ChecksumConcurrency: ChecksumConcurrency,
 		},
 		BWList: &filter.Rules{},
 	}
@@ -309,6 +575,34 @@ func (cfg *Config) Adjust() error {
 		return errors.New("invalid config: cannot use the same character for both CSV delimiter and separator")
 	}
 
+	csv.TrailingSep = strings.ToLower(csv.TrailingSep)
+	switch csv.TrailingSep {
+	case "":
+		csv.TrailingSep = TrailingSepStrict
+	case TrailingSepStrict:
+	case TrailingSepIgnore:
+		if csv.TrimLastSep {
+			return errors.New("invalid config: `mydumper.csv.trailing-separator` cannot be \"ignore\" when `mydumper.csv.trim-last-separator` is true")
+		}
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.csv.trailing-separator` (%s)", csv.TrailingSep)
+	}
+
+	switch csv.Terminator {
+	case "", "\n", "\r\n", "\r":
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.csv.terminator` (%q), must be empty, \"\\n\", \"\\r\\n\" or \"\\r\"", csv.Terminator)
+	}
+
+	csv.OnNulByte = strings.ToLower(csv.OnNulByte)
+	switch csv.OnNulByte {
+	case "":
+		csv.OnNulByte = NulByteKeep
+	case NulByteKeep, NulByteError:
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.csv.on-nul-byte` (%s)", csv.OnNulByte)
+	}
+
 	if csv.BackslashEscape {
 		if csv.Separator == `\` {
 			return errors.New("invalid config: cannot use '\\' as CSV separator when `mydumper.csv.backslash-escape` is true")
@@ -320,31 +614,84 @@ func (cfg *Config) Adjust() error {
 
 	cfg.TikvImporter.Backend = strings.ToLower(cfg.TikvImporter.Backend)
 	switch cfg.TikvImporter.Backend {
-	case BackendTiDB:
-		if cfg.App.IndexConcurrency == 0 {
-			cfg.App.IndexConcurrency = cfg.App.RegionConcurrency
-		}
-		if cfg.App.TableConcurrency == 0 {
-			cfg.App.TableConcurrency = cfg.App.RegionConcurrency
-		}
-	case BackendImporter:
-		if cfg.App.IndexConcurrency == 0 {
-			cfg.App.IndexConcurrency = 2
-		}
-		if cfg.App.TableConcurrency == 0 {
-			cfg.App.TableConcurrency = 6
-		}
+	case BackendTiDB, BackendImporter:
 	default:
 		return errors.Errorf("invalid config: unsupported `tikv-importer.backend` (%s)", cfg.TikvImporter.Backend)
 	}
 
-	if cfg.TikvImporter.Backend == BackendTiDB {
-		cfg.TikvImporter.OnDuplicate = strings.ToLower(cfg.TikvImporter.OnDuplicate)
-		switch cfg.TikvImporter.OnDuplicate {
-		case ReplaceOnDup, IgnoreOnDup, ErrorOnDup:
-		default:
-			return errors.Errorf("invalid config: unsupported `tikv-importer.on-duplicate` (%s)", cfg.TikvImporter.OnDuplicate)
+	cfg.App.SummaryFormat = strings.ToLower(cfg.App.SummaryFormat)
+	switch cfg.App.SummaryFormat {
+	case "":
+		cfg.App.SummaryFormat = SummaryFormatJSON
+	case SummaryFormatJSON, SummaryFormatText:
+	default:
+		return errors.Errorf("invalid config: unsupported `lightning.summary-format` (%s)", cfg.App.SummaryFormat)
+	}
+
+	if cfg.App.MaxOpenFiles < 0 {
+		return errors.New("invalid config: `lightning.max-open-files` must not be negative")
+	}
+	if cfg.App.MaxTableRetry < 0 {
+		return errors.New("invalid config: `lightning.max-table-retry` must not be negative")
+	}
+	if cfg.App.MaxSchemaErrors < 0 {
+		return errors.New("invalid config: `lightning.max-schema-errors` must not be negative")
+	}
+	if cfg.App.MaxClockSkew.Duration < 0 {
+		return errors.New("invalid config: `lightning.max-clock-skew` must not be negative")
+	}
+	cfg.App.OnClockSkew = strings.ToLower(cfg.App.OnClockSkew)
+	switch cfg.App.OnClockSkew {
+	case "":
+		cfg.App.OnClockSkew = ClockSkewWarn
+	case ClockSkewWarn, ClockSkewError:
+	default:
+		return errors.Errorf("invalid config: unsupported `lightning.on-clock-skew` (%s)", cfg.App.OnClockSkew)
+	}
+	if cfg.App.MaxOpenFiles == 0 {
+		cfg.App.MaxOpenFiles = defaultMaxOpenFiles()
+	}
+
+	if cfg.TikvImporter.TxnSize < 0 {
+		return errors.New("invalid config: `tikv-importer.txn-size` must not be negative")
+	}
+	if cfg.TikvImporter.TxnSize == 0 {
+		cfg.TikvImporter.TxnSize = TiDBTxnSize
+	}
+	if cfg.TikvImporter.MaxWriteSpeed < 0 {
+		return errors.New("invalid config: `tikv-importer.max-write-speed` must not be negative")
+	}
+	if cfg.TikvImporter.MaxRowSize < 0 {
+		return errors.New("invalid config: `tikv-importer.max-row-size` must not be negative")
+	}
+	cfg.TikvImporter.OnOversizedRow = strings.ToLower(cfg.TikvImporter.OnOversizedRow)
+	switch cfg.TikvImporter.OnOversizedRow {
+	case "":
+		cfg.TikvImporter.OnOversizedRow = OversizedRowError
+	case OversizedRowError, OversizedRowSkip:
+	default:
+		return errors.Errorf("invalid config: unsupported `tikv-importer.on-oversized-row` (%s)", cfg.TikvImporter.OnOversizedRow)
+	}
+	cfg.TikvImporter.KVKind = strings.ToLower(cfg.TikvImporter.KVKind)
+	switch cfg.TikvImporter.KVKind {
+	case "":
+		cfg.TikvImporter.KVKind = KVKindAll
+	case KVKindAll:
+	case KVKindData, KVKindIndex:
+		if cfg.TikvImporter.Backend != BackendImporter {
+			return errors.Errorf("invalid config: `tikv-importer.kv-kind` (%s) requires the 'importer' backend", cfg.TikvImporter.KVKind)
 		}
+	default:
+		return errors.Errorf("invalid config: unsupported `tikv-importer.kv-kind` (%s)", cfg.TikvImporter.KVKind)
+	}
+	if cfg.TikvImporter.UploadChunkSize == 0 {
+		cfg.TikvImporter.UploadChunkSize = UploadChunkSize
+	}
+	if cfg.TikvImporter.UploadChunkSize < MinUploadChunkSize || cfg.TikvImporter.UploadChunkSize > MaxUploadChunkSize {
+		return errors.Errorf(
+			"invalid config: `tikv-importer.upload-chunk-size` must be between %d and %d bytes",
+			MinUploadChunkSize, MaxUploadChunkSize,
+		)
 	}
 
 	var err error
@@ -352,6 +699,83 @@ func (cfg *Config) Adjust() error {
 	if err != nil {
 		return errors.Annotate(err, "invalid config: `mydumper.tidb.sql_mode` must be a valid SQL_MODE")
 	}
+	cfg.TiDB.Location, err = ParseTimeZone(cfg.TiDB.TimeZone)
+	if err != nil {
+		return errors.Annotate(err, "invalid config: `tidb.time-zone` must be a time zone name or an offset like +08:00")
+	}
+	if len(cfg.TiDB.DatabaseCharset) == 0 {
+		cfg.TiDB.DatabaseCharset = mysql.DefaultCharset
+	}
+	dbCharset, dbCollation, err := charset.GetCharsetInfo(cfg.TiDB.DatabaseCharset)
+	if err != nil {
+		return errors.Errorf("invalid config: unsupported `tidb.database-charset` (%s)", cfg.TiDB.DatabaseCharset)
+	}
+	cfg.TiDB.DatabaseCharset = dbCharset
+	if len(cfg.TiDB.DatabaseCollation) == 0 {
+		cfg.TiDB.DatabaseCollation = dbCollation
+	} else if !charset.ValidCharsetAndCollation(dbCharset, cfg.TiDB.DatabaseCollation) {
+		return errors.Errorf("invalid config: unsupported `tidb.database-collation` (%s) for the charset %s", cfg.TiDB.DatabaseCollation, dbCharset)
+	}
+	cfg.TiDB.DatabaseCollation = strings.ToLower(cfg.TiDB.DatabaseCollation)
+	cfg.TiDB.OutOfRange = strings.ToLower(cfg.TiDB.OutOfRange)
+	switch cfg.TiDB.OutOfRange {
+	case "", OutOfRangeError, OutOfRangeClamp, OutOfRangeNull:
+	default:
+		return errors.Errorf("invalid config: unsupported `tidb.out-of-range` (%s)", cfg.TiDB.OutOfRange)
+	}
+	if cfg.TikvImporter.MinStoreAvailableRatio < 0 || cfg.TikvImporter.MinStoreAvailableRatio >= 1 {
+		return errors.New("invalid config: `tikv-importer.min-store-available-ratio` must be between 0 and 1")
+	}
+	if cfg.TikvImporter.MinStoreAvailableRatio > 0 && cfg.Cron.CheckStoreDisk.Duration <= 0 {
+		return errors.New("invalid config: `cron.check-store-disk` must be positive")
+	}
+	if cfg.Cron.CheckStall.Duration < 0 {
+		return errors.New("invalid config: `cron.check-stall` must not be negative")
+	}
+	if cfg.TiDB.StoreJitter.Duration < 0 {
+		return errors.New("invalid config: `tidb.store-jitter` must not be negative")
+	}
+	if cfg.TiDB.StoreStateGrace.Duration < 0 {
+		return errors.New("invalid config: `tidb.store-state-grace` must not be negative")
+	}
+	if cfg.PostRestore.ChecksumStoreConcurrency < 0 {
+		return errors.New("invalid config: `post-restore.checksum-store-concurrency` must not be negative")
+	}
+	if cfg.PostRestore.AnalyzeMinRows < 0 {
+		return errors.New("invalid config: `post-restore.analyze-min-rows` must not be negative")
+	}
+	cfg.Mydumper.OnEmptyFile = strings.ToLower(cfg.Mydumper.OnEmptyFile)
+	switch cfg.Mydumper.OnEmptyFile {
+	case "":
+		cfg.Mydumper.OnEmptyFile = EmptyFileIgnore
+	case EmptyFileIgnore, EmptyFileError:
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.on-empty-file` (%s)", cfg.Mydumper.OnEmptyFile)
+	}
+	cfg.Mydumper.FileOrder = strings.ToLower(cfg.Mydumper.FileOrder)
+	switch cfg.Mydumper.FileOrder {
+	case "":
+		cfg.Mydumper.FileOrder = FileOrderPath
+	case FileOrderPath, FileOrderName, FileOrderNumeric:
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.file-order` (%s)", cfg.Mydumper.FileOrder)
+	}
+	cfg.Mydumper.OnInsertTrigger = strings.ToLower(cfg.Mydumper.OnInsertTrigger)
+	switch cfg.Mydumper.OnInsertTrigger {
+	case "":
+		cfg.Mydumper.OnInsertTrigger = InsertTriggerWarn
+	case InsertTriggerWarn, InsertTriggerError:
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.on-insert-trigger` (%s)", cfg.Mydumper.OnInsertTrigger)
+	}
+	cfg.Mydumper.EnumSetFormat = strings.ToLower(cfg.Mydumper.EnumSetFormat)
+	switch cfg.Mydumper.EnumSetFormat {
+	case "":
+		cfg.Mydumper.EnumSetFormat = EnumSetFormatAuto
+	case EnumSetFormatAuto, EnumSetFormatLabel, EnumSetFormatIndex:
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.enum-set-format` (%s)", cfg.Mydumper.EnumSetFormat)
+	}
 
 	cfg.BWList.IgnoreDBs = append(cfg.BWList.IgnoreDBs,
 		"mysql",
@@ -369,6 +793,38 @@ func (cfg *Config) Adjust() error {
 		}
 	}
 
+	if err := cfg.adjustTableConfigs(); err != nil {
+		return err
+	}
+	if err := cfg.Events.adjust(); err != nil {
+		return err
+	}
+	// the engines of tikv-importer are expensive, so the concurrency is kept
+	// low whenever any table uses it, even if the default backend is "tidb".
+	if cfg.UsesBackend(BackendImporter) {
+		if cfg.App.IndexConcurrency == 0 {
+			cfg.App.IndexConcurrency = 2
+		}
+		if cfg.App.TableConcurrency == 0 {
+			cfg.App.TableConcurrency = 6
+		}
+	} else {
+		if cfg.App.IndexConcurrency == 0 {
+			cfg.App.IndexConcurrency = cfg.App.RegionConcurrency
+		}
+		if cfg.App.TableConcurrency == 0 {
+			cfg.App.TableConcurrency = cfg.App.RegionConcurrency
+		}
+	}
+	if cfg.UsesBackend(BackendTiDB) {
+		cfg.TikvImporter.OnDuplicate = strings.ToLower(cfg.TikvImporter.OnDuplicate)
+		switch cfg.TikvImporter.OnDuplicate {
+		case ReplaceOnDup, IgnoreOnDup, ErrorOnDup:
+		default:
+			return errors.Errorf("invalid config: unsupported `tikv-importer.on-duplicate` (%s)", cfg.TikvImporter.OnDuplicate)
+		}
+	}
+
 	// automatically determine the TiDB port & PD address from TiDB settings
 	if cfg.TiDB.Port <= 0 || len(cfg.TiDB.PdAddr) == 0 {
 		resp, err := http.Get(fmt.Sprintf("http://%s:%d/settings", cfg.TiDB.Host, cfg.TiDB.StatusPort))
@@ -398,6 +854,18 @@ func (cfg *Config) Adjust() error {
 			}
 		}
 	}
+	if err := cfg.adjustPdURL(); err != nil {
+		return err
+	}
+	if cfg.TiDB.PdMaxConcurrentRequests <= 0 {
+		cfg.TiDB.PdMaxConcurrentRequests = PDMaxConcurrentRequests
+	}
+	if cfg.TiDB.PdRetryCount <= 0 {
+		cfg.TiDB.PdRetryCount = PDRetryCount
+	}
+	if cfg.TiDB.PdRetryBackoff.Duration < 0 {
+		return errors.New("invalid config: `tidb.pd-retry-backoff` must not be negative")
+	}
 
 	// handle mydumper
 	if cfg.Mydumper.BatchSize <= 0 {
@@ -406,12 +874,65 @@ func (cfg *Config) Adjust() error {
 	if cfg.Mydumper.BatchImportRatio < 0.0 || cfg.Mydumper.BatchImportRatio >= 1.0 {
 		cfg.Mydumper.BatchImportRatio = 0.75
 	}
+	if cfg.Mydumper.MaxEngines < 0 {
+		return errors.New("invalid config: `mydumper.max-engines-per-table` must not be negative")
+	}
 	if cfg.Mydumper.ReadBlockSize <= 0 {
 		cfg.Mydumper.ReadBlockSize = ReadBlockSize
 	}
+	if cfg.Mydumper.MaxRegionSize <= 0 {
+		cfg.Mydumper.MaxRegionSize = MaxRegionSize
+	}
+	if cfg.GRPC.KeepaliveTime.Duration <= 0 {
+		cfg.GRPC.KeepaliveTime.Duration = defaultKeepaliveTime
+	}
+	if cfg.GRPC.KeepaliveTimeout.Duration <= 0 {
+		cfg.GRPC.KeepaliveTimeout.Duration = defaultKeepaliveTimeout
+	}
+	cfg.GRPC.Compression = strings.ToLower(cfg.GRPC.Compression)
+	switch cfg.GRPC.Compression {
+	case "":
+		cfg.GRPC.Compression = GRPCCompressionNone
+	case GRPCCompressionNone, GRPCCompressionGzip:
+	default:
+		return errors.Errorf("invalid config: unsupported `grpc.compression` (%s)", cfg.GRPC.Compression)
+	}
+	if cfg.PostRestore.ChecksumConcurrency <= 0 {
+		cfg.PostRestore.ChecksumConcurrency = ChecksumConcurrency
+	}
+	cfg.PostRestore.ChecksumFallback = strings.ToLower(cfg.PostRestore.ChecksumFallback)
+	switch cfg.PostRestore.ChecksumFallback {
+	case "":
+		cfg.PostRestore.ChecksumFallback = ChecksumFallbackNone
+	case ChecksumFallbackNone, ChecksumFallbackCount, ChecksumFallbackWarn:
+	default:
+		return errors.Errorf("invalid config: unsupported `post-restore.checksum-fallback` (%s)", cfg.PostRestore.ChecksumFallback)
+	}
 	if len(cfg.Mydumper.CharacterSet) == 0 {
 		cfg.Mydumper.CharacterSet = "auto"
 	}
+	cfg.Mydumper.DataCharacterSet = strings.ToLower(cfg.Mydumper.DataCharacterSet)
+	if len(cfg.Mydumper.DataCharacterSet) == 0 {
+		cfg.Mydumper.DataCharacterSet = "binary"
+	}
+	if !isValidDataCharacterSet(cfg.Mydumper.DataCharacterSet) {
+		return errors.Errorf("invalid config: unsupported `mydumper.data-character-set` (%s)", cfg.Mydumper.DataCharacterSet)
+	}
+	cfg.Mydumper.DataInvalidChar = strings.ToLower(cfg.Mydumper.DataInvalidChar)
+	switch cfg.Mydumper.DataInvalidChar {
+	case "":
+		cfg.Mydumper.DataInvalidChar = DataInvalidCharError
+	case DataInvalidCharError, DataInvalidCharReplace:
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.data-invalid-char` (%s)", cfg.Mydumper.DataInvalidChar)
+	}
+
+	if err := cfg.adjustStdin(); err != nil {
+		return err
+	}
+	if (len(cfg.Mydumper.HTTP.CertPath) == 0) != (len(cfg.Mydumper.HTTP.KeyPath) == 0) {
+		return errors.New("invalid config: `mydumper.http.cert-path` and `mydumper.http.key-path` must be set together")
+	}
 
 	if len(cfg.Checkpoint.Schema) == 0 {
 		cfg.Checkpoint.Schema = "tidb_lightning_checkpoint"
@@ -419,14 +940,168 @@ func (cfg *Config) Adjust() error {
 	if len(cfg.Checkpoint.Driver) == 0 {
 		cfg.Checkpoint.Driver = CheckpointDriverFile
 	}
+	cfg.Checkpoint.OnSchemaChange = strings.ToLower(cfg.Checkpoint.OnSchemaChange)
+	switch cfg.Checkpoint.OnSchemaChange {
+	case "":
+		cfg.Checkpoint.OnSchemaChange = SchemaChangeError
+	case SchemaChangeError, SchemaChangeReimport:
+	default:
+		return errors.Errorf("invalid config: unsupported `checkpoint.on-schema-change` (%s)", cfg.Checkpoint.OnSchemaChange)
+	}
+	cfg.Checkpoint.OnBackendChange = strings.ToLower(cfg.Checkpoint.OnBackendChange)
+	switch cfg.Checkpoint.OnBackendChange {
+	case "":
+		cfg.Checkpoint.OnBackendChange = BackendChangeError
+	case BackendChangeError, BackendChangeReimport:
+	default:
+		return errors.Errorf("invalid config: unsupported `checkpoint.on-backend-change` (%s)", cfg.Checkpoint.OnBackendChange)
+	}
+	if cfg.Checkpoint.FlushInterval.Duration < 0 {
+		return errors.New("invalid config: `checkpoint.flush-interval` must not be negative")
+	}
+	if !checkpointNamespaceRegexp.MatchString(cfg.Checkpoint.Namespace) {
+		return errors.Errorf("invalid config: `checkpoint.namespace` must consist of at most %d ASCII letters, digits or underscores, got '%s'", maxCheckpointNamespaceLen, cfg.Checkpoint.Namespace)
+	}
 	if len(cfg.Checkpoint.DSN) == 0 {
 		switch cfg.Checkpoint.Driver {
 		case CheckpointDriverMySQL:
-			cfg.Checkpoint.DSN = common.ToDSN(cfg.TiDB.Host, cfg.TiDB.Port, cfg.TiDB.User, cfg.TiDB.Psw, mysql.DefaultSQLMode, defaultMaxAllowedPacket)
+			cfg.Checkpoint.DSN = common.ToDSN(cfg.TiDB.Host, cfg.TiDB.Port, cfg.TiDB.User, cfg.TiDB.Psw, mysql.DefaultSQLMode, defaultMaxAllowedPacket, "")
 		case CheckpointDriverFile:
-			cfg.Checkpoint.DSN = "/tmp/" + cfg.Checkpoint.Schema + ".pb"
+			if len(cfg.Checkpoint.Namespace) > 0 {
+				cfg.Checkpoint.DSN = "/tmp/" + cfg.Checkpoint.Schema + "." + cfg.Checkpoint.Namespace + ".pb"
+			} else {
+				cfg.Checkpoint.DSN = "/tmp/" + cfg.Checkpoint.Schema + ".pb"
+			}
 		}
 	}
 
 	return nil
 }
+
+// adjustStdin validates the target table when the data are read from the
+// standard input.
+func (cfg *Config) adjustStdin() error {
+	if cfg.Mydumper.SourceDir != StdinSourceDir {
+		return nil
+	}
+
+	stdin := &cfg.Mydumper.Stdin
+	if len(stdin.Schema) == 0 || len(stdin.Table) == 0 {
+		return errors.New("invalid config: `mydumper.stdin.schema` and `mydumper.stdin.table` must be set when reading from stdin")
+	}
+	stdin.Type = strings.ToLower(stdin.Type)
+	switch stdin.Type {
+	case "":
+		stdin.Type = StdinTypeCSV
+	case StdinTypeCSV, StdinTypeSQL:
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.stdin.type` (%s)", stdin.Type)
+	}
+	if !cfg.Mydumper.NoSchema {
+		return errors.New("invalid config: `mydumper.no-schema` must be true when reading from stdin, the target table must already exist")
+	}
+
+	// resuming requires seeking back to the saved offset, which stdin can't do.
+	if cfg.Checkpoint.Enable {
+		log.L().Warn("checkpoints are disabled because the data are read from stdin, which cannot be resumed after interruption")
+		cfg.Checkpoint.Enable = false
+	}
+	return nil
+}
+
+// adjustPdURL validates the base URL of the PD HTTP API, defaulting to plain
+// HTTP on `tidb.pd-addr`.
+func (cfg *Config) adjustPdURL() error {
+	if len(cfg.TiDB.PdURL) == 0 {
+		cfg.TiDB.PdURL = "http://" + cfg.TiDB.PdAddr
+		return nil
+	}
+
+	u, err := url.Parse(cfg.TiDB.PdURL)
+	if err != nil {
+		return errors.Annotate(err, "invalid config: `tidb.pd-url` is not a valid URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.Errorf("invalid config: `tidb.pd-url` must use the http or https scheme, got '%s'", cfg.TiDB.PdURL)
+	}
+	if len(u.Host) == 0 {
+		return errors.Errorf("invalid config: `tidb.pd-url` must contain a host, got '%s'", cfg.TiDB.PdURL)
+	}
+	if len(u.RawQuery) > 0 || len(u.Fragment) > 0 || u.User != nil {
+		return errors.Errorf("invalid config: `tidb.pd-url` must not contain user info, query or fragment, got '%s'", cfg.TiDB.PdURL)
+	}
+	cfg.TiDB.PdURL = strings.TrimRight(cfg.TiDB.PdURL, "/")
+	return nil
+}
+
+// defaultMaxOpenFiles returns a share of the soft limit on open file
+// descriptors of this process, or 0 (unlimited) if the limit is unknown.
+func defaultMaxOpenFiles() int {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		log.L().Warn("cannot get the open files limit, leaving `lightning.max-open-files` unlimited", log.ShortError(err))
+		return 0
+	}
+	if rlimit.Cur >= math.MaxInt32 {
+		return 0
+	}
+	return int(float64(rlimit.Cur) * defaultOpenFilesRatio)
+}
+
+func (events *Events) adjust() error {
+	if len(events.Sink) > 0 {
+		u, err := url.Parse(events.Sink)
+		if err != nil {
+			return errors.Annotate(err, "invalid config: `events.sink` is not a valid URL")
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https":
+		default:
+			return errors.Errorf("invalid config: unsupported `events.sink` scheme (%s), only http and https are supported", u.Scheme)
+		}
+	}
+	if (len(events.CertPath) == 0) != (len(events.KeyPath) == 0) {
+		return errors.New("invalid config: `events.cert-path` and `events.key-path` must be set together")
+	}
+	if len(events.Types) == 0 {
+		events.Types = []string{EventTableStarted, EventChunkProgress, EventTableCompleted, EventTableFailed}
+	}
+	for i, tp := range events.Types {
+		tp = strings.ToLower(tp)
+		switch tp {
+		case EventTableStarted, EventChunkProgress, EventTableCompleted, EventTableFailed:
+		default:
+			return errors.Errorf("invalid config: unsupported `events.types` (%s)", tp)
+		}
+		events.Types[i] = tp
+	}
+	if events.Timeout.Duration <= 0 {
+		return errors.New("invalid config: `events.timeout` must be positive")
+	}
+	return nil
+}
+
+var timeZoneOffsetRegexp = regexp.MustCompile(`^([+-])(\d{1,2}):(\d{2})$`)
+
+// ParseTimeZone parses a time zone name of the IANA database (e.g.
+// "Asia/Shanghai"), or an offset from UTC (e.g. "+08:00"). An empty string is
+// the local time zone of the machine.
+func ParseTimeZone(name string) (*time.Location, error) {
+	if len(name) == 0 {
+		return time.Local, nil
+	}
+	if m := timeZoneOffsetRegexp.FindStringSubmatch(name); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		if hours > 14 || minutes >= 60 {
+			return nil, errors.Errorf("time zone offset %s out of range", name)
+		}
+		offset := hours*3600 + minutes*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(name, offset), nil
+	}
+	loc, err := time.LoadLocation(name)
+	return loc, errors.Trace(err)
+}
@@ -22,7 +22,9 @@ import (
 	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/parser/mysql"
@@ -125,6 +127,554 @@ func (s *configTestSuite) TestAdjustInvalidBackend(c *C) {
 	c.Assert(err, ErrorMatches, "invalid config: unsupported `tikv-importer\\.backend` \\(no_such_backend\\)")
 }
 
+func (s *configTestSuite) TestAdjustNegativeMaxWriteSpeed(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TikvImporter.MaxWriteSpeed = -1
+	err := cfg.Adjust()
+	c.Assert(err, ErrorMatches, "invalid config: `tikv-importer\\.max-write-speed` must not be negative")
+}
+
+func (s *configTestSuite) TestAdjustUploadChunkSize(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TikvImporter.UploadChunkSize, Equals, config.UploadChunkSize)
+
+	cfg.TikvImporter.UploadChunkSize = 0
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TikvImporter.UploadChunkSize, Equals, config.UploadChunkSize)
+
+	cfg.TikvImporter.UploadChunkSize = 100
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tikv-importer\\.upload-chunk-size` must be between 1024 and 32505856 bytes")
+
+	cfg.TikvImporter.UploadChunkSize = 32 << 20
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tikv-importer\\.upload-chunk-size` must be between 1024 and 32505856 bytes")
+}
+
+func (s *configTestSuite) TestAdjustChecksumConcurrency(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.PostRestore.ChecksumConcurrency, Equals, 2)
+
+	cfg.PostRestore.ChecksumConcurrency = 0
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.PostRestore.ChecksumConcurrency, Equals, 2)
+
+	cfg.PostRestore.ChecksumConcurrency = 7
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.PostRestore.ChecksumConcurrency, Equals, 7)
+}
+
+func (s *configTestSuite) TestAdjustCheckpointNamespace(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.Checkpoint.Namespace = "team_a"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Checkpoint.DSN, Equals, "/tmp/tidb_lightning_checkpoint.team_a.pb")
+
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.Checkpoint.Namespace = "team-a"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `checkpoint.namespace` must consist of .*")
+
+	cfg.Checkpoint.Namespace = strings.Repeat("a", 49)
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `checkpoint.namespace` must consist of at most 48 .*")
+}
+
+func (s *configTestSuite) TestAdjustStdin(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.Mydumper.SourceDir = config.StdinSourceDir
+	cfg.Mydumper.NoSchema = true
+	cfg.Checkpoint.Enable = true
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `mydumper.stdin.schema` and `mydumper.stdin.table` must be set .*")
+
+	cfg.Mydumper.Stdin.Schema = "db"
+	cfg.Mydumper.Stdin.Table = "tbl"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Mydumper.Stdin.Type, Equals, config.StdinTypeCSV)
+	c.Assert(cfg.Checkpoint.Enable, IsFalse)
+
+	cfg.Mydumper.Stdin.Type = "JSON"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `mydumper.stdin.type` \\(json\\)")
+
+	cfg.Mydumper.Stdin.Type = "SQL"
+	cfg.Mydumper.NoSchema = false
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `mydumper.no-schema` must be true .*")
+}
+
+func (s *configTestSuite) TestAdjustOutOfRange(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TiDB.OutOfRange = "CLAMP"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TiDB.OutOfRange, Equals, config.OutOfRangeClamp)
+
+	cfg.TiDB.OutOfRange = "wrap"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `tidb.out-of-range` \\(wrap\\)")
+}
+
+func (s *configTestSuite) TestAdjustEnumSetFormat(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Mydumper.EnumSetFormat, Equals, config.EnumSetFormatAuto)
+
+	cfg.Mydumper.EnumSetFormat = "Label"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Mydumper.EnumSetFormat, Equals, config.EnumSetFormatLabel)
+
+	cfg.Mydumper.EnumSetFormat = "name"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `mydumper.enum-set-format` \\(name\\)")
+}
+
+func (s *configTestSuite) TestAdjustOnEmptyFile(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Mydumper.OnEmptyFile, Equals, config.EmptyFileIgnore)
+
+	cfg.Mydumper.OnEmptyFile = "ERROR"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Mydumper.OnEmptyFile, Equals, config.EmptyFileError)
+
+	cfg.Mydumper.OnEmptyFile = "skip"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `mydumper.on-empty-file` \\(skip\\)")
+}
+
+func (s *configTestSuite) TestAdjustChecksumFallback(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.PostRestore.ChecksumFallback, Equals, config.ChecksumFallbackNone)
+
+	cfg.PostRestore.ChecksumFallback = "Count"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.PostRestore.ChecksumFallback, Equals, config.ChecksumFallbackCount)
+
+	cfg.PostRestore.ChecksumFallback = "skip"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `post-restore.checksum-fallback` \\(skip\\)")
+}
+
+func (s *configTestSuite) TestAdjustChecksumStoreConcurrency(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.PostRestore.ChecksumStoreConcurrency, Equals, 0)
+
+	cfg.PostRestore.ChecksumStoreConcurrency = -1
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `post-restore.checksum-store-concurrency` must not be negative")
+}
+
+func (s *configTestSuite) TestAdjustHTTPSource(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.Mydumper.SourceDir = "https://example.com/dump/"
+	cfg.Mydumper.HTTP.CertPath = "client.pem"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `mydumper.http.cert-path` and `mydumper.http.key-path` must be set together")
+
+	cfg.Mydumper.HTTP.KeyPath = "client-key.pem"
+	c.Assert(cfg.Adjust(), IsNil)
+}
+
+func (s *configTestSuite) TestAdjustOnInsertTrigger(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Mydumper.OnInsertTrigger, Equals, config.InsertTriggerWarn)
+
+	cfg.Mydumper.OnInsertTrigger = "Error"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Mydumper.OnInsertTrigger, Equals, config.InsertTriggerError)
+
+	cfg.Mydumper.OnInsertTrigger = "ignore"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `mydumper.on-insert-trigger` \\(ignore\\)")
+}
+
+func (s *configTestSuite) TestAdjustClockSkew(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.App.MaxClockSkew.Duration, Equals, 10*time.Second)
+	c.Assert(cfg.App.OnClockSkew, Equals, config.ClockSkewWarn)
+
+	cfg.App.OnClockSkew = "fail"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `lightning.on-clock-skew` \\(fail\\)")
+
+	cfg.App.OnClockSkew = config.ClockSkewError
+	cfg.App.MaxClockSkew.Duration = -time.Second
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `lightning.max-clock-skew` must not be negative")
+}
+
+func (s *configTestSuite) TestAdjustMinStoreAvailableRatio(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TikvImporter.MinStoreAvailableRatio = 0.1
+	c.Assert(cfg.Adjust(), IsNil)
+
+	cfg.TikvImporter.MinStoreAvailableRatio = 1
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tikv-importer.min-store-available-ratio` must be between 0 and 1")
+
+	cfg.TikvImporter.MinStoreAvailableRatio = 0.1
+	cfg.Cron.CheckStoreDisk.Duration = 0
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `cron.check-store-disk` must be positive")
+}
+
+func (s *configTestSuite) TestAdjustMaxOpenFiles(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.App.MaxOpenFiles, GreaterEqual, 0)
+
+	cfg.App.MaxOpenFiles = 100
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.App.MaxOpenFiles, Equals, 100)
+
+	cfg.App.MaxOpenFiles = -1
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `lightning.max-open-files` must not be negative")
+}
+
+func (s *configTestSuite) TestAdjustMaxTableRetry(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.App.MaxTableRetry, Equals, 0)
+	c.Assert(cfg.App.ContinueOnTableFailure, IsFalse)
+
+	cfg.App.MaxTableRetry = -1
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `lightning.max-table-retry` must not be negative")
+}
+
+func (s *configTestSuite) TestAdjustMaxSchemaErrors(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.App.MaxSchemaErrors, Equals, 0)
+
+	cfg.App.MaxSchemaErrors = -1
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `lightning.max-schema-errors` must not be negative")
+}
+
+func (s *configTestSuite) TestAdjustCheckpointFlushInterval(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Checkpoint.FlushInterval.Duration, Equals, time.Duration(0))
+
+	cfg.Checkpoint.FlushInterval.Duration = -time.Second
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `checkpoint.flush-interval` must not be negative")
+}
+
+func (s *configTestSuite) TestAdjustEvents(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Events.Sink, Equals, "")
+	c.Assert(cfg.Events.Types, DeepEquals, []string{"started", "chunk-progress", "completed", "failed"})
+
+	cfg.Events.Sink = "https://example.com/events"
+	cfg.Events.Types = []string{"Completed", "FAILED"}
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Events.Types, DeepEquals, []string{config.EventTableCompleted, config.EventTableFailed})
+
+	cfg.Events.Types = []string{"finished"}
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `events.types` \\(finished\\)")
+
+	cfg.Events.Types = nil
+	cfg.Events.Sink = "kafka://broker:9092/lightning"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `events.sink` scheme \\(kafka\\), only http and https are supported")
+
+	cfg.Events.Sink = "https://example.com/events"
+	cfg.Events.CertPath = "/path/to/cert.pem"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `events.cert-path` and `events.key-path` must be set together")
+	cfg.Events.CertPath = ""
+
+	cfg.Events.Sink = ""
+	cfg.Events.Timeout.Duration = 0
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `events.timeout` must be positive")
+}
+
+func (s *configTestSuite) TestAdjustTimeZone(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TiDB.Location, Equals, time.Local)
+
+	cfg.TiDB.TimeZone = "UTC"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TiDB.Location, Equals, time.UTC)
+
+	cfg.TiDB.TimeZone = "-05:30"
+	c.Assert(cfg.Adjust(), IsNil)
+	_, offset := time.Unix(0, 0).In(cfg.TiDB.Location).Zone()
+	c.Assert(offset, Equals, -(5*3600 + 30*60))
+
+	cfg.TiDB.TimeZone = "+25:00"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tidb.time-zone` must be a time zone name or an offset like \\+08:00: time zone offset \\+25:00 out of range")
+
+	cfg.TiDB.TimeZone = "Mars/Olympus_Mons"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tidb.time-zone` must be a time zone name or an offset like \\+08:00: .*")
+}
+
+func (s *configTestSuite) TestAdjustMaxEngines(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Mydumper.MaxEngines, Equals, 0)
+
+	cfg.Mydumper.MaxEngines = -1
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `mydumper.max-engines-per-table` must not be negative")
+}
+
+func (s *configTestSuite) TestAdjustOversizedRow(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TikvImporter.MaxRowSize, Equals, int64(0))
+	c.Assert(cfg.TikvImporter.OnOversizedRow, Equals, config.OversizedRowError)
+
+	cfg.TikvImporter.OnOversizedRow = "SKIP"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TikvImporter.OnOversizedRow, Equals, config.OversizedRowSkip)
+
+	cfg.TikvImporter.OnOversizedRow = "truncate"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `tikv-importer.on-oversized-row` \\(truncate\\)")
+
+	cfg.TikvImporter.OnOversizedRow = ""
+	cfg.TikvImporter.MaxRowSize = -1
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tikv-importer.max-row-size` must not be negative")
+}
+
+func (s *configTestSuite) TestAdjustFileOrder(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Mydumper.FileOrder, Equals, config.FileOrderPath)
+
+	cfg.Mydumper.FileOrder = "Numeric"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Mydumper.FileOrder, Equals, config.FileOrderNumeric)
+
+	cfg.Mydumper.FileOrder = "size"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `mydumper.file-order` \\(size\\)")
+}
+
+func (s *configTestSuite) TestAdjustDatabaseCharset(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TiDB.AutoCreateDatabase, IsFalse)
+	c.Assert(cfg.TiDB.DatabaseCharset, Equals, "utf8mb4")
+	c.Assert(cfg.TiDB.DatabaseCollation, Equals, "utf8mb4_bin")
+
+	cfg.TiDB.DatabaseCharset = "Latin1"
+	cfg.TiDB.DatabaseCollation = ""
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TiDB.DatabaseCharset, Equals, "latin1")
+	c.Assert(cfg.TiDB.DatabaseCollation, Equals, "latin1_bin")
+
+	cfg.TiDB.DatabaseCharset = "utf8mb4"
+	cfg.TiDB.DatabaseCollation = "UTF8MB4_GENERAL_CI"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TiDB.DatabaseCollation, Equals, "utf8mb4_general_ci")
+
+	cfg.TiDB.DatabaseCollation = "latin1_bin"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `tidb.database-collation` \\(latin1_bin\\) for the charset utf8mb4")
+
+	cfg.TiDB.DatabaseCharset = "gbk"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `tidb.database-charset` \\(gbk\\)")
+}
+
+func (s *configTestSuite) TestAdjustPdURL(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TiDB.PdURL, Equals, "http://234.56.78.90:12345")
+
+	cfg.TiDB.PdURL = "https://gateway.example.com/clusters/c1/"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TiDB.PdURL, Equals, "https://gateway.example.com/clusters/c1")
+
+	cfg.TiDB.PdURL = "gateway.example.com:2379"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tidb.pd-url` must use the http or https scheme.*")
+
+	cfg.TiDB.PdURL = "http:///pd"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tidb.pd-url` must contain a host.*")
+
+	cfg.TiDB.PdURL = "http://gateway.example.com/pd?token=x"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tidb.pd-url` must not contain user info, query or fragment.*")
+}
+
+func (s *configTestSuite) TestTableConfigChecksum(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.PostRestore.Checksum = false
+	cfg.TableConfigs = []*config.TableConfig{
+		{Pattern: "Logs.*", Checksum: "skip"},
+		{Pattern: "app.orders", Checksum: "required"},
+	}
+	err := cfg.Adjust()
+	c.Assert(err, IsNil)
+
+	shouldChecksum, err := cfg.ShouldChecksum("logs", "access")
+	c.Assert(err, IsNil)
+	c.Assert(shouldChecksum, IsFalse)
+	shouldChecksum, err = cfg.ShouldChecksum("APP", "orders")
+	c.Assert(err, IsNil)
+	c.Assert(shouldChecksum, IsTrue)
+	shouldChecksum, err = cfg.ShouldChecksum("app", "users")
+	c.Assert(err, IsNil)
+	c.Assert(shouldChecksum, IsFalse)
+
+	cfg.PostRestore.Checksum = true
+	shouldChecksum, err = cfg.ShouldChecksum("app", "users")
+	c.Assert(err, IsNil)
+	c.Assert(shouldChecksum, IsTrue)
+
+	// overlapping globs can only be detected on the actual table.
+	cfg.TableConfigs = append(cfg.TableConfigs, &config.TableConfig{Pattern: "logs.a*", Checksum: "required"})
+	_, err = cfg.ShouldChecksum("logs", "access")
+	c.Assert(err, ErrorMatches, "table `logs`.`access` requires checksum by `logs.a\\*` but is also skipped by `logs.\\*`")
+}
+
+func (s *configTestSuite) TestDataCharacterSet(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Mydumper.DataCharacterSet, Equals, "binary")
+	c.Assert(cfg.Mydumper.DataInvalidChar, Equals, config.DataInvalidCharError)
+
+	cfg.Mydumper.DataCharacterSet = "LATIN1"
+	cfg.Mydumper.DataInvalidChar = "Replace"
+	cfg.TableConfigs = []*config.TableConfig{
+		{Pattern: "legacy.*", DataCharacterSet: "GBK"},
+		{Pattern: "legacy.t1", DataCharacterSet: "gb18030"},
+	}
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Mydumper.DataInvalidChar, Equals, config.DataInvalidCharReplace)
+	c.Assert(cfg.DataCharacterSet("Legacy", "t1"), Equals, "gbk")
+	c.Assert(cfg.DataCharacterSet("app", "t1"), Equals, "latin1")
+
+	cfg.Mydumper.DataCharacterSet = "ucs2"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `mydumper.data-character-set` \\(ucs2\\)")
+	cfg.Mydumper.DataCharacterSet = ""
+	cfg.Mydumper.DataInvalidChar = "skip"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `mydumper.data-invalid-char` \\(skip\\)")
+}
+
+func (s *configTestSuite) TestTransforms(c *C) {
+	cfg := config.NewConfig()
+	err := cfg.LoadFromTOML([]byte(`
+		[[table-config]]
+		pattern = "shop.orders"
+		checksum = "skip"
+
+		[[table-config]]
+		pattern = "shop.*"
+		transform = { name = "TRIM(name)" }
+
+		[[table-config]]
+		pattern = "shop.orders"
+		transform = { code = "UPPER(code)" }
+	`))
+	c.Assert(err, IsNil)
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Transforms("Shop", "orders"), DeepEquals, map[string]string{"name": "TRIM(name)"})
+	c.Assert(cfg.Transforms("app", "orders"), IsNil)
+}
+
+func (s *configTestSuite) TestTableBackend(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	cfg.TikvImporter.Addr = ""
+	cfg.TableConfigs = []*config.TableConfig{
+		{Pattern: "shop.fact_*", Backend: "Importer"},
+	}
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `table-config.backend` of `shop.fact_\\*` is 'importer' but `tikv-importer.addr` is not set")
+
+	cfg.TikvImporter.Addr = "127.0.0.1:8287"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.UsesBackend(config.BackendImporter), IsTrue)
+	c.Assert(cfg.TableBackend("shop", "fact_orders"), Equals, config.BackendImporter)
+	c.Assert(cfg.TableBackend("shop", "regions"), Equals, config.BackendTiDB)
+
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TikvImporter.KVKind = config.KVKindData
+	cfg.TableConfigs = []*config.TableConfig{
+		{Pattern: "shop.regions", Backend: "tidb"},
+	}
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `table-config.backend` of `shop.regions` is 'tidb' but `tikv-importer.kv-kind` \\(data\\) requires the 'importer' backend")
+
+	cfg.TikvImporter.KVKind = config.KVKindAll
+	cfg.TikvImporter.OnDuplicate = "overwrite"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `tikv-importer.on-duplicate` \\(overwrite\\)")
+	c.Assert(cfg.UsesBackend(config.BackendTiDB), IsTrue)
+}
+
+func (s *configTestSuite) TestTablePriority(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TableConfigs = []*config.TableConfig{
+		{Pattern: "shop.orders", Checksum: "skip"},
+		{Pattern: "Shop.order*", Priority: 10},
+		{Pattern: "shop.*", Priority: -1},
+	}
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TablePriority("shop", "Orders"), Equals, 10)
+	c.Assert(cfg.TablePriority("shop", "regions"), Equals, -1)
+	c.Assert(cfg.TablePriority("app", "orders"), Equals, 0)
+}
+
+func (s *configTestSuite) TestInvalidTableConfig(c *C) {
+	testCases := []struct {
+		tableConfig config.TableConfig
+		err         string
+	}{
+		{
+			tableConfig: config.TableConfig{Checksum: "skip"},
+			err:         "invalid config: `table-config.pattern` must not be empty",
+		},
+		{
+			tableConfig: config.TableConfig{Pattern: "db.[", Checksum: "skip"},
+			err:         "invalid config: `table-config.pattern` \\(db.\\[\\) is not a valid glob",
+		},
+		{
+			tableConfig: config.TableConfig{Pattern: "db.t", Checksum: "sometimes"},
+			err:         "invalid config: unsupported `table-config.checksum` \\(sometimes\\)",
+		},
+		{
+			tableConfig: config.TableConfig{Pattern: "db.t", DataCharacterSet: "big5"},
+			err:         "invalid config: unsupported `table-config.data-character-set` \\(big5\\)",
+		},
+		{
+			tableConfig: config.TableConfig{Pattern: "db.t", Backend: "local"},
+			err:         "invalid config: unsupported `table-config.backend` \\(local\\)",
+		},
+	}
+
+	for _, tc := range testCases {
+		cfg := config.NewConfig()
+		assignMinimalLegalValue(cfg)
+		tableConfig := tc.tableConfig
+		cfg.TableConfigs = []*config.TableConfig{&tableConfig}
+		c.Assert(cfg.Adjust(), ErrorMatches, tc.err)
+	}
+
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TableConfigs = []*config.TableConfig{
+		{Pattern: "logs.*", Checksum: "skip"},
+		{Pattern: "logs.important", Checksum: "required"},
+	}
+	err := cfg.Adjust()
+	c.Assert(err, ErrorMatches, "invalid config: table pattern `logs.important` requires checksum but is also skipped by `logs.\\*`")
+}
+
 func (s *configTestSuite) TestDecodeError(c *C) {
 	ts, host, port := startMockServer(c, http.StatusOK, "invalid-string")
 	defer ts.Close()
@@ -265,6 +815,56 @@ func (s *configTestSuite) TestInvalidCSV(c *C) {
 			`,
 			err: "invalid config: cannot use '\\' as CSV delimiter when `mydumper.csv.backslash-escape` is true",
 		},
+		{
+			input: `
+				[mydumper.csv]
+				trailing-separator = "Ignore"
+			`,
+			err: "",
+		},
+		{
+			input: `
+				[mydumper.csv]
+				trailing-separator = "ignore"
+				trim-last-separator = true
+			`,
+			err: "invalid config: `mydumper.csv.trailing-separator` cannot be \"ignore\" when `mydumper.csv.trim-last-separator` is true",
+		},
+		{
+			input: `
+				[mydumper.csv]
+				trailing-separator = "drop"
+			`,
+			err: "invalid config: unsupported `mydumper.csv.trailing-separator` (drop)",
+		},
+		{
+			input: `
+				[mydumper.csv]
+				terminator = "\r\n"
+			`,
+			err: "",
+		},
+		{
+			input: `
+				[mydumper.csv]
+				terminator = "|"
+			`,
+			err: "invalid config: unsupported `mydumper.csv.terminator` (\"|\"), must be empty, \"\\n\", \"\\r\\n\" or \"\\r\"",
+		},
+		{
+			input: `
+				[mydumper.csv]
+				on-nul-byte = "Error"
+			`,
+			err: "",
+		},
+		{
+			input: `
+				[mydumper.csv]
+				on-nul-byte = "strip"
+			`,
+			err: "invalid config: unsupported `mydumper.csv.on-nul-byte` (strip)",
+		},
 		{
 			input: `
 				[tidb]
@@ -327,6 +927,87 @@ func (s *configTestSuite) TestTOMLUnusedKeys(c *C) {
 	c.Assert(err, ErrorMatches, regexp.QuoteMeta("config file contained unknown configuration options: lightning.typo"))
 }
 
+func (s *configTestSuite) TestAdjustKVKind(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TikvImporter.KVKind, Equals, config.KVKindAll)
+
+	cfg.TikvImporter.KVKind = "Index"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TikvImporter.KVKind, Equals, config.KVKindIndex)
+
+	cfg.TikvImporter.KVKind = "rows"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `tikv-importer\\.kv-kind` \\(rows\\)")
+
+	cfg.TikvImporter.KVKind = config.KVKindData
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tikv-importer\\.kv-kind` \\(data\\) requires the 'importer' backend")
+}
+
+func (s *configTestSuite) TestAdjustOnSchemaChange(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Checkpoint.OnSchemaChange, Equals, config.SchemaChangeError)
+
+	cfg.Checkpoint.OnSchemaChange = "REIMPORT"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Checkpoint.OnSchemaChange, Equals, config.SchemaChangeReimport)
+
+	cfg.Checkpoint.OnSchemaChange = "ignore"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `checkpoint\\.on-schema-change` \\(ignore\\)")
+}
+
+func (s *configTestSuite) TestAdjustOnBackendChange(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Checkpoint.OnBackendChange, Equals, config.BackendChangeError)
+
+	cfg.Checkpoint.OnBackendChange = "Reimport"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Checkpoint.OnBackendChange, Equals, config.BackendChangeReimport)
+
+	cfg.Checkpoint.OnBackendChange = "continue"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `checkpoint\\.on-backend-change` \\(continue\\)")
+}
+
+func (s *configTestSuite) TestGRPCKeepalive(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.GRPC.KeepaliveTime.Duration, Equals, 10*time.Second)
+	c.Assert(cfg.GRPC.KeepaliveTimeout.Duration, Equals, 3*time.Second)
+	c.Assert(cfg.GRPC.KeepalivePermitWithoutStream, IsTrue)
+
+	err := cfg.LoadFromTOML([]byte(`
+		[grpc]
+		keepalive-time = "1m"
+		keepalive-timeout = "0s"
+		keepalive-permit-without-stream = false
+	`))
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.GRPC.KeepaliveTime.Duration, Equals, time.Minute)
+	c.Assert(cfg.GRPC.KeepaliveTimeout.Duration, Equals, 3*time.Second)
+	c.Assert(cfg.GRPC.KeepalivePermitWithoutStream, IsFalse)
+}
+
+func (s *configTestSuite) TestGRPCCompression(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.GRPC.Compression, Equals, config.GRPCCompressionNone)
+
+	cfg.GRPC.Compression = "GZIP"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.GRPC.Compression, Equals, config.GRPCCompressionGzip)
+
+	cfg.GRPC.Compression = "snappy"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `grpc\\.compression` \\(snappy\\)")
+}
+
 func (s *configTestSuite) TestDurationUnmarshal(c *C) {
 	duration := config.Duration{}
 	err := duration.UnmarshalText([]byte("13m20s"))
@@ -420,6 +1101,19 @@ func (s *configTestSuite) TestDefaultTidbBackendValue(c *C) {
 	c.Assert(cfg.App.TableConcurrency, Equals, 123)
 }
 
+func (s *configTestSuite) TestDefaultMixedBackendValue(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TikvImporter.Backend = "tidb"
+	cfg.App.RegionConcurrency = 123
+	cfg.TikvImporter.Addr = "127.0.0.1:8287"
+	cfg.TableConfigs = []*config.TableConfig{{Pattern: "db.big", Backend: config.BackendImporter}}
+	err := cfg.Adjust()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.App.IndexConcurrency, Equals, 2)
+	c.Assert(cfg.App.TableConcurrency, Equals, 6)
+}
+
 func (s *configTestSuite) TestDefaultCouldBeOverwritten(c *C) {
 	cfg := config.NewConfig()
 	assignMinimalLegalValue(cfg)
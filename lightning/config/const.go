@@ -13,6 +13,8 @@
 
 package config
 
+import "time"
+
 const (
 	_K = int64(1 << 10)
 	_M = _K << 10
@@ -21,8 +23,35 @@ const (
 	// mydumper
 	ReadBlockSize int64 = 64 * _K
 	MinRegionSize int64 = 256 * _M
+	MaxRegionSize int64 = 256 * _M
 
 	BufferSizeScale = 5
 
+	// tikv-importer
+	UploadChunkSize    int64 = 31 * _K
+	MinUploadChunkSize int64 = 1 * _K
+	MaxUploadChunkSize int64 = 31 * _M
+	TiDBTxnSize        int64 = 1 * _M
+
+	// lightning
+	// the share of the soft RLIMIT_NOFILE used as the default `max-open-files`,
+	// leaving the rest for log files, HTTP and SQL connections.
+	defaultOpenFilesRatio = 0.75
+
+	// tidb
+	PDMaxConcurrentRequests = 4
+	PDRetryCount            = 3
+
+	// checkpoint
+	// the namespace prefixes table names of at most 64 characters, and the
+	// longest suffix is "_engine_v5".
+	maxCheckpointNamespaceLen = 48
+
+	// post-restore
+	ChecksumConcurrency = 2
+
 	defaultMaxAllowedPacket = 64 * 1024 * 1024
+
+	defaultKeepaliveTime    = 10 * time.Second
+	defaultKeepaliveTimeout = 3 * time.Second
 )
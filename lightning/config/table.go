@@ -0,0 +1,251 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+const (
+	// ChecksumSkip skips the post-import checksum of the table.
+	ChecksumSkip = "skip"
+	// ChecksumRequired always checksums the table even if disabled globally.
+	ChecksumRequired = "required"
+)
+
+// TableConfig overrides the global settings for the tables matching the
+// pattern.
+type TableConfig struct {
+	// Pattern is a glob matching the target table name in the form `db.table`,
+	// e.g. "logs.*".
+	Pattern  string `toml:"pattern" json:"pattern"`
+	Checksum string `toml:"checksum" json:"checksum"`
+	// DataCharacterSet overrides `mydumper.data-character-set`.
+	DataCharacterSet string `toml:"data-character-set" json:"data-character-set"`
+	// Transform maps a column name to the SQL expression computing the value
+	// imported into the column from the raw fields of each row.
+	Transform map[string]string `toml:"transform" json:"transform"`
+	// Columns lists the only columns imported from the data files. The other
+	// columns of the table are filled with their default values.
+	Columns []string `toml:"columns" json:"columns"`
+	// Backend overrides `tikv-importer.backend`.
+	Backend string `toml:"backend" json:"backend"`
+	// Priority orders the import of the tables. Tables of higher priorities
+	// are imported first. The default is 0.
+	Priority int `toml:"priority" json:"priority"`
+}
+
+func (tc *TableConfig) matches(schema, table string) bool {
+	matched, _ := path.Match(tc.Pattern, schema+"."+table)
+	return matched
+}
+
+func (cfg *Config) adjustTableConfigs() error {
+	for _, tc := range cfg.TableConfigs {
+		if len(tc.Pattern) == 0 {
+			return errors.New("invalid config: `table-config.pattern` must not be empty")
+		}
+		if !cfg.Mydumper.CaseSensitive {
+			tc.Pattern = strings.ToLower(tc.Pattern)
+		}
+		if _, err := path.Match(tc.Pattern, ""); err != nil {
+			return errors.Errorf("invalid config: `table-config.pattern` (%s) is not a valid glob", tc.Pattern)
+		}
+
+		tc.Checksum = strings.ToLower(tc.Checksum)
+		switch tc.Checksum {
+		case "", ChecksumSkip, ChecksumRequired:
+		default:
+			return errors.Errorf("invalid config: unsupported `table-config.checksum` (%s)", tc.Checksum)
+		}
+
+		tc.DataCharacterSet = strings.ToLower(tc.DataCharacterSet)
+		if len(tc.DataCharacterSet) > 0 && !isValidDataCharacterSet(tc.DataCharacterSet) {
+			return errors.Errorf("invalid config: unsupported `table-config.data-character-set` (%s)", tc.DataCharacterSet)
+		}
+
+		for i, column := range tc.Columns {
+			if len(column) == 0 {
+				return errors.Errorf("invalid config: `table-config.columns` of `%s` contains an empty column name", tc.Pattern)
+			}
+			// column names are always case-insensitive.
+			tc.Columns[i] = strings.ToLower(column)
+		}
+
+		tc.Backend = strings.ToLower(tc.Backend)
+		switch tc.Backend {
+		case "", cfg.TikvImporter.Backend:
+		case BackendImporter:
+			if len(cfg.TikvImporter.Addr) == 0 {
+				return errors.Errorf("invalid config: `table-config.backend` of `%s` is 'importer' but `tikv-importer.addr` is not set", tc.Pattern)
+			}
+		case BackendTiDB:
+			if cfg.TikvImporter.KVKind != KVKindAll {
+				return errors.Errorf("invalid config: `table-config.backend` of `%s` is 'tidb' but `tikv-importer.kv-kind` (%s) requires the 'importer' backend", tc.Pattern, cfg.TikvImporter.KVKind)
+			}
+		default:
+			return errors.Errorf("invalid config: unsupported `table-config.backend` (%s)", tc.Backend)
+		}
+	}
+
+	for _, required := range cfg.TableConfigs {
+		if required.Checksum != ChecksumRequired {
+			continue
+		}
+		for _, skipped := range cfg.TableConfigs {
+			if skipped.Checksum != ChecksumSkip {
+				continue
+			}
+			if matched, _ := path.Match(skipped.Pattern, required.Pattern); matched {
+				return errors.Errorf("invalid config: table pattern `%s` requires checksum but is also skipped by `%s`", required.Pattern, skipped.Pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// ShouldChecksum returns whether the post-import checksum should be performed
+// on the given target table.
+func (cfg *Config) ShouldChecksum(schema, table string) (bool, error) {
+	if !cfg.Mydumper.CaseSensitive {
+		schema = strings.ToLower(schema)
+		table = strings.ToLower(table)
+	}
+
+	var required, skipped *TableConfig
+	for _, tc := range cfg.TableConfigs {
+		if !tc.matches(schema, table) {
+			continue
+		}
+		switch tc.Checksum {
+		case ChecksumRequired:
+			required = tc
+		case ChecksumSkip:
+			skipped = tc
+		}
+	}
+
+	switch {
+	case required != nil && skipped != nil:
+		return false, errors.Errorf("table `%s`.`%s` requires checksum by `%s` but is also skipped by `%s`", schema, table, required.Pattern, skipped.Pattern)
+	case required != nil:
+		return true, nil
+	case skipped != nil:
+		return false, nil
+	default:
+		return cfg.PostRestore.Checksum, nil
+	}
+}
+
+func isValidDataCharacterSet(characterSet string) bool {
+	switch characterSet {
+	case "binary", "utf8mb4", "gbk", "gb18030", "latin1":
+		return true
+	default:
+		return false
+	}
+}
+
+// DataCharacterSet returns the character set of the data files of the given
+// target table. The first matching `[[table-config]]` overriding it wins.
+func (cfg *Config) DataCharacterSet(schema, table string) string {
+	if !cfg.Mydumper.CaseSensitive {
+		schema = strings.ToLower(schema)
+		table = strings.ToLower(table)
+	}
+	for _, tc := range cfg.TableConfigs {
+		if len(tc.DataCharacterSet) > 0 && tc.matches(schema, table) {
+			return tc.DataCharacterSet
+		}
+	}
+	return cfg.Mydumper.DataCharacterSet
+}
+
+// Transforms returns the column transforms of the given target table. The
+// first matching `[[table-config]]` having transforms wins.
+func (cfg *Config) Transforms(schema, table string) map[string]string {
+	if !cfg.Mydumper.CaseSensitive {
+		schema = strings.ToLower(schema)
+		table = strings.ToLower(table)
+	}
+	for _, tc := range cfg.TableConfigs {
+		if len(tc.Transform) > 0 && tc.matches(schema, table) {
+			return tc.Transform
+		}
+	}
+	return nil
+}
+
+// ColumnProjection returns the only columns to be imported from the data files
+// of the given target table, or nil to import all of them. The first matching
+// `[[table-config]]` listing columns wins.
+func (cfg *Config) ColumnProjection(schema, table string) []string {
+	if !cfg.Mydumper.CaseSensitive {
+		schema = strings.ToLower(schema)
+		table = strings.ToLower(table)
+	}
+	for _, tc := range cfg.TableConfigs {
+		if len(tc.Columns) > 0 && tc.matches(schema, table) {
+			return tc.Columns
+		}
+	}
+	return nil
+}
+
+// TablePriority returns the import priority of the given target table. The
+// first matching `[[table-config]]` with a non-zero priority wins.
+func (cfg *Config) TablePriority(schema, table string) int {
+	if !cfg.Mydumper.CaseSensitive {
+		schema = strings.ToLower(schema)
+		table = strings.ToLower(table)
+	}
+	for _, tc := range cfg.TableConfigs {
+		if tc.Priority != 0 && tc.matches(schema, table) {
+			return tc.Priority
+		}
+	}
+	return 0
+}
+
+// UsesBackend returns whether any table may be imported by the given backend,
+// either globally or by a `[[table-config]]` overriding it.
+func (cfg *Config) UsesBackend(backend string) bool {
+	if cfg.TikvImporter.Backend == backend {
+		return true
+	}
+	for _, tc := range cfg.TableConfigs {
+		if tc.Backend == backend {
+			return true
+		}
+	}
+	return false
+}
+
+// TableBackend returns the backend importing the given target table. The first
+// matching `[[table-config]]` overriding it wins.
+func (cfg *Config) TableBackend(schema, table string) string {
+	if !cfg.Mydumper.CaseSensitive {
+		schema = strings.ToLower(schema)
+		table = strings.ToLower(table)
+	}
+	for _, tc := range cfg.TableConfigs {
+		if len(tc.Backend) > 0 && tc.matches(schema, table) {
+			return tc.Backend
+		}
+	}
+	return cfg.TikvImporter.Backend
+}
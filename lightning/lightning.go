@@ -34,6 +34,7 @@ import (
 	"github.com/shurcooL/httpgzip"
 	"go.uber.org/zap"
 
+	kv "github.com/pingcap/tidb-lightning/lightning/backend"
 	"github.com/pingcap/tidb-lightning/lightning/common"
 	"github.com/pingcap/tidb-lightning/lightning/config"
 	"github.com/pingcap/tidb-lightning/lightning/log"
@@ -96,6 +97,8 @@ func (l *Lightning) GoServe() error {
 	mux.HandleFunc("/progress/table", handleProgressTable)
 	mux.HandleFunc("/pause", handlePause)
 	mux.HandleFunc("/resume", handleResume)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", l.handleReadyz)
 
 	mux.Handle("/web/", http.StripPrefix("/web", httpgzip.FileServer(web.Res, httpgzip.FileServerOptions{
 		IndexHTML: true,
@@ -140,6 +143,7 @@ func (l *Lightning) RunOnce() error {
 
 func (l *Lightning) RunServer() error {
 	l.taskCfgs = config.NewConfigList()
+	web.BroadcastReady(true)
 	log.L().Info(
 		"Lightning server is running, post to /tasks to start an import task",
 		zap.Stringer("address", l.serverAddr),
@@ -178,6 +182,10 @@ func (l *Lightning) run(taskCfg *config.Config) (err error) {
 		l.cancel = nil
 		l.cancelLock.Unlock()
 		web.BroadcastEndTask(err)
+		// in server mode, we are ready to accept the next task.
+		if l.taskCfgs != nil {
+			web.BroadcastReady(true)
+		}
 	}()
 
 	failpoint.Inject("SkipRunTask", func() error {
@@ -191,6 +199,14 @@ func (l *Lightning) run(taskCfg *config.Config) (err error) {
 		return nil
 	})
 
+	common.SetFDBudget(common.NewFDBudget(taskCfg.App.MaxOpenFiles))
+	defer common.SetFDBudget(nil)
+	kv.SetPDConcurrency(taskCfg.TiDB.PdMaxConcurrentRequests)
+	kv.SetPDRetry(taskCfg.TiDB.PdRetryCount, taskCfg.TiDB.PdRetryBackoff.Duration)
+	if err = mydump.SetHTTPSource(&taskCfg.Mydumper.HTTP); err != nil {
+		return errors.Trace(err)
+	}
+
 	loadTask := log.L().Begin(zap.InfoLevel, "load data source")
 	var mdl *mydump.MDLoader
 	mdl, err = mydump.NewMyDumpLoader(taskCfg)
@@ -199,6 +215,20 @@ func (l *Lightning) run(taskCfg *config.Config) (err error) {
 		return errors.Trace(err)
 	}
 
+	if files := mdl.GetUnresumableFiles(); len(files) > 0 && taskCfg.Checkpoint.Enable {
+		log.L().Warn("checkpoints are disabled because some data files do not support range requests, which cannot be resumed after interruption",
+			zap.Strings("files", files))
+		taskCfg.Checkpoint.Enable = false
+	}
+
+	dumpMeta, err := mdl.GetDumpMetadata()
+	if err != nil {
+		if len(taskCfg.Mydumper.MetadataOutput) > 0 {
+			return errors.Trace(err)
+		}
+		log.L().Warn("cannot read the dump metadata, ignored", log.ShortError(err))
+	}
+
 	dbMetas := mdl.GetDatabases()
 	web.BroadcastInitProgress(dbMetas)
 
@@ -212,10 +242,37 @@ func (l *Lightning) run(taskCfg *config.Config) (err error) {
 
 	err = procedure.Run(ctx)
 	procedure.Wait()
+	if err == nil && ctx.Err() == nil {
+		err = reportDumpMetadata(dumpMeta, taskCfg)
+	}
 	return errors.Trace(err)
 }
 
+// reportDumpMetadata logs the binlog position recorded by mydumper after the
+// import is completed, and writes it into `mydumper.metadata-output` if set, so
+// the replication can be started from the position.
+func reportDumpMetadata(meta *mydump.DumpMetadata, cfg *config.Config) error {
+	output := cfg.Mydumper.MetadataOutput
+	if meta == nil {
+		if len(output) > 0 {
+			log.L().Warn("no metadata file in the data source, nothing to write", zap.String("output", output))
+		}
+		return nil
+	}
+
+	log.L().Info("dump metadata", meta.LogFields()...)
+	if len(output) == 0 {
+		return nil
+	}
+	content, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Annotatef(ioutil.WriteFile(output, content, 0644), "cannot write dump metadata to %s", output)
+}
+
 func (l *Lightning) Stop() {
+	web.BroadcastReady(false)
 	if err := l.server.Shutdown(l.ctx); err != nil {
 		log.L().Warn("failed to shutdown HTTP server", log.ShortError(err))
 	}
@@ -500,3 +557,21 @@ func handleResume(w http.ResponseWriter, req *http.Request) {
 		writeJSONError(w, http.StatusMethodNotAllowed, "only PUT is allowed", nil)
 	}
 }
+
+func handleHealthz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+func (l *Lightning) handleReadyz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	// not ready once Lightning starts shutting down.
+	if web.IsReady() && l.ctx.Err() == nil {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"ready":true}`))
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"ready":false}`))
+	}
+}
@@ -25,6 +25,7 @@ import (
 	. "github.com/pingcap/check"
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/web"
 )
 
 type lightningSuite struct{}
@@ -323,3 +324,37 @@ func (s *lightningServerSuite) TestGetDeleteTask(c *C) {
 		Queue:   []int64{},
 	})
 }
+
+func (s *lightningServerSuite) TestHealthAndReadiness(c *C) {
+	url := "http://" + s.lightning.serverAddr.String()
+
+	resp, err := http.Get(url + "/healthz")
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	resp.Body.Close()
+
+	web.BroadcastReady(false)
+	resp, err = http.Get(url + "/readyz")
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusServiceUnavailable)
+	resp.Body.Close()
+
+	web.BroadcastReady(true)
+	resp, err = http.Get(url + "/readyz")
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	var result struct {
+		Ready bool `json:"ready"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(result.Ready, IsTrue)
+
+	// readiness is revoked once the server starts shutting down.
+	s.lightning.shutdown()
+	resp, err = http.Get(url + "/readyz")
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusServiceUnavailable)
+	resp.Body.Close()
+}
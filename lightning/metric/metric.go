@@ -43,6 +43,13 @@ const (
 
 	BlockDeliverKindIndex = "index"
 	BlockDeliverKindData  = "data"
+
+	// phases used for the ChunkPhaseSecondsHistogram labels. "ingest" is
+	// the time spent writing the KV pairs into the backend, while importing
+	// the engines afterwards is not counted in any chunk.
+	ChunkPhaseParse  = "parse"
+	ChunkPhaseEncode = "encode"
+	ChunkPhaseIngest = "ingest"
 )
 
 var (
@@ -53,6 +60,20 @@ var (
 			Help:      "counting open and closed importer engines",
 		}, []string{"type"})
 
+	OversizedRowsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "lightning",
+			Name:      "oversized_rows",
+			Help:      "number of rows skipped for exceeding the max row size",
+		})
+
+	OpenEnginesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "lightning",
+			Name:      "open_engines",
+			Help:      "number of engines currently opened for writing",
+		})
+
 	IdleWorkersGauge = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: "lightning",
@@ -60,6 +81,44 @@ var (
 			Help:      "counting idle workers",
 		}, []string{"name"})
 
+	OpenFileDescriptorsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "lightning",
+			Name:      "open_file_descriptors",
+			Help:      "number of file descriptors acquired from the budget",
+		})
+
+	PDInflightRequestsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "lightning",
+			Name:      "pd_inflight_requests",
+			Help:      "number of HTTP requests to PD being processed",
+		})
+
+	StoreAvailableRatioGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "lightning",
+			Name:      "store_available_ratio",
+			Help:      "fraction of the disk capacity still available on each TiKV store",
+		}, []string{"store"},
+	)
+
+	ChecksumStoreInflightGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "lightning",
+			Name:      "checksum_store_inflight",
+			Help:      "number of tables being checksummed with region leaders on each TiKV store",
+		}, []string{"store"},
+	)
+
+	OutOfRangeRowsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "lightning",
+			Name:      "out_of_range_rows",
+			Help:      "counting rows with out-of-range integer values adjusted by the encoder",
+		}, []string{"action"},
+	)
+
 	KvEncoderCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "lightning",
@@ -173,6 +232,29 @@ var (
 			Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
 		}, []string{"kind"},
 	)
+	WriteLimiterBytesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "lightning",
+			Name:      "write_limiter_bytes",
+			Help:      "number of bytes passed through the global write speed limiter",
+		},
+	)
+	UploadChunkSecondsHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "lightning",
+			Name:      "upload_chunk_seconds",
+			Help:      "round-trip time of writing a batch of KV pairs to importer, until it is acknowledged",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 3.1622776601683795, 10),
+		},
+	)
+	ChunkPhaseSecondsHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "lightning",
+			Name:      "chunk_phase_seconds",
+			Help:      "time spent in each phase of restoring a chunk (the phases overlap)",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 3.1622776601683795, 10),
+		}, []string{"phase"},
+	)
 	ChecksumSecondsHistogram = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Namespace: "lightning",
@@ -184,9 +266,16 @@ var (
 )
 
 func init() {
+	prometheus.MustRegister(OpenEnginesGauge)
+	prometheus.MustRegister(OversizedRowsCounter)
 	prometheus.MustRegister(IdleWorkersGauge)
+	prometheus.MustRegister(OpenFileDescriptorsGauge)
+	prometheus.MustRegister(PDInflightRequestsGauge)
+	prometheus.MustRegister(StoreAvailableRatioGauge)
+	prometheus.MustRegister(ChecksumStoreInflightGauge)
 	prometheus.MustRegister(ImporterEngineCounter)
 	prometheus.MustRegister(KvEncoderCounter)
+	prometheus.MustRegister(OutOfRangeRowsCounter)
 	prometheus.MustRegister(TableCounter)
 	prometheus.MustRegister(ProcessedEngineCounter)
 	prometheus.MustRegister(ChunkCounter)
@@ -198,7 +287,10 @@ func init() {
 	prometheus.MustRegister(BlockDeliverSecondsHistogram)
 	prometheus.MustRegister(BlockDeliverBytesHistogram)
 	prometheus.MustRegister(BlockDeliverKVPairsHistogram)
+	prometheus.MustRegister(WriteLimiterBytesCounter)
 	prometheus.MustRegister(ChecksumSecondsHistogram)
+	prometheus.MustRegister(ChunkPhaseSecondsHistogram)
+	prometheus.MustRegister(UploadChunkSecondsHistogram)
 	prometheus.MustRegister(ChunkParserReadBlockSecondsHistogram)
 	prometheus.MustRegister(ApplyWorkerSecondsHistogram)
 }
@@ -232,6 +324,15 @@ func ReadCounter(counter prometheus.Counter) float64 {
 	return metric.Counter.GetValue()
 }
 
+// ReadGauge reports the current value of the gauge.
+func ReadGauge(gauge prometheus.Gauge) float64 {
+	var metric dto.Metric
+	if err := gauge.Write(&metric); err != nil {
+		return math.NaN()
+	}
+	return metric.Gauge.GetValue()
+}
+
 // ReadCounter reports the sum of all observed values in the histogram.
 func ReadHistogramSum(histogram prometheus.Histogram) float64 {
 	var metric dto.Metric
@@ -0,0 +1,128 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// CharsetConvertor transcodes the string fields of the data files from their
+// character set into UTF-8, which TiDB uses for all non-binary strings. A nil
+// convertor leaves the fields unchanged.
+type CharsetConvertor struct {
+	characterSet string
+	encoding     encoding.Encoding
+	// whether to replace invalid byte sequences by U+FFFD instead of
+	// reporting them as errors.
+	replaceInvalid bool
+}
+
+// NewCharsetConvertor creates a convertor from the given `data-character-set`.
+// Returns nil if the data files need no conversion ("binary").
+//
+// If `replaceInvalid` is true, invalid byte sequences are replaced by U+FFFD,
+// otherwise converting them is an error.
+func NewCharsetConvertor(characterSet string, replaceInvalid bool) (*CharsetConvertor, error) {
+	cc := &CharsetConvertor{characterSet: characterSet, replaceInvalid: replaceInvalid}
+	switch characterSet {
+	case "", "binary":
+		return nil, nil
+	case "utf8mb4":
+		// no conversion, only validation.
+	case "gbk":
+		cc.encoding = simplifiedchinese.GBK
+	case "gb18030":
+		cc.encoding = simplifiedchinese.GB18030
+	case "latin1":
+		// MySQL's latin1 is actually Windows-1252.
+		cc.encoding = charmap.Windows1252
+	default:
+		return nil, errors.Errorf("unsupported data character set %s", characterSet)
+	}
+	return cc, nil
+}
+
+// ConvertRow converts every string field of the row in-place, except those
+// where `binaryFields[i]` is true (e.g. going into a BLOB column).
+func (cc *CharsetConvertor) ConvertRow(row []types.Datum, binaryFields []bool) error {
+	if cc == nil {
+		return nil
+	}
+	for i := range row {
+		if i < len(binaryFields) && binaryFields[i] {
+			continue
+		}
+		switch row[i].Kind() {
+		case types.KindString, types.KindBytes:
+		default:
+			continue
+		}
+		converted, err := cc.Convert(row[i].GetString())
+		if err != nil {
+			return errors.Annotatef(err, "column #%d", i+1)
+		}
+		row[i].SetString(converted)
+	}
+	return nil
+}
+
+// Convert transcodes a single string into UTF-8.
+func (cc *CharsetConvertor) Convert(s string) (string, error) {
+	if cc.encoding == nil {
+		if utf8.ValidString(s) {
+			return s, nil
+		}
+		return cc.replaceInvalidUTF8(s)
+	}
+
+	decoded, err := cc.encoding.NewDecoder().String(s)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	// the decoder silently replaces invalid byte sequences by U+FFFD, which
+	// some character sets (e.g. GB18030) can also encode validly. so a U+FFFD
+	// only comes from invalid input if the string does not encode back.
+	if !cc.replaceInvalid && strings.ContainsRune(decoded, utf8.RuneError) {
+		encoded, err := cc.encoding.NewEncoder().String(decoded)
+		if err != nil || encoded != s {
+			return "", errors.Errorf("invalid %s byte sequence in %q", cc.characterSet, s)
+		}
+	}
+	return decoded, nil
+}
+
+func (cc *CharsetConvertor) replaceInvalidUTF8(s string) (string, error) {
+	if !cc.replaceInvalid {
+		return "", errors.Errorf("invalid %s byte sequence in %q", cc.characterSet, s)
+	}
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for i := 0; i < len(s); {
+		r, width := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && width == 1 {
+			sb.WriteRune(utf8.RuneError)
+		} else {
+			sb.WriteString(s[i : i+width])
+		}
+		i += width
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/types"
+
+	md "github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testCharsetSuite{})
+
+type testCharsetSuite struct{}
+
+func (s *testCharsetSuite) TestBinaryNeedsNoConvertor(c *C) {
+	cc, err := md.NewCharsetConvertor("binary", false)
+	c.Assert(err, IsNil)
+	c.Assert(cc, IsNil)
+
+	row := []types.Datum{types.NewStringDatum("\xd6\xd0")}
+	c.Assert(cc.ConvertRow(row, nil), IsNil)
+	c.Assert(row[0].GetString(), Equals, "\xd6\xd0")
+
+	_, err = md.NewCharsetConvertor("ebcdic", false)
+	c.Assert(err, ErrorMatches, "unsupported data character set ebcdic")
+}
+
+func (s *testCharsetSuite) TestConvertGBK(c *C) {
+	cc, err := md.NewCharsetConvertor("gbk", false)
+	c.Assert(err, IsNil)
+
+	// "中文测试" encoded in GBK.
+	converted, err := cc.Convert("\xd6\xd0\xce\xc4\xb2\xe2\xca\xd4")
+	c.Assert(err, IsNil)
+	c.Assert(converted, Equals, "中文测试")
+
+	converted, err = cc.Convert("plain ascii")
+	c.Assert(err, IsNil)
+	c.Assert(converted, Equals, "plain ascii")
+
+	// 0xff is not a valid GBK byte.
+	_, err = cc.Convert("\xd6\xd0\xff")
+	c.Assert(err, ErrorMatches, `invalid gbk byte sequence in "\\xd6\\xd0\\xff"`)
+
+	cc, err = md.NewCharsetConvertor("gbk", true)
+	c.Assert(err, IsNil)
+	converted, err = cc.Convert("\xd6\xd0\xff")
+	c.Assert(err, IsNil)
+	c.Assert(converted, Equals, "中�")
+}
+
+func (s *testCharsetSuite) TestConvertGB18030ReplacementCharacter(c *C) {
+	cc, err := md.NewCharsetConvertor("gb18030", false)
+	c.Assert(err, IsNil)
+
+	// U+FFFD itself is a valid GB18030 character.
+	converted, err := cc.Convert("\xd6\xd0\x84\x31\xa4\x37")
+	c.Assert(err, IsNil)
+	c.Assert(converted, Equals, "中\ufffd")
+
+	_, err = cc.Convert("\xd6\xd0\x84\x31\xa4\x37\xff")
+	c.Assert(err, ErrorMatches, `invalid gb18030 byte sequence in .*`)
+}
+
+func (s *testCharsetSuite) TestConvertLatin1(c *C) {
+	cc, err := md.NewCharsetConvertor("latin1", false)
+	c.Assert(err, IsNil)
+	converted, err := cc.Convert("caf\xe9 \x80")
+	c.Assert(err, IsNil)
+	c.Assert(converted, Equals, "café €")
+}
+
+func (s *testCharsetSuite) TestValidateUTF8(c *C) {
+	cc, err := md.NewCharsetConvertor("utf8mb4", false)
+	c.Assert(err, IsNil)
+	converted, err := cc.Convert("中文")
+	c.Assert(err, IsNil)
+	c.Assert(converted, Equals, "中文")
+	_, err = cc.Convert("a\xffb")
+	c.Assert(err, ErrorMatches, `invalid utf8mb4 byte sequence in "a\\xffb"`)
+
+	cc, err = md.NewCharsetConvertor("utf8mb4", true)
+	c.Assert(err, IsNil)
+	converted, err = cc.Convert("a\xffb中")
+	c.Assert(err, IsNil)
+	c.Assert(converted, Equals, "a�b中")
+}
+
+func (s *testCharsetSuite) TestConvertRow(c *C) {
+	cc, err := md.NewCharsetConvertor("gbk", false)
+	c.Assert(err, IsNil)
+
+	row := []types.Datum{
+		types.NewStringDatum("\xd6\xd0"),
+		types.NewBytesDatum([]byte("\xd6\xd0")),
+		types.NewIntDatum(1),
+		types.NewStringDatum("\xce\xc4"),
+		{},
+	}
+	c.Assert(cc.ConvertRow(row, []bool{false, true}), IsNil)
+	c.Assert(row[0].GetString(), Equals, "中")
+	c.Assert(row[1].GetBytes(), DeepEquals, []byte("\xd6\xd0"))
+	c.Assert(row[2].GetInt64(), Equals, int64(1))
+	c.Assert(row[3].GetString(), Equals, "文")
+	c.Assert(row[4].IsNull(), IsTrue)
+
+	row = []types.Datum{types.NewIntDatum(1), types.NewStringDatum("\xff")}
+	c.Assert(cc.ConvertRow(row, nil), ErrorMatches, "column #2: invalid gbk byte sequence.*")
+}
@@ -1,6 +1,7 @@
 package mydump
 
 import (
+	"bytes"
 	"io"
 	"strings"
 
@@ -14,6 +15,10 @@ type CSVParser struct {
 	blockParser
 	cfg       *config.CSVConfig
 	escFlavor backslashEscapeFlavor
+	// the line breaks following a terminator which are not a terminator
+	// themselves, and so begin the next row. They are excluded from the
+	// position until the next row consumes them.
+	pendingLineBreaks []byte
 }
 
 func NewCSVParser(
@@ -45,8 +50,65 @@ const (
 	csvTokSep
 	csvTokNewLine
 	csvTokField
+	// line breaks in an unquoted field which are not the configured
+	// terminator, so they are a part of the field.
+	csvTokLineBreak
 )
 
+// SetPos changes the reported position and row ID.
+func (parser *CSVParser) SetPos(pos int64, rowID int64) {
+	parser.pendingLineBreaks = nil
+	parser.blockParser.SetPos(pos, rowID)
+}
+
+// lexRow returns the next token like lex, but only the configured terminator
+// ends a row. The lexer treats every run of "\r" and "\n" as a row terminator,
+// which is also what an empty `terminator` means.
+func (parser *CSVParser) lexRow() (csvToken, []byte, error) {
+	terminator := []byte(parser.cfg.Terminator)
+	if len(terminator) == 0 {
+		return parser.lex()
+	}
+
+	var content []byte
+	if len(parser.pendingLineBreaks) > 0 {
+		content = parser.pendingLineBreaks
+		parser.pendingLineBreaks = nil
+		parser.pos += int64(len(content))
+	} else {
+		tok, lexed, err := parser.lex()
+		if err != nil || tok != csvTokNewLine {
+			return tok, lexed, err
+		}
+		content = lexed
+	}
+
+	i := bytes.Index(content, terminator)
+	if i != 0 {
+		if i > 0 {
+			parser.keepLineBreaks(content[i:])
+			content = content[:i]
+		}
+		return csvTokLineBreak, content, nil
+	}
+	// like the lexer, empty rows between the terminators are skipped.
+	rest := content
+	for bytes.HasPrefix(rest, terminator) {
+		rest = rest[len(terminator):]
+	}
+	parser.keepLineBreaks(rest)
+	return csvTokNewLine, content[:len(content)-len(rest)], nil
+}
+
+func (parser *CSVParser) keepLineBreaks(lineBreaks []byte) {
+	if len(lineBreaks) == 0 {
+		return
+	}
+	// the content is only valid until the next block is read.
+	parser.pendingLineBreaks = append([]byte(nil), lineBreaks...)
+	parser.pos -= int64(len(lineBreaks))
+}
+
 func (parser *CSVParser) appendEmptyValues(sepCount int) {
 	var datum types.Datum
 	if !parser.cfg.NotNull && parser.cfg.Null == "" {
@@ -92,6 +154,7 @@ func (parser *CSVParser) unescapeString(input string) (unescaped string, isNull
 func (parser *CSVParser) ReadRow() error {
 	emptySepCount := 1
 	hasField := false
+	hasFieldToken := false
 
 	row := &parser.lastRow
 	row.RowID++
@@ -99,26 +162,17 @@ func (parser *CSVParser) ReadRow() error {
 
 	// skip the header first
 	if parser.pos == 0 && parser.cfg.Header {
-		parser.columns = make([]string, 0, len(row.Row))
-	outside:
-		for {
-			tok, content, err := parser.lex()
-			if err != nil {
-				return errors.Trace(err)
-			}
-			switch tok {
-			case csvTokSep:
-			case csvTokField:
-				colName, _ := parser.unescapeString(string(content))
-				parser.columns = append(parser.columns, strings.ToLower(colName))
-			case csvTokNewLine:
-				break outside
-			}
+		if err := parser.ReadColumns(); err != nil {
+			return errors.Trace(err)
 		}
 	}
 
+	// a field is split into several tokens by the line breaks in it, which
+	// are joined before appending the field.
+	var field string
+	hasPendingField, joining := false, false
 	for {
-		tok, content, err := parser.lex()
+		tok, content, err := parser.lexRow()
 		switch errors.Cause(err) {
 		case nil:
 		case io.EOF:
@@ -133,20 +187,74 @@ func (parser *CSVParser) ReadRow() error {
 
 		hasField = true
 
+		if tok == csvTokLineBreak || (tok == csvTokField && joining) {
+			field += string(content)
+			hasPendingField, joining = true, tok == csvTokLineBreak
+			continue
+		}
+		joining = false
+		if hasPendingField {
+			parser.appendEmptyValues(emptySepCount - 1)
+			emptySepCount = 0
+			hasFieldToken = true
+			parser.appendField(field)
+			field, hasPendingField = "", false
+		}
+
 		switch tok {
+		case csvTokField:
+			field = string(content)
+			hasPendingField = true
+
 		case csvTokSep:
 			emptySepCount++
 
-		case csvTokField:
-			parser.appendEmptyValues(emptySepCount - 1)
-			emptySepCount = 0
-			parser.appendField(string(content))
-
 		case csvTokNewLine:
-			if !parser.cfg.TrimLastSep {
+			switch {
+			case parser.cfg.TrimLastSep:
+			case parser.cfg.TrailingSep == config.TrailingSepIgnore && emptySepCount > 0:
+				// without any field token, the row has only empty fields, and
+				// the separators are not trailing a field.
+				if emptySepCount > 1 && hasFieldToken {
+					return errors.Errorf("syntax error: more than one trailing separator at offset %d", parser.pos)
+				}
+				parser.appendEmptyValues(emptySepCount - 1)
+			default:
 				parser.appendEmptyValues(emptySepCount)
 			}
 			return nil
 		}
 	}
 }
+
+// ReadColumns reads the header line of the CSV file as the column names. The
+// parser must be positioned at the start of the file.
+func (parser *CSVParser) ReadColumns() error {
+	parser.columns = make([]string, 0, len(parser.lastRow.Row))
+	var field string
+	hasPendingField, joining := false, false
+	for {
+		tok, content, err := parser.lexRow()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if tok == csvTokLineBreak || (tok == csvTokField && joining) {
+			field += string(content)
+			hasPendingField, joining = true, tok == csvTokLineBreak
+			continue
+		}
+		joining = false
+		if hasPendingField {
+			colName, _ := parser.unescapeString(field)
+			parser.columns = append(parser.columns, strings.ToLower(colName))
+			field, hasPendingField = "", false
+		}
+		switch tok {
+		case csvTokField:
+			field = string(content)
+			hasPendingField = true
+		case csvTokNewLine:
+			return nil
+		}
+	}
+}
@@ -420,6 +420,82 @@ func (s *testMydumpCSVParserSuite) TestEmpty(c *C) {
 	c.Assert(errors.Cause(parser.ReadRow()), Equals, io.EOF)
 }
 
+func (s *testMydumpCSVParserSuite) TestBOM(c *C) {
+	cfg := config.CSVConfig{
+		Separator: ",",
+		Delimiter: `"`,
+	}
+
+	// the BOM is skipped, but the position stays the file offset.
+	parser := mydump.NewCSVParser(&cfg, strings.NewReader("\xef\xbb\xbfaaa,1\nbbb,2\n"), config.ReadBlockSize, s.ioWorkers)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{
+		RowID: 1,
+		Row:   []types.Datum{types.NewStringDatum("aaa"), types.NewStringDatum("1")},
+	})
+	c.Assert(parser, posEq, 9, 1)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{
+		RowID: 2,
+		Row:   []types.Datum{types.NewStringDatum("bbb"), types.NewStringDatum("2")},
+	})
+	c.Assert(parser, posEq, 15, 2)
+	c.Assert(errors.Cause(parser.ReadRow()), Equals, io.EOF)
+
+	// the BOM split across the blocks is skipped too.
+	parser = mydump.NewCSVParser(&cfg, strings.NewReader("\xef\xbb\xbfaaa,1\n"), 1, s.ioWorkers)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow().Row, DeepEquals, []types.Datum{types.NewStringDatum("aaa"), types.NewStringDatum("1")})
+	c.Assert(parser, posEq, 9, 1)
+
+	// files in UTF-16 are rejected.
+	parser = mydump.NewCSVParser(&cfg, strings.NewReader("\xff\xfea\x00,\x001\x00\n\x00"), config.ReadBlockSize, s.ioWorkers)
+	c.Assert(parser.ReadRow(), ErrorMatches, ".*UTF-16 byte order mark.*")
+	parser = mydump.NewCSVParser(&cfg, strings.NewReader("\xfe\xff\x00a\x00,\x001\x00\n"), config.ReadBlockSize, s.ioWorkers)
+	parser.SetPreserveBOM(true)
+	c.Assert(parser.ReadRow(), ErrorMatches, ".*UTF-16 byte order mark.*")
+
+	// only the BOM at the start of the file is skipped.
+	parser = mydump.NewCSVParser(&cfg, strings.NewReader("aaa,1\n\xef\xbb\xbfbbb,2\n"), config.ReadBlockSize, s.ioWorkers)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow().Row, DeepEquals, []types.Datum{types.NewStringDatum("\xef\xbb\xbfbbb"), types.NewStringDatum("2")})
+
+	parser = mydump.NewCSVParser(&cfg, strings.NewReader("aaa,1\n\xef\xbb\xbfbbb,2\n"), config.ReadBlockSize, s.ioWorkers)
+	parser.SetPos(6, 1)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow().Row, DeepEquals, []types.Datum{types.NewStringDatum("aaa"), types.NewStringDatum("1")})
+
+	// the BOM is kept if requested.
+	parser = mydump.NewCSVParser(&cfg, strings.NewReader("\xef\xbb\xbfaaa,1\n"), config.ReadBlockSize, s.ioWorkers)
+	parser.SetPreserveBOM(true)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow().Row, DeepEquals, []types.Datum{types.NewStringDatum("\xef\xbb\xbfaaa"), types.NewStringDatum("1")})
+	c.Assert(parser, posEq, 9, 1)
+
+	// Try again with headers.
+
+	cfg.Header = true
+
+	parser = mydump.NewCSVParser(&cfg, strings.NewReader("\xef\xbb\xbfa,b\naaa,1\n"), config.ReadBlockSize, s.ioWorkers)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.Columns(), DeepEquals, []string{"a", "b"})
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{
+		RowID: 1,
+		Row:   []types.Datum{types.NewStringDatum("aaa"), types.NewStringDatum("1")},
+	})
+	c.Assert(parser, posEq, 13, 1)
+
+	parser = mydump.NewCSVParser(&cfg, strings.NewReader("\xef\xbb\xbfa,b\n"), config.ReadBlockSize, s.ioWorkers)
+	c.Assert(parser.ReadColumns(), IsNil)
+	c.Assert(parser.Columns(), DeepEquals, []string{"a", "b"})
+
+	parser = mydump.NewCSVParser(&cfg, strings.NewReader("\xef\xbb\xbfa,b\naaa,1\n"), config.ReadBlockSize, s.ioWorkers)
+	parser.SetPreserveBOM(true)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.Columns(), DeepEquals, []string{"\xef\xbb\xbfa", "b"})
+}
+
 func (s *testMydumpCSVParserSuite) TestCRLF(c *C) {
 	cfg := config.CSVConfig{
 		Separator: ",",
@@ -454,6 +530,92 @@ func (s *testMydumpCSVParserSuite) TestCRLF(c *C) {
 	c.Assert(errors.Cause(parser.ReadRow()), Equals, io.EOF)
 }
 
+func (s *testMydumpCSVParserSuite) TestLineTerminators(c *C) {
+	cfg := config.CSVConfig{
+		Separator: ",",
+		Delimiter: `"`,
+	}
+
+	// the terminators are detected automatically by default, see TestCRLF.
+	testCases := []testCase{
+		{
+			input: "1,\"a\r\nb\"\r\n2,\"c\nd\re\"\r\n",
+			expected: [][]types.Datum{
+				{types.NewStringDatum("1"), types.NewStringDatum("a\r\nb")},
+				{types.NewStringDatum("2"), types.NewStringDatum("c\nd\re")},
+			},
+		},
+		{
+			input: "1,\r\n2,\r\n",
+			expected: [][]types.Datum{
+				{types.NewStringDatum("1"), nullDatum},
+				{types.NewStringDatum("2"), nullDatum},
+			},
+		},
+	}
+	// a tiny block size makes the "\r\n" pairs straddle the block boundaries.
+	s.runTestCases(c, &cfg, 1, testCases)
+	s.runTestCases(c, &cfg, config.ReadBlockSize, testCases)
+
+	// with a configured terminator, the other line breaks are a part of the
+	// unquoted fields.
+	cfg.Terminator = "\r\n"
+	testCases = []testCase{
+		{
+			input: "1,a\nb\r\n2,c\rd\r\n",
+			expected: [][]types.Datum{
+				{types.NewStringDatum("1"), types.NewStringDatum("a\nb")},
+				{types.NewStringDatum("2"), types.NewStringDatum("c\rd")},
+			},
+		},
+		{
+			input: "1,\"a\r\nb\"\r\n\r\n2,\n\r\n",
+			expected: [][]types.Datum{
+				{types.NewStringDatum("1"), types.NewStringDatum("a\r\nb")},
+				{types.NewStringDatum("2"), types.NewStringDatum("\n")},
+			},
+		},
+		{
+			input: "1,a\r\n\n2,b\r\r\n",
+			expected: [][]types.Datum{
+				{types.NewStringDatum("1"), types.NewStringDatum("a")},
+				{types.NewStringDatum("\n2"), types.NewStringDatum("b\r")},
+			},
+		},
+	}
+	s.runTestCases(c, &cfg, 1, testCases)
+	s.runTestCases(c, &cfg, config.ReadBlockSize, testCases)
+
+	// the line breaks starting the next row are not a part of the position.
+	parser := mydump.NewCSVParser(&cfg, strings.NewReader("1,a\r\n\n2,b\r\n"), config.ReadBlockSize, s.ioWorkers)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser, posEq, 5, 1)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser, posEq, 11, 2)
+
+	cfg.Terminator = "\n"
+	s.runTestCases(c, &cfg, 1, []testCase{
+		{
+			input: "1,aa\r\n2,bb\r\n",
+			expected: [][]types.Datum{
+				{types.NewStringDatum("1"), types.NewStringDatum("aa\r")},
+				{types.NewStringDatum("2"), types.NewStringDatum("bb\r")},
+			},
+		},
+	})
+
+	cfg.Terminator = "\r"
+	cfg.Header = true
+	s.runTestCases(c, &cfg, 1, []testCase{
+		{
+			input: "id,val\r1,a\nb\r",
+			expected: [][]types.Datum{
+				{types.NewStringDatum("1"), types.NewStringDatum("a\nb")},
+			},
+		},
+	})
+}
+
 func (s *testMydumpCSVParserSuite) TestQuotedSeparator(c *C) {
 	cfg := config.CSVConfig{
 		Separator: ",",
@@ -506,6 +668,75 @@ func (s *testMydumpCSVParserSuite) TestConsecutiveFields(c *C) {
 	s.runTestCases(c, &cfg, config.ReadBlockSize, testCases)
 }
 
+func (s *testMydumpCSVParserSuite) TestNulBytesInQuotedField(c *C) {
+	cfg := config.CSVConfig{
+		Separator: ",",
+		Delimiter: `"`,
+	}
+	testCases := []testCase{
+		{
+			input:    "\"a\x00b\",\"\x00\"\n",
+			expected: [][]types.Datum{{types.NewStringDatum("a\x00b"), types.NewStringDatum("\x00")}},
+		},
+		{
+			input:    "\"\x00,\x00\"\r\n\"\x00\"\"\"",
+			expected: [][]types.Datum{{types.NewStringDatum("\x00,\x00")}, {types.NewStringDatum("\x00\"")}},
+		},
+	}
+	s.runTestCases(c, &cfg, config.ReadBlockSize, testCases)
+
+	cfg.BackslashEscape = true
+	s.runTestCases(c, &cfg, config.ReadBlockSize, []testCase{
+		{
+			input:    "\"a\x00\\0\"\n",
+			expected: [][]types.Datum{{types.NewStringDatum("a\x00\x00")}},
+		},
+	})
+}
+
+func (s *testMydumpCSVParserSuite) TestTrailingSeparator(c *C) {
+	cfg := config.CSVConfig{
+		Separator:   ",",
+		Delimiter:   `"`,
+		TrailingSep: config.TrailingSepIgnore,
+	}
+
+	testCases := []testCase{
+		{
+			input:    "a,b\n",
+			expected: [][]types.Datum{{types.NewStringDatum("a"), types.NewStringDatum("b")}},
+		},
+		{
+			input: "a,b,\nc,d,",
+			expected: [][]types.Datum{
+				{types.NewStringDatum("a"), types.NewStringDatum("b")},
+				{types.NewStringDatum("c"), types.NewStringDatum("d")},
+			},
+		},
+		{
+			input:    "a,\"\",\n",
+			expected: [][]types.Datum{{types.NewStringDatum("a"), nullDatum}},
+		},
+		{
+			input:    ",\n",
+			expected: [][]types.Datum{{nullDatum}},
+		},
+	}
+	s.runTestCases(c, &cfg, config.ReadBlockSize, testCases)
+
+	parser := mydump.NewCSVParser(&cfg, strings.NewReader("a,b,,\n"), config.ReadBlockSize, s.ioWorkers)
+	c.Assert(parser.ReadRow(), ErrorMatches, "syntax error: more than one trailing separator at offset 6")
+
+	// the trailing separator starts an empty field by default.
+	cfg.TrailingSep = config.TrailingSepStrict
+	s.runTestCases(c, &cfg, config.ReadBlockSize, []testCase{
+		{
+			input:    "a,b,\n",
+			expected: [][]types.Datum{{types.NewStringDatum("a"), types.NewStringDatum("b"), nullDatum}},
+		},
+	})
+}
+
 func (s *testMydumpCSVParserSuite) TestSpecialChars(c *C) {
 	cfg := config.CSVConfig{Separator: ",", Delimiter: `"`}
 	testCases := []testCase{
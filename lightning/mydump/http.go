@@ -0,0 +1,340 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+// Files served over HTTP(S) are referred by their URLs, e.g.
+// "https://example.com/dump/db.tbl.sql". A data source URL ending with "/" is
+// a directory listing, whose links below it are the files and subdirectories
+// to import.
+
+func isHTTPPath(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// httpFileInfo is the result of probing a file with a HEAD request.
+type httpFileInfo struct {
+	size int64
+	// whether the server supports range requests on the file.
+	ranged bool
+}
+
+// httpSource sends the requests reading the source files.
+type httpSource struct {
+	client  *http.Client
+	headers map[string]string
+
+	mu    sync.Mutex
+	files map[string]httpFileInfo
+}
+
+func newHTTPSource(client *http.Client, headers map[string]string) *httpSource {
+	return &httpSource{
+		client:  client,
+		headers: headers,
+		files:   make(map[string]httpFileInfo),
+	}
+}
+
+const (
+	// httpSourceTimeout limits connecting to the server and waiting for the
+	// response headers. The whole request is not limited, since downloading a
+	// large file may take arbitrarily long.
+	httpSourceTimeout = 30 * time.Second
+	// maxHTTPReadRetry is the number of times a response ended before the end
+	// of the file is requested again from where it stopped.
+	maxHTTPReadRetry = 3
+)
+
+func newHTTPSourceClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   httpSourceTimeout,
+			KeepAlive: httpSourceTimeout,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   httpSourceTimeout,
+		ResponseHeaderTimeout: httpSourceTimeout,
+		IdleConnTimeout:       90 * time.Second,
+	}}
+}
+
+var (
+	globalHTTPSourceLock sync.RWMutex
+	globalHTTPSource     = newHTTPSource(newHTTPSourceClient(nil), nil)
+)
+
+// SetHTTPSource configures the TLS and the extra headers of the requests
+// reading the source files served over HTTP(S).
+func SetHTTPSource(cfg *config.HTTPSource) error {
+	tlsConfig, err := common.ToTLSConfig(cfg.CAPath, cfg.CertPath, cfg.KeyPath)
+	if err != nil {
+		return errors.Annotate(err, "invalid TLS config of the HTTP source")
+	}
+
+	globalHTTPSourceLock.Lock()
+	globalHTTPSource = newHTTPSource(newHTTPSourceClient(tlsConfig), cfg.Headers)
+	globalHTTPSourceLock.Unlock()
+	return nil
+}
+
+func getHTTPSource() *httpSource {
+	globalHTTPSourceLock.RLock()
+	defer globalHTTPSourceLock.RUnlock()
+	return globalHTTPSource
+}
+
+func (s *httpSource) do(method string, fileURL string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, fileURL, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := s.client.Do(req)
+	return resp, errors.Trace(err)
+}
+
+// stat probes the size and the range support of the file. The result is
+// cached, since the source is assumed to be immutable.
+func (s *httpSource) stat(fileURL string) (httpFileInfo, error) {
+	s.mu.Lock()
+	info, ok := s.files[fileURL]
+	s.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	resp, err := s.do(http.MethodHead, fileURL, nil)
+	if err != nil {
+		return info, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return info, errors.Errorf("head %s http status code != 200, status %s", fileURL, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return info, errors.Errorf("cannot get the size of %s", fileURL)
+	}
+	info = httpFileInfo{
+		size:   resp.ContentLength,
+		ranged: resp.Header.Get("Accept-Ranges") == "bytes",
+	}
+
+	s.mu.Lock()
+	s.files[fileURL] = info
+	s.mu.Unlock()
+	return info, nil
+}
+
+var hrefRegexp = regexp.MustCompile(`(?i)href\s*=\s*["']([^"']+)["']`)
+
+// list returns the URLs of all files below a directory listing, sorted.
+// Subdirectories (links ending with "/") are listed recursively. Links
+// outside of the directory, or with a query (e.g. the sorting links of
+// nginx), are ignored.
+func (s *httpSource) list(dirURL string) ([]string, error) {
+	base, err := url.Parse(dirURL)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	resp, err := s.do(http.MethodGet, dirURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("get %s http status code != 200, message %s", dirURL, string(body))
+	}
+
+	dirURL = base.String()
+	seen := make(map[string]struct{})
+	var files []string
+	for _, match := range hrefRegexp.FindAllStringSubmatch(string(body), -1) {
+		ref, err := url.Parse(match[1])
+		if err != nil || len(ref.RawQuery) > 0 {
+			continue
+		}
+		ref.Fragment = ""
+		link := base.ResolveReference(ref).String()
+		if link == dirURL || !strings.HasPrefix(link, dirURL) {
+			continue
+		}
+		if _, ok := seen[link]; ok {
+			continue
+		}
+		seen[link] = struct{}{}
+
+		if strings.HasSuffix(link, "/") {
+			subFiles, err := s.list(link)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, subFiles...)
+		} else {
+			files = append(files, link)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// httpFileName returns the unescaped base name of the file.
+func httpFileName(fileURL string) string {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return path.Base(fileURL)
+	}
+	return path.Base(u.Path)
+}
+
+// httpFileReader reads a file served over HTTP(S). Seeking closes the current
+// response, and the next read sends a ranged GET from the new offset. If the
+// server does not support range requests, the file is downloaded again from
+// the start and the content before the offset is discarded.
+//
+// A response ending before the end of the file, e.g. when the connection is
+// dropped, is requested again from where it stopped, up to maxHTTPReadRetry
+// times in a row before failing with io.ErrUnexpectedEOF.
+type httpFileReader struct {
+	source *httpSource
+	url    string
+	info   httpFileInfo
+
+	body io.ReadCloser
+	pos  int64
+}
+
+func openHTTPFile(fileURL string) (*httpFileReader, error) {
+	source := getHTTPSource()
+	info, err := source.stat(fileURL)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &httpFileReader{source: source, url: fileURL, info: info}, nil
+}
+
+func (r *httpFileReader) open() error {
+	header := make(http.Header)
+	expectedStatus := http.StatusOK
+	if r.pos > 0 && r.info.ranged {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", r.pos))
+		expectedStatus = http.StatusPartialContent
+	}
+	resp, err := r.source.do(http.MethodGet, r.url, header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != expectedStatus {
+		resp.Body.Close()
+		return errors.Errorf("get %s http status code != %d, status %s", r.url, expectedStatus, resp.Status)
+	}
+	if expectedStatus == http.StatusOK && r.pos > 0 {
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, r.pos); err != nil {
+			resp.Body.Close()
+			return errors.Annotatef(err, "cannot skip to offset %d of %s", r.pos, r.url)
+		}
+	}
+	r.body = resp.Body
+	return nil
+}
+
+func (r *httpFileReader) Read(p []byte) (int, error) {
+	if r.pos >= r.info.size {
+		return 0, io.EOF
+	}
+	for retry := 0; ; retry++ {
+		if r.body == nil {
+			if err := r.open(); err != nil {
+				return 0, errors.Trace(err)
+			}
+		}
+		n, err := r.body.Read(p)
+		r.pos += int64(n)
+		if (err != io.EOF && err != io.ErrUnexpectedEOF) || r.pos >= r.info.size {
+			return n, err
+		}
+
+		// the response ended early, the rest is read from a new request.
+		r.close()
+		if n > 0 {
+			return n, nil
+		}
+		if retry >= maxHTTPReadRetry {
+			return 0, errors.Annotatef(io.ErrUnexpectedEOF, "%s ended at offset %d of %d", r.url, r.pos, r.info.size)
+		}
+	}
+}
+
+func (r *httpFileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.info.size
+	default:
+		return r.pos, errors.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return r.pos, errors.New("negative seek offset")
+	}
+	if offset != r.pos {
+		r.close()
+		r.pos = offset
+	}
+	return r.pos, nil
+}
+
+func (r *httpFileReader) close() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+}
+
+func (r *httpFileReader) Close() error {
+	r.close()
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	md "github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testMydumpHTTPSuite{})
+
+type testMydumpHTTPSuite struct{}
+
+func (s *testMydumpHTTPSuite) TestLoadDirectoryListing(c *C) {
+	dir := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(dir, "data"), 0755), IsNil)
+	files := map[string]string{
+		"db-schema-create.sql": "CREATE DATABASE db;\n",
+		"db.t-schema.sql":      "CREATE TABLE t (a INT);\n",
+		"data/db.t.1.sql":      "INSERT INTO t VALUES (1),(2);\n",
+		"data/db.t.2.csv":      "a\n3\n4\n",
+	}
+	for name, content := range files {
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), IsNil)
+	}
+
+	fileServer := http.FileServer(http.Dir(dir))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fileServer.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	c.Assert(md.SetHTTPSource(&config.HTTPSource{
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+	}), IsNil)
+	defer md.SetHTTPSource(&config.HTTPSource{})
+
+	cfg := &config.Config{Mydumper: config.MydumperRuntime{SourceDir: server.URL + "/", CharacterSet: "auto"}}
+	mdl, err := md.NewMyDumpLoader(cfg)
+	c.Assert(err, IsNil)
+	c.Assert(mdl.GetUnresumableFiles(), HasLen, 0)
+
+	dbs := mdl.GetDatabases()
+	c.Assert(dbs, HasLen, 1)
+	c.Assert(dbs[0].Tables, HasLen, 1)
+	table := dbs[0].Tables[0]
+	c.Assert(table.SchemaFile, Equals, server.URL+"/db.t-schema.sql")
+	c.Assert(table.DataFiles, DeepEquals, []string{
+		server.URL + "/data/db.t.1.sql",
+		server.URL + "/data/db.t.2.csv",
+	})
+	c.Assert(table.TotalSize, Equals, int64(36))
+	c.Assert(table.GetSchema(), Equals, "CREATE TABLE t (a INT);")
+	c.Assert(md.IsSeekableSourceFile(table.DataFiles[0]), IsTrue)
+
+	// seeking sends a ranged request from the new offset.
+	r, err := md.OpenSourceFile(table.DataFiles[0])
+	c.Assert(err, IsNil)
+	defer r.Close()
+	pos, err := r.Seek(25, io.SeekStart)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(25))
+	content, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "(2);\n")
+	_, err = r.Seek(7, io.SeekStart)
+	c.Assert(err, IsNil)
+	content, err = ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "INTO t VALUES (1),(2);\n")
+}
+
+func (s *testMydumpHTTPSuite) TestLoadFileWithoutRanges(c *C) {
+	const content = "INSERT INTO t VALUES (1),(2);\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/db.t.sql" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		io.WriteString(w, content)
+	}))
+	defer server.Close()
+
+	fileURL := server.URL + "/db.t.sql"
+	cfg := &config.Config{Mydumper: config.MydumperRuntime{SourceDir: fileURL, NoSchema: true}}
+	mdl, err := md.NewMyDumpLoader(cfg)
+	c.Assert(err, IsNil)
+	c.Assert(mdl.GetUnresumableFiles(), DeepEquals, []string{fileURL})
+
+	dbs := mdl.GetDatabases()
+	c.Assert(dbs, HasLen, 1)
+	c.Assert(dbs[0].Tables, HasLen, 1)
+	c.Assert(dbs[0].Tables[0].DataFiles, DeepEquals, []string{fileURL})
+	c.Assert(dbs[0].Tables[0].TotalSize, Equals, int64(len(content)))
+	c.Assert(md.IsSeekableSourceFile(fileURL), IsFalse)
+
+	// seeking downloads the file again and skips to the offset.
+	r, err := md.OpenSourceFile(fileURL)
+	c.Assert(err, IsNil)
+	defer r.Close()
+	_, err = r.Seek(25, io.SeekStart)
+	c.Assert(err, IsNil)
+	read, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(read), Equals, "(2);\n")
+
+	_, err = md.OpenSourceFile(server.URL + "/missing.sql")
+	c.Assert(err, ErrorMatches, "head .*/missing.sql http status code != 200.*")
+}
+
+func (s *testMydumpHTTPSuite) TestReadResumesTruncatedResponses(c *C) {
+	const content = "INSERT INTO t VALUES (1),(2);\n"
+	var stalled int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if req.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			return
+		}
+		offset := 0
+		status := http.StatusOK
+		if rng := req.Header.Get("Range"); len(rng) > 0 {
+			offset, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			status = http.StatusPartialContent
+		}
+		// every response ends cleanly after at most 8 bytes.
+		end := len(content)
+		if end > offset+8 {
+			end = offset + 8
+		}
+		if atomic.LoadInt32(&stalled) != 0 {
+			end = offset
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, content[offset:end])
+	}))
+	defer server.Close()
+
+	r, err := md.OpenSourceFile(server.URL + "/db.t.sql")
+	c.Assert(err, IsNil)
+	defer r.Close()
+	read, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(read), Equals, content)
+
+	// a server which never sends the rest fails the read.
+	atomic.StoreInt32(&stalled, 1)
+	_, err = r.Seek(8, io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF)
+}
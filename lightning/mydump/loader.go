@@ -18,6 +18,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/pingcap/errors"
@@ -43,6 +44,10 @@ type MDTableMeta struct {
 	DataFiles  []string
 	charSet    string
 	TotalSize  int64
+	// The partition of each data file dumped per partition, keyed by path.
+	DataFilePartitions map[string]string
+	// The file containing the CREATE TRIGGER statements of the table.
+	TriggerFile string
 }
 
 func (m *MDTableMeta) GetSchema() string {
@@ -57,6 +62,25 @@ func (m *MDTableMeta) GetSchema() string {
 	return string(schema)
 }
 
+var beforeInsertTriggerRegexp = regexp.MustCompile("(?is)\\bTRIGGER\\s+((?:`[^`]*`|\\S)+?)\\s+BEFORE\\s+INSERT\\s+ON\\s")
+
+// GetBeforeInsertTriggers returns the names of the BEFORE INSERT triggers of
+// the table, which are not run when the rows are imported.
+func (m *MDTableMeta) GetBeforeInsertTriggers() ([]string, error) {
+	if len(m.TriggerFile) == 0 {
+		return nil, nil
+	}
+	content, err := ExportStatement(m.TriggerFile, m.charSet)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var triggers []string
+	for _, match := range beforeInsertTriggerRegexp.FindAllSubmatch(content, -1) {
+		triggers = append(triggers, string(match[1]))
+	}
+	return triggers, nil
+}
+
 /*
 	Mydumper File Loader
 */
@@ -67,6 +91,18 @@ type MDLoader struct {
 	filter   *filter.Filter
 	router   *router.Table
 	charSet  string
+	stdin    config.Stdin
+	metadata string
+	// data files which can only be read from the start.
+	unresumableFiles []string
+	// the order of the data files of a table, one of "path", "name" or "numeric".
+	fileOrder string
+	// whether the databases without "{db}-schema-create.sql" are accepted, to
+	// be created by `tidb.auto-create-database`.
+	autoCreateDB bool
+	// the source tables routed by the routes without wildcards.
+	exactRoutes   map[filter.Table]struct{}
+	caseSensitive bool
 }
 
 type mdLoaderSetup struct {
@@ -74,8 +110,11 @@ type mdLoaderSetup struct {
 	dbSchemas     []fileInfo
 	tableSchemas  []fileInfo
 	tableDatas    []fileInfo
+	tableTriggers []fileInfo
 	dbIndexMap    map[string]int
 	tableIndexMap map[filter.Table]int
+	// the tables having schema files, including the filtered ones.
+	schemaTables map[filter.Table]struct{}
 }
 
 func NewMyDumpLoader(cfg *config.Config) (*MDLoader, error) {
@@ -94,18 +133,41 @@ func NewMyDumpLoader(cfg *config.Config) (*MDLoader, error) {
 		filter:   filter.New(false, cfg.BWList),
 		router:   r,
 		charSet:  cfg.Mydumper.CharacterSet,
+		stdin:    cfg.Mydumper.Stdin,
+
+		fileOrder:    cfg.Mydumper.FileOrder,
+		autoCreateDB: cfg.TiDB.AutoCreateDatabase,
+
+		exactRoutes:   make(map[filter.Table]struct{}),
+		caseSensitive: cfg.Mydumper.CaseSensitive,
+	}
+	for _, rule := range cfg.Routes {
+		if isExactRoute(rule) {
+			mdl.exactRoutes[filter.Table{Schema: rule.SchemaPattern, Name: rule.TablePattern}] = struct{}{}
+		}
 	}
 
 	setup := mdLoaderSetup{
 		loader:        mdl,
 		dbIndexMap:    make(map[string]int),
 		tableIndexMap: make(map[filter.Table]int),
+		schemaTables:  make(map[filter.Table]struct{}),
 	}
 
 	if err := setup.setup(mdl.dir); err != nil {
 		return nil, errors.Trace(err)
 	}
 
+	// a table both skipping and requiring the checksum by overlapping
+	// `[[table-config]]` patterns is rejected before importing anything.
+	for _, dbMeta := range mdl.dbs {
+		for _, tableMeta := range dbMeta.Tables {
+			if _, err := cfg.ShouldChecksum(tableMeta.DB, tableMeta.Name); err != nil {
+				return nil, errors.Annotate(err, "invalid config")
+			}
+		}
+	}
+
 	return mdl, nil
 }
 
@@ -115,6 +177,7 @@ const (
 	fileTypeDatabaseSchema fileType = iota
 	fileTypeTableSchema
 	fileTypeTableData
+	fileTypeTableTrigger
 )
 
 func (ftype fileType) String() string {
@@ -125,6 +188,8 @@ func (ftype fileType) String() string {
 		return "table schema"
 	case fileTypeTableData:
 		return "table data"
+	case fileTypeTableTrigger:
+		return "table trigger"
 	default:
 		return "(unknown)"
 	}
@@ -132,11 +197,46 @@ func (ftype fileType) String() string {
 
 type fileInfo struct {
 	tableName filter.Table
+	partition string
 	path      string
+	name      string
 	size      int64
+	// the part number of "{db}.{table}.{part}.sql", or -1 if there is none.
+	part int64
+	// whether the name may be "{db}.{table}#{partition}", in which case the
+	// file is only filtered once resolved by resolvePartitions.
+	maybePartition bool
 }
 
-var tableNameRegexp = regexp.MustCompile(`^([^.]+)\.(.*?)(?:\.[0-9]+)?$`)
+var (
+	tableNameRegexp  = regexp.MustCompile(`^([^.]+)\.(.*?)(?:\.[0-9]+)?$`)
+	partNumberRegexp = regexp.MustCompile(`^[^.]+\..+\.([0-9]+)$`)
+)
+
+// sortTableDatas sorts the data files by the configured order. The sort is
+// stable, and the files are listed in the lexicographical order of the paths
+// to begin with.
+func sortTableDatas(infos []fileInfo, order string) {
+	var less func(a, b *fileInfo) bool
+	switch order {
+	case config.FileOrderName:
+		less = func(a, b *fileInfo) bool {
+			return a.name < b.name
+		}
+	case config.FileOrderNumeric:
+		less = func(a, b *fileInfo) bool {
+			if a.part != b.part {
+				return a.part < b.part
+			}
+			return a.name < b.name
+		}
+	default:
+		return
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return less(&infos[i], &infos[j])
+	})
+}
 
 // setup the `s.loader.dbs` slice by scanning all *.sql files inside `dir`.
 //
@@ -157,22 +257,37 @@ func (s *mdLoaderSetup) setup(dir string) error {
 		Mydumper file names format
 			db    —— {db}-schema-create.sql
 			table —— {db}.{table}-schema.sql
+			trigger —— {db}.{table}-schema-trigger.sql
 			sql   —— {db}.{table}.{part}.sql / {db}.{table}.sql
+			partition —— {db}.{table}#{partition}.{part}.sql / {db}.{table}#{partition}.sql
+			              (only if {db}.{table}-schema.sql exists and {db}.{table}#{partition}-schema.sql does not)
 	*/
-	if !common.IsDirExists(dir) {
-		return errors.Errorf("%s: mydumper dir does not exist", dir)
-	}
-
-	if err := s.listFiles(dir); err != nil {
-		return errors.Annotate(err, "list file failed")
+	if dir == config.StdinSourceDir {
+		s.addStdin()
+	} else if isHTTPPath(dir) {
+		if err := s.listHTTPFiles(dir); err != nil {
+			return errors.Annotate(err, "list file failed")
+		}
+	} else if IsTarArchive(dir) {
+		if err := s.listTarFiles(dir); err != nil {
+			return errors.Annotate(err, "list file failed")
+		}
+	} else {
+		if !common.IsDirExists(dir) {
+			return errors.Errorf("%s: mydumper dir does not exist", dir)
+		}
+		if err := s.listFiles(dir); err != nil {
+			return errors.Annotate(err, "list file failed")
+		}
 	}
+	s.resolvePartitions()
 	if err := s.route(); err != nil {
 		return errors.Trace(err)
 	}
 
 	if !s.loader.noSchema {
 		// setup database schema
-		if len(s.dbSchemas) == 0 {
+		if len(s.dbSchemas) == 0 && !s.loader.autoCreateDB {
 			return errors.New("missing {schema}-schema-create.sql")
 		}
 		for _, fileInfo := range s.dbSchemas {
@@ -184,7 +299,7 @@ func (s *mdLoaderSetup) setup(dir string) error {
 		// setup table schema
 		for _, fileInfo := range s.tableSchemas {
 			_, dbExists, tableExists := s.insertTable(fileInfo.tableName, fileInfo.path)
-			if !dbExists {
+			if !dbExists && !s.loader.autoCreateDB {
 				return errors.Errorf("invalid table schema file, cannot find db - %s", fileInfo.path)
 			} else if tableExists && s.loader.router == nil {
 				return errors.Errorf("invalid table schema file, duplicated item - %s", fileInfo.path)
@@ -193,6 +308,7 @@ func (s *mdLoaderSetup) setup(dir string) error {
 	}
 
 	// Sql file for restore data
+	sortTableDatas(s.tableDatas, s.loader.fileOrder)
 	for _, fileInfo := range s.tableDatas {
 		tableMeta, dbExists, tableExists := s.insertTable(fileInfo.tableName, "")
 		if !s.loader.noSchema {
@@ -204,6 +320,26 @@ func (s *mdLoaderSetup) setup(dir string) error {
 		}
 		tableMeta.DataFiles = append(tableMeta.DataFiles, fileInfo.path)
 		tableMeta.TotalSize += fileInfo.size
+		if len(fileInfo.partition) > 0 {
+			if tableMeta.DataFilePartitions == nil {
+				tableMeta.DataFilePartitions = make(map[string]string)
+			}
+			tableMeta.DataFilePartitions[fileInfo.path] = fileInfo.partition
+		}
+	}
+
+	// the triggers are only recorded for the tables being imported.
+	for _, fileInfo := range s.tableTriggers {
+		if tableIndex, ok := s.tableIndexMap[fileInfo.tableName]; ok {
+			dbMeta := s.loader.dbs[s.dbIndexMap[fileInfo.tableName.Schema]]
+			dbMeta.Tables[tableIndex].TriggerFile = fileInfo.path
+		}
+	}
+
+	for _, fileInfo := range s.tableDatas {
+		if isHTTPPath(fileInfo.path) && !IsSeekableSourceFile(fileInfo.path) {
+			s.loader.unresumableFiles = append(s.loader.unresumableFiles, fileInfo.path)
+		}
 	}
 
 	// Put the small table in the front of the slice which can avoid large table
@@ -230,71 +366,190 @@ func (s *mdLoaderSetup) listFiles(dir string) error {
 			return nil
 		}
 
-		fname := strings.TrimSpace(f.Name())
-		lowerFName := strings.ToLower(fname)
+		s.addFile(path, f.Name(), f.Size())
+		return nil
+	})
 
-		info := fileInfo{path: path, size: f.Size()}
-		logger := log.With(zap.String("path", path))
+	return errors.Trace(err)
+}
 
-		var (
-			ftype         fileType
-			qualifiedName string
-		)
-		switch {
-		case strings.HasSuffix(lowerFName, "-schema-create.sql"):
-			ftype = fileTypeDatabaseSchema
-			qualifiedName = fname[:len(fname)-18] + "."
-
-		case strings.HasSuffix(lowerFName, "-schema.sql"):
-			ftype = fileTypeTableSchema
-			qualifiedName = fname[:len(fname)-11]
-
-			// ignore functionality :
-			// 		- view
-			//		- triggers
-		case strings.HasSuffix(lowerFName, "-schema-view.sql"),
-			strings.HasSuffix(lowerFName, "-schema-trigger.sql"),
-			strings.HasSuffix(lowerFName, "-schema-post.sql"):
-			logger.Warn("[loader] ignore unsupport view/trigger")
-			return nil
-		case strings.HasSuffix(lowerFName, ".sql"), strings.HasSuffix(lowerFName, ".csv"):
-			ftype = fileTypeTableData
-			qualifiedName = fname[:len(fname)-4]
-		default:
-			return nil
+// addFile classifies the file by its name and records it into the setup.
+func (s *mdLoaderSetup) addFile(path string, name string, size int64) {
+	fname := strings.TrimSpace(name)
+	lowerFName := strings.ToLower(fname)
+
+	info := fileInfo{path: path, name: fname, size: size, part: -1}
+	logger := log.With(zap.String("path", path))
+
+	var (
+		ftype         fileType
+		qualifiedName string
+	)
+	switch {
+	case lowerFName == metadataFileName:
+		if len(s.loader.metadata) == 0 {
+			s.loader.metadata = path
+		} else {
+			logger.Warn("[loader] ignore extra metadata file", zap.String("used", s.loader.metadata))
 		}
+		return
+
+	case strings.HasSuffix(lowerFName, "-schema-create.sql"):
+		ftype = fileTypeDatabaseSchema
+		qualifiedName = fname[:len(fname)-18] + "."
+
+	case strings.HasSuffix(lowerFName, "-schema.sql"):
+		ftype = fileTypeTableSchema
+		qualifiedName = fname[:len(fname)-11]
+
+	case strings.HasSuffix(lowerFName, "-schema-trigger.sql"):
+		ftype = fileTypeTableTrigger
+		qualifiedName = fname[:len(fname)-19]
+
+		// ignore functionality :
+		// 		- view
+	case strings.HasSuffix(lowerFName, "-schema-view.sql"),
+		strings.HasSuffix(lowerFName, "-schema-post.sql"):
+		logger.Warn("[loader] ignore unsupport view")
+		return
+	case strings.HasSuffix(lowerFName, ".sql"), strings.HasSuffix(lowerFName, ".csv"):
+		ftype = fileTypeTableData
+		qualifiedName = fname[:len(fname)-4]
+	default:
+		return
+	}
 
-		matchRes := tableNameRegexp.FindStringSubmatch(qualifiedName)
-		if len(matchRes) != 3 {
-			logger.Debug("[loader] ignore almost " + ftype.String() + " file")
-			return nil
-		}
-		info.tableName.Schema = matchRes[1]
-		info.tableName.Name = matchRes[2]
+	matchRes := tableNameRegexp.FindStringSubmatch(qualifiedName)
+	if len(matchRes) != 3 {
+		logger.Debug("[loader] ignore almost " + ftype.String() + " file")
+		return
+	}
+	info.tableName.Schema = matchRes[1]
+	info.tableName.Name = matchRes[2]
+	if m := partNumberRegexp.FindStringSubmatch(qualifiedName); m != nil {
+		info.part, _ = strconv.ParseInt(m[1], 10, 64)
+	}
+	if ftype == fileTypeTableSchema {
+		s.schemaTables[info.tableName] = struct{}{}
+	}
+	// whether "#" separates the partition is only known once all the schema
+	// files are listed.
+	if ftype == fileTypeTableData && strings.IndexByte(info.tableName.Name, '#') > 0 {
+		info.maybePartition = true
+		s.tableDatas = append(s.tableDatas, info)
+		return
+	}
+
+	if s.loader.shouldSkip(&info.tableName) {
+		logger.Debug("[filter] ignoring table file")
+		return
+	}
+
+	switch ftype {
+	case fileTypeDatabaseSchema:
+		s.dbSchemas = append(s.dbSchemas, info)
+	case fileTypeTableSchema:
+		s.tableSchemas = append(s.tableSchemas, info)
+	case fileTypeTableData:
+		s.tableDatas = append(s.tableDatas, info)
+	case fileTypeTableTrigger:
+		s.tableTriggers = append(s.tableTriggers, info)
+	}
+}
 
+// resolvePartitions moves the data files named "{db}.{table}#{partition}" to
+// the table they are dumped from, if the dump has the schema of that table but
+// none of a table literally named "{table}#{partition}". Whether the table is
+// indeed partitioned is checked against its schema before importing.
+func (s *mdLoaderSetup) resolvePartitions() {
+	tableDatas := s.tableDatas[:0]
+	for _, info := range s.tableDatas {
+		if !info.maybePartition {
+			tableDatas = append(tableDatas, info)
+			continue
+		}
+		name := info.tableName.Name
+		_, isTable := s.schemaTables[info.tableName]
+		if i := strings.LastIndexByte(name, '#'); !isTable && i > 0 && i < len(name)-1 {
+			parent := filter.Table{Schema: info.tableName.Schema, Name: name[:i]}
+			if _, ok := s.schemaTables[parent]; ok {
+				info.tableName = parent
+				info.partition = name[i+1:]
+			}
+		}
 		if s.loader.shouldSkip(&info.tableName) {
-			logger.Debug("[filter] ignoring table file")
-			return nil
+			log.L().Debug("[filter] ignoring table file", zap.String("path", info.path))
+			continue
 		}
+		tableDatas = append(tableDatas, info)
+	}
+	s.tableDatas = tableDatas
+}
 
-		switch ftype {
-		case fileTypeDatabaseSchema:
-			s.dbSchemas = append(s.dbSchemas, info)
-		case fileTypeTableSchema:
-			s.tableSchemas = append(s.tableSchemas, info)
-		case fileTypeTableData:
-			s.tableDatas = append(s.tableDatas, info)
-		}
-		return nil
+// addStdin records the standard input as the only data file of the table
+// given in the config. Its size is unknown and thus recorded as 0.
+func (s *mdLoaderSetup) addStdin() {
+	stdin := &s.loader.stdin
+	s.tableDatas = append(s.tableDatas, fileInfo{
+		tableName: filter.Table{Schema: stdin.Schema, Name: stdin.Table},
+		path:      joinStdinPath(stdin),
 	})
+}
 
-	return errors.Trace(err)
+// listTarFiles lists the entries inside a tar archive as if the archive has
+// been extracted into a directory.
+func (s *mdLoaderSetup) listTarFiles(archive string) error {
+	entries, err := listTarEntries(archive)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, entry := range entries {
+		s.addFile(joinTarEntryPath(archive, entry.name), filepath.Base(entry.name), entry.size)
+	}
+	return nil
+}
+
+// listHTTPFiles lists the files below a directory listing URL (ending with
+// "/"), or the single file if the URL names a file.
+func (s *mdLoaderSetup) listHTTPFiles(dirURL string) error {
+	source := getHTTPSource()
+	files := []string{dirURL}
+	if strings.HasSuffix(dirURL, "/") {
+		var err error
+		if files, err = source.list(dirURL); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	for _, file := range files {
+		info, err := source.stat(file)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		s.addFile(file, httpFileName(file), info.size)
+	}
+	return nil
 }
 
 func (l *MDLoader) shouldSkip(table *filter.Table) bool {
 	return len(l.filter.ApplyOn([]*filter.Table{table})) == 0
 }
 
+// isExactRoute returns whether the route has no wildcards, i.e. it routes a
+// single table.
+func isExactRoute(rule *router.TableRule) bool {
+	return len(rule.TablePattern) > 0 &&
+		!strings.ContainsAny(rule.SchemaPattern, "*?") &&
+		!strings.ContainsAny(rule.TablePattern, "*?")
+}
+
+func (l *MDLoader) isExactlyRouted(table filter.Table) bool {
+	if !l.caseSensitive {
+		table = filter.Table{Schema: strings.ToLower(table.Schema), Name: strings.ToLower(table.Name)}
+	}
+	_, ok := l.exactRoutes[table]
+	return ok
+}
+
 func (s *mdLoaderSetup) route() error {
 	r := s.loader.router
 	if r == nil {
@@ -319,12 +574,24 @@ func (s *mdLoaderSetup) route() error {
 		knownDBNames[info.tableName.Schema] = dbInfo
 	}
 
+	// a table routed without wildcards must be the only source of its target.
+	sources := make(map[filter.Table]filter.Table)
+
 	run := func(arr []fileInfo) error {
 		for i, info := range arr {
 			dbName, tableName, err := r.Route(info.tableName.Schema, info.tableName.Name)
 			if err != nil {
 				return errors.Trace(err)
 			}
+			target := filter.Table{Schema: dbName, Name: tableName}
+			if source, ok := sources[target]; !ok {
+				sources[target] = info.tableName
+			} else if source != info.tableName && (s.loader.isExactlyRouted(source) || s.loader.isExactlyRouted(info.tableName)) {
+				return errors.Errorf("tables %s and %s are both routed to %s, but a route without wildcards must not merge tables",
+					common.UniqueTable(source.Schema, source.Name),
+					common.UniqueTable(info.tableName.Schema, info.tableName.Name),
+					common.UniqueTable(dbName, tableName))
+			}
 			if dbName != info.tableName.Schema {
 				oldInfo := knownDBNames[info.tableName.Schema]
 				oldInfo.count--
@@ -352,6 +619,9 @@ func (s *mdLoaderSetup) route() error {
 	if err := run(s.tableDatas); err != nil {
 		return errors.Trace(err)
 	}
+	if err := run(s.tableTriggers); err != nil {
+		return errors.Trace(err)
+	}
 
 	// remove all schemas which has been entirely routed away
 	// https://github.com/golang/go/wiki/SliceTricks#filtering-without-allocating
@@ -401,6 +671,22 @@ func (s *mdLoaderSetup) insertTable(tableName filter.Table, path string) (*MDTab
 	}
 }
 
+// GetDumpMetadata returns the content of the metadata file written by mydumper,
+// or nil if the data source has no metadata file.
+func (l *MDLoader) GetDumpMetadata() (*DumpMetadata, error) {
+	if len(l.metadata) == 0 {
+		return nil, nil
+	}
+	return ReadDumpMetadata(l.metadata)
+}
+
 func (l *MDLoader) GetDatabases() []*MDDatabaseMeta {
 	return l.dbs
 }
+
+// GetUnresumableFiles returns the data files served over HTTP(S) without
+// range requests, which have to be downloaded again from the start to resume
+// from a checkpoint.
+func (l *MDLoader) GetUnresumableFiles() []string {
+	return l.unresumableFiles
+}
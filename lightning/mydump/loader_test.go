@@ -15,6 +15,7 @@ package mydump_test
 
 import (
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path"
 	"testing"
@@ -128,6 +129,39 @@ func (s *testMydumpLoaderSuite) TestTableNoHostDB(c *C) {
 	c.Assert(err, ErrorMatches, `invalid table schema file, cannot find db - .*/db.tbl-schema\.sql`)
 }
 
+func (s *testMydumpLoaderSuite) TestTableNoHostDBAutoCreate(c *C) {
+	/*
+		path/
+			notdb-schema-create.sql
+			db.tbl-schema.sql
+	*/
+
+	pNotDBSchema := s.touch(c, "notdb-schema-create.sql")
+	pTblSchema := s.touch(c, "db.tbl-schema.sql")
+
+	s.cfg.TiDB.AutoCreateDatabase = true
+
+	mdl, err := md.NewMyDumpLoader(s.cfg)
+	c.Assert(err, IsNil)
+
+	c.Assert(mdl.GetDatabases(), DeepEquals, []*md.MDDatabaseMeta{
+		{
+			Name:       "notdb",
+			SchemaFile: pNotDBSchema,
+		},
+		{
+			Name:       "db",
+			SchemaFile: "",
+			Tables: []*md.MDTableMeta{{
+				DB:         "db",
+				Name:       "tbl",
+				SchemaFile: pTblSchema,
+				DataFiles:  []string{},
+			}},
+		},
+	})
+}
+
 func (s *testMydumpLoaderSuite) TestDuplicatedTable(c *C) {
 	/*
 		path/
@@ -236,6 +270,152 @@ func (s *testMydumpLoaderSuite) TestTablesWithDots(c *C) {
 	}})
 }
 
+func (s *testMydumpLoaderSuite) TestPartitionFiles(c *C) {
+	pDBSchema := s.touch(c, "db-schema-create.sql")
+	pSchema := s.touch(c, "db.tbl-schema.sql")
+	pP0Data := s.touch(c, "db.tbl#p0.csv")
+	pP1Data1 := s.touch(c, "db.tbl#p1.0001.csv")
+	pP1Data2 := s.touch(c, "db.tbl#p1.0002.csv")
+	pData := s.touch(c, "db.tbl.csv")
+
+	mdl, err := md.NewMyDumpLoader(s.cfg)
+	c.Assert(err, IsNil)
+
+	c.Assert(mdl.GetDatabases(), DeepEquals, []*md.MDDatabaseMeta{{
+		Name:       "db",
+		SchemaFile: pDBSchema,
+		Tables: []*md.MDTableMeta{{
+			DB:         "db",
+			Name:       "tbl",
+			SchemaFile: pSchema,
+			DataFiles:  []string{pP0Data, pP1Data1, pP1Data2, pData},
+			DataFilePartitions: map[string]string{
+				pP0Data:  "p0",
+				pP1Data1: "p1",
+				pP1Data2: "p1",
+			},
+		}},
+	}})
+}
+
+func (s *testMydumpLoaderSuite) TestTablesWithHash(c *C) {
+	pDBSchema := s.touch(c, "db-schema-create.sql")
+	pT1Schema := s.touch(c, "db.a#b-schema.sql")
+	pT1Data := s.touch(c, "db.a#b.csv")
+	pT2Schema := s.touch(c, "db.a-schema.sql")
+	pT2Data := s.touch(c, "db.a#c.csv")
+
+	mdl, err := md.NewMyDumpLoader(s.cfg)
+	c.Assert(err, IsNil)
+
+	// "a#b" is a table, but "a#c" is the partition "c" of the table "a".
+	c.Assert(mdl.GetDatabases(), DeepEquals, []*md.MDDatabaseMeta{{
+		Name:       "db",
+		SchemaFile: pDBSchema,
+		Tables: []*md.MDTableMeta{
+			{
+				DB:         "db",
+				Name:       "a#b",
+				SchemaFile: pT1Schema,
+				DataFiles:  []string{pT1Data},
+			},
+			{
+				DB:                 "db",
+				Name:               "a",
+				SchemaFile:         pT2Schema,
+				DataFiles:          []string{pT2Data},
+				DataFilePartitions: map[string]string{pT2Data: "c"},
+			},
+		},
+	}})
+
+	// without the schema of "a", "a#c" is a table too.
+	s.cfg.Mydumper.NoSchema = true
+	c.Assert(os.Remove(pT1Schema), IsNil)
+	c.Assert(os.Remove(pT2Schema), IsNil)
+	mdl, err = md.NewMyDumpLoader(s.cfg)
+	c.Assert(err, IsNil)
+	tables := mdl.GetDatabases()[0].Tables
+	c.Assert(tables, HasLen, 2)
+	for _, tableMeta := range tables {
+		c.Assert(tableMeta.Name, Matches, "a#[bc]")
+		c.Assert(tableMeta.DataFilePartitions, IsNil)
+	}
+}
+
+func (s *testMydumpLoaderSuite) TestFileOrder(c *C) {
+	names := []string{"db.tbl.sql", "db.tbl.1.sql", "db.tbl.2.sql", "db.tbl.10.sql", "db.tbl.0011.sql"}
+	expected := map[string][]string{
+		config.FileOrderName:    {"db.tbl.0011.sql", "db.tbl.1.sql", "db.tbl.10.sql", "db.tbl.2.sql", "db.tbl.sql"},
+		config.FileOrderNumeric: {"db.tbl.sql", "db.tbl.1.sql", "db.tbl.2.sql", "db.tbl.10.sql", "db.tbl.0011.sql"},
+	}
+
+	// the files are put into shuffled subdirectories, so the paths are listed
+	// in a different order every round.
+	for i := 0; i < 5; i++ {
+		for order, expectedNames := range expected {
+			s.cfg = &config.Config{Mydumper: config.MydumperRuntime{SourceDir: c.MkDir(), FileOrder: order}}
+			s.touch(c, "db-schema-create.sql")
+			s.touch(c, "db.tbl-schema.sql")
+			paths := make(map[string]string, len(names))
+			for j, k := range rand.Perm(len(names)) {
+				dir := string('a' + rune(k))
+				s.mkdir(c, dir)
+				paths[names[j]] = s.touch(c, dir, names[j])
+			}
+
+			mdl, err := md.NewMyDumpLoader(s.cfg)
+			c.Assert(err, IsNil)
+			dbs := mdl.GetDatabases()
+			c.Assert(dbs, HasLen, 1)
+			c.Assert(dbs[0].Tables, HasLen, 1)
+
+			expectedPaths := make([]string, 0, len(expectedNames))
+			for _, name := range expectedNames {
+				expectedPaths = append(expectedPaths, paths[name])
+			}
+			c.Assert(dbs[0].Tables[0].DataFiles, DeepEquals, expectedPaths, Commentf("order %s", order))
+		}
+	}
+}
+
+func (s *testMydumpLoaderSuite) TestTriggers(c *C) {
+	s.cfg.Mydumper.CharacterSet = "auto"
+	s.touch(c, "db-schema-create.sql")
+	s.touch(c, "db.tbl-schema.sql")
+	s.touch(c, "db.tbl.sql")
+	s.touch(c, "db.other-schema.sql")
+	pTrigger := path.Join(s.cfg.Mydumper.SourceDir, "db.tbl-schema-trigger.sql")
+	err := ioutil.WriteFile(pTrigger, []byte(`
+/*!40101 SET NAMES binary*/;
+DELIMITER ;;
+CREATE DEFINER=`+"`root`@`localhost`"+` TRIGGER `+"`set b`"+` BEFORE INSERT ON `+"`tbl`"+` FOR EACH ROW SET NEW.b = NEW.a * 2;;
+CREATE TRIGGER log_a AFTER INSERT ON tbl FOR EACH ROW INSERT INTO log VALUES (NEW.a);;
+create trigger fill_c before insert on tbl for each row set new.c = 1;;
+DELIMITER ;
+`), 0644)
+	c.Assert(err, IsNil)
+	// triggers of tables not being imported are ignored.
+	s.touch(c, "db.missing-schema-trigger.sql")
+
+	mdl, err := md.NewMyDumpLoader(s.cfg)
+	c.Assert(err, IsNil)
+
+	tables := mdl.GetDatabases()[0].Tables
+	c.Assert(tables, HasLen, 2)
+	c.Assert(tables[0].Name, Equals, "other")
+	c.Assert(tables[0].TriggerFile, Equals, "")
+	triggers, err := tables[0].GetBeforeInsertTriggers()
+	c.Assert(err, IsNil)
+	c.Assert(triggers, HasLen, 0)
+
+	c.Assert(tables[1].Name, Equals, "tbl")
+	c.Assert(tables[1].TriggerFile, Equals, pTrigger)
+	triggers, err = tables[1].GetBeforeInsertTriggers()
+	c.Assert(err, IsNil)
+	c.Assert(triggers, DeepEquals, []string{"`set b`", "fill_c"})
+}
+
 func (s *testMydumpLoaderSuite) TestRouter(c *C) {
 	s.cfg.Routes = []*router.TableRule{
 		{
@@ -333,6 +513,36 @@ func (s *testMydumpLoaderSuite) TestRouter(c *C) {
 	})
 }
 
+func (s *testMydumpLoaderSuite) TestRouterExactRouteNotMerged(c *C) {
+	s.cfg.Routes = []*router.TableRule{
+		{
+			SchemaPattern: "a",
+			TablePattern:  "t",
+			TargetSchema:  "b",
+			TargetTable:   "u",
+		},
+		{
+			SchemaPattern: "c*",
+			TablePattern:  "*",
+			TargetSchema:  "b",
+			TargetTable:   "u",
+		},
+	}
+
+	s.touch(c, "a-schema-create.sql")
+	s.touch(c, "a.t-schema.sql")
+	s.touch(c, "c-schema-create.sql")
+	s.touch(c, "c.t-schema.sql")
+
+	_, err := md.NewMyDumpLoader(s.cfg)
+	c.Assert(err, ErrorMatches, "tables `(a|c)`.`t` and `(a|c)`.`t` are both routed to `b`.`u`.*")
+
+	// the tables routed with wildcards can still be merged.
+	s.cfg.Routes[0].SchemaPattern = "a*"
+	_, err = md.NewMyDumpLoader(s.cfg)
+	c.Assert(err, IsNil)
+}
+
 func (s *testMydumpLoaderSuite) TestBadRouterRule(c *C) {
 	s.cfg.Routes = []*router.TableRule{{
 		SchemaPattern: "a*b",
@@ -344,3 +554,25 @@ func (s *testMydumpLoaderSuite) TestBadRouterRule(c *C) {
 	_, err := md.NewMyDumpLoader(s.cfg)
 	c.Assert(err, ErrorMatches, `.*pattern a\*b not valid`)
 }
+
+func (s *testMydumpLoaderSuite) TestChecksumConfigConflict(c *C) {
+	// neither pattern matches the other, so only the tables can tell they
+	// overlap.
+	s.cfg.TableConfigs = []*config.TableConfig{
+		{Pattern: "logs.*", Checksum: config.ChecksumSkip},
+		{Pattern: "*.orders", Checksum: config.ChecksumRequired},
+	}
+
+	s.touch(c, "logs-schema-create.sql")
+	s.touch(c, "logs.access-schema.sql")
+	s.touch(c, "logs.access.sql")
+
+	_, err := md.NewMyDumpLoader(s.cfg)
+	c.Assert(err, IsNil)
+
+	s.touch(c, "logs.orders-schema.sql")
+	s.touch(c, "logs.orders.sql")
+
+	_, err = md.NewMyDumpLoader(s.cfg)
+	c.Assert(err, ErrorMatches, "invalid config: table `logs`.`orders` requires checksum by `\\*.orders` but is also skipped by `logs.\\*`")
+}
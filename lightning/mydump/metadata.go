@@ -0,0 +1,163 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// metadataFileName is the name of the file written by mydumper recording the
+// binlog position at the time of the dump.
+const metadataFileName = "metadata"
+
+// BinlogPosition is a replication position recorded in the metadata file.
+type BinlogPosition struct {
+	Host string `json:"host,omitempty"`
+	Log  string `json:"log"`
+	Pos  uint64 `json:"pos"`
+	GTID string `json:"gtid"`
+}
+
+// DumpMetadata is the content of the metadata file written by mydumper, e.g.
+//
+//	Started dump at: 2019-09-10 11:31:45
+//	SHOW MASTER STATUS:
+//		Log: mysql-bin.000003
+//		Pos: 404
+//		GTID:3ccc475b-2343-11e7-be21-6c0b84d59f30:1-14
+//
+//	SHOW SLAVE STATUS:
+//		Host: 127.0.0.1
+//		Log: mysql-bin.000001
+//		Pos: 1024
+//		GTID:
+//
+//	Finish dump at: 2019-09-10 11:31:46
+//
+// The slave status only exists if the dumped server is a replica.
+type DumpMetadata struct {
+	StartedAt  string          `json:"started-at"`
+	FinishedAt string          `json:"finished-at"`
+	Master     *BinlogPosition `json:"master,omitempty"`
+	Slave      *BinlogPosition `json:"slave,omitempty"`
+}
+
+// ParseDumpMetadata parses the content of a mydumper metadata file.
+func ParseDumpMetadata(r io.Reader) (*DumpMetadata, error) {
+	meta := &DumpMetadata{}
+	var current *BinlogPosition
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) == 0:
+			current = nil
+			continue
+		case strings.HasPrefix(line, "Started dump at:"):
+			meta.StartedAt = strings.TrimSpace(strings.TrimPrefix(line, "Started dump at:"))
+			continue
+		case strings.HasPrefix(line, "Finish dump at:"):
+			meta.FinishedAt = strings.TrimSpace(strings.TrimPrefix(line, "Finish dump at:"))
+			continue
+		case strings.HasPrefix(line, "SHOW MASTER STATUS"):
+			meta.Master = &BinlogPosition{}
+			current = meta.Master
+			continue
+		case strings.HasPrefix(line, "SHOW SLAVE STATUS"):
+			meta.Slave = &BinlogPosition{}
+			current = meta.Slave
+			continue
+		case current == nil:
+			continue
+		}
+
+		// a GTID set of multiple sources spans multiple lines, each but the
+		// last one ending with a comma.
+		if strings.HasSuffix(current.GTID, ",") {
+			current.GTID += line
+			continue
+		}
+
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return nil, errors.Errorf("invalid metadata line %d: %s", lineNo, line)
+		}
+		value := strings.TrimSpace(line[colon+1:])
+		switch line[:colon] {
+		case "Host":
+			current.Host = value
+		case "Log":
+			current.Log = value
+		case "Pos":
+			pos, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, errors.Annotatef(err, "invalid metadata line %d: %s", lineNo, line)
+			}
+			current.Pos = pos
+		case "GTID":
+			current.GTID = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return meta, nil
+}
+
+// ReadDumpMetadata reads and parses the metadata file at the path.
+func ReadDumpMetadata(path string) (*DumpMetadata, error) {
+	file, err := OpenSourceFile(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer file.Close()
+
+	meta, err := ParseDumpMetadata(file)
+	return meta, errors.Annotatef(err, "cannot parse %s", path)
+}
+
+// MarshalLogObject implements the zapcore.ObjectMarshaler interface.
+func (pos *BinlogPosition) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
+	if len(pos.Host) > 0 {
+		encoder.AddString("host", pos.Host)
+	}
+	encoder.AddString("log", pos.Log)
+	encoder.AddUint64("pos", pos.Pos)
+	encoder.AddString("gtid", pos.GTID)
+	return nil
+}
+
+// LogFields returns the metadata as logging fields.
+func (meta *DumpMetadata) LogFields() []zap.Field {
+	fields := []zap.Field{
+		zap.String("startedAt", meta.StartedAt),
+		zap.String("finishedAt", meta.FinishedAt),
+	}
+	if meta.Master != nil {
+		fields = append(fields, zap.Object("master", meta.Master))
+	}
+	if meta.Slave != nil {
+		fields = append(fields, zap.Object("slave", meta.Slave))
+	}
+	return fields
+}
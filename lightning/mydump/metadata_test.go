@@ -0,0 +1,87 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	md "github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testMydumpMetadataSuite{})
+
+type testMydumpMetadataSuite struct{}
+
+const testMetadata = `Started dump at: 2019-09-10 11:31:45
+SHOW MASTER STATUS:
+	Log: mysql-bin.000003
+	Pos: 404
+	GTID:3ccc475b-2343-11e7-be21-6c0b84d59f30:1-14,
+406a3f61-690d-11e7-87c5-6c92bf46f384:1-94321
+
+SHOW SLAVE STATUS:
+	Host: 127.0.0.1
+	Log: mysql-bin.000001
+	Pos: 1024
+	GTID:
+
+Finish dump at: 2019-09-10 11:31:46
+`
+
+func (s *testMydumpMetadataSuite) TestParseDumpMetadata(c *C) {
+	meta, err := md.ParseDumpMetadata(strings.NewReader(testMetadata))
+	c.Assert(err, IsNil)
+	c.Assert(meta, DeepEquals, &md.DumpMetadata{
+		StartedAt:  "2019-09-10 11:31:45",
+		FinishedAt: "2019-09-10 11:31:46",
+		Master: &md.BinlogPosition{
+			Log:  "mysql-bin.000003",
+			Pos:  404,
+			GTID: "3ccc475b-2343-11e7-be21-6c0b84d59f30:1-14,406a3f61-690d-11e7-87c5-6c92bf46f384:1-94321",
+		},
+		Slave: &md.BinlogPosition{
+			Host: "127.0.0.1",
+			Log:  "mysql-bin.000001",
+			Pos:  1024,
+		},
+	})
+
+	meta, err = md.ParseDumpMetadata(strings.NewReader("SHOW MASTER STATUS:\n\tLog: mysql-bin.000003\n\tPos: 40x\n"))
+	c.Assert(err, ErrorMatches, "invalid metadata line 3: Pos: 40x.*")
+	c.Assert(meta, IsNil)
+}
+
+func (s *testMydumpMetadataSuite) TestLoadDumpMetadata(c *C) {
+	dir := c.MkDir()
+	cfg := &config.Config{Mydumper: config.MydumperRuntime{SourceDir: dir, NoSchema: true}}
+
+	mdl, err := md.NewMyDumpLoader(cfg)
+	c.Assert(err, IsNil)
+	meta, err := mdl.GetDumpMetadata()
+	c.Assert(err, IsNil)
+	c.Assert(meta, IsNil)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "metadata"), []byte(testMetadata), 0644)
+	c.Assert(err, IsNil)
+	mdl, err = md.NewMyDumpLoader(cfg)
+	c.Assert(err, IsNil)
+	meta, err = mdl.GetDumpMetadata()
+	c.Assert(err, IsNil)
+	c.Assert(meta.Master.Log, Equals, "mysql-bin.000003")
+	c.Assert(meta.Master.Pos, Equals, uint64(404))
+}
@@ -45,6 +45,8 @@ type blockParser struct {
 	lastRow Row
 	// Current file offset.
 	pos int64
+	// Whether to keep the byte order mark at the start of the file.
+	preserveBOM bool
 
 	// cache
 	remainBuf *bytes.Buffer
@@ -72,6 +74,10 @@ type ChunkParser struct {
 	blockParser
 
 	escFlavor backslashEscapeFlavor
+
+	// The file offset and row ID just before the last INSERT statement.
+	stmtPos   int64
+	stmtRowID int64
 }
 
 // Chunk represents a portion of the data file.
@@ -153,6 +159,18 @@ func (parser *blockParser) Columns() []string {
 	return parser.columns
 }
 
+// SetPreserveBOM sets whether the byte order mark at the start of the file is
+// kept as part of the content. By default it is skipped.
+func (parser *blockParser) SetPreserveBOM(preserve bool) {
+	parser.preserveBOM = preserve
+}
+
+// SetColumns overrides the column names, used when the parser starts in the
+// middle of a file where the column names cannot be read from the content.
+func (parser *blockParser) SetColumns(columns []string) {
+	parser.columns = columns
+}
+
 func (parser *blockParser) logSyntaxError() {
 	content := parser.buf
 	if len(content) > 256 {
@@ -213,6 +231,28 @@ func (tok token) String() string {
 	return fmt.Sprintf("<Unknown(%d)>", t)
 }
 
+var (
+	utf8BOM = []byte{0xef, 0xbb, 0xbf}
+	// byte order marks of UTF-16BE and UTF-16LE.
+	utf16BOMs = [][]byte{{0xfe, 0xff}, {0xff, 0xfe}}
+)
+
+// checkBOM rejects the file starting with a UTF-16 byte order mark, and skips
+// the UTF-8 one unless it is preserved. The position still counts the skipped
+// bytes so that it remains the file offset.
+func (parser *blockParser) checkBOM() error {
+	for _, bom := range utf16BOMs {
+		if bytes.HasPrefix(parser.buf, bom) {
+			return errors.New("the data file starts with a UTF-16 byte order mark, which is not supported, convert it to UTF-8 first")
+		}
+	}
+	if !parser.preserveBOM && bytes.HasPrefix(parser.buf, utf8BOM) {
+		parser.buf = parser.buf[len(utf8BOM):]
+		parser.pos += int64(len(utf8BOM))
+	}
+	return nil
+}
+
 func (parser *blockParser) readBlock() error {
 	startTime := time.Now()
 
@@ -235,6 +275,13 @@ func (parser *blockParser) readBlock() error {
 		parser.appendBuf.Write(parser.blockBuf[:n])
 		parser.buf = parser.appendBuf.Bytes()
 		metric.ChunkParserReadBlockSecondsHistogram.Observe(time.Since(startTime).Seconds())
+		if parser.pos == 0 {
+			// the byte order mark may be split across the blocks.
+			if len(parser.buf) < len(utf8BOM) && !parser.isLastChunk {
+				return parser.readBlock()
+			}
+			return parser.checkBOM()
+		}
 		return nil
 	default:
 		return errors.Trace(err)
@@ -431,6 +478,8 @@ func (parser *ChunkParser) ReadRow() error {
 				st = stateRow
 			case tokUnquoted, tokDoubleQuoted, tokBackQuoted:
 				parser.columns = nil
+				parser.stmtPos = parser.pos - int64(len(content))
+				parser.stmtRowID = row.RowID
 				st = stateTableName
 			case tokValues:
 			default:
@@ -533,3 +582,89 @@ func ReadChunks(parser Parser, minSize int64) ([]Chunk, error) {
 		}
 	}
 }
+
+// ReadStatementChunks parses the entire SQL file and splits it into continuous
+// chunks of size >= minSize. A chunk starting in the middle of an INSERT
+// statement is restored with the column list of the first statement of the
+// file (see ReadColumns), so a chunk boundary is only placed between the rows
+// of a statement if it has the same column list. Otherwise, the boundary is
+// placed at the start of the statement, so that its column list is never
+// separated from its values.
+func ReadStatementChunks(parser *ChunkParser, minSize int64) ([]Chunk, error) {
+	var chunks []Chunk
+	var firstColumns []string
+	isFirstRow := true
+
+	pos, lastRowID := parser.Pos()
+	cur := Chunk{
+		Offset:       pos,
+		EndOffset:    pos,
+		PrevRowIDMax: lastRowID,
+		RowIDMax:     lastRowID,
+	}
+	parser.stmtPos = pos
+
+	for {
+		switch err := parser.ReadRow(); errors.Cause(err) {
+		case nil:
+			if isFirstRow {
+				firstColumns = parser.Columns()
+				isFirstRow = false
+			}
+			if parser.stmtPos-cur.Offset >= minSize {
+				cur.EndOffset, cur.RowIDMax = parser.stmtPos, parser.stmtRowID
+				chunks = append(chunks, cur)
+				cur.Offset = cur.EndOffset
+				cur.PrevRowIDMax = cur.RowIDMax
+			}
+			cur.EndOffset, cur.RowIDMax = parser.Pos()
+			if cur.EndOffset-cur.Offset >= minSize && sameColumns(parser.Columns(), firstColumns) {
+				chunks = append(chunks, cur)
+				cur.Offset = cur.EndOffset
+				cur.PrevRowIDMax = cur.RowIDMax
+			}
+
+		case io.EOF:
+			if cur.Offset < cur.EndOffset {
+				chunks = append(chunks, cur)
+			}
+			return chunks, nil
+
+		default:
+			return nil, errors.Trace(err)
+		}
+	}
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadColumns reads the column list of the first INSERT statement of the file,
+// along with its first row. The parser must be at the start of the file.
+func (parser *ChunkParser) ReadColumns() error {
+	return errors.Trace(parser.ReadRow())
+}
+
+// SkipTo reads and discards the rows up to the offset, which must be the end
+// of a row, so that the parser continues with the column list of the statement
+// containing the offset.
+func (parser *ChunkParser) SkipTo(offset int64) error {
+	for parser.pos < offset {
+		if err := parser.ReadRow(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if parser.pos != offset {
+		return errors.Errorf("offset %d is not at the end of a row, the next row ends at %d", offset, parser.pos)
+	}
+	return nil
+}
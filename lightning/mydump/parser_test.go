@@ -15,6 +15,7 @@ package mydump_test
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"strings"
 
@@ -173,6 +174,90 @@ func (s *testMydumpParserSuite) TestReadChunks(c *C) {
 	})
 }
 
+func (s *testMydumpParserSuite) readStatementChunk(c *C, data string, chunk mydump.Chunk) (rows []uint64, columns []string) {
+	reader := strings.NewReader(data)
+	parser := mydump.NewChunkParser(mysql.ModeNone, reader, 16, s.ioWorkers)
+	if chunk.Offset > 0 {
+		c.Assert(parser.ReadColumns(), IsNil)
+		columns := parser.Columns()
+		parser = mydump.NewChunkParser(mysql.ModeNone, reader, 16, s.ioWorkers)
+		parser.SetColumns(columns)
+	}
+	reader.Seek(chunk.Offset, io.SeekStart)
+	parser.SetPos(chunk.Offset, chunk.PrevRowIDMax)
+	for {
+		offset, _ := parser.Pos()
+		if offset >= chunk.EndOffset {
+			break
+		}
+		c.Assert(parser.ReadRow(), IsNil)
+		row := parser.LastRow()
+		c.Assert(row.Row, HasLen, 1)
+		c.Assert(uint64(row.RowID), Equals, row.Row[0].GetUint64())
+		rows = append(rows, row.Row[0].GetUint64())
+	}
+	c.Assert(int64(len(rows)), Equals, chunk.RowIDMax-chunk.PrevRowIDMax)
+	return rows, parser.Columns()
+}
+
+func (s *testMydumpParserSuite) TestReadStatementChunksOfLargeStatement(c *C) {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO `t` (`a`) VALUES ")
+	for i := 1; i <= 100; i++ {
+		if i > 1 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, "(%d)", i)
+	}
+	sb.WriteString(";\n")
+	data := sb.String()
+
+	// the statement is much larger than both the block buffer and the chunk size.
+	parser := mydump.NewChunkParser(mysql.ModeNone, strings.NewReader(data), 16, s.ioWorkers)
+	chunks, err := mydump.ReadStatementChunks(parser, 64)
+	c.Assert(err, IsNil)
+	c.Assert(len(chunks), Greater, 5)
+
+	var allRows []uint64
+	for i, chunk := range chunks {
+		if i > 0 {
+			c.Assert(chunk.Offset, Equals, chunks[i-1].EndOffset)
+			c.Assert(chunk.PrevRowIDMax, Equals, chunks[i-1].RowIDMax)
+		}
+		rows, columns := s.readStatementChunk(c, data, chunk)
+		c.Assert(columns, DeepEquals, []string{"a"})
+		allRows = append(allRows, rows...)
+	}
+	c.Assert(allRows, HasLen, 100)
+	for i, row := range allRows {
+		c.Assert(row, Equals, uint64(i+1))
+	}
+}
+
+func (s *testMydumpParserSuite) TestReadStatementChunksWithDifferentColumns(c *C) {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO `t` (`a`) VALUES (1),(2),(3),(4),(5),(6),(7),(8),(9),(10);\n")
+	stmtStart := int64(sb.Len()) + int64(len("INSERT INTO "))
+	sb.WriteString("INSERT INTO `t` (`b`) VALUES (11),(12),(13),(14),(15),(16),(17),(18),(19),(20);\n")
+	data := sb.String()
+
+	parser := mydump.NewChunkParser(mysql.ModeNone, strings.NewReader(data), 16, s.ioWorkers)
+	chunks, err := mydump.ReadStatementChunks(parser, 16)
+	c.Assert(err, IsNil)
+
+	// the first statement may be split between its rows, but the second one
+	// has a different column list and so must not be split.
+	c.Assert(len(chunks), Greater, 2)
+	last := chunks[len(chunks)-1]
+	c.Assert(last.Offset, LessEqual, stmtStart)
+	c.Assert(last.PrevRowIDMax, LessEqual, int64(10))
+	c.Assert(last.RowIDMax, Equals, int64(20))
+
+	rows, columns := s.readStatementChunk(c, data, last)
+	c.Assert(columns, DeepEquals, []string{"b"})
+	c.Assert(rows[len(rows)-10:], DeepEquals, []uint64{11, 12, 13, 14, 15, 16, 17, 18, 19, 20})
+}
+
 func (s *testMydumpParserSuite) TestNestedRow(c *C) {
 	reader := strings.NewReader(`
 		INSERT INTO exam_detail VALUES
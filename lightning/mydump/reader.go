@@ -17,7 +17,6 @@ import (
 	"bufio"
 	"bytes"
 	"io"
-	"os"
 	"strings"
 	"unicode/utf8"
 
@@ -65,20 +64,20 @@ func decodeCharacterSet(data []byte, characterSet string) ([]byte, error) {
 }
 
 func ExportStatement(sqlFile string, characterSet string) ([]byte, error) {
-	fd, err := os.Open(sqlFile)
+	fd, err := OpenSourceFile(sqlFile)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	defer fd.Close()
 
 	br := bufio.NewReader(fd)
-	f, err := os.Stat(sqlFile)
+	size, err := SourceFileSize(sqlFile)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	data := make([]byte, 0, f.Size()+1)
-	buffer := make([]byte, 0, f.Size()+1)
+	data := make([]byte, 0, size+1)
+	buffer := make([]byte, 0, size+1)
 	for {
 		line, err := br.ReadString('\n')
 		if errors.Cause(err) == io.EOF && len(line) == 0 { // it will return EOF if there is no trailing new line.
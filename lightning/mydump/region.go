@@ -15,10 +15,13 @@ package mydump
 
 import (
 	"math"
-	"os"
 	"strings"
 
 	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+	"go.uber.org/zap"
 )
 
 type TableRegion struct {
@@ -132,12 +135,28 @@ func AllocateEngineIDs(
 	}
 }
 
+// CapEngineIDs merges the consecutive engines evenly, so that the regions of a
+// table use at most `maxEngines` engines in total. This does not limit how
+// many engines are open at the same time, which is governed by the
+// concurrency settings. Nothing is changed if `maxEngines` is 0.
+func CapEngineIDs(filesRegions []*TableRegion, maxEngines int) {
+	if maxEngines <= 0 || len(filesRegions) == 0 {
+		return
+	}
+	engineCount := int64(filesRegions[len(filesRegions)-1].EngineID) + 1
+	if engineCount <= int64(maxEngines) {
+		return
+	}
+	for _, region := range filesRegions {
+		region.EngineID = int32(int64(region.EngineID) * int64(maxEngines) / engineCount)
+	}
+}
+
 func MakeTableRegions(
 	meta *MDTableMeta,
 	columns int,
-	batchSize int64,
-	batchImportRatio float64,
-	tableConcurrency int,
+	cfg *config.Config,
+	ioWorkers *worker.Pool,
 ) ([]*TableRegion, error) {
 	// Split files into regions
 	filesRegions := make(regionSlice, 0, len(meta.DataFiles))
@@ -145,11 +164,30 @@ func MakeTableRegions(
 
 	prevRowIDMax := int64(0)
 	for _, dataFile := range meta.DataFiles {
-		dataFileInfo, err := os.Stat(dataFile)
+		dataFileSize, err := SourceFileSize(dataFile)
 		if err != nil {
 			return nil, errors.Annotatef(err, "cannot stat %s", dataFile)
 		}
-		dataFileSize := dataFileInfo.Size()
+
+		// files inside an archive cannot be seeked cheaply, so they are always
+		// restored as a whole.
+		if cfg.Mydumper.MaxRegionSize > 0 && dataFileSize > cfg.Mydumper.MaxRegionSize && IsSeekableSourceFile(dataFile) {
+			chunks, err := splitLargeFile(dataFile, dataFileSize, prevRowIDMax, cfg, ioWorkers)
+			if err != nil {
+				return nil, errors.Annotatef(err, "cannot split %s", dataFile)
+			}
+			for _, chunk := range chunks {
+				filesRegions = append(filesRegions, &TableRegion{
+					DB:    meta.DB,
+					Table: meta.Name,
+					File:  dataFile,
+					Chunk: chunk,
+				})
+				prevRowIDMax = chunk.RowIDMax
+				dataFileSizes = append(dataFileSizes, float64(chunk.EndOffset-chunk.Offset))
+			}
+			continue
+		}
 
 		divisor := int64(columns)
 		if strings.HasSuffix(strings.ToLower(dataFile), ".sql") {
@@ -168,9 +206,60 @@ func MakeTableRegions(
 			},
 		})
 		prevRowIDMax = rowIDMax
+		if dataFileSize == UnknownSourceFileSize {
+			// stdin is always a single region, its size does not matter.
+			dataFileSize = 0
+		}
 		dataFileSizes = append(dataFileSizes, float64(dataFileSize))
 	}
 
-	AllocateEngineIDs(filesRegions, dataFileSizes, float64(batchSize), batchImportRatio, float64(tableConcurrency))
+	AllocateEngineIDs(filesRegions, dataFileSizes, float64(cfg.Mydumper.BatchSize), cfg.Mydumper.BatchImportRatio, float64(cfg.App.TableConcurrency))
+	CapEngineIDs(filesRegions, cfg.Mydumper.MaxEngines)
 	return filesRegions, nil
 }
+
+// splitLargeFile scans through a data file and splits it into chunks of about
+// `max-region-size` bytes. The chunk boundaries are found by the parser, so a
+// row (including quoted fields containing line breaks) or an INSERT statement
+// is never split across two chunks.
+func splitLargeFile(
+	dataFile string,
+	dataFileSize int64,
+	prevRowIDMax int64,
+	cfg *config.Config,
+	ioWorkers *worker.Pool,
+) ([]Chunk, error) {
+	reader, err := OpenSourceFile(dataFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer reader.Close()
+
+	task := log.With(zap.String("path", dataFile)).Begin(zap.InfoLevel, "split large file")
+
+	var chunks []Chunk
+	blockBufSize := cfg.Mydumper.ReadBlockSize
+	if strings.HasSuffix(strings.ToLower(dataFile), ".csv") {
+		parser := NewCSVParser(&cfg.Mydumper.CSV, reader, blockBufSize, ioWorkers)
+		parser.SetPreserveBOM(cfg.Mydumper.PreserveBOM)
+		parser.SetPos(0, prevRowIDMax)
+		chunks, err = ReadChunks(parser, cfg.Mydumper.MaxRegionSize)
+	} else {
+		parser := NewChunkParser(cfg.TiDB.SQLMode, reader, blockBufSize, ioWorkers)
+		parser.SetPreserveBOM(cfg.Mydumper.PreserveBOM)
+		parser.SetPos(0, prevRowIDMax)
+		chunks, err = ReadStatementChunks(parser, cfg.Mydumper.MaxRegionSize)
+	}
+	task.End(zap.ErrorLevel, err, zap.Int("chunks", len(chunks)))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// the parser stops at the end of the last row. include the trailing
+	// content too so the chunks cover the whole file.
+	if len(chunks) == 0 {
+		chunks = append(chunks, Chunk{PrevRowIDMax: prevRowIDMax, RowIDMax: prevRowIDMax})
+	}
+	chunks[len(chunks)-1].EndOffset = dataFileSize
+	return chunks, nil
+}
@@ -14,13 +14,17 @@
 package mydump_test
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/tidb-lightning/lightning/config"
 	. "github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
 )
 
 var _ = Suite(&testMydumpRegionSuite{})
@@ -59,9 +63,10 @@ func (s *testMydumpRegionSuite) TestTableRegion(c *C) {
 	cfg := &config.Config{Mydumper: config.MydumperRuntime{SourceDir: "./examples"}}
 	loader, _ := NewMyDumpLoader(cfg)
 	dbMeta := loader.GetDatabases()[0]
+	ioWorkers := worker.NewPool(context.Background(), 5, "test")
 
 	for _, meta := range dbMeta.Tables {
-		regions, err := MakeTableRegions(meta, 1, 1, 0, 1)
+		regions, err := MakeTableRegions(meta, 1, cfg, ioWorkers)
 		c.Assert(err, IsNil)
 
 		table := meta.Name
@@ -192,4 +197,94 @@ func (s *testMydumpRegionSuite) TestAllocateEngineIDs(c *C) {
 		5: 100,
 		6: 100,
 	})
+
+	// Capping the number of engines merges the consecutive engines
+	CapEngineIDs(filesRegions, 10)
+	checkEngineSizes("max engines = 10", map[int32]int{
+		0: 100,
+		1: 100,
+		2: 100,
+		3: 100,
+		4: 100,
+		5: 100,
+		6: 100,
+	})
+	CapEngineIDs(filesRegions, 3)
+	checkEngineSizes("max engines = 3", map[int32]int{
+		0: 300,
+		1: 200,
+		2: 200,
+	})
+	CapEngineIDs(filesRegions, 1)
+	checkEngineSizes("max engines = 1", map[int32]int{
+		0: 700,
+	})
+}
+
+func (s *testMydumpRegionSuite) TestSplitLargeCSVFile(c *C) {
+	dir := c.MkDir()
+	content := "a,b\n" +
+		"1,\"aaaa\nbbbb\"\n" +
+		"2,\"cc\"\n" +
+		"3,\"dddddd\neeeeee\"\n" +
+		"4,\"f\"\n"
+	fileName := filepath.Join(dir, "db.t.csv")
+	err := ioutil.WriteFile(fileName, []byte(content), 0644)
+	c.Assert(err, IsNil)
+
+	cfg := config.NewConfig()
+	cfg.Mydumper.CSV.Header = true
+	cfg.Mydumper.CSV.BackslashEscape = false
+	// a naive split at offset 12 would cut the quoted field "aaaa\nbbbb".
+	cfg.Mydumper.MaxRegionSize = 12
+	meta := &MDTableMeta{DB: "db", Name: "t", DataFiles: []string{fileName}}
+
+	regions, err := MakeTableRegions(meta, 2, cfg, worker.NewPool(context.Background(), 1, "io"))
+	c.Assert(err, IsNil)
+
+	row2 := int64(strings.Index(content, "2,"))
+	row4 := int64(strings.Index(content, "4,"))
+	chunks := make([]Chunk, 0, len(regions))
+	for _, region := range regions {
+		chunks = append(chunks, region.Chunk)
+	}
+	c.Assert(chunks, DeepEquals, []Chunk{
+		{Offset: 0, EndOffset: row2, PrevRowIDMax: 0, RowIDMax: 1},
+		{Offset: row2, EndOffset: row4, PrevRowIDMax: 1, RowIDMax: 3},
+		{Offset: row4, EndOffset: int64(len(content)), PrevRowIDMax: 3, RowIDMax: 4},
+	})
+}
+
+func (s *testMydumpRegionSuite) TestSplitLargeSQLFile(c *C) {
+	dir := c.MkDir()
+	content := "INSERT INTO t VALUES\n" +
+		"(1,'a'),\n" +
+		"(2,'b;\nc');\n" +
+		"INSERT INTO t (a,b) VALUES\n" +
+		"(3,'d'),\n" +
+		"(4,'e');\n"
+	fileName := filepath.Join(dir, "db.t.sql")
+	err := ioutil.WriteFile(fileName, []byte(content), 0644)
+	c.Assert(err, IsNil)
+
+	cfg := config.NewConfig()
+	// the first statement can be split between its rows, but the second one
+	// has a different column list and must be kept whole.
+	cfg.Mydumper.MaxRegionSize = 10
+	meta := &MDTableMeta{DB: "db", Name: "t", DataFiles: []string{fileName}}
+
+	regions, err := MakeTableRegions(meta, 2, cfg, worker.NewPool(context.Background(), 1, "io"))
+	c.Assert(err, IsNil)
+
+	row2 := int64(strings.Index(content, "(2,"))
+	stmt2 := int64(strings.LastIndex(content, "INSERT"))
+	chunks := make([]Chunk, 0, len(regions))
+	for _, region := range regions {
+		chunks = append(chunks, region.Chunk)
+	}
+	c.Assert(chunks, DeepEquals, []Chunk{
+		{Offset: 0, EndOffset: row2 - 2, PrevRowIDMax: 0, RowIDMax: 1},
+		{Offset: row2 - 2, EndOffset: stmt2 - 2, PrevRowIDMax: 1, RowIDMax: 2},
+		{Offset: stmt2 - 2, EndOffset: int64(len(content)), PrevRowIDMax: 2, RowIDMax: 4},
+	})
 }
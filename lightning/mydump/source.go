@@ -0,0 +1,121 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+)
+
+// ReadSeekCloser is the interface of an opened source file.
+type ReadSeekCloser interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// budgetedFile releases its file descriptor to the budget when closed.
+type budgetedFile struct {
+	ReadSeekCloser
+	budget *common.FDBudget
+	once   sync.Once
+}
+
+func (f *budgetedFile) Close() error {
+	err := f.ReadSeekCloser.Close()
+	f.once.Do(f.budget.Release)
+	return err
+}
+
+// OpenSourceFile opens a data or schema file for reading. Besides a normal
+// file, the path may also refer to an entry inside a tar archive, a file
+// served over HTTP(S), or to the standard input.
+//
+// A file descriptor is acquired from the global budget before opening, which
+// blocks if too many files are already open. It is released when the returned
+// file is closed.
+func OpenSourceFile(path string) (ReadSeekCloser, error) {
+	return OpenSourceFileContext(context.Background(), path)
+}
+
+// OpenSourceFileContext is like OpenSourceFile, but stops waiting for a file
+// descriptor when the context is done. The context is also used when a tar
+// entry is reopened for seeking backwards.
+func OpenSourceFileContext(ctx context.Context, path string) (ReadSeekCloser, error) {
+	budget := common.GlobalFDBudget()
+	if archive, entry, ok := splitTarEntryPath(path); ok {
+		// the archive is reopened on seeking, which takes the descriptor from
+		// the budget every time.
+		return openTarEntry(ctx, budget, archive, entry)
+	}
+	if err := budget.Acquire(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var file ReadSeekCloser
+	var err error
+	if isStdinPath(path) {
+		file = openStdin()
+	} else if isHTTPPath(path) {
+		file, err = openHTTPFile(path)
+	} else {
+		file, err = os.Open(path)
+		err = errors.Trace(err)
+	}
+	if err != nil {
+		budget.Release()
+		return nil, err
+	}
+	return &budgetedFile{ReadSeekCloser: file, budget: budget}, nil
+}
+
+// SourceFileSize returns the size of a data or schema file. The size of stdin
+// is unknown and reported as UnknownSourceFileSize.
+func SourceFileSize(path string) (int64, error) {
+	if isStdinPath(path) {
+		return UnknownSourceFileSize, nil
+	}
+	if isHTTPPath(path) {
+		info, err := getHTTPSource().stat(path)
+		return info.size, errors.Trace(err)
+	}
+	if archive, entry, ok := splitTarEntryPath(path); ok {
+		return tarEntrySize(archive, entry)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return info.Size(), nil
+}
+
+// IsSeekableSourceFile returns whether seeking inside the source file is cheap.
+// Files which are not seekable should not be split into multiple chunks.
+func IsSeekableSourceFile(path string) bool {
+	if isStdinPath(path) {
+		return false
+	}
+	if isHTTPPath(path) {
+		// downloading the file again to skip to an offset is not cheap.
+		info, err := getHTTPSource().stat(path)
+		return err == nil && info.ranged
+	}
+	_, _, ok := splitTarEntryPath(path)
+	return !ok
+}
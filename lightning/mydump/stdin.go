@@ -0,0 +1,79 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+// The data piped through stdin is referred as "-/<db>.<table>.<type>", e.g.
+// "-/db.tbl.csv", so the parser can still be chosen by the extension.
+
+// UnknownSourceFileSize is reported as the size of stdin, so the whole stream
+// is restored as a single chunk until EOF. It must not be counted as the
+// actual size of the data, e.g. in the progress.
+const UnknownSourceFileSize = int64(^uint64(0) >> 1)
+
+func joinStdinPath(stdin *config.Stdin) string {
+	return config.StdinSourceDir + "/" + stdin.Schema + "." + stdin.Table + "." + stdin.Type
+}
+
+func isStdinPath(path string) bool {
+	return strings.HasPrefix(path, config.StdinSourceDir+"/")
+}
+
+// stdinReader reads the standard input. It can only be "seeked" forward by
+// discarding the content, since the consumed data are gone.
+type stdinReader struct {
+	reader io.Reader
+	pos    int64
+}
+
+func openStdin() *stdinReader {
+	return &stdinReader{reader: os.Stdin}
+}
+
+func (r *stdinReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *stdinReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	default:
+		return r.pos, errors.New("seeking from the end of stdin is not supported")
+	}
+	if offset < r.pos {
+		return r.pos, errors.Errorf("cannot rewind stdin from offset %d to %d", r.pos, offset)
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, offset-r.pos); err != nil && err != io.EOF {
+		return r.pos, errors.Trace(err)
+	}
+	return r.pos, nil
+}
+
+// Close does nothing, stdin is owned by the process.
+func (r *stdinReader) Close() error {
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"context"
+	"io"
+	"os"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	md "github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+	"github.com/pingcap/tidb/types"
+)
+
+var _ = Suite(&testMydumpStdinSuite{})
+
+type testMydumpStdinSuite struct {
+	origStdin *os.File
+}
+
+func (s *testMydumpStdinSuite) SetUpTest(c *C) {
+	s.origStdin = os.Stdin
+}
+
+func (s *testMydumpStdinSuite) TearDownTest(c *C) {
+	os.Stdin = s.origStdin
+}
+
+// pipeStdin replaces stdin by a pipe fed with the content.
+func pipeStdin(c *C, content string) {
+	r, w, err := os.Pipe()
+	c.Assert(err, IsNil)
+	go func() {
+		w.Write([]byte(content))
+		w.Close()
+	}()
+	os.Stdin = r
+}
+
+func stdinConfig() *config.Config {
+	cfg := config.NewConfig()
+	cfg.Mydumper.SourceDir = config.StdinSourceDir
+	cfg.Mydumper.NoSchema = true
+	cfg.Mydumper.Stdin = config.Stdin{Schema: "db", Table: "tbl", Type: config.StdinTypeCSV}
+	return cfg
+}
+
+func (s *testMydumpStdinSuite) TestLoadStdin(c *C) {
+	cfg := stdinConfig()
+	mdl, err := md.NewMyDumpLoader(cfg)
+	c.Assert(err, IsNil)
+
+	dbs := mdl.GetDatabases()
+	c.Assert(dbs, HasLen, 1)
+	c.Assert(dbs[0].Name, Equals, "db")
+	c.Assert(dbs[0].Tables, HasLen, 1)
+	table := dbs[0].Tables[0]
+	c.Assert(table.Name, Equals, "tbl")
+	c.Assert(table.DataFiles, DeepEquals, []string{"-/db.tbl.csv"})
+	c.Assert(md.IsSeekableSourceFile(table.DataFiles[0]), IsFalse)
+
+	// stdin is never split, and restored until EOF.
+	regions, err := md.MakeTableRegions(table, 2, cfg, nil)
+	c.Assert(err, IsNil)
+	c.Assert(regions, HasLen, 1)
+	c.Assert(regions[0].Chunk.Offset, Equals, int64(0))
+	c.Assert(regions[0].Chunk.EndOffset, Equals, md.UnknownSourceFileSize)
+	c.Assert(table.TotalSize, Equals, int64(0))
+}
+
+func (s *testMydumpStdinSuite) TestReadStdin(c *C) {
+	cfg := stdinConfig()
+	pipeStdin(c, "a,b\n1,2\n3,4")
+
+	reader, err := md.OpenSourceFile("-/db.tbl.csv")
+	c.Assert(err, IsNil)
+	defer reader.Close()
+
+	pos, err := reader.Seek(0, io.SeekStart)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(0))
+
+	parser := md.NewCSVParser(&cfg.Mydumper.CSV, reader, 3, worker.NewPool(context.Background(), 1, "io"))
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow().Row, DeepEquals, []types.Datum{types.NewStringDatum("1"), types.NewStringDatum("2")})
+	// the last row is not terminated by a newline but still complete.
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow().Row, DeepEquals, []types.Datum{types.NewStringDatum("3"), types.NewStringDatum("4")})
+	c.Assert(errors.Cause(parser.ReadRow()), Equals, io.EOF)
+
+	// consumed data cannot be read again.
+	_, err = reader.Seek(0, io.SeekStart)
+	c.Assert(err, ErrorMatches, "cannot rewind stdin from offset 11 to 0")
+}
+
+func (s *testMydumpStdinSuite) TestReadTruncatedStdin(c *C) {
+	cfg := stdinConfig()
+	pipeStdin(c, "1,2\n3,\"4")
+
+	reader, err := md.OpenSourceFile("-/db.tbl.csv")
+	c.Assert(err, IsNil)
+	defer reader.Close()
+
+	parser := md.NewCSVParser(&cfg.Mydumper.CSV, reader, 3, worker.NewPool(context.Background(), 1, "io"))
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.ReadRow(), ErrorMatches, "syntax error.*")
+}
@@ -0,0 +1,306 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+)
+
+// Entries inside a tar archive are referred as "<archive path>/<entry name>",
+// e.g. "/data/dump.tar.gz/db.tbl.sql".
+
+// IsTarArchive returns whether the path names a tar archive, which can be used
+// as the data source directly.
+func IsTarArchive(path string) bool {
+	if !hasTarSuffix(path) {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func hasTarSuffix(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, ".tar") ||
+		strings.HasSuffix(lower, ".tar.gz") ||
+		strings.HasSuffix(lower, ".tgz")
+}
+
+func isGzipArchive(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+func joinTarEntryPath(archive string, entry string) string {
+	return archive + "/" + entry
+}
+
+// tarArchiveCache caches whether the directories of the source files are tar
+// archives, since every source file path is split on every open and stat.
+var tarArchiveCache struct {
+	sync.Mutex
+	isArchive map[string]bool
+}
+
+func isCachedTarArchive(path string) bool {
+	tarArchiveCache.Lock()
+	defer tarArchiveCache.Unlock()
+	isArchive, ok := tarArchiveCache.isArchive[path]
+	if !ok {
+		if tarArchiveCache.isArchive == nil {
+			tarArchiveCache.isArchive = make(map[string]bool)
+		}
+		isArchive = IsTarArchive(path)
+		tarArchiveCache.isArchive[path] = isArchive
+	}
+	return isArchive
+}
+
+func splitTarEntryPath(path string) (archive string, entry string, ok bool) {
+	for i := strings.IndexByte(path, '/'); i >= 0; {
+		if prefix := path[:i]; hasTarSuffix(prefix) && isCachedTarArchive(prefix) {
+			return prefix, path[i+1:], true
+		}
+		next := strings.IndexByte(path[i+1:], '/')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return "", "", false
+}
+
+func normalizeTarEntryName(name string) string {
+	return strings.TrimPrefix(name, "./")
+}
+
+type tarEntryInfo struct {
+	name string
+	size int64
+}
+
+var tarIndexCache struct {
+	sync.Mutex
+	archives map[string]map[string]int64
+}
+
+// listTarEntries returns all regular files inside the archive, sorted by name.
+func listTarEntries(archive string) ([]tarEntryInfo, error) {
+	entries := make([]tarEntryInfo, 0, 16)
+	err := walkTar(archive, func(hdr *tar.Header, _ *tar.Reader) (bool, error) {
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+			entries = append(entries, tarEntryInfo{
+				name: normalizeTarEntryName(hdr.Name),
+				size: hdr.Size,
+			})
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	sizes := make(map[string]int64, len(entries))
+	for _, e := range entries {
+		sizes[e.name] = e.size
+	}
+	tarIndexCache.Lock()
+	if tarIndexCache.archives == nil {
+		tarIndexCache.archives = make(map[string]map[string]int64)
+	}
+	tarIndexCache.archives[archive] = sizes
+	tarIndexCache.Unlock()
+
+	return entries, nil
+}
+
+func tarEntrySize(archive string, entry string) (int64, error) {
+	tarIndexCache.Lock()
+	sizes, ok := tarIndexCache.archives[archive]
+	tarIndexCache.Unlock()
+	if !ok {
+		if _, err := listTarEntries(archive); err != nil {
+			return 0, errors.Trace(err)
+		}
+		tarIndexCache.Lock()
+		sizes = tarIndexCache.archives[archive]
+		tarIndexCache.Unlock()
+	}
+	size, ok := sizes[entry]
+	if !ok {
+		return 0, errors.Errorf("cannot find %s in %s", entry, archive)
+	}
+	return size, nil
+}
+
+// walkTar iterates the headers of the tar archive until `fn` returns true.
+func walkTar(archive string, fn func(*tar.Header, *tar.Reader) (bool, error)) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if isGzipArchive(archive) {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return errors.Annotatef(err, "cannot decompress %s", archive)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Annotatef(err, "cannot read %s", archive)
+		}
+		if stop, err := fn(hdr, tr); stop || err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
+
+// tarEntryReader reads an entry inside a tar archive. Since the archive can
+// only be read sequentially, seeking is implemented by re-reading from the
+// start of the entry and discarding the content before the target offset.
+//
+// The file descriptor of the archive is taken from the budget whenever the
+// archive is opened, and returned when it is closed.
+type tarEntryReader struct {
+	archive string
+	entry   string
+	ctx     context.Context
+	budget  *common.FDBudget
+
+	file   *os.File
+	gz     *gzip.Reader
+	reader io.Reader
+	pos    int64
+}
+
+func openTarEntry(ctx context.Context, budget *common.FDBudget, archive string, entry string) (*tarEntryReader, error) {
+	r := &tarEntryReader{archive: archive, entry: entry, ctx: ctx, budget: budget}
+	if err := r.reopen(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return r, nil
+}
+
+func (r *tarEntryReader) reopen() error {
+	r.close()
+
+	if err := r.budget.Acquire(r.ctx); err != nil {
+		return errors.Trace(err)
+	}
+	file, err := os.Open(r.archive)
+	if err != nil {
+		r.budget.Release()
+		return errors.Trace(err)
+	}
+	r.file = file
+
+	var reader io.Reader = file
+	if isGzipArchive(r.archive) {
+		r.gz, err = gzip.NewReader(file)
+		if err != nil {
+			r.close()
+			return errors.Annotatef(err, "cannot decompress %s", r.archive)
+		}
+		reader = r.gz
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			r.close()
+			return errors.Errorf("cannot find %s in %s", r.entry, r.archive)
+		}
+		if err != nil {
+			r.close()
+			return errors.Annotatef(err, "cannot read %s", r.archive)
+		}
+		if normalizeTarEntryName(hdr.Name) == r.entry {
+			r.reader = tr
+			r.pos = 0
+			return nil
+		}
+	}
+}
+
+func (r *tarEntryReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *tarEntryReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	default:
+		return r.pos, errors.New("seeking from the end of a tar entry is not supported")
+	}
+	if offset < 0 {
+		return r.pos, errors.New("negative seek offset")
+	}
+
+	if offset < r.pos {
+		if err := r.reopen(); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, offset-r.pos); err != nil && err != io.EOF {
+		return r.pos, errors.Trace(err)
+	}
+	return r.pos, nil
+}
+
+func (r *tarEntryReader) close() {
+	if r.gz != nil {
+		r.gz.Close()
+		r.gz = nil
+	}
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+		r.budget.Release()
+	}
+}
+
+func (r *tarEntryReader) Close() error {
+	r.close()
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	md "github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testMydumpTarSuite{})
+
+type testMydumpTarSuite struct{}
+
+func writeTarArchive(c *C, archive string, files map[string]string, order []string) {
+	f, err := os.Create(archive)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	var w io.Writer = f
+	if filepath.Ext(archive) == ".gz" {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	for _, name := range order {
+		content := files[name]
+		err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})
+		c.Assert(err, IsNil)
+		_, err = tw.Write([]byte(content))
+		c.Assert(err, IsNil)
+	}
+}
+
+var tarTestFiles = map[string]string{
+	"./dump/db-schema-create.sql": "CREATE DATABASE db;\n",
+	"./dump/db.t-schema.sql":      "CREATE TABLE t (a INT);\n",
+	"./dump/db.t.1.sql":           "INSERT INTO t VALUES (1),(2);\n",
+	"./dump/db.t.2.csv":           "a\n3\n4\n",
+}
+
+func (s *testMydumpTarSuite) testLoadTar(c *C, archive string) {
+	writeTarArchive(c, archive, tarTestFiles, []string{
+		"./dump/db.t.2.csv",
+		"./dump/db.t.1.sql",
+		"./dump/db.t-schema.sql",
+		"./dump/db-schema-create.sql",
+	})
+
+	cfg := &config.Config{Mydumper: config.MydumperRuntime{SourceDir: archive, CharacterSet: "auto"}}
+	mdl, err := md.NewMyDumpLoader(cfg)
+	c.Assert(err, IsNil)
+
+	dbs := mdl.GetDatabases()
+	c.Assert(dbs, HasLen, 1)
+	c.Assert(dbs[0].Name, Equals, "db")
+	c.Assert(dbs[0].Tables, HasLen, 1)
+	table := dbs[0].Tables[0]
+	c.Assert(table.Name, Equals, "t")
+	c.Assert(table.SchemaFile, Equals, archive+"/dump/db.t-schema.sql")
+	c.Assert(table.DataFiles, DeepEquals, []string{
+		archive + "/dump/db.t.1.sql",
+		archive + "/dump/db.t.2.csv",
+	})
+	c.Assert(table.TotalSize, Equals, int64(36))
+	c.Assert(table.GetSchema(), Equals, "CREATE TABLE t (a INT);")
+
+	size, err := md.SourceFileSize(table.DataFiles[1])
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(6))
+	c.Assert(md.IsSeekableSourceFile(table.DataFiles[1]), IsFalse)
+
+	// seeking re-reads the entry from the start.
+	r, err := md.OpenSourceFile(table.DataFiles[0])
+	c.Assert(err, IsNil)
+	defer r.Close()
+	pos, err := r.Seek(25, io.SeekStart)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(25))
+	content, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "(2);\n")
+	pos, err = r.Seek(7, io.SeekStart)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(7))
+	content, err = ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "INTO t VALUES (1),(2);\n")
+}
+
+func (s *testMydumpTarSuite) TestLoadTar(c *C) {
+	s.testLoadTar(c, filepath.Join(c.MkDir(), "dump.tar"))
+}
+
+func (s *testMydumpTarSuite) TestLoadTarGz(c *C) {
+	s.testLoadTar(c, filepath.Join(c.MkDir(), "dump.tar.gz"))
+}
+
+func (s *testMydumpTarSuite) TestMissingEntry(c *C) {
+	archive := filepath.Join(c.MkDir(), "dump.tar")
+	writeTarArchive(c, archive, tarTestFiles, []string{"./dump/db.t.1.sql"})
+
+	_, err := md.OpenSourceFile(archive + "/dump/db.t.2.csv")
+	c.Assert(err, ErrorMatches, "cannot find dump/db.t.2.csv in .*")
+	_, err = md.SourceFileSize(archive + "/dump/db.t.2.csv")
+	c.Assert(err, ErrorMatches, "cannot find dump/db.t.2.csv in .*")
+}
+
+func (s *testMydumpTarSuite) TestArchiveLookupCached(c *C) {
+	archive := filepath.Join(c.MkDir(), "dump.tar")
+	writeTarArchive(c, archive, tarTestFiles, []string{"./dump/db.t.1.sql"})
+	size, err := md.SourceFileSize(archive + "/dump/db.t.1.sql")
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(len(tarTestFiles["./dump/db.t.1.sql"])))
+
+	// the archive is not looked up on the file system again, so the entry is
+	// still resolved after the archive is gone.
+	c.Assert(os.Remove(archive), IsNil)
+	c.Assert(md.IsTarArchive(archive), IsFalse)
+	size, err = md.SourceFileSize(archive + "/dump/db.t.1.sql")
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(len(tarTestFiles["./dump/db.t.1.sql"])))
+}
+
+func (s *testMydumpTarSuite) TestReopenWithinBudget(c *C) {
+	archive := filepath.Join(c.MkDir(), "dump.tar")
+	writeTarArchive(c, archive, tarTestFiles, []string{"./dump/db.t.1.sql"})
+	budget := common.NewFDBudget(1)
+	common.SetFDBudget(budget)
+	defer common.SetFDBudget(nil)
+
+	r, err := md.OpenSourceFile(archive + "/dump/db.t.1.sql")
+	c.Assert(err, IsNil)
+	c.Assert(budget.InUse(), Equals, 1)
+
+	// seeking backwards reopens the archive with the descriptor released by
+	// the previous one.
+	_, err = r.Seek(25, io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = r.Seek(7, io.SeekStart)
+	c.Assert(err, IsNil)
+	c.Assert(budget.InUse(), Equals, 1)
+
+	// no other file can be opened until the entry is closed.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = md.OpenSourceFileContext(ctx, archive+"/dump/db.t.1.sql")
+	c.Assert(err, ErrorMatches, "context canceled")
+
+	c.Assert(r.Close(), IsNil)
+	c.Assert(budget.InUse(), Equals, 0)
+}
@@ -14,12 +14,14 @@
 package restore
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/coreos/go-semver/semver"
 	. "github.com/pingcap/check"
@@ -123,14 +125,12 @@ func (s *checkReqSuite) TestCheckPDVersion(c *C) {
 		err := json.NewEncoder(w).Encode(version)
 		c.Assert(err, IsNil)
 	}))
-	mockURL, err := url.Parse(mockServer.URL)
-	c.Assert(err, IsNil)
 	mockClient := mockServer.Client()
 
 	rc := &RestoreController{
 		cfg: &config.Config{
 			TiDB: config.DBStore{
-				PdAddr: mockURL.Host,
+				PdURL: mockServer.URL,
 			},
 		},
 	}
@@ -145,7 +145,12 @@ func (s *checkReqSuite) TestCheckPDVersion(c *C) {
 func (s *checkReqSuite) TestCheckTiKVVersion(c *C) {
 	var versions []string
 
-	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	var mockServer *httptest.Server
+	mockServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/pd/api/v1/members" {
+			fmt.Fprintf(w, `{"leader":{"client_urls":["%s"]}}`, mockServer.URL)
+			return
+		}
 		c.Assert(req.URL.Path, Equals, "/pd/api/v1/stores")
 		w.WriteHeader(http.StatusOK)
 
@@ -164,14 +169,12 @@ func (s *checkReqSuite) TestCheckTiKVVersion(c *C) {
 		})
 		c.Assert(err, IsNil)
 	}))
-	mockURL, err := url.Parse(mockServer.URL)
-	c.Assert(err, IsNil)
 	mockClient := mockServer.Client()
 
 	rc := &RestoreController{
 		cfg: &config.Config{
 			TiDB: config.DBStore{
-				PdAddr: mockURL.Host,
+				PdURL: mockServer.URL,
 			},
 		},
 	}
@@ -182,3 +185,52 @@ func (s *checkReqSuite) TestCheckTiKVVersion(c *C) {
 	versions = []string{"9999.0.0", "1.0.0"}
 	c.Assert(rc.checkTiKVVersion(mockClient), ErrorMatches, `TiKV \(at tikv1\.test:20160\) version too old.*`)
 }
+
+func (s *checkReqSuite) TestCheckClockSkew(c *C) {
+	var offset time.Duration
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Assert(req.URL.Path, Equals, "/pd/api/v1/version")
+		w.Header().Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockServer.Close()
+	mockClient := mockServer.Client()
+
+	rc := &RestoreController{
+		cfg: &config.Config{
+			App: config.Lightning{
+				MaxClockSkew: config.Duration{Duration: 10 * time.Second},
+				OnClockSkew:  config.ClockSkewError,
+			},
+			TiDB: config.DBStore{
+				PdURL: mockServer.URL,
+			},
+		},
+	}
+	ctx := context.Background()
+
+	offset = 0
+	c.Assert(rc.checkClockSkew(ctx, mockClient), IsNil)
+
+	offset = -time.Hour
+	c.Assert(rc.checkClockSkew(ctx, mockClient), ErrorMatches, "the local clock differs from PD by 59m5.*")
+
+	offset = time.Hour
+	c.Assert(rc.checkClockSkew(ctx, mockClient), ErrorMatches, "the local clock differs from PD by -(59m5|1h0m0).*")
+
+	rc.cfg.App.OnClockSkew = config.ClockSkewWarn
+	c.Assert(rc.checkClockSkew(ctx, mockClient), IsNil)
+
+	// failing to get PD's clock is only an error when the skew is.
+	downServer := httptest.NewServer(http.NotFoundHandler())
+	downServer.Close()
+	rc.cfg.TiDB.PdURL = downServer.URL
+	c.Assert(rc.checkClockSkew(ctx, mockClient), IsNil)
+	rc.cfg.App.OnClockSkew = config.ClockSkewError
+	c.Assert(rc.checkClockSkew(ctx, mockClient), NotNil)
+
+	rc.cfg.TiDB.PdURL = mockServer.URL
+	rc.cfg.App.MaxClockSkew.Duration = 0
+	c.Assert(rc.checkClockSkew(ctx, mockClient), IsNil)
+}
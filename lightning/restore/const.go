@@ -13,6 +13,12 @@
 
 package restore
 
+import "time"
+
 const (
 	defReadBlockSize int64 = 1024 * 128 // TODO ... config
+
+	// timeout of the requests reverting TiKV to normal mode when the task ends
+	// abnormally, excluding the configured delays (see revertModeTimeout).
+	revertModeBaseTimeout = 10 * time.Second
 )
@@ -0,0 +1,223 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// maxQueuedEvents is the number of events waiting to be sent, beyond which new
+// events are dropped instead of blocking the import.
+const maxQueuedEvents = 1024
+
+// tableEvent is a lifecycle event of a table, published to `events.sink`.
+type tableEvent struct {
+	Type  string    `json:"type"`
+	Table string    `json:"table"`
+	Time  time.Time `json:"time"`
+	// the error failing the table, for "failed" events.
+	Error string `json:"error,omitempty"`
+	// the position of the chunk written so far, for "chunk-progress" events.
+	EngineID *int32 `json:"engine_id,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+}
+
+// eventSink delivers the events to an external system.
+type eventSink interface {
+	send(ctx context.Context, ev *tableEvent) error
+}
+
+// httpEventSink POSTs every event as a JSON object to the endpoint.
+type httpEventSink struct {
+	client   *http.Client
+	endpoint string
+}
+
+func (s *httpEventSink) send(ctx context.Context, ev *tableEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("post %s http status code is not 2xx, status %s", s.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// eventEmitter publishes the events to the sink in the background. Failing to
+// send an event is only logged, and the events are dropped if the sink falls
+// behind, so the import is never blocked by the sink. A nil emitter publishes
+// nothing.
+type eventEmitter struct {
+	sink    eventSink
+	types   map[string]struct{}
+	timeout time.Duration
+
+	queue   chan *tableEvent
+	wg      sync.WaitGroup
+	dropped int64
+	// cancelled by close() when the queued events are not sent in time.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newEventSink creates the sink configured by `events`, or returns nil if
+// `events.sink` is empty. The scheme is validated by config.Adjust, only HTTP
+// sinks are supported.
+func newEventSink(cfg *config.Events) (eventSink, error) {
+	if len(cfg.Sink) == 0 {
+		return nil, nil
+	}
+	tlsConfig, err := common.ToTLSConfig(cfg.CAPath, cfg.CertPath, cfg.KeyPath)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid TLS config of the event sink")
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+		Timeout: cfg.Timeout.Duration,
+	}
+	return &httpEventSink{client: client, endpoint: cfg.Sink}, nil
+}
+
+// startEventEmitter starts publishing the events of the given types to the
+// sink, or returns nil if the sink is nil.
+func startEventEmitter(sink eventSink, types []string, timeout time.Duration) *eventEmitter {
+	if sink == nil {
+		return nil
+	}
+	e := &eventEmitter{
+		sink:    sink,
+		types:   make(map[string]struct{}, len(types)),
+		timeout: timeout,
+		queue:   make(chan *tableEvent, maxQueuedEvents),
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	for _, tp := range types {
+		e.types[tp] = struct{}{}
+	}
+	e.wg.Add(1)
+	go e.run()
+	return e
+}
+
+func (e *eventEmitter) run() {
+	defer e.wg.Done()
+	for ev := range e.queue {
+		if e.ctx.Err() != nil {
+			atomic.AddInt64(&e.dropped, 1)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(e.ctx, e.timeout)
+		err := e.sink.send(ctx, ev)
+		cancel()
+		if err != nil {
+			log.L().Warn("failed to send event",
+				zap.String("type", ev.Type),
+				zap.String("table", ev.Table),
+				log.ShortError(err),
+			)
+		}
+	}
+}
+
+func (e *eventEmitter) emit(ev *tableEvent) {
+	if e == nil {
+		return
+	}
+	if _, ok := e.types[ev.Type]; !ok {
+		return
+	}
+	ev.Time = time.Now()
+	select {
+	case e.queue <- ev:
+	default:
+		atomic.AddInt64(&e.dropped, 1)
+	}
+}
+
+func (e *eventEmitter) tableStarted(tableName string) {
+	e.emit(&tableEvent{Type: config.EventTableStarted, Table: tableName})
+}
+
+func (e *eventEmitter) tableEnded(tableName string, err error) {
+	if err != nil {
+		e.emit(&tableEvent{Type: config.EventTableFailed, Table: tableName, Error: err.Error()})
+	} else {
+		e.emit(&tableEvent{Type: config.EventTableCompleted, Table: tableName})
+	}
+}
+
+func (e *eventEmitter) chunkProgress(tableName string, engineID int32, path string, offset int64) {
+	e.emit(&tableEvent{
+		Type:     config.EventChunkProgress,
+		Table:    tableName,
+		EngineID: &engineID,
+		Path:     path,
+		Offset:   offset,
+	})
+}
+
+// close waits for the queued events to be sent, as long as `events.timeout`
+// in total. The events not sent by then are dropped.
+func (e *eventEmitter) close() {
+	if e == nil {
+		return
+	}
+	close(e.queue)
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(e.timeout):
+		e.cancel()
+		<-done
+	}
+	e.cancel()
+	if dropped := atomic.LoadInt64(&e.dropped); dropped > 0 {
+		log.L().Warn("events dropped since the sink is too slow", zap.Int64("count", dropped))
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+var _ = Suite(&eventsSuite{})
+
+type eventsSuite struct{}
+
+func (s *eventsSuite) TestHTTPEventSink(c *C) {
+	var mu sync.Mutex
+	var received []tableEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var ev tableEvent
+		if req.Method != http.MethodPost || json.NewDecoder(req.Body).Decode(&ev) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, ev)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	cfg := config.NewConfig()
+	cfg.Events.Sink = server.URL
+	cfg.Events.Types = []string{config.EventTableStarted, config.EventChunkProgress, config.EventTableFailed}
+	sink, err := newEventSink(&cfg.Events)
+	c.Assert(err, IsNil)
+	e := startEventEmitter(sink, cfg.Events.Types, cfg.Events.Timeout.Duration)
+	e.tableStarted("`db`.`t`")
+	e.chunkProgress("`db`.`t`", 0, "/data/db.t.csv", 123)
+	e.tableEnded("`db`.`t`", nil)
+	e.tableEnded("`db`.`u`", errors.New("some error"))
+	e.close()
+
+	c.Assert(received, HasLen, 3)
+	c.Assert(received[0].Type, Equals, config.EventTableStarted)
+	c.Assert(received[0].Table, Equals, "`db`.`t`")
+	c.Assert(received[0].Time.IsZero(), IsFalse)
+	c.Assert(received[1].Type, Equals, config.EventChunkProgress)
+	c.Assert(*received[1].EngineID, Equals, int32(0))
+	c.Assert(received[1].Path, Equals, "/data/db.t.csv")
+	c.Assert(received[1].Offset, Equals, int64(123))
+	// "completed" is not configured to be sent.
+	c.Assert(received[2].Type, Equals, config.EventTableFailed)
+	c.Assert(received[2].Table, Equals, "`db`.`u`")
+	c.Assert(received[2].Error, Equals, "some error")
+}
+
+func (s *eventsSuite) TestHTTPEventSinkTLS(c *C) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	cfg := config.NewConfig()
+	cfg.Events.Sink = server.URL
+	ev := &tableEvent{Type: config.EventTableStarted, Table: "`db`.`t`"}
+
+	// the server certificate is not trusted by the system CA.
+	sink, err := newEventSink(&cfg.Events)
+	c.Assert(err, IsNil)
+	c.Assert(sink.(*httpEventSink).client.Timeout, Equals, cfg.Events.Timeout.Duration)
+	c.Assert(sink.send(context.Background(), ev), ErrorMatches, ".*certificate.*")
+
+	caPath := filepath.Join(c.MkDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	c.Assert(ioutil.WriteFile(caPath, ca, 0644), IsNil)
+	cfg.Events.CAPath = caPath
+	sink, err = newEventSink(&cfg.Events)
+	c.Assert(err, IsNil)
+	c.Assert(sink.send(context.Background(), ev), IsNil)
+
+	cfg.Events.CAPath = filepath.Join(c.MkDir(), "missing.pem")
+	_, err = newEventSink(&cfg.Events)
+	c.Assert(err, ErrorMatches, "invalid TLS config of the event sink: cannot read the CA certificate.*")
+}
+
+type blockingEventSink struct {
+	unblock chan struct{}
+}
+
+func (s *blockingEventSink) send(ctx context.Context, ev *tableEvent) error {
+	select {
+	case <-s.unblock:
+		return errors.New("sink is down")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *eventsSuite) TestEventEmitterNeverBlocks(c *C) {
+	sink := &blockingEventSink{unblock: make(chan struct{})}
+	e := startEventEmitter(sink, []string{config.EventChunkProgress}, time.Minute)
+
+	// the emitter drops the events beyond the queue instead of waiting for
+	// the stuck sink.
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < maxQueuedEvents*2; i++ {
+			e.chunkProgress("`db`.`t`", 0, "/data/db.t.csv", int64(i))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		c.Fatal("emitting events is blocked by the sink")
+	}
+	c.Assert(e.dropped, Greater, int64(0))
+
+	close(sink.unblock)
+	e.close()
+
+	// closing drops the events not sent before the timeout, rather than
+	// waiting for each of them to time out.
+	sink = &blockingEventSink{unblock: make(chan struct{})}
+	e = startEventEmitter(sink, []string{config.EventChunkProgress}, 100*time.Millisecond)
+	for i := 0; i < maxQueuedEvents; i++ {
+		e.chunkProgress("`db`.`t`", 0, "/data/db.t.csv", int64(i))
+	}
+	start := time.Now()
+	e.close()
+	c.Assert(time.Since(start), Less, 5*time.Second)
+	c.Assert(e.dropped, Greater, int64(maxQueuedEvents/2))
+
+	// a nil emitter publishes nothing.
+	var nilEmitter *eventEmitter
+	nilEmitter.tableStarted("`db`.`t`")
+	nilEmitter.close()
+	noSink, err := newEventSink(&config.Events{})
+	c.Assert(err, IsNil)
+	c.Assert(noSink, IsNil)
+	c.Assert(startEventEmitter(noSink, nil, time.Minute), IsNil)
+}
@@ -14,27 +14,36 @@
 package restore
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/coreos/go-semver/semver"
+	gomysql "github.com/go-sql-driver/mysql"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
 	sstpb "github.com/pingcap/kvproto/pkg/import_sstpb"
 	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
 	tidbcfg "github.com/pingcap/tidb/config"
 	"github.com/pingcap/tidb/meta/autoid"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
 	"github.com/pingcap/tidb/table"
 	"github.com/pingcap/tidb/table/tables"
+	"github.com/pingcap/tidb/types"
 	"go.uber.org/zap"
 	"modernc.org/mathutil"
 
@@ -96,6 +105,9 @@ type errorSummaries struct {
 	sync.Mutex
 	logger  log.Logger
 	summary map[string]errorSummary
+
+	// tables imported without verifying the checksum.
+	checksumSkipped []string
 }
 
 // makeErrorSummaries returns an initialized errorSummaries instance
@@ -110,6 +122,10 @@ func (es *errorSummaries) emitLog() {
 	es.Lock()
 	defer es.Unlock()
 
+	if skippedCount := len(es.checksumSkipped); skippedCount > 0 {
+		es.logger.Warn("tables imported with checksum skipped", zap.Int("count", skippedCount), zap.Strings("tables", es.checksumSkipped))
+	}
+
 	if errorCount := len(es.summary); errorCount > 0 {
 		logger := es.logger
 		logger.Error("tables failed to be imported", zap.Int("count", errorCount))
@@ -129,6 +145,25 @@ func (es *errorSummaries) record(tableName string, err error, status CheckpointS
 	es.summary[tableName] = errorSummary{status: status, err: err}
 }
 
+// clear forgets the errors of a table which eventually succeeded on retry.
+func (es *errorSummaries) clear(tableName string) {
+	es.Lock()
+	defer es.Unlock()
+	delete(es.summary, tableName)
+}
+
+func (es *errorSummaries) recordChecksumSkipped(tableName string) {
+	es.Lock()
+	defer es.Unlock()
+	// a table may skip the checksum again when retried.
+	for _, name := range es.checksumSkipped {
+		if name == tableName {
+			return
+		}
+	}
+	es.checksumSkipped = append(es.checksumSkipped, tableName)
+}
+
 type RestoreController struct {
 	cfg             *config.Config
 	dbMetas         []*mydump.MDDatabaseMeta
@@ -137,20 +172,45 @@ type RestoreController struct {
 	indexWorkers    *worker.Pool
 	regionWorkers   *worker.Pool
 	ioWorkers       *worker.Pool
+	checksumWorkers *worker.Pool
+	checksumStores  *storeLimiter
 	pauser          *common.Pauser
+	// pauses the delivery while some TiKV stores are running out of disk.
+	diskPauser      *common.Pauser
 	backend         kv.Backend
+	backends        map[string]kv.Backend // all backends in use, including the global `backend`
 	tidbMgr         *TiDBManager
 	postProcessLock sync.Mutex // a simple way to ensure post-processing is not concurrent without using complicated goroutines
 	alterTableLock  sync.Mutex
 	compactState    int32
 
+	// whether TiKV may have been switched to import mode and not yet back.
+	importModeSwitched int32
+
+	// number of tables waiting for, running and finished the checksum.
+	checksumQueued   int32
+	checksumRunning  int32
+	checksumFinished int32
+
 	errorSummaries errorSummaries
+	summary        *importSummary
+
+	// tables which failed but were skipped due to
+	// `lightning.continue-on-table-failure`.
+	failedTablesLock sync.Mutex
+	failedTables     []string
+	// schemaErrors are the errors creating the tables tolerated by
+	// `lightning.max-schema-errors`. These tables are not imported.
+	schemaErrors map[string]error
 
 	checkpointsDB CheckpointsDB
 	saveCpCh      chan saveCp
 	checkpointsWg sync.WaitGroup
 
 	closedEngineLimit *worker.Pool
+	writeLimiter      *common.RateLimiter
+	watchdog          *stallWatchdog
+	events            *eventEmitter
 }
 
 func NewRestoreController(ctx context.Context, dbMetas []*mydump.MDDatabaseMeta, cfg *config.Config) (*RestoreController, error) {
@@ -168,40 +228,95 @@ func NewRestoreControllerWithPauser(ctx context.Context, dbMetas []*mydump.MDDat
 		return nil, errors.Trace(err)
 	}
 
-	var backend kv.Backend
-	switch cfg.TikvImporter.Backend {
-	case config.BackendImporter:
-		var err error
-		backend, err = kv.NewImporter(ctx, cfg.TikvImporter.Addr, cfg.TiDB.PdAddr)
+	eventSink, err := newEventSink(&cfg.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	backends := make(map[string]kv.Backend)
+	for _, name := range []string{config.BackendImporter, config.BackendTiDB} {
+		if !cfg.UsesBackend(name) {
+			continue
+		}
+		backend, err := newBackend(ctx, name, cfg, tidbMgr)
 		if err != nil {
+			for _, be := range backends {
+				be.Close()
+			}
 			return nil, err
 		}
-	case config.BackendTiDB:
-		backend = kv.NewTiDBBackend(tidbMgr.db, cfg.TikvImporter.OnDuplicate)
-	default:
+		backends[name] = backend
+	}
+	backend, ok := backends[cfg.TikvImporter.Backend]
+	if !ok {
 		return nil, errors.New("unknown backend: " + cfg.TikvImporter.Backend)
 	}
 
 	rc := &RestoreController{
-		cfg:           cfg,
-		dbMetas:       dbMetas,
-		tableWorkers:  worker.NewPool(ctx, cfg.App.TableConcurrency, "table"),
-		indexWorkers:  worker.NewPool(ctx, cfg.App.IndexConcurrency, "index"),
-		regionWorkers: worker.NewPool(ctx, cfg.App.RegionConcurrency, "region"),
-		ioWorkers:     worker.NewPool(ctx, cfg.App.IOConcurrency, "io"),
-		pauser:        pauser,
-		backend:       backend,
-		tidbMgr:       tidbMgr,
+		cfg:             cfg,
+		dbMetas:         dbMetas,
+		tableWorkers:    worker.NewPool(ctx, cfg.App.TableConcurrency, "table"),
+		indexWorkers:    worker.NewPool(ctx, cfg.App.IndexConcurrency, "index"),
+		regionWorkers:   worker.NewPool(ctx, cfg.App.RegionConcurrency, "region"),
+		ioWorkers:       worker.NewPool(ctx, cfg.App.IOConcurrency, "io"),
+		checksumWorkers: worker.NewPool(ctx, cfg.PostRestore.ChecksumConcurrency, "checksum"),
+		checksumStores:  newStoreLimiter(cfg.PostRestore.ChecksumStoreConcurrency),
+		pauser:          pauser,
+		diskPauser:      common.NewPauser(),
+		backend:         backend,
+		backends:        backends,
+		tidbMgr:         tidbMgr,
 
 		errorSummaries:    makeErrorSummaries(log.L()),
+		summary:           newImportSummary(dbMetas),
 		checkpointsDB:     cpdb,
 		saveCpCh:          make(chan saveCp),
 		closedEngineLimit: worker.NewPool(ctx, cfg.App.TableConcurrency*2, "closed-engine"),
+		writeLimiter:      common.NewRateLimiter(cfg.TikvImporter.MaxWriteSpeed),
+		watchdog:          newStallWatchdog(),
+		events:            startEventEmitter(eventSink, cfg.Events.Types, cfg.Events.Timeout.Duration),
 	}
 
 	return rc, nil
 }
 
+func newBackend(ctx context.Context, name string, cfg *config.Config, tidbMgr *TiDBManager) (kv.Backend, error) {
+	switch name {
+	case config.BackendImporter:
+		return kv.NewImporter(ctx, cfg.TikvImporter.Addr, cfg.TiDB.PdAddr, cfg.TikvImporter.UploadChunkSize, kv.GRPCDialOptions(cfg.GRPC)...)
+	case config.BackendTiDB:
+		return kv.NewTiDBBackend(tidbMgr.db, cfg.TikvImporter.OnDuplicate, int(cfg.TikvImporter.TxnSize)), nil
+	default:
+		return kv.Backend{}, errors.New("unknown backend: " + name)
+	}
+}
+
+// backendNamed returns the backend of the given name, or the global backend if
+// the name is empty. Tables overriding `table-config.backend` may be imported
+// by a backend other than the global one.
+func (rc *RestoreController) backendNamed(name string) kv.Backend {
+	if backend, ok := rc.backends[name]; ok {
+		return backend
+	}
+	return rc.backend
+}
+
+// backendStore describes the server a step delivering to the backend of the
+// given name waits on, so that the stall watchdog can tell which one is stuck.
+func (rc *RestoreController) backendStore(name string) string {
+	if name == "" {
+		name = rc.cfg.TikvImporter.Backend
+	}
+	if name == config.BackendImporter {
+		return "tikv-importer " + rc.cfg.TikvImporter.Addr
+	}
+	return rc.tidbStore()
+}
+
+func (rc *RestoreController) tidbStore() string {
+	return "tidb " + net.JoinHostPort(rc.cfg.TiDB.Host, strconv.Itoa(rc.cfg.TiDB.Port))
+}
+
 func OpenCheckpointsDB(ctx context.Context, cfg *config.Config) (CheckpointsDB, error) {
 	if !cfg.Checkpoint.Enable {
 		return NewNullCheckpointsDB(), nil
@@ -213,7 +328,7 @@ func OpenCheckpointsDB(ctx context.Context, cfg *config.Config) (CheckpointsDB,
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
-		cpdb, err := NewMySQLCheckpointsDB(ctx, db, cfg.Checkpoint.Schema, cfg.TaskID)
+		cpdb, err := NewMySQLCheckpointsDB(ctx, db, cfg.Checkpoint.Schema, cfg.Checkpoint.Namespace, cfg.TaskID)
 		if err != nil {
 			db.Close()
 			return nil, errors.Trace(err)
@@ -234,8 +349,11 @@ func (rc *RestoreController) Wait() {
 }
 
 func (rc *RestoreController) Close() {
-	rc.backend.Close()
+	for _, backend := range rc.backends {
+		backend.Close()
+	}
 	rc.tidbMgr.Close()
+	rc.events.close()
 }
 
 func (rc *RestoreController) Run(ctx context.Context) error {
@@ -249,6 +367,7 @@ func (rc *RestoreController) Run(ctx context.Context) error {
 	}
 
 	task := log.L().Begin(zap.InfoLevel, "the whole procedure")
+	defer rc.revertTiKVModeOnPanic()
 
 	var err error
 outside:
@@ -271,10 +390,24 @@ outside:
 
 	task.End(zap.ErrorLevel, err)
 	rc.errorSummaries.emitLog()
+	rc.writeSummary(err)
+	rc.revertTiKVModeOnExit("task ended early")
 
 	return errors.Trace(err)
 }
 
+// writeSummary writes the summary report of the task if configured. Failing to
+// write the report does not fail the task.
+func (rc *RestoreController) writeSummary(taskErr error) {
+	path := rc.cfg.App.SummaryFile
+	if len(path) == 0 {
+		return
+	}
+	if err := rc.summary.report(taskErr).writeFile(path, rc.cfg.App.SummaryFormat); err != nil {
+		log.L().Warn("failed to write the summary report", zap.String("path", path), log.ShortError(err))
+	}
+}
+
 func (rc *RestoreController) restoreSchema(ctx context.Context) error {
 	tidbMgr, err := NewTiDBManager(rc.cfg.TiDB)
 	if err != nil {
@@ -282,6 +415,88 @@ func (rc *RestoreController) restoreSchema(ctx context.Context) error {
 	}
 	defer tidbMgr.Close()
 
+	if err := rc.checkDatabasesExist(ctx, tidbMgr); err != nil {
+		return errors.Trace(err)
+	}
+	if err := rc.initSchemas(ctx, tidbMgr); err != nil {
+		return err
+	}
+
+	// Tables which have been altered since the checkpoints were created are
+	// dropped, so we need to restore their schema and checkpoints again.
+	reimport, err := rc.checkSchemaChanges(ctx, tidbMgr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if reimport {
+		if err := rc.initSchemas(ctx, tidbMgr); err != nil {
+			return err
+		}
+	}
+	// Likewise for tables partially imported by another backend.
+	reimport, err = rc.checkBackendChanges(ctx, tidbMgr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if reimport {
+		if err := rc.initSchemas(ctx, tidbMgr); err != nil {
+			return err
+		}
+	}
+	if err := rc.checkColumnProjections(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := rc.checkDataFilePartitions(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := rc.checkInsertTriggers(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := rc.checkTablesEmpty(ctx, tidbMgr); err != nil {
+		return errors.Trace(err)
+	}
+	web.BroadcastReady(true)
+
+	go rc.listenCheckpointUpdates()
+
+	// Estimate the number of chunks for progress reporting
+	rc.estimateChunkCountIntoMetrics()
+	return nil
+}
+
+// checkDatabasesExist stops the import with all the missing target databases
+// listed, rather than failing table by table, if the data source creates no
+// databases, i.e. with `mydumper.no-schema`. Creating the databases would not
+// help, since the tables are not created either.
+func (rc *RestoreController) checkDatabasesExist(ctx context.Context, tidbMgr *TiDBManager) error {
+	if !rc.cfg.Mydumper.NoSchema || len(rc.dbMetas) == 0 {
+		return nil
+	}
+
+	exists, err := tidbMgr.listDatabases(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var missing []string
+	for _, dbMeta := range rc.dbMetas {
+		if !exists(dbMeta.Name) {
+			var builder strings.Builder
+			common.WriteMySQLIdentifier(&builder, dbMeta.Name)
+			missing = append(missing, builder.String())
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	log.L().Error("target databases do not exist", zap.Strings("databases", missing))
+	return errors.Errorf("target databases %s do not exist; with `mydumper.no-schema` the databases "+
+		"and tables must be created before importing", strings.Join(missing, ", "))
+}
+
+// initSchemas creates the tables if they do not exist yet, loads their schema
+// into `rc.dbInfos` and initializes their checkpoints.
+func (rc *RestoreController) initSchemas(ctx context.Context, tidbMgr *TiDBManager) error {
 	if !rc.cfg.Mydumper.NoSchema {
 		tidbMgr.db.ExecContext(ctx, "SET SQL_MODE = ?", rc.cfg.TiDB.StrSQLMode)
 
@@ -292,13 +507,22 @@ func (rc *RestoreController) restoreSchema(ctx context.Context) error {
 			for _, tblMeta := range dbMeta.Tables {
 				tablesSchema[tblMeta.Name] = tblMeta.GetSchema()
 			}
-			err = tidbMgr.InitSchema(ctx, dbMeta.Name, tablesSchema)
+			// databases without "{db}-schema-create.sql" are only accepted by
+			// the loader with `tidb.auto-create-database`.
+			var dbCharset, dbCollation string
+			if len(dbMeta.SchemaFile) == 0 {
+				dbCharset, dbCollation = rc.cfg.TiDB.DatabaseCharset, rc.cfg.TiDB.DatabaseCollation
+			}
+			err := tidbMgr.InitSchema(ctx, dbMeta.Name, dbCharset, dbCollation, tablesSchema, func(table string, err error) error {
+				return rc.tolerateSchemaError(common.UniqueTable(dbMeta.Name, table), err)
+			})
 
 			task.End(zap.ErrorLevel, err)
 			if err != nil {
 				return errors.Annotatef(err, "restore table schema %s failed", dbMeta.Name)
 			}
 		}
+		rc.skipSchemaErrorTables()
 	}
 	dbInfos, err := tidbMgr.LoadSchemaInfo(ctx, rc.dbMetas)
 	if err != nil {
@@ -307,18 +531,412 @@ func (rc *RestoreController) restoreSchema(ctx context.Context) error {
 	rc.dbInfos = dbInfos
 
 	// Load new checkpoints
-	err = rc.checkpointsDB.Initialize(ctx, dbInfos)
+	return errors.Trace(rc.checkpointsDB.Initialize(ctx, dbInfos))
+}
+
+// tolerateSchemaError records the error creating the table, or returns it if
+// there are already `lightning.max-schema-errors` such errors. Only the errors
+// of the schema itself are tolerated, InitSchema already stops on the retryable
+// errors of TiDB. The cancellation is returned too, since it fails the other
+// tables as well.
+func (rc *RestoreController) tolerateSchemaError(tableName string, err error) error {
+	if common.IsContextCanceledError(err) || errors.Cause(err) == context.DeadlineExceeded {
+		return err
+	}
+	if _, ok := rc.schemaErrors[tableName]; !ok && len(rc.schemaErrors) >= rc.cfg.App.MaxSchemaErrors {
+		return err
+	}
+	log.L().Warn("failed to create table, the table will not be imported",
+		zap.String("table", tableName), log.ShortError(err))
+	if rc.schemaErrors == nil {
+		rc.schemaErrors = make(map[string]error)
+	}
+	rc.schemaErrors[tableName] = err
+	return nil
+}
+
+// skipSchemaErrorTables removes the tables failed to be created from the
+// tables to import, and marks them as skipped in the summary.
+func (rc *RestoreController) skipSchemaErrorTables() {
+	if len(rc.schemaErrors) == 0 {
+		return
+	}
+	dbMetas := make([]*mydump.MDDatabaseMeta, 0, len(rc.dbMetas))
+	for _, dbMeta := range rc.dbMetas {
+		tables := make([]*mydump.MDTableMeta, 0, len(dbMeta.Tables))
+		for _, tableMeta := range dbMeta.Tables {
+			tableName := common.UniqueTable(tableMeta.DB, tableMeta.Name)
+			if err, ok := rc.schemaErrors[tableName]; ok {
+				rc.summary.skipTable(tableName, err)
+				continue
+			}
+			tables = append(tables, tableMeta)
+		}
+		copied := *dbMeta
+		copied.Tables = tables
+		dbMetas = append(dbMetas, &copied)
+	}
+	rc.dbMetas = dbMetas
+
+	tableNames := make([]string, 0, len(rc.schemaErrors))
+	for tableName := range rc.schemaErrors {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	log.L().Warn("some tables failed to be created and are skipped", zap.Strings("tables", tableNames))
+}
+
+// checkColumnProjections verifies the `table-config.columns` of every table
+// before importing anything.
+func (rc *RestoreController) checkColumnProjections() error {
+	for _, dbMeta := range rc.dbMetas {
+		dbInfo := rc.dbInfos[dbMeta.Name]
+		for _, tableMeta := range dbMeta.Tables {
+			tableInfo := dbInfo.Tables[tableMeta.Name]
+			projection := rc.cfg.ColumnProjection(tableMeta.DB, tableMeta.Name)
+			if err := checkColumnProjection(tableInfo.Core, projection); err != nil {
+				return errors.Annotatef(err, "invalid `table-config.columns` of table %s", common.UniqueTable(dbInfo.Name, tableInfo.Name))
+			}
+		}
+	}
+	return nil
+}
+
+// checkDataFilePartitions verifies the partitions of the data files dumped per
+// partition exist in their tables before importing anything.
+func (rc *RestoreController) checkDataFilePartitions() error {
+	for _, dbMeta := range rc.dbMetas {
+		dbInfo := rc.dbInfos[dbMeta.Name]
+		for _, tableMeta := range dbMeta.Tables {
+			tableInfo := dbInfo.Tables[tableMeta.Name]
+			for path, partition := range tableMeta.DataFilePartitions {
+				if _, err := findFilePartition(tableInfo.Core, path, partition); err != nil {
+					return errors.Annotatef(err, "table %s", common.UniqueTable(dbInfo.Name, tableInfo.Name))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkInsertTriggers warns about, or rejects, the tables having BEFORE INSERT
+// triggers in the dump. The import bypasses the triggers, so the values they
+// would compute are missing from the imported rows.
+func (rc *RestoreController) checkInsertTriggers() error {
+	for _, dbMeta := range rc.dbMetas {
+		for _, tableMeta := range dbMeta.Tables {
+			tableName := common.UniqueTable(tableMeta.DB, tableMeta.Name)
+			triggers, err := tableMeta.GetBeforeInsertTriggers()
+			if err != nil {
+				return errors.Annotatef(err, "failed to read the triggers of table %s", tableName)
+			}
+			if len(triggers) == 0 {
+				continue
+			}
+			if rc.cfg.Mydumper.OnInsertTrigger == config.InsertTriggerError {
+				return errors.Errorf("table %s has BEFORE INSERT triggers (%s) which will not run during the import, "+
+					"set `mydumper.on-insert-trigger` to \"warn\" to import it anyway", tableName, strings.Join(triggers, ", "))
+			}
+			log.L().Warn("table has BEFORE INSERT triggers which will not run during the import, the values they compute will be missing",
+				zap.String("table", tableName), zap.Strings("triggers", triggers))
+		}
+	}
+	return nil
+}
+
+// checkTablesEmpty refuses to import into target tables already containing
+// rows, unless `tikv-importer.incremental-import` is set. The importer backend
+// writes the KV pairs directly, so the imported rows may silently collide with
+// the existing ones. Tables imported by the TiDB backend are not checked, since
+// the conflicts are resolved by `tikv-importer.on-duplicate`. Tables already
+// started in a previous run are not checked either, nor are any tables with
+// `tikv-importer.kv-kind = "index"`, whose rows are expected to be written by
+// the preceding "data" phase.
+func (rc *RestoreController) checkTablesEmpty(ctx context.Context, tidbMgr *TiDBManager) error {
+	if rc.cfg.TikvImporter.IncrementalImport || rc.cfg.TikvImporter.KVKind == config.KVKindIndex {
+		return nil
+	}
+
+	var nonEmptyTables []string
+	for _, dbMeta := range rc.dbMetas {
+		for _, tableMeta := range dbMeta.Tables {
+			if rc.cfg.TableBackend(tableMeta.DB, tableMeta.Name) != config.BackendImporter {
+				continue
+			}
+			tableName := common.UniqueTable(tableMeta.DB, tableMeta.Name)
+			cp, err := rc.checkpointsDB.Get(ctx, tableName)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if cp.Status > CheckpointStatusLoaded || len(cp.Engines) > 0 {
+				continue
+			}
+
+			var hasRows bool
+			err = common.SQLWithRetry{DB: tidbMgr.db, Logger: log.With(zap.String("table", tableName))}.
+				QueryRow(ctx, "check table empty", "SELECT EXISTS (SELECT 1 FROM "+tableName+" LIMIT 1)", &hasRows)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if hasRows {
+				nonEmptyTables = append(nonEmptyTables, tableName)
+			}
+		}
+	}
+
+	if len(nonEmptyTables) > 0 {
+		log.L().Error("target tables are not empty", zap.Strings("tables", nonEmptyTables))
+		return errors.Errorf("target tables %s are not empty, importing into them may create duplicated rows; "+
+			"set `tikv-importer.incremental-import` to true to import anyway", strings.Join(nonEmptyTables, ", "))
+	}
+	return nil
+}
+
+// findFilePartition returns the ID of the partition which the data file is
+// dumped from.
+func findFilePartition(tbl *model.TableInfo, path string, partition string) (int64, error) {
+	if tbl.GetPartitionInfo() == nil {
+		return 0, errors.Errorf("data file %s is of partition %s, but the table is not partitioned", path, partition)
+	}
+	pid, err := tables.FindPartitionByName(tbl, partition)
 	if err != nil {
-		return errors.Trace(err)
+		return 0, errors.Errorf("data file %s is of partition %s, which does not exist in the table", path, partition)
 	}
+	return pid, nil
+}
 
-	go rc.listenCheckpointUpdates()
+// checkColumnProjection verifies the projection only lists columns of the
+// table, and leaves out no column which must be given a value, i.e. a NOT NULL
+// column without a default value, which is neither auto-incremented nor
+// generated.
+func checkColumnProjection(tbl *model.TableInfo, projection []string) error {
+	if len(projection) == 0 {
+		return nil
+	}
+	projected := make(map[string]struct{}, len(projection))
+	for _, column := range projection {
+		if model.FindColumnInfo(tbl.Columns, column) == nil {
+			return errors.Errorf("unknown column %s", column)
+		}
+		projected[column] = struct{}{}
+	}
+	for _, colInfo := range tbl.Columns {
+		if _, ok := projected[colInfo.Name.L]; ok {
+			continue
+		}
+		if mysql.HasNotNullFlag(colInfo.Flag) &&
+			colInfo.GetDefaultValue() == nil &&
+			!mysql.HasAutoIncrementFlag(colInfo.Flag) &&
+			!colInfo.IsGenerated() {
+			return errors.Errorf("column %s is NOT NULL without a default value and must be imported", colInfo.Name.O)
+		}
+	}
+	return nil
+}
 
-	// Estimate the number of chunks for progress reporting
-	rc.estimateChunkCountIntoMetrics()
+// checkSchemaChanges compares the schema of every table against the hash
+// recorded in its checkpoint, so that a table altered while the import is
+// paused won't be resumed with the wrong encoding. Depending on
+// `checkpoint.on-schema-change`, an altered table either fails the import, or
+// is dropped together with its checkpoint and engines to be imported again
+// from scratch. Returns true if any table is dropped.
+func (rc *RestoreController) checkSchemaChanges(ctx context.Context, tidbMgr *TiDBManager) (bool, error) {
+	var alteredTables []string
+	reimport := false
+
+	for _, dbMeta := range rc.dbMetas {
+		dbInfo, ok := rc.dbInfos[dbMeta.Name]
+		if !ok {
+			continue
+		}
+		for _, tableMeta := range dbMeta.Tables {
+			tableInfo, ok := dbInfo.Tables[tableMeta.Name]
+			if !ok {
+				continue
+			}
+			tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
+			cp, err := rc.checkpointsDB.Get(ctx, tableName)
+			if err != nil {
+				return false, errors.Trace(err)
+			}
+			if cp.Status <= CheckpointStatusMaxInvalid || isUnknownSchemaHash(cp.Hash) ||
+				bytes.Equal(cp.Hash, TableSchemaHash(tableInfo.Core)) {
+				continue
+			}
+
+			logger := log.With(zap.String("table", tableName))
+			switch {
+			case cp.Status == CheckpointStatusLoaded:
+				// nothing has been imported yet, simply start over with the new schema.
+				logger.Info("table schema changed before the import started, resetting checkpoint")
+			case rc.cfg.Checkpoint.OnSchemaChange == config.SchemaChangeReimport && !rc.cfg.Mydumper.NoSchema:
+				logger.Warn("table schema changed since the checkpoint was created, dropping the table to import it again")
+				if err := tidbMgr.DropTable(ctx, tableName); err != nil {
+					return false, errors.Trace(err)
+				}
+				if err := rc.cleanupEngines(ctx, tableMeta, tableName, cp); err != nil {
+					return false, errors.Trace(err)
+				}
+			default:
+				alteredTables = append(alteredTables, tableName)
+				continue
+			}
+
+			if err := rc.checkpointsDB.RemoveCheckpoint(ctx, tableName); err != nil {
+				return false, errors.Trace(err)
+			}
+			reimport = true
+		}
+	}
+
+	if len(alteredTables) != 0 {
+		return false, errors.Errorf(
+			"the schema of the tables %s has been changed since the checkpoints were created, "+
+				"resuming them would produce wrongly encoded data; set `checkpoint.on-schema-change` to \"%s\" "+
+				"to drop and import these tables again",
+			strings.Join(alteredTables, ", "), config.SchemaChangeReimport,
+		)
+	}
+	return reimport, nil
+}
+
+// checkBackendChanges compares the backend of every table against the backends
+// recorded in its engine checkpoints. The data written by one backend cannot
+// be continued by another, e.g. the engines in tikv-importer are never imported
+// by the "tidb" backend. A table which has not written anything yet simply
+// has its checkpoint reset, and a table whose engines are all imported is
+// resumed as usual. Other tables, depending on `checkpoint.on-backend-change`,
+// either fail the import, or are dropped together with their checkpoints and
+// engines to be imported again from scratch. Returns true if any checkpoint is
+// removed.
+func (rc *RestoreController) checkBackendChanges(ctx context.Context, tidbMgr *TiDBManager) (bool, error) {
+	var changedTables []string
+	reimport := false
+
+	for _, dbMeta := range rc.dbMetas {
+		dbInfo, ok := rc.dbInfos[dbMeta.Name]
+		if !ok {
+			continue
+		}
+		for _, tableMeta := range dbMeta.Tables {
+			tableInfo, ok := dbInfo.Tables[tableMeta.Name]
+			if !ok {
+				continue
+			}
+			tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
+			cp, err := rc.checkpointsDB.Get(ctx, tableName)
+			if err != nil {
+				return false, errors.Trace(err)
+			}
+			backend := rc.cfg.TableBackend(tableMeta.DB, tableMeta.Name)
+			previous := previousBackend(cp, backend)
+			if cp.Status <= CheckpointStatusMaxInvalid || cp.Status >= CheckpointStatusIndexImported || len(previous) == 0 {
+				continue
+			}
+
+			logger := log.With(zap.String("table", tableName), zap.String("previous", previous), zap.String("backend", backend))
+			switch {
+			case !hasWrittenData(cp):
+				logger.Info("table is resumed with another backend before writing anything, resetting checkpoint")
+			case rc.cfg.Checkpoint.OnBackendChange == config.BackendChangeReimport && !rc.cfg.Mydumper.NoSchema:
+				logger.Warn("table is partially imported by another backend, dropping the table to import it again")
+				if err := tidbMgr.DropTable(ctx, tableName); err != nil {
+					return false, errors.Trace(err)
+				}
+			default:
+				changedTables = append(changedTables, fmt.Sprintf("%s (%s)", tableName, previous))
+				continue
+			}
+
+			if err := rc.cleanupEngines(ctx, tableMeta, tableName, cp); err != nil {
+				return false, errors.Trace(err)
+			}
+			if err := rc.checkpointsDB.RemoveCheckpoint(ctx, tableName); err != nil {
+				return false, errors.Trace(err)
+			}
+			reimport = true
+		}
+	}
+
+	if len(changedTables) != 0 {
+		return false, errors.Errorf(
+			"the tables %s have been partially imported by another backend, which cannot be resumed by "+
+				"the current backend; switch back to the previous backend, or set `checkpoint.on-backend-change` "+
+				"to \"%s\" to drop and import these tables again",
+			strings.Join(changedTables, ", "), config.BackendChangeReimport,
+		)
+	}
+	return reimport, nil
+}
+
+// previousBackend returns the backend other than the current one which has
+// written an engine of the table, or "" if there is none. Engine checkpoints
+// created by older versions of Lightning do not record the backend and are
+// ignored.
+func previousBackend(cp *TableCheckpoint, current string) string {
+	for _, engine := range cp.Engines {
+		if len(engine.Backend) > 0 && engine.Backend != current {
+			return engine.Backend
+		}
+	}
+	return ""
+}
+
+// hasWrittenData returns whether any data of the table has been written to
+// its engines.
+func hasWrittenData(cp *TableCheckpoint) bool {
+	if cp.Status >= CheckpointStatusAllWritten {
+		return true
+	}
+	for _, engine := range cp.Engines {
+		if engine.Status >= CheckpointStatusAllWritten {
+			return true
+		}
+		for _, chunk := range engine.Chunks {
+			if chunk.Chunk.Offset > chunk.Key.Offset {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cleanupEngines removes the engines of a table which is going to be imported
+// again. Each engine is cleaned up by the backend which has written it.
+func (rc *RestoreController) cleanupEngines(ctx context.Context, tableMeta *mydump.MDTableMeta, tableName string, cp *TableCheckpoint) error {
+	for engineID, engine := range cp.Engines {
+		name := engine.Backend
+		if len(name) == 0 {
+			name = rc.cfg.TableBackend(tableMeta.DB, tableMeta.Name)
+		}
+		backend, ok := rc.backends[name]
+		if !ok {
+			log.L().Warn("the backend of the engine is not in use, please clean up the engine manually",
+				zap.String("table", tableName), zap.Int32("engineID", engineID), zap.String("backend", name))
+			continue
+		}
+		closedEngine, err := backend.UnsafeCloseEngine(ctx, tableName, engineID)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := closedEngine.Cleanup(ctx); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	return nil
 }
 
+// isUnknownSchemaHash returns whether the hash is absent, which happens for
+// checkpoints created by older versions of Lightning.
+func isUnknownSchemaHash(hash []byte) bool {
+	for _, b := range hash {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func (rc *RestoreController) estimateChunkCountIntoMetrics() {
 	estimatedChunkCount := 0
 	for _, dbMeta := range rc.dbMetas {
@@ -361,8 +979,22 @@ func (rc *RestoreController) listenCheckpointUpdates() {
 	hasCheckpoint := make(chan struct{}, 1)
 	defer close(hasCheckpoint)
 
+	// stopping wakes up the writer waiting for the flush interval, so the
+	// remaining checkpoints are written immediately on exit.
+	stopping := make(chan struct{})
+	flushInterval := rc.cfg.Checkpoint.FlushInterval.Duration
+
 	go func() {
+		var lastFlush time.Time
 		for range hasCheckpoint {
+			if wait := flushInterval - time.Since(lastFlush); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-stopping:
+				}
+			}
+			lastFlush = time.Now()
+
 			lock.Lock()
 			cpd := coalesed
 			coalesed = make(map[string]*TableCheckpointDiff)
@@ -424,16 +1056,38 @@ func (rc *RestoreController) listenCheckpointUpdates() {
 			}
 		})
 	}
+	close(stopping)
 	rc.checkpointsWg.Done()
 }
 
 func (rc *RestoreController) runPeriodicActions(ctx context.Context, stop <-chan struct{}) {
-	switchModeTicker := time.NewTicker(rc.cfg.Cron.SwitchMode.Duration)
 	logProgressTicker := time.NewTicker(rc.cfg.Cron.LogProgress.Duration)
-	defer func() {
-		switchModeTicker.Stop()
-		logProgressTicker.Stop()
-	}()
+	defer logProgressTicker.Stop()
+
+	var switchModeC <-chan time.Time
+	if rc.cfg.TikvImporter.DisableSwitchMode {
+		log.L().Warn("switching TiKV to import mode is disabled, the import may be much slower in normal mode")
+	} else {
+		switchModeTicker := time.NewTicker(rc.cfg.Cron.SwitchMode.Duration)
+		defer switchModeTicker.Stop()
+		switchModeC = switchModeTicker.C
+	}
+
+	// the disk usage check is disabled by a nil channel which never fires.
+	var checkStoreDiskC <-chan time.Time
+	if rc.cfg.TikvImporter.MinStoreAvailableRatio > 0 {
+		checkStoreDiskTicker := time.NewTicker(rc.cfg.Cron.CheckStoreDisk.Duration)
+		defer checkStoreDiskTicker.Stop()
+		checkStoreDiskC = checkStoreDiskTicker.C
+		rc.checkStoreDisk(ctx)
+	}
+
+	var checkStallC <-chan time.Time
+	if rc.cfg.Cron.CheckStall.Duration > 0 {
+		checkStallTicker := time.NewTicker(rc.cfg.Cron.CheckStall.Duration)
+		defer checkStallTicker.Stop()
+		checkStallC = checkStallTicker.C
+	}
 
 	rc.switchToImportMode(ctx)
 
@@ -448,10 +1102,16 @@ func (rc *RestoreController) runPeriodicActions(ctx context.Context, stop <-chan
 			log.L().Info("everything imported, stopping periodic actions")
 			return
 
-		case <-switchModeTicker.C:
+		case <-switchModeC:
 			// periodically switch to import mode, as requested by TiKV 3.0
 			rc.switchToImportMode(ctx)
 
+		case <-checkStoreDiskC:
+			rc.checkStoreDisk(ctx)
+
+		case now := <-checkStallC:
+			rc.watchdog.check(now, rc.cfg.Cron.CheckStall.Duration, rc.pauser.IsPaused() || rc.diskPauser.IsPaused())
+
 		case <-logProgressTicker.C:
 			// log the current progress periodically, so OPS will know that we're still working
 			nanoseconds := float64(time.Since(start).Nanoseconds())
@@ -487,6 +1147,58 @@ func (rc *RestoreController) runPeriodicActions(ctx context.Context, stop <-chan
 	}
 }
 
+// checkStoreDisk pauses delivering KV pairs if any TiKV store has less
+// available disk space than `tikv-importer.min-store-available-ratio`, and
+// resumes when all stores are above the threshold again. The import is paused
+// rather than failed, so it can continue once space is freed or stores are
+// added.
+func (rc *RestoreController) checkStoreDisk(ctx context.Context) {
+	minRatio := rc.cfg.TikvImporter.MinStoreAvailableRatio
+
+	var (
+		lowStoresLock sync.Mutex
+		lowStores     []string
+	)
+	err := kv.ForAllStores(
+		ctx,
+		&http.Client{},
+		rc.cfg.TiDB.PdURL,
+		kv.StoreStateOffline,
+		func(c context.Context, store *kv.Store) error {
+			if store.Capacity == 0 {
+				return nil
+			}
+			ratio := store.AvailableRatio()
+			metric.StoreAvailableRatioGauge.WithLabelValues(store.Address).Set(ratio)
+			if ratio < minRatio {
+				lowStoresLock.Lock()
+				lowStores = append(lowStores, fmt.Sprintf("%s (%.1f%%)", store.Address, ratio*100))
+				lowStoresLock.Unlock()
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		// keep the current state, we'll try again later.
+		log.L().Warn("cannot check disk usage of TiKV stores", log.ShortError(err))
+		return
+	}
+
+	switch {
+	case len(lowStores) > 0:
+		if !rc.diskPauser.IsPaused() {
+			log.L().Warn("some TiKV stores are running out of disk space, pause importing until the space is freed",
+				zap.Strings("stores", lowStores),
+				zap.Float64("minAvailableRatio", minRatio),
+			)
+			rc.diskPauser.Pause()
+		}
+	case rc.diskPauser.IsPaused():
+		log.L().Info("all TiKV stores have enough disk space, resume importing")
+		rc.diskPauser.Resume()
+	}
+}
+
 type gcLifeTimeManager struct {
 	runningJobsLock sync.Mutex
 	runningJobs     int
@@ -568,13 +1280,27 @@ func (rc *RestoreController) restoreTables(ctx context.Context) error {
 	ctx2 := context.WithValue(ctx, &gcLifeTimeKey, manager)
 	for i := 0; i < rc.cfg.App.IndexConcurrency; i++ {
 		go func() {
+			defer rc.revertTiKVModeOnPanic()
 			for task := range taskCh {
 				tableLogTask := task.tr.logger.Begin(zap.InfoLevel, "restore table")
 				web.BroadcastTableCheckpoint(task.tr.tableName, task.cp)
-				err := task.tr.restoreTable(ctx2, rc, task.cp)
+				rc.summary.startTable(task.tr.tableName)
+				rc.events.tableStarted(task.tr.tableName)
+				rc.summary.setBackend(task.tr.tableName, task.tr.backendName)
+				if rc.cfg.App.SummaryKeyRanges {
+					rc.summary.setKeyRanges(task.tr.tableName, tableKeyRanges(task.tr.tableInfo.Core))
+				}
+				err := task.tr.restoreTableWithRetry(ctx2, rc, task.cp)
+				rc.summary.endTable(task.tr.tableName, task.cp, err)
+				rc.events.tableEnded(task.tr.tableName, err)
 				tableLogTask.End(zap.ErrorLevel, err)
 				web.BroadcastError(task.tr.tableName, err)
 				metric.RecordTableCount("completed", err)
+				if err != nil && rc.cfg.App.ContinueOnTableFailure && !common.IsContextCanceledError(err) {
+					task.tr.logger.Error("table failed, skipped and continue with other tables", log.ShortError(err))
+					rc.addFailedTable(task.tr.tableName)
+					err = nil
+				}
 				restoreErr.Set(err)
 				wg.Done()
 			}
@@ -640,37 +1366,195 @@ func (rc *RestoreController) restoreTables(ctx context.Context) error {
 		return errors.New("TiDB Lightning has failed last time; please resolve these errors first")
 	}
 
-	for _, dbMeta := range rc.dbMetas {
-		dbInfo := rc.dbInfos[dbMeta.Name]
-		for _, tableMeta := range dbMeta.Tables {
-			tableInfo := dbInfo.Tables[tableMeta.Name]
-			tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
-			cp, err := rc.checkpointsDB.Get(ctx, tableName)
-			if err != nil {
-				return errors.Trace(err)
-			}
-			tr, err := NewTableRestore(tableName, tableMeta, dbInfo, tableInfo, cp)
-			if err != nil {
-				return errors.Trace(err)
-			}
+	orderedTables := orderTables(rc.dbMetas, rc.dbInfos, rc.cfg.TablePriority)
+	for i, tableMeta := range orderedTables {
+		dbInfo := rc.dbInfos[tableMeta.DB]
+		tableInfo := dbInfo.Tables[tableMeta.Name]
+		tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
+		cp, err := rc.checkpointsDB.Get(ctx, tableName)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		tr, err := NewTableRestore(tableName, tableMeta, dbInfo, tableInfo, cp)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		tr.projection = rc.cfg.ColumnProjection(tableMeta.DB, tableMeta.Name)
+		tr.backendName = rc.cfg.TableBackend(tableMeta.DB, tableMeta.Name)
+		rc.summary.setOrder(tableName, i+1)
 
-			wg.Add(1)
-			select {
-			case taskCh <- task{tr: tr, cp: cp}:
-			case <-ctx.Done():
-				return ctx.Err()
-			}
+		wg.Add(1)
+		select {
+		case taskCh <- task{tr: tr, cp: cp}:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 
 	wg.Wait()
 	stopPeriodicActions <- struct{}{}
 
+	if failedTables := rc.getFailedTables(); len(failedTables) > 0 {
+		log.L().Warn("some tables failed and were skipped, please check the errors above",
+			zap.Strings("tables", failedTables))
+	}
+
 	err := restoreErr.Get()
 	logTask.End(zap.ErrorLevel, err)
 	return err
 }
 
+// orderTables returns the order to dispatch all tables for import. Tables of
+// higher `table-config.priority` come first, and ties keep the order of the
+// databases and the order of their tables by orderTablesByForeignKeys. A table
+// referenced by the foreign keys of another table inherits its priority if
+// higher, so that the referenced table is still dispatched before.
+func orderTables(dbMetas []*mydump.MDDatabaseMeta, dbInfos map[string]*TidbDBInfo, priorityOf func(schema, table string) int) []*mydump.MDTableMeta {
+	type prioritizedTable struct {
+		tableMeta *mydump.MDTableMeta
+		priority  int
+	}
+	var tables []prioritizedTable
+	for _, dbMeta := range dbMetas {
+		dbInfo := dbInfos[dbMeta.Name]
+		ordered := orderTablesByForeignKeys(dbMeta.Tables, dbInfo)
+		priorities := make(map[string]int, len(ordered))
+		for _, tableMeta := range ordered {
+			priorities[strings.ToLower(tableMeta.Name)] = priorityOf(tableMeta.DB, tableMeta.Name)
+		}
+		// the referencing tables come after the referenced ones, so visiting
+		// them backwards propagates the priorities through the whole chain.
+		for i := len(ordered) - 1; i >= 0; i-- {
+			tableInfo, ok := dbInfo.Tables[ordered[i].Name]
+			if !ok || tableInfo.Core == nil {
+				continue
+			}
+			priority := priorities[strings.ToLower(ordered[i].Name)]
+			for _, fk := range tableInfo.Core.ForeignKeys {
+				if refPriority, ok := priorities[fk.RefTable.L]; ok && refPriority < priority {
+					priorities[fk.RefTable.L] = priority
+				}
+			}
+		}
+		for _, tableMeta := range ordered {
+			tables = append(tables, prioritizedTable{tableMeta: tableMeta, priority: priorities[strings.ToLower(tableMeta.Name)]})
+		}
+	}
+
+	sort.SliceStable(tables, func(i, j int) bool {
+		return tables[i].priority > tables[j].priority
+	})
+	result := make([]*mydump.MDTableMeta, 0, len(tables))
+	for _, table := range tables {
+		result = append(result, table.tableMeta)
+	}
+	return result
+}
+
+// orderTablesByForeignKeys sorts the tables of a database such that every
+// table comes after the tables its foreign keys reference, so that the
+// referenced tables are dispatched for import first. This is only the order of
+// dispatching: the import does not wait for the referenced tables to finish,
+// so with `table-concurrency` > 1 they may still be imported concurrently.
+// Apart from this the original order is preserved. References to tables
+// outside of the import and self-references are ignored. If the references
+// form a cycle, a warning is logged and the original order is returned.
+func orderTablesByForeignKeys(tables []*mydump.MDTableMeta, dbInfo *TidbDBInfo) []*mydump.MDTableMeta {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	byName := make(map[string]*mydump.MDTableMeta, len(tables))
+	for _, tableMeta := range tables {
+		byName[strings.ToLower(tableMeta.Name)] = tableMeta
+	}
+	states := make(map[string]int, len(tables))
+	ordered := make([]*mydump.MDTableMeta, 0, len(tables))
+
+	var visit func(tableMeta *mydump.MDTableMeta) bool
+	visit = func(tableMeta *mydump.MDTableMeta) bool {
+		name := strings.ToLower(tableMeta.Name)
+		switch states[name] {
+		case visiting:
+			return false
+		case visited:
+			return true
+		}
+		states[name] = visiting
+		if tableInfo, ok := dbInfo.Tables[tableMeta.Name]; ok && tableInfo.Core != nil {
+			for _, fk := range tableInfo.Core.ForeignKeys {
+				refTable, ok := byName[fk.RefTable.L]
+				if !ok || fk.RefTable.L == name {
+					continue
+				}
+				if !visit(refTable) {
+					return false
+				}
+			}
+		}
+		states[name] = visited
+		ordered = append(ordered, tableMeta)
+		return true
+	}
+
+	for _, tableMeta := range tables {
+		if !visit(tableMeta) {
+			log.L().Warn("foreign keys form a cycle, tables are imported in their original order",
+				zap.String("db", dbInfo.Name), zap.String("table", tableMeta.Name))
+			return tables
+		}
+	}
+	return ordered
+}
+
+func (rc *RestoreController) addFailedTable(tableName string) {
+	rc.failedTablesLock.Lock()
+	defer rc.failedTablesLock.Unlock()
+	rc.failedTables = append(rc.failedTables, tableName)
+}
+
+func (rc *RestoreController) getFailedTables() []string {
+	rc.failedTablesLock.Lock()
+	defer rc.failedTablesLock.Unlock()
+	return append([]string(nil), rc.failedTables...)
+}
+
+// restoreTableWithRetry restores the table, and restarts from the in-memory
+// checkpoint up to `lightning.max-table-retry` times if it failed. The statuses
+// of the in-memory checkpoint are advanced together with the saved ones, so a
+// retry resumes after the last completed step, e.g. without opening the closed
+// engines again.
+func (t *TableRestore) restoreTableWithRetry(
+	ctx context.Context,
+	rc *RestoreController,
+	cp *TableCheckpoint,
+) error {
+	for retry := 0; ; retry++ {
+		err := t.restoreTable(ctx, rc, cp)
+		if err == nil && retry > 0 {
+			// the failed attempts are recorded by saveStatusCheckpoint.
+			rc.errorSummaries.clear(t.tableName)
+		}
+		if err == nil || common.IsContextCanceledError(err) || retry >= rc.cfg.App.MaxTableRetry {
+			return err
+		}
+
+		t.logger.Warn("restore table failed, going to retry",
+			zap.Int("retryCnt", retry+1),
+			zap.Int("maxRetry", rc.cfg.App.MaxTableRetry),
+			log.ShortError(err),
+		)
+		rc.summary.addRetry(t.tableName)
+		select {
+		case <-time.After(rc.backendNamed(t.backendName).RetryImportDelay()):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
 func (t *TableRestore) restoreTable(
 	ctx context.Context,
 	rc *RestoreController,
@@ -691,9 +1575,18 @@ func (t *TableRestore) restoreTable(
 			zap.Int("filesCnt", cp.CountChunks()),
 		)
 	} else if cp.Status < CheckpointStatusAllWritten {
-		if err := t.populateChunks(rc.cfg, cp); err != nil {
+		if err := t.populateChunks(rc.cfg, cp, rc.ioWorkers); err != nil {
 			return errors.Trace(err)
 		}
+		if rc.cfg.Mydumper.PreserveAutoIncrement {
+			maxValue, err := t.maxAutoIncrementValue(ctx, rc.cfg, cp, rc.ioWorkers)
+			if err != nil {
+				// forget the chunks, so a retry scans them again.
+				cp.Engines = make(map[int32]*EngineCheckpoint)
+				return errors.Trace(err)
+			}
+			cp.AllocBase = mathutil.MaxInt64(cp.AllocBase, maxValue)
+		}
 		if err := rc.checkpointsDB.InsertEngineCheckpoints(ctx, t.tableName, cp.Engines); err != nil {
 			return errors.Trace(err)
 		}
@@ -737,7 +1630,7 @@ func (t *TableRestore) restoreEngines(ctx context.Context, rc *RestoreController
 	if indexEngineCp.Status < CheckpointStatusImported && cp.Status < CheckpointStatusIndexImported {
 		indexWorker := rc.indexWorkers.Apply()
 		defer rc.indexWorkers.Recycle(indexWorker)
-		indexEngine, err := rc.backend.OpenEngine(ctx, t.tableName, indexEngineID)
+		indexEngine, err := rc.backendNamed(t.backendName).OpenEngine(ctx, t.tableName, indexEngineID)
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -777,6 +1670,7 @@ func (t *TableRestore) restoreEngines(ctx context.Context, rc *RestoreController
 			restoreWorker := rc.tableWorkers.Apply()
 
 			go func(w *worker.Worker, eid int32, ecp *EngineCheckpoint) {
+				defer rc.revertTiKVModeOnPanic()
 				defer wg.Done()
 
 				engineLogTask := t.logger.With(zap.Int32("engineNumber", eid)).Begin(zap.InfoLevel, "restore engine")
@@ -806,7 +1700,7 @@ func (t *TableRestore) restoreEngines(ctx context.Context, rc *RestoreController
 		// If index engine file has been closed but not imported only if context cancel occurred
 		// when `importKV()` execution, so `UnsafeCloseEngine` and continue import it.
 		if indexEngineCp.Status == CheckpointStatusClosed {
-			closedIndexEngine, err = rc.backend.UnsafeCloseEngine(ctx, t.tableName, indexEngineID)
+			closedIndexEngine, err = rc.backendNamed(t.backendName).UnsafeCloseEngine(ctx, t.tableName, indexEngineID)
 		} else {
 			closedIndexEngine, err = indexEngine.Close(ctx)
 			rc.saveStatusCheckpoint(t.tableName, indexEngineID, err, CheckpointStatusClosed)
@@ -814,15 +1708,18 @@ func (t *TableRestore) restoreEngines(ctx context.Context, rc *RestoreController
 		if err != nil {
 			return errors.Trace(err)
 		}
+		indexEngineCp.Status = CheckpointStatusClosed
 	}
 
 	if cp.Status < CheckpointStatusIndexImported {
 		var err error
 		if indexEngineCp.Status < CheckpointStatusImported {
 			// the lock ensures the import() step will not be concurrent.
+			endStep := rc.watchdog.begin(t.tableName, indexEngineID, "import engine", rc.backendStore(t.backendName))
 			rc.postProcessLock.Lock()
 			err = t.importKV(ctx, closedIndexEngine)
 			rc.postProcessLock.Unlock()
+			endStep()
 			rc.saveStatusCheckpoint(t.tableName, indexEngineID, err, CheckpointStatusImported)
 		}
 
@@ -834,6 +1731,8 @@ func (t *TableRestore) restoreEngines(ctx context.Context, rc *RestoreController
 		if err != nil {
 			return errors.Trace(err)
 		}
+		indexEngineCp.Status = CheckpointStatusImported
+		cp.Status = CheckpointStatusIndexImported
 	}
 	return nil
 }
@@ -847,7 +1746,7 @@ func (t *TableRestore) restoreEngine(
 ) (*kv.ClosedEngine, *worker.Worker, error) {
 	if cp.Status >= CheckpointStatusClosed {
 		w := rc.closedEngineLimit.Apply()
-		closedEngine, err := rc.backend.UnsafeCloseEngine(ctx, t.tableName, engineID)
+		closedEngine, err := rc.backendNamed(t.backendName).UnsafeCloseEngine(ctx, t.tableName, engineID)
 		// If any error occurred, recycle worker immediately
 		if err != nil {
 			rc.closedEngineLimit.Recycle(w)
@@ -858,7 +1757,7 @@ func (t *TableRestore) restoreEngine(
 
 	logTask := t.logger.With(zap.Int32("engineNumber", engineID)).Begin(zap.InfoLevel, "encode kv data and write")
 
-	dataEngine, err := rc.backend.OpenEngine(ctx, t.tableName, engineID)
+	dataEngine, err := rc.backendNamed(t.backendName).OpenEngine(ctx, t.tableName, engineID)
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
@@ -888,7 +1787,7 @@ func (t *TableRestore) restoreEngine(
 		// 	3. load kvs data (into kv deliver server)
 		// 	4. flush kvs data (into tikv node)
 
-		cr, err := newChunkRestore(chunkIndex, rc.cfg, chunk, rc.ioWorkers)
+		cr, err := newChunkRestore(ctx, chunkIndex, rc.cfg, chunk, rc.ioWorkers)
 		if err != nil {
 			return nil, nil, errors.Trace(err)
 		}
@@ -897,6 +1796,7 @@ func (t *TableRestore) restoreEngine(
 		restoreWorker := rc.regionWorkers.Apply()
 		wg.Add(1)
 		go func(w *worker.Worker, cr *chunkRestore) {
+			defer rc.revertTiKVModeOnPanic()
 			// Restore a chunk.
 			defer func() {
 				cr.close()
@@ -904,7 +1804,9 @@ func (t *TableRestore) restoreEngine(
 				rc.regionWorkers.Recycle(w)
 			}()
 			metric.ChunkCounter.WithLabelValues(metric.ChunkStateRunning).Inc()
+			endStep := rc.watchdog.begin(t.tableName, engineID, "restore chunk "+cr.chunk.Key.String(), rc.backendStore(t.backendName))
 			err := cr.restore(ctx, t, engineID, dataEngine, indexEngine, rc)
+			endStep()
 			if err == nil {
 				metric.ChunkCounter.WithLabelValues(metric.ChunkStateFinished).Inc()
 				return
@@ -921,7 +1823,11 @@ func (t *TableRestore) restoreEngine(
 	totalSQLSize := int64(0)
 	for _, chunk := range cp.Chunks {
 		totalKVSize += chunk.Checksum.SumSize()
-		totalSQLSize += chunk.Chunk.EndOffset
+		if chunk.Chunk.EndOffset == mydump.UnknownSourceFileSize {
+			totalSQLSize += chunk.Chunk.Offset
+		} else {
+			totalSQLSize += chunk.Chunk.EndOffset
+		}
 	}
 
 	err = chunkErr.Get()
@@ -933,6 +1839,7 @@ func (t *TableRestore) restoreEngine(
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
+	cp.Status = CheckpointStatusAllWritten
 
 	dataWorker := rc.closedEngineLimit.Apply()
 	closedDataEngine, err := dataEngine.Close(ctx)
@@ -942,6 +1849,7 @@ func (t *TableRestore) restoreEngine(
 		rc.closedEngineLimit.Recycle(dataWorker)
 		return nil, nil, errors.Trace(err)
 	}
+	cp.Status = CheckpointStatusClosed
 	return closedDataEngine, dataWorker, nil
 }
 
@@ -960,13 +1868,16 @@ func (t *TableRestore) importEngine(
 	// FIXME: flush is an asynchronous operation, what if flush failed?
 
 	// the lock ensures the import() step will not be concurrent.
+	endStep := rc.watchdog.begin(t.tableName, engineID, "import engine", rc.backendStore(t.backendName))
 	rc.postProcessLock.Lock()
 	err := t.importKV(ctx, closedEngine)
 	rc.postProcessLock.Unlock()
+	endStep()
 	rc.saveStatusCheckpoint(t.tableName, engineID, err, CheckpointStatusImported)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	cp.Status = CheckpointStatusImported
 
 	// 2. perform a level-1 compact if idling.
 	if rc.cfg.PostRestore.Level1Compact &&
@@ -983,7 +1894,7 @@ func (t *TableRestore) importEngine(
 }
 
 func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, cp *TableCheckpoint) error {
-	if !rc.backend.ShouldPostProcess() {
+	if !rc.backendNamed(t.backendName).ShouldPostProcess() {
 		t.logger.Debug("skip post-processing, not supported by backend")
 		rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, nil, CheckpointStatusAnalyzeSkipped)
 		return nil
@@ -992,14 +1903,22 @@ func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, c
 	setSessionConcurrencyVars(ctx, rc.tidbMgr.db, rc.cfg.TiDB)
 
 	// 3. alter table set auto_increment
+	// this is independent of the checksum, which may be skipped.
 	if cp.Status < CheckpointStatusAlteredAutoInc {
-		rc.alterTableLock.Lock()
-		err := AlterAutoIncrement(ctx, rc.tidbMgr.db, t.tableName, t.alloc.Base()+1)
-		rc.alterTableLock.Unlock()
-		rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, err, CheckpointStatusAlteredAutoInc)
-		if err != nil {
-			return err
+		if !rc.cfg.PostRestore.AlterAutoIncrement {
+			t.logger.Warn("skip alter table auto_increment, later inserts may conflict with the imported rows",
+				zap.Int64("auto_increment", t.alloc.Base()+1))
+			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, nil, CheckpointStatusAlteredAutoInc)
+		} else {
+			rc.alterTableLock.Lock()
+			err := AlterAutoIncrement(ctx, rc.tidbMgr.db, t.tableName, t.alloc.Base()+1)
+			rc.alterTableLock.Unlock()
+			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, err, CheckpointStatusAlteredAutoInc)
+			if err != nil {
+				return err
+			}
 		}
+		cp.Status = CheckpointStatusAlteredAutoInc
 	}
 
 	// 4. do table checksum
@@ -1011,17 +1930,44 @@ func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, c
 	}
 
 	t.logger.Info("local checksum", zap.Object("checksum", &localChecksum))
+	shouldChecksum, err := rc.cfg.ShouldChecksum(t.dbInfo.Name, t.tableInfo.Name)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	if cp.Status < CheckpointStatusChecksummed {
-		if !rc.cfg.PostRestore.Checksum {
+		if rc.cfg.TikvImporter.KVKind == config.KVKindData {
+			// the indexes are imported in a later phase, so the table is
+			// incomplete and can't match the local checksum yet.
+			t.logger.Info("skip checksum, only row data are imported")
+			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, nil, CheckpointStatusChecksumSkipped)
+			rc.errorSummaries.recordChecksumSkipped(t.tableName)
+			rc.summary.setChecksum(t.tableName, checksumSkipped)
+		} else if !shouldChecksum {
 			t.logger.Info("skip checksum")
 			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, nil, CheckpointStatusChecksumSkipped)
+			rc.errorSummaries.recordChecksumSkipped(t.tableName)
+			rc.summary.setChecksum(t.tableName, checksumSkipped)
 		} else {
-			err := t.compareChecksum(ctx, rc.tidbMgr.db, localChecksum)
-			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, err, CheckpointStatusChecksummed)
+			checksum, err := rc.runChecksum(ctx, t, localChecksum)
+			status := CheckpointStatusChecksummed
+			if checksum == checksumUnavailable {
+				status = CheckpointStatusChecksumSkipped
+			}
+			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, err, status)
 			if err != nil {
+				rc.summary.setChecksum(t.tableName, checksumFailed)
 				return errors.Trace(err)
 			}
+			rc.summary.setChecksum(t.tableName, checksum)
+			cp.Status = status
 		}
+	} else if cp.Status == CheckpointStatusChecksummed || (shouldChecksum && rc.cfg.TikvImporter.KVKind != config.KVKindData) {
+		// verified in a previous run.
+		rc.summary.setChecksum(t.tableName, checksumPassed)
+	} else {
+		// the table was analyzed after its checksum was skipped in a previous
+		// run, which the checkpoint status no longer distinguishes.
+		rc.summary.setChecksum(t.tableName, checksumSkipped)
 	}
 
 	// 5. do table analyze
@@ -1029,8 +1975,14 @@ func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, c
 		if !rc.cfg.PostRestore.Analyze {
 			t.logger.Info("skip analyze")
 			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, nil, CheckpointStatusAnalyzeSkipped)
+		} else if rows := t.importedRows(localChecksum); rows < uint64(rc.cfg.PostRestore.AnalyzeMinRows) {
+			t.logger.Info("skip analyze, too few rows for analyze-min-rows, leaving it to auto-analyze",
+				zap.Uint64("rows", rows), zap.Int64("analyze-min-rows", rc.cfg.PostRestore.AnalyzeMinRows))
+			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, nil, CheckpointStatusAnalyzeSkipped)
 		} else {
+			endStep := rc.watchdog.begin(t.tableName, WholeTableEngineID, "analyze", rc.tidbStore())
 			err := t.analyzeTable(ctx, rc.tidbMgr.db)
+			endStep()
 			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, err, CheckpointStatusAnalyzed)
 			if err != nil {
 				return errors.Trace(err)
@@ -1038,7 +1990,101 @@ func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, c
 		}
 	}
 
-	return nil
+	// 6. scatter the regions of the table
+	// this only speeds up reaching a balanced state, so failures are ignored.
+	if rc.cfg.PostRestore.Scatter {
+		endStep := rc.watchdog.begin(t.tableName, WholeTableEngineID, "scatter", "pd "+rc.cfg.TiDB.PdAddr)
+		t.scatterRegions(ctx, rc)
+		endStep()
+	}
+
+	return nil
+}
+
+// runChecksum compares the checksum of the table inside a slot of the checksum
+// worker pool, so that at most `post-restore.checksum-concurrency` tables are
+// being checksummed at the same time. If ADMIN CHECKSUM is unavailable, the
+// table is verified as `post-restore.checksum-fallback` says. Returns how the
+// table is verified for the summary.
+//
+// With `post-restore.checksum-store-concurrency`, it also waits until few
+// enough tables are being checksummed on the stores of the region leaders of
+// the table.
+func (rc *RestoreController) runChecksum(ctx context.Context, t *TableRestore, localChecksum verify.KVChecksum) (string, error) {
+	atomic.AddInt32(&rc.checksumQueued, 1)
+	store := rc.tidbStore()
+	var stores []uint64
+	if rc.checksumStores != nil {
+		var err error
+		stores, err = rc.tidbMgr.getTableLeaderStores(t.dbInfo.Name, t.tableInfo.Name)
+		if err != nil {
+			// only the load is affected, so don't fail the checksum.
+			t.logger.Warn("failed to get the stores of the table, checksum without the store limit", log.ShortError(err))
+		} else {
+			store = fmt.Sprintf("tikv stores %v", stores)
+		}
+	}
+	// waiting for the stores and the workers is also part of the step.
+	defer rc.watchdog.begin(t.tableName, WholeTableEngineID, "checksum", store)()
+	if rc.checksumStores != nil {
+		if err := rc.checksumStores.acquire(ctx, stores); err != nil {
+			atomic.AddInt32(&rc.checksumQueued, -1)
+			return checksumFailed, errors.Trace(err)
+		}
+		defer rc.checksumStores.release(stores)
+	}
+	worker := rc.checksumWorkers.Apply()
+	atomic.AddInt32(&rc.checksumQueued, -1)
+	atomic.AddInt32(&rc.checksumRunning, 1)
+	defer func() {
+		atomic.AddInt32(&rc.checksumRunning, -1)
+		atomic.AddInt32(&rc.checksumFinished, 1)
+		rc.checksumWorkers.Recycle(worker)
+		rc.logChecksumProgress()
+	}()
+
+	err := t.compareChecksum(ctx, rc.tidbMgr.db, localChecksum)
+	if err == nil || !isChecksumUnavailableError(err) {
+		return checksumPassed, err
+	}
+
+	// a mismatch always fails, only a checksum which cannot be computed at
+	// all reaches here.
+	switch rc.cfg.PostRestore.ChecksumFallback {
+	case config.ChecksumFallbackCount:
+		t.logger.Warn("ADMIN CHECKSUM is unavailable, only the row count is compared", log.ShortError(err))
+		return checksumRowCount, t.compareRowCount(ctx, rc.tidbMgr.db, localChecksum)
+	case config.ChecksumFallbackWarn:
+		t.logger.Warn("ADMIN CHECKSUM is unavailable, the table is not verified", log.ShortError(err))
+		rc.errorSummaries.recordChecksumSkipped(t.tableName)
+		return checksumUnavailable, nil
+	default:
+		return checksumFailed, errors.Annotate(err, "ADMIN CHECKSUM is unavailable, consider setting `post-restore.checksum-fallback`")
+	}
+}
+
+// isChecksumUnavailableError returns whether the checksum failed because ADMIN
+// CHECKSUM is not allowed or not supported, rather than a mismatch.
+func isChecksumUnavailableError(err error) bool {
+	merr, ok := errors.Cause(err).(*gomysql.MySQLError)
+	if !ok {
+		return false
+	}
+	switch merr.Number {
+	case mysql.ErrDBaccessDenied, mysql.ErrTableaccessDenied, mysql.ErrSpecificAccessDenied,
+		mysql.ErrNotSupportedYet, mysql.ErrParse:
+		return true
+	default:
+		return false
+	}
+}
+
+func (rc *RestoreController) logChecksumProgress() {
+	log.L().Info("checksum progress",
+		zap.Int32("queued", atomic.LoadInt32(&rc.checksumQueued)),
+		zap.Int32("running", atomic.LoadInt32(&rc.checksumRunning)),
+		zap.Int32("finished", atomic.LoadInt32(&rc.checksumFinished)),
+	)
 }
 
 // do full compaction for the whole data.
@@ -1059,27 +2105,97 @@ func (rc *RestoreController) fullCompact(ctx context.Context) error {
 }
 
 func (rc *RestoreController) doCompact(ctx context.Context, level int32) error {
-	return kv.ForAllStores(
+	return kv.ForAllStoresWithJitter(
 		ctx,
 		&http.Client{},
-		rc.cfg.TiDB.PdAddr,
+		rc.cfg.TiDB.PdURL,
 		kv.StoreStateDisconnected,
+		kv.StoreJitter{
+			Window:     rc.cfg.TiDB.StoreJitter.Duration,
+			StateGrace: rc.cfg.TiDB.StoreStateGrace.Duration,
+		},
 		func(c context.Context, store *kv.Store) error {
-			return kv.Compact(c, store.Address, level)
+			err := kv.Compact(c, store.Address, level, kv.GRPCDialOptions(rc.cfg.GRPC)...)
+			if rc.cfg.PostRestore.CompactOptional && kv.IsCompactUnsupportedError(err) {
+				log.L().Warn("skip compaction on TiKV store", zap.String("store", store.Address), log.ShortError(err))
+				return nil
+			}
+			return err
 		},
 	)
 }
 
 func (rc *RestoreController) switchToImportMode(ctx context.Context) {
-	rc.switchTiKVMode(ctx, sstpb.SwitchMode_Import)
+	if rc.cfg.TikvImporter.DisableSwitchMode {
+		return
+	}
+	atomic.StoreInt32(&rc.importModeSwitched, 1)
+	// we ignore switch mode failure since it is not fatal.
+	_ = rc.switchTiKVMode(ctx, sstpb.SwitchMode_Import)
 }
 
 func (rc *RestoreController) switchToNormalMode(ctx context.Context) error {
-	rc.switchTiKVMode(ctx, sstpb.SwitchMode_Normal)
+	if rc.cfg.TikvImporter.DisableSwitchMode {
+		return nil
+	}
+	_ = rc.switchTiKVMode(ctx, sstpb.SwitchMode_Normal)
+	atomic.StoreInt32(&rc.importModeSwitched, 0)
 	return nil
 }
 
-func (rc *RestoreController) switchTiKVMode(ctx context.Context, mode sstpb.SwitchMode) {
+// revertTiKVModeOnExit makes a best-effort attempt to switch TiKV back to
+// normal mode when the task ends before the normal switch-back step, e.g. on
+// panic, error or interruption. It uses its own short timeout since the task
+// context may have been canceled already.
+func (rc *RestoreController) revertTiKVModeOnExit(reason string) {
+	if !rc.cfg.App.RevertModeOnExit || atomic.LoadInt32(&rc.importModeSwitched) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rc.revertModeTimeout())
+	defer cancel()
+	task := log.L().With(zap.String("reason", reason)).Begin(zap.WarnLevel, "revert TiKV to normal mode before exit")
+	err := rc.switchTiKVMode(ctx, sstpb.SwitchMode_Normal)
+	task.End(zap.ErrorLevel, err)
+	if err != nil {
+		log.L().Error("failed to revert TiKV to normal mode, please run `tidb-lightning-ctl -switch-mode=normal` manually")
+		return
+	}
+	atomic.StoreInt32(&rc.importModeSwitched, 0)
+}
+
+// revertModeTimeout returns the time allowed for reverting TiKV to normal mode
+// before exit. Besides the requests themselves, it covers the delays which
+// switchTiKVMode may wait: the backoff of retrying the store list from PD,
+// and then either the jitter of a store, or its grace period followed by
+// another retried request to PD.
+func (rc *RestoreController) revertModeTimeout() time.Duration {
+	tidbCfg := &rc.cfg.TiDB
+	pdBackoff := time.Duration(0)
+	backoff := tidbCfg.PdRetryBackoff.Duration
+	for i := 1; i < tidbCfg.PdRetryCount; i++ {
+		pdBackoff += backoff
+		backoff *= 2
+	}
+	storeDelay := tidbCfg.StoreJitter.Duration
+	if grace := tidbCfg.StoreStateGrace.Duration + pdBackoff; grace > storeDelay {
+		storeDelay = grace
+	}
+	return revertModeBaseTimeout + pdBackoff + storeDelay
+}
+
+// revertTiKVModeOnPanic reverts TiKV to normal mode if the current goroutine
+// is panicking, and then continues panicking. It must be called directly by
+// defer.
+func (rc *RestoreController) revertTiKVModeOnPanic() {
+	if r := recover(); r != nil {
+		log.L().Error("unexpected panic", zap.Reflect("panic", r), zap.ByteString("stack", debug.Stack()))
+		rc.revertTiKVModeOnExit("panic")
+		panic(r)
+	}
+}
+
+func (rc *RestoreController) switchTiKVMode(ctx context.Context, mode sstpb.SwitchMode) error {
 	// It is fine if we miss some stores which did not switch to Import mode,
 	// since we're running it periodically, so we exclude disconnected stores.
 	// But it is essential all stores be switched back to Normal mode to allow
@@ -1091,20 +2207,23 @@ func (rc *RestoreController) switchTiKVMode(ctx context.Context, mode sstpb.Swit
 		minState = kv.StoreStateDisconnected
 	}
 
-	// we ignore switch mode failure since it is not fatal.
 	// no need log the error, it is done in kv.SwitchMode already.
-	_ = kv.ForAllStores(
+	return kv.ForAllStoresWithJitter(
 		ctx,
 		&http.Client{},
-		rc.cfg.TiDB.PdAddr,
+		rc.cfg.TiDB.PdURL,
 		minState,
+		kv.StoreJitter{
+			Window:     rc.cfg.TiDB.StoreJitter.Duration,
+			StateGrace: rc.cfg.TiDB.StoreStateGrace.Duration,
+		},
 		func(c context.Context, store *kv.Store) error {
-			return kv.SwitchMode(c, store.Address, mode)
+			return kv.SwitchMode(c, store.Address, mode, kv.GRPCDialOptions(rc.cfg.GRPC)...)
 		},
 	)
 }
 
-func (rc *RestoreController) checkRequirements(_ context.Context) error {
+func (rc *RestoreController) checkRequirements(ctx context.Context) error {
 	// skip requirement check if explicitly turned off
 	if !rc.cfg.App.CheckRequirements {
 		return nil
@@ -1120,7 +2239,38 @@ func (rc *RestoreController) checkRequirements(_ context.Context) error {
 	if err := rc.checkTiKVVersion(client); err != nil {
 		return errors.Trace(err)
 	}
+	if err := rc.checkClockSkew(ctx, client); err != nil {
+		return errors.Trace(err)
+	}
+
+	return nil
+}
+
+// checkClockSkew verifies the local clock and PD's clock differ by no more
+// than `lightning.max-clock-skew`.
+func (rc *RestoreController) checkClockSkew(ctx context.Context, client *http.Client) error {
+	maxSkew := rc.cfg.App.MaxClockSkew.Duration
+	if maxSkew <= 0 {
+		return nil
+	}
+
+	skew, err := kv.EstimatePDClockSkew(ctx, client, rc.cfg.TiDB.PdURL)
+	if err != nil {
+		if rc.cfg.App.OnClockSkew == config.ClockSkewError {
+			return errors.Trace(err)
+		}
+		log.L().Warn("cannot compare the local clock with PD, skipping the clock skew check", log.ShortError(err))
+		return nil
+	}
+	if skew <= maxSkew && skew >= -maxSkew {
+		return nil
+	}
 
+	if rc.cfg.App.OnClockSkew == config.ClockSkewError {
+		return errors.Errorf("the local clock differs from PD by %s, exceeding the limit %s; please synchronize the clocks", skew, maxSkew)
+	}
+	log.L().Warn("the local clock differs from PD, timestamps generated during the import may be wrong",
+		zap.Duration("skew", skew), zap.Duration("maxSkew", maxSkew))
 	return nil
 }
 
@@ -1164,9 +2314,9 @@ func (rc *RestoreController) checkTiDBVersion(client *http.Client) error {
 }
 
 func (rc *RestoreController) checkPDVersion(client *http.Client) error {
-	url := fmt.Sprintf("http://%s/pd/api/v1/config/cluster-version", rc.cfg.TiDB.PdAddr)
+	url := rc.cfg.TiDB.PdURL + "/pd/api/v1/config/cluster-version"
 	var rawVersion string
-	err := common.GetJSON(client, url, &rawVersion)
+	err := kv.GetPDJSON(context.Background(), client, url, &rawVersion)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -1183,7 +2333,7 @@ func (rc *RestoreController) checkTiKVVersion(client *http.Client) error {
 	return kv.ForAllStores(
 		context.Background(),
 		client,
-		rc.cfg.TiDB.PdAddr,
+		rc.cfg.TiDB.PdURL,
 		kv.StoreStateDown,
 		func(c context.Context, store *kv.Store) error {
 			component := fmt.Sprintf("TiKV (at %s)", store.Address)
@@ -1218,6 +2368,13 @@ func (rc *RestoreController) cleanCheckpoints(ctx context.Context) error {
 		zap.Int64("taskID", rc.cfg.TaskID),
 	)
 
+	// keep the checkpoints of the skipped tables, so they are not imported
+	// again from scratch by accident.
+	if failedTables := rc.getFailedTables(); len(failedTables) > 0 {
+		logger.Warn("some tables failed, keep the checkpoints", zap.Strings("tables", failedTables))
+		return nil
+	}
+
 	task := logger.Begin(zap.InfoLevel, "clean checkpoints")
 	var err error
 	if rc.cfg.Checkpoint.KeepAfterSuccess {
@@ -1233,9 +2390,24 @@ type chunkRestore struct {
 	parser mydump.Parser
 	index  int
 	chunk  *ChunkCheckpoint
+	// transcodes the string fields, nil if the data file needs no conversion.
+	convertor *mydump.CharsetConvertor
+	// rewrites the fields by `table-config.transform`, nil if there is none.
+	transformer *kv.RowTransformer
+	// whether a data file without any rows is an error.
+	emptyFileIsError bool
+	// whether a NUL byte in a field going into a non-binary string column is
+	// an error.
+	rejectNulBytes bool
+	// whether the rows exceeding the max row size are skipped instead of
+	// failing the chunk.
+	skipOversizedRows bool
+	// the number of rows skipped since the chunk is opened.
+	skippedRows int64
 }
 
 func newChunkRestore(
+	ctx context.Context,
 	index int,
 	cfg *config.Config,
 	chunk *ChunkCheckpoint,
@@ -1243,7 +2415,7 @@ func newChunkRestore(
 ) (*chunkRestore, error) {
 	blockBufSize := cfg.Mydumper.ReadBlockSize
 
-	reader, err := os.Open(chunk.Key.Path)
+	reader, err := mydump.OpenSourceFileContext(ctx, chunk.Key.Path)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -1251,18 +2423,62 @@ func newChunkRestore(
 	var parser mydump.Parser
 	switch path.Ext(strings.ToLower(chunk.Key.Path)) {
 	case ".csv":
-		parser = mydump.NewCSVParser(&cfg.Mydumper.CSV, reader, blockBufSize, ioWorkers)
+		csvParser := mydump.NewCSVParser(&cfg.Mydumper.CSV, reader, blockBufSize, ioWorkers)
+		csvParser.SetPreserveBOM(cfg.Mydumper.PreserveBOM)
+		parser = csvParser
 	default:
-		parser = mydump.NewChunkParser(cfg.TiDB.SQLMode, reader, blockBufSize, ioWorkers)
+		sqlParser := mydump.NewChunkParser(cfg.TiDB.SQLMode, reader, blockBufSize, ioWorkers)
+		sqlParser.SetPreserveBOM(cfg.Mydumper.PreserveBOM)
+		parser = sqlParser
 	}
 
-	reader.Seek(chunk.Chunk.Offset, io.SeekStart)
+	// a CSV chunk split from the middle of the file doesn't contain the
+	// header, so read the column names from the start of the file first.
+	if csvParser, ok := parser.(*mydump.CSVParser); ok && cfg.Mydumper.CSV.Header && chunk.Chunk.Offset > 0 {
+		if err := csvParser.ReadColumns(); err != nil {
+			reader.Close()
+			return nil, errors.Annotatef(err, "failed to read header of %s", chunk.Key.Path)
+		}
+		columns := csvParser.Columns()
+		csvParser = mydump.NewCSVParser(&cfg.Mydumper.CSV, reader, blockBufSize, ioWorkers)
+		csvParser.SetColumns(columns)
+		parser = csvParser
+	}
+	// likewise, a SQL chunk may start in the middle of an INSERT statement,
+	// which shares the column list of the first statement of the file.
+	if sqlParser, ok := parser.(*mydump.ChunkParser); ok && chunk.Key.Offset > 0 {
+		if err := sqlParser.ReadColumns(); err != nil {
+			reader.Close()
+			return nil, errors.Annotatef(err, "failed to read column list of %s", chunk.Key.Path)
+		}
+		columns := sqlParser.Columns()
+		sqlParser = mydump.NewChunkParser(cfg.TiDB.SQLMode, reader, blockBufSize, ioWorkers)
+		sqlParser.SetColumns(columns)
+		parser = sqlParser
+	}
+	// but a resumed SQL chunk may stop in the middle of any statement, so the
+	// chunk is parsed again up to where it stopped for the column list.
+	if sqlParser, ok := parser.(*mydump.ChunkParser); ok && chunk.Chunk.Offset > chunk.Key.Offset {
+		reader.Seek(chunk.Key.Offset, io.SeekStart)
+		sqlParser.SetPos(chunk.Key.Offset, 0)
+		if err := sqlParser.SkipTo(chunk.Chunk.Offset); err != nil {
+			reader.Close()
+			return nil, errors.Annotatef(err, "failed to resume %s", &chunk.Key)
+		}
+	} else {
+		reader.Seek(chunk.Chunk.Offset, io.SeekStart)
+	}
 	parser.SetPos(chunk.Chunk.Offset, chunk.Chunk.PrevRowIDMax)
 
+	_, isCSV := parser.(*mydump.CSVParser)
 	return &chunkRestore{
-		parser: parser,
-		index:  index,
-		chunk:  chunk,
+		parser:           parser,
+		index:            index,
+		chunk:            chunk,
+		emptyFileIsError: cfg.Mydumper.OnEmptyFile == config.EmptyFileError,
+		rejectNulBytes:   isCSV && cfg.Mydumper.CSV.OnNulByte == config.NulByteError,
+
+		skipOversizedRows: cfg.TikvImporter.OnOversizedRow == config.OversizedRowSkip,
 	}, nil
 }
 
@@ -1279,6 +2495,10 @@ type TableRestore struct {
 	encTable  table.Table
 	alloc     autoid.Allocator
 	logger    log.Logger
+	// the only columns to import, or nil to import all of them.
+	projection []string
+	// the backend importing the table, or empty for the global backend.
+	backendName string
 }
 
 func NewTableRestore(
@@ -1305,14 +2525,29 @@ func NewTableRestore(
 	}, nil
 }
 
+// encoderTable returns the table which the rows of the data file are encoded
+// into. The rows of a data file dumped per partition are routed to that
+// partition.
+func (tr *TableRestore) encoderTable(path string) (table.Table, error) {
+	partition, ok := tr.tableMeta.DataFilePartitions[path]
+	if !ok {
+		return tr.encTable, nil
+	}
+	pid, err := findFilePartition(tr.tableInfo.Core, path, partition)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return tr.encTable.(table.PartitionedTable).GetPartition(pid), nil
+}
+
 func (tr *TableRestore) Close() {
 	tr.encTable = nil
 	tr.logger.Info("restore done")
 }
 
-func (t *TableRestore) populateChunks(cfg *config.Config, cp *TableCheckpoint) error {
+func (t *TableRestore) populateChunks(cfg *config.Config, cp *TableCheckpoint, ioWorkers *worker.Pool) error {
 	task := t.logger.Begin(zap.InfoLevel, "load engines and files")
-	chunks, err := mydump.MakeTableRegions(t.tableMeta, t.tableInfo.Columns, cfg.Mydumper.BatchSize, cfg.Mydumper.BatchImportRatio, cfg.App.TableConcurrency)
+	chunks, err := mydump.MakeTableRegions(t.tableMeta, t.tableInfo.Columns, cfg, ioWorkers)
 	if err == nil {
 		timestamp := time.Now().Unix()
 		failpoint.Inject("PopulateChunkTimestamp", func(v failpoint.Value) {
@@ -1322,7 +2557,8 @@ func (t *TableRestore) populateChunks(cfg *config.Config, cp *TableCheckpoint) e
 			engine, found := cp.Engines[chunk.EngineID]
 			if !found {
 				engine = &EngineCheckpoint{
-					Status: CheckpointStatusLoaded,
+					Status:  CheckpointStatusLoaded,
+					Backend: t.backendName,
 				}
 				cp.Engines[chunk.EngineID] = engine
 			}
@@ -1338,7 +2574,7 @@ func (t *TableRestore) populateChunks(cfg *config.Config, cp *TableCheckpoint) e
 		}
 
 		// Add index engine checkpoint
-		cp.Engines[indexEngineID] = &EngineCheckpoint{Status: CheckpointStatusLoaded}
+		cp.Engines[indexEngineID] = &EngineCheckpoint{Status: CheckpointStatusLoaded, Backend: t.backendName}
 	}
 	task.End(zap.ErrorLevel, err,
 		zap.Int("enginesCnt", len(cp.Engines)),
@@ -1347,6 +2583,159 @@ func (t *TableRestore) populateChunks(cfg *config.Config, cp *TableCheckpoint) e
 	return err
 }
 
+// maxAutoIncrementValue returns the largest explicit value of the
+// auto-increment column in all chunks of the table, or 0 if there is none.
+//
+// With `mydumper.preserve-auto-increment`, the rows without a value are
+// allocated IDs from the table allocator while the chunks are encoded
+// concurrently, so the allocator must start after the explicit values of every
+// chunk, not only of those encoded so far. This costs an extra pass over the
+// data files before the engines are restored. Values which cannot be converted
+// to an integer are ignored here, and reported by the encoder later.
+func (t *TableRestore) maxAutoIncrementValue(ctx context.Context, cfg *config.Config, cp *TableCheckpoint, ioWorkers *worker.Pool) (int64, error) {
+	autoIncOffset := -1
+	for i, colInfo := range t.tableInfo.Core.Columns {
+		if mysql.HasAutoIncrementFlag(colInfo.Flag) {
+			autoIncOffset = i
+			break
+		}
+	}
+	if autoIncOffset < 0 {
+		return 0, nil
+	}
+
+	convertor, err := mydump.NewCharsetConvertor(
+		cfg.DataCharacterSet(t.tableMeta.DB, t.tableMeta.Name),
+		cfg.Mydumper.DataInvalidChar == config.DataInvalidCharReplace,
+	)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	sessionOptions := &kv.SessionOptions{SQLMode: cfg.TiDB.SQLMode}
+	transformer, err := kv.NewRowTransformer(t.tableInfo.Core, cfg.Transforms(t.tableMeta.DB, t.tableMeta.Name), sessionOptions)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	sc := &stmtctx.StatementContext{}
+
+	task := t.logger.Begin(zap.InfoLevel, "scan auto-increment values")
+	var maxValue int64
+	scanChunk := func(chunk ChunkCheckpoint) error {
+		cr, err := newChunkRestore(ctx, 0, cfg, &chunk, ioWorkers)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer cr.close()
+
+		var binaryFields []bool
+		for {
+			offset, _ := cr.parser.Pos()
+			if offset >= chunk.Chunk.EndOffset {
+				return nil
+			}
+			switch err := cr.parser.ReadRow(); errors.Cause(err) {
+			case nil:
+			case io.EOF:
+				return nil
+			default:
+				return errors.Annotatef(err, "in file %s at offset %d", &chunk.Key, offset)
+			}
+			if len(chunk.ColumnPermutation) == 0 {
+				if err := t.initializeColumns(cr.parser.Columns(), &chunk); err != nil {
+					return err
+				}
+				binaryFields = t.binaryFields(chunk.ColumnPermutation)
+			}
+			field := chunk.ColumnPermutation[autoIncOffset]
+			if field < 0 {
+				// the whole chunk is allocated by the table allocator.
+				return nil
+			}
+
+			row := cr.parser.LastRow().Row
+			if err := convertor.ConvertRow(row, binaryFields); err != nil {
+				return errors.Annotatef(err, "in file %s at offset %d", &chunk.Key, offset)
+			}
+			if err := transformer.Transform(row, chunk.ColumnPermutation); err != nil {
+				return errors.Annotatef(err, "in file %s at offset %d", &chunk.Key, offset)
+			}
+			if field >= len(row) || row[field].IsNull() {
+				continue
+			}
+			if value, err := row[field].ToInt64(sc); err == nil && value > maxValue {
+				maxValue = value
+			}
+		}
+	}
+scan:
+	for _, engine := range cp.Engines {
+		for _, chunk := range engine.Chunks {
+			if err = ctx.Err(); err != nil {
+				break scan
+			}
+			if err = scanChunk(*chunk); err != nil {
+				break scan
+			}
+		}
+	}
+	task.End(zap.ErrorLevel, err, zap.Int64("maxValue", maxValue))
+	return maxValue, err
+}
+
+// binaryFields returns which fields of the data file are going into binary
+// string columns, which must not be transcoded.
+func (t *TableRestore) binaryFields(columnPermutation []int) []bool {
+	var fields []bool
+	for i, colInfo := range t.tableInfo.Core.Columns {
+		if i >= len(columnPermutation) {
+			break
+		}
+		j := columnPermutation[i]
+		if j < 0 || !types.IsBinaryStr(&colInfo.FieldType) {
+			continue
+		}
+		for len(fields) <= j {
+			fields = append(fields, false)
+		}
+		fields[j] = true
+	}
+	return fields
+}
+
+// textFields returns the names of the non-binary string columns the fields of
+// the data file are going into, or "" for fields going elsewhere.
+func (t *TableRestore) textFields(columnPermutation []int) []string {
+	var fields []string
+	for i, colInfo := range t.tableInfo.Core.Columns {
+		if i >= len(columnPermutation) {
+			break
+		}
+		j := columnPermutation[i]
+		if j < 0 || !types.IsNonBinaryStr(&colInfo.FieldType) {
+			continue
+		}
+		for len(fields) <= j {
+			fields = append(fields, "")
+		}
+		fields[j] = colInfo.Name.O
+	}
+	return fields
+}
+
+// checkNulBytes rejects the row if a field going into a non-binary string
+// column contains a NUL byte.
+func checkNulBytes(row []types.Datum, textFields []string) error {
+	for i, column := range textFields {
+		if len(column) == 0 || i >= len(row) || row[i].Kind() != types.KindString {
+			continue
+		}
+		if strings.IndexByte(row[i].GetString(), 0) >= 0 {
+			return errors.Errorf("field %d contains a NUL byte, which is rejected for the text column `%s`", i+1, column)
+		}
+	}
+	return nil
+}
+
 // initializeColumns computes the "column permutation" for an INSERT INTO
 // statement. Suppose a table has columns (a, b, c, d) in canonical order, and
 // we execute `INSERT INTO (d, b, a) VALUES ...`, we will need to remap the
@@ -1360,14 +2749,23 @@ func (t *TableRestore) populateChunks(cfg *config.Config, cp *TableCheckpoint) e
 // The column permutation of (d, b, a) is set to be [2, 1, -1, 0].
 //
 // The argument `columns` _must_ be in lower case.
-func (t *TableRestore) initializeColumns(columns []string, ccp *ChunkCheckpoint) {
+//
+// Columns of the table missing from `columns` are filled with their default
+// values, but an error is returned if `columns` contains any column not found
+// in the table, since its data would be silently discarded. Columns excluded
+// by `t.projection` are treated as missing even if the data file has them.
+func (t *TableRestore) initializeColumns(columns []string, ccp *ChunkCheckpoint) error {
 	colPerm := make([]int, 0, len(t.tableInfo.Core.Columns)+1)
 	shouldIncludeRowID := !t.tableInfo.Core.PKIsHandle
 
 	if len(columns) == 0 {
 		// no provided columns, so use identity permutation.
-		for i := range t.tableInfo.Core.Columns {
-			colPerm = append(colPerm, i)
+		for i, colInfo := range t.tableInfo.Core.Columns {
+			if t.isProjectedOut(colInfo.Name.L) {
+				colPerm = append(colPerm, -1)
+			} else {
+				colPerm = append(colPerm, i)
+			}
 		}
 		if shouldIncludeRowID {
 			colPerm = append(colPerm, -1)
@@ -1377,26 +2775,151 @@ func (t *TableRestore) initializeColumns(columns []string, ccp *ChunkCheckpoint)
 		for i, column := range columns {
 			columnMap[column] = i
 		}
+		var missingColumns []string
 		for _, colInfo := range t.tableInfo.Core.Columns {
-			if i, ok := columnMap[colInfo.Name.L]; ok {
+			if t.isProjectedOut(colInfo.Name.L) {
+				delete(columnMap, colInfo.Name.L)
+				colPerm = append(colPerm, -1)
+			} else if i, ok := columnMap[colInfo.Name.L]; ok {
 				colPerm = append(colPerm, i)
+				delete(columnMap, colInfo.Name.L)
 			} else {
 				t.logger.Warn("column missing from data file, going to fill with default value",
 					zap.Stringer("path", &ccp.Key),
 					zap.String("colName", colInfo.Name.O),
 					zap.Stringer("colType", &colInfo.FieldType),
 				)
+				missingColumns = append(missingColumns, colInfo.Name.O)
 				colPerm = append(colPerm, -1)
 			}
 		}
 		if i, ok := columnMap[model.ExtraHandleName.L]; ok {
 			colPerm = append(colPerm, i)
+			delete(columnMap, model.ExtraHandleName.L)
 		} else if shouldIncludeRowID {
 			colPerm = append(colPerm, -1)
 		}
+
+		if len(columnMap) > 0 {
+			extraColumns := make([]string, 0, len(columnMap))
+			for _, column := range columns {
+				if _, ok := columnMap[column]; ok {
+					extraColumns = append(extraColumns, column)
+				}
+			}
+			return errors.Errorf(
+				"column mismatch in file %s: table %s has %d columns (%s), but the file provides %d columns (%s); extra columns: %s; missing columns: %s",
+				&ccp.Key, t.tableName,
+				len(t.tableInfo.Core.Columns), strings.Join(t.columnNames(), ", "),
+				len(columns), strings.Join(columns, ", "),
+				strings.Join(extraColumns, ", "), joinOrNone(missingColumns),
+			)
+		}
 	}
 
 	ccp.ColumnPermutation = colPerm
+	return nil
+}
+
+// checkValueCount verifies the number of values in a row of a data file
+// without column names matches the number of columns in the table.
+func (t *TableRestore) checkValueCount(row []types.Datum, ccp *ChunkCheckpoint) error {
+	if len(row) == len(t.tableInfo.Core.Columns) {
+		return nil
+	}
+	return errors.Errorf(
+		"column count mismatch in file %s: table %s has %d columns (%s), but the first row read from offset %d has %d values",
+		&ccp.Key, t.tableName,
+		len(t.tableInfo.Core.Columns), strings.Join(t.columnNames(), ", "),
+		ccp.Chunk.Offset, len(row),
+	)
+}
+
+// isProjectedOut returns whether the column is excluded from import by the
+// `table-config.columns` of the table.
+func (t *TableRestore) isProjectedOut(column string) bool {
+	if len(t.projection) == 0 {
+		return false
+	}
+	for _, projected := range t.projection {
+		if projected == column {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldProjection keeps only the fields of the data file going into the
+// projected columns, since the TiDB backend inserts the fields as-is rather
+// than following the column permutation.
+type fieldProjection struct {
+	// the indices of the kept fields, in the order of the data file.
+	fields []int
+	// the names of the columns of the kept fields.
+	columns []string
+	// the column permutation into the kept fields.
+	permutation []int
+}
+
+func (t *TableRestore) projectFields(columnPermutation []int) *fieldProjection {
+	type keptField struct {
+		field  int
+		column string
+	}
+	kept := make([]keptField, 0, len(columnPermutation))
+	for i, j := range columnPermutation {
+		if j < 0 {
+			continue
+		}
+		column := model.ExtraHandleName.O
+		if i < len(t.tableInfo.Core.Columns) {
+			column = t.tableInfo.Core.Columns[i].Name.O
+		}
+		kept = append(kept, keptField{field: j, column: column})
+	}
+	sort.Slice(kept, func(a, b int) bool { return kept[a].field < kept[b].field })
+
+	p := &fieldProjection{
+		fields:      make([]int, 0, len(kept)),
+		columns:     make([]string, 0, len(kept)),
+		permutation: make([]int, len(columnPermutation)),
+	}
+	for _, k := range kept {
+		p.fields = append(p.fields, k.field)
+		p.columns = append(p.columns, k.column)
+	}
+	for i, j := range columnPermutation {
+		p.permutation[i] = -1
+		if j >= 0 {
+			p.permutation[i] = sort.SearchInts(p.fields, j)
+		}
+	}
+	return p
+}
+
+func (p *fieldProjection) project(row []types.Datum) []types.Datum {
+	projected := make([]types.Datum, len(p.fields))
+	for i, j := range p.fields {
+		if j < len(row) {
+			projected[i] = row[j]
+		}
+	}
+	return projected
+}
+
+func (t *TableRestore) columnNames() []string {
+	names := make([]string, 0, len(t.tableInfo.Core.Columns))
+	for _, colInfo := range t.tableInfo.Core.Columns {
+		names = append(names, colInfo.Name.O)
+	}
+	return names
+}
+
+func joinOrNone(names []string) string {
+	if len(names) == 0 {
+		return "(none)"
+	}
+	return strings.Join(names, ", ")
 }
 
 func (tr *TableRestore) importKV(ctx context.Context, closedEngine *kv.ClosedEngine) error {
@@ -1441,6 +2964,66 @@ func (tr *TableRestore) compareChecksum(ctx context.Context, db *sql.DB, localCh
 	return nil
 }
 
+// importedRows estimates the number of rows imported from the local checksum.
+// Every row is encoded into one KV pair for the row data and one for every
+// index.
+func (tr *TableRestore) importedRows(localChecksum verify.KVChecksum) uint64 {
+	return localChecksum.SumKVS() / uint64(len(tr.encTable.Indices())+1)
+}
+
+// compareRowCount compares the row count of the table against the rows
+// imported, which is a much weaker verification than the checksum.
+func (tr *TableRestore) compareRowCount(ctx context.Context, db *sql.DB, localChecksum verify.KVChecksum) error {
+	localRows := tr.importedRows(localChecksum)
+
+	var remoteRows uint64
+	task := tr.logger.Begin(zap.InfoLevel, "remote row count")
+	err := common.SQLWithRetry{DB: db, Logger: tr.logger}.QueryRow(ctx, "count rows",
+		"SELECT COUNT(*) FROM "+tr.tableName, &remoteRows,
+	)
+	task.End(zap.ErrorLevel, err)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if remoteRows != localRows {
+		return errors.Errorf("row count mismatched remote vs local => (%d vs %d)", remoteRows, localRows)
+	}
+	tr.logger.Info("row count pass", zap.Uint64("rows", localRows))
+	return nil
+}
+
+// scatterRegions asks PD to scatter every region of the table once, so that
+// the regions are balanced across the stores much sooner than the normal
+// balancing would. The operators are one-off, so scattering again on resume
+// is harmless and leaves nothing behind in PD.
+func (tr *TableRestore) scatterRegions(ctx context.Context, rc *RestoreController) {
+	task := tr.logger.Begin(zap.InfoLevel, "scatter regions")
+	regions, err := rc.tidbMgr.getTableRegions(tr.dbInfo.Name, tr.tableInfo.Name)
+	if err != nil {
+		task.End(zap.WarnLevel, err)
+		return
+	}
+
+	seen := make(map[uint64]struct{}, len(regions))
+	failed := 0
+	for _, region := range regions {
+		if _, ok := seen[region.ID]; ok {
+			continue
+		}
+		seen[region.ID] = struct{}{}
+		if err = kv.ScatterRegion(ctx, &http.Client{}, rc.cfg.TiDB.PdURL, region.ID); err != nil {
+			if common.IsContextCanceledError(err) {
+				break
+			}
+			tr.logger.Warn("cannot scatter region", zap.Uint64("region", region.ID), log.ShortError(err))
+			failed++
+			err = nil
+		}
+	}
+	task.End(zap.WarnLevel, err, zap.Int("regions", len(seen)), zap.Int("failed", failed))
+}
+
 func (tr *TableRestore) analyzeTable(ctx context.Context, db *sql.DB) error {
 	task := tr.logger.Begin(zap.InfoLevel, "analyze")
 	err := common.SQLWithRetry{DB: db, Logger: tr.logger}.
@@ -1566,8 +3149,8 @@ func (cr *chunkRestore) deliverLoop(
 	rc *RestoreController,
 ) (deliverTotalDur time.Duration, err error) {
 	var channelClosed bool
-	dataKVs := rc.backend.MakeEmptyRows()
-	indexKVs := rc.backend.MakeEmptyRows()
+	dataKVs := rc.backendNamed(t.backendName).MakeEmptyRows()
+	indexKVs := rc.backendNamed(t.backendName).MakeEmptyRows()
 
 	deliverLogger := t.logger.With(
 		zap.Int32("engineNumber", engineID),
@@ -1578,7 +3161,7 @@ func (cr *chunkRestore) deliverLoop(
 
 	for !channelClosed {
 		var dataChecksum, indexChecksum verify.KVChecksum
-		var offset, rowID int64
+		var offset, rowID, rows int64
 		var columns []string
 
 		// Fetch enough KV pairs from the source.
@@ -1595,12 +3178,34 @@ func (cr *chunkRestore) deliverLoop(
 				columns = d.columns
 				offset = d.offset
 				rowID = d.rowID
+				rows++
 			case <-ctx.Done():
 				err = ctx.Err()
 				return
 			}
 		}
 
+		// Drop the KVs not going to be imported in this phase. They are still
+		// included in the checksum, which covers the whole table after all
+		// phases are done.
+		deliverSize := dataChecksum.SumSize() + indexChecksum.SumSize()
+		switch rc.cfg.TikvImporter.KVKind {
+		case config.KVKindData:
+			indexKVs = indexKVs.Clear()
+			deliverSize = dataChecksum.SumSize()
+		case config.KVKindIndex:
+			dataKVs = dataKVs.Clear()
+			deliverSize = indexChecksum.SumSize()
+		}
+
+		// Wait for the global write speed limit before sending out the KVs.
+		if rc.writeLimiter != nil {
+			if err = rc.writeLimiter.WaitN(ctx, int(deliverSize)); err != nil {
+				return
+			}
+			metric.WriteLimiterBytesCounter.Add(float64(deliverSize))
+		}
+
 		// Write KVs into the engine
 		start := time.Now()
 
@@ -1613,6 +3218,7 @@ func (cr *chunkRestore) deliverLoop(
 			return
 		}
 
+		rc.watchdog.progress()
 		deliverDur := time.Since(start)
 		deliverTotalDur += deliverDur
 		metric.BlockDeliverSecondsHistogram.Observe(deliverDur.Seconds())
@@ -1623,6 +3229,7 @@ func (cr *chunkRestore) deliverLoop(
 
 		dataKVs = dataKVs.Clear()
 		indexKVs = indexKVs.Clear()
+		rc.summary.addRows(t.tableName, rows)
 
 		// Update the table, and save a checkpoint.
 		// (the write to the importer is effective immediately, thus update these here)
@@ -1660,6 +3267,7 @@ func (cr *chunkRestore) saveCheckpoint(t *TableRestore, engineID int32, rc *Rest
 			RowID:    cr.chunk.Chunk.PrevRowIDMax,
 		},
 	}
+	rc.events.chunkProgress(t.tableName, engineID, cr.chunk.Key.Path, cr.chunk.Chunk.Offset)
 }
 
 func (cr *chunkRestore) encodeLoop(
@@ -1669,7 +3277,7 @@ func (cr *chunkRestore) encodeLoop(
 	logger log.Logger,
 	kvEncoder kv.Encoder,
 	deliverCompleteCh <-chan deliverResult,
-	pauser *common.Pauser,
+	pausers ...*common.Pauser,
 ) (readTotalDur time.Duration, encodeTotalDur time.Duration, err error) {
 	send := func(kvs deliveredKVs) error {
 		select {
@@ -1690,10 +3298,22 @@ func (cr *chunkRestore) encodeLoop(
 	}
 
 	initializedColumns := false
+	var binaryFields []bool
+	var textFields []string
+	var projection *fieldProjection
+	// only the first chunk of a file, restored from the start, can tell
+	// whether the whole file has no rows.
+	startOffset, _ := cr.parser.Pos()
+	fromFileStart := cr.chunk.Key.Offset == 0 && startOffset == 0
 outside:
 	for {
-		if err = pauser.Wait(ctx); err != nil {
-			return
+		for _, pauser := range pausers {
+			if pauser == nil {
+				continue
+			}
+			if err = pauser.Wait(ctx); err != nil {
+				return
+			}
 		}
 
 		offset, _ := cr.parser.Pos()
@@ -1709,7 +3329,23 @@ outside:
 		case nil:
 			if !initializedColumns {
 				if len(cr.chunk.ColumnPermutation) == 0 {
-					t.initializeColumns(columnNames, cr.chunk)
+					if err = t.initializeColumns(columnNames, cr.chunk); err != nil {
+						return
+					}
+				}
+				if len(columnNames) == 0 {
+					if err = t.checkValueCount(cr.parser.LastRow().Row, cr.chunk); err != nil {
+						return
+					}
+				}
+				if cr.convertor != nil {
+					binaryFields = t.binaryFields(cr.chunk.ColumnPermutation)
+				}
+				if cr.rejectNulBytes {
+					textFields = t.textFields(cr.chunk.ColumnPermutation)
+				}
+				if len(t.projection) > 0 {
+					projection = t.projectFields(cr.chunk.ColumnPermutation)
 				}
 				initializedColumns = true
 			}
@@ -1727,11 +3363,37 @@ outside:
 
 		// sql -> kv
 		lastRow := cr.parser.LastRow()
-		kvs, encodeErr := kvEncoder.Encode(logger, lastRow.Row, lastRow.RowID, cr.chunk.ColumnPermutation)
+		if err = checkNulBytes(lastRow.Row, textFields); err != nil {
+			err = errors.Annotatef(err, "in file %s at offset %d", &cr.chunk.Key, newOffset)
+			return
+		}
+		if err = cr.convertor.ConvertRow(lastRow.Row, binaryFields); err != nil {
+			err = errors.Annotatef(err, "in file %s at offset %d", &cr.chunk.Key, newOffset)
+			return
+		}
+		if err = cr.transformer.Transform(lastRow.Row, cr.chunk.ColumnPermutation); err != nil {
+			err = errors.Annotatef(err, "in file %s at offset %d", &cr.chunk.Key, newOffset)
+			return
+		}
+		row, columns, columnPermutation := lastRow.Row, columnNames, cr.chunk.ColumnPermutation
+		if projection != nil {
+			row, columns, columnPermutation = projection.project(row), projection.columns, projection.permutation
+		}
+		kvs, encodeErr := kvEncoder.Encode(logger, row, lastRow.RowID, columnPermutation)
 		encodeDur := time.Since(start)
 		encodeTotalDur += encodeDur
 		metric.RowEncodeSecondsHistogram.Observe(encodeDur.Seconds())
 
+		if encodeErr != nil && cr.skipOversizedRows && errors.Cause(encodeErr) == kv.ErrRowTooLarge {
+			logger.Warn("skipped oversized row",
+				zap.String("path", cr.chunk.Key.Path),
+				zap.Int64("offset", newOffset),
+				log.ShortError(encodeErr),
+			)
+			metric.OversizedRowsCounter.Inc()
+			cr.skippedRows++
+			continue
+		}
 		if encodeErr != nil {
 			// error is already logged inside kvEncoder.Encode(), just propagate up directly.
 			err = errors.Annotatef(encodeErr, "in file %s at offset %d", &cr.chunk.Key, newOffset)
@@ -1739,12 +3401,20 @@ outside:
 		}
 
 		deliverKvStart := time.Now()
-		if err = send(deliveredKVs{kvs: kvs, columns: columnNames, offset: newOffset, rowID: rowID}); err != nil {
+		if err = send(deliveredKVs{kvs: kvs, columns: columns, offset: newOffset, rowID: rowID}); err != nil {
 			return
 		}
 		metric.RowKVDeliverSecondsHistogram.Observe(time.Since(deliverKvStart).Seconds())
 	}
 
+	if fromFileStart && !initializedColumns {
+		if cr.emptyFileIsError {
+			err = errors.Errorf("data file %s contains no rows", cr.chunk.Key.Path)
+			return
+		}
+		logger.Warn("data file contains no rows, imported as empty", zap.String("path", cr.chunk.Key.Path))
+	}
+
 	err = send(deliveredKVs{kvs: nil})
 	return
 }
@@ -1756,8 +3426,35 @@ func (cr *chunkRestore) restore(
 	dataEngine, indexEngine *kv.OpenedEngine,
 	rc *RestoreController,
 ) error {
+	convertor, err := mydump.NewCharsetConvertor(
+		rc.cfg.DataCharacterSet(t.tableMeta.DB, t.tableMeta.Name),
+		rc.cfg.Mydumper.DataInvalidChar == config.DataInvalidCharReplace,
+	)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cr.convertor = convertor
+
 	// Create the encoder.
-	kvEncoder := rc.backend.NewEncoder(t.encTable, rc.cfg.TiDB.SQLMode, cr.chunk.Timestamp)
+	sessionOptions := &kv.SessionOptions{
+		SQLMode:               rc.cfg.TiDB.SQLMode,
+		Timestamp:             cr.chunk.Timestamp,
+		PreserveAutoIncrement: rc.cfg.Mydumper.PreserveAutoIncrement,
+		OutOfRange:            rc.cfg.TiDB.OutOfRange,
+		EnumSetFormat:         rc.cfg.Mydumper.EnumSetFormat,
+		TimeZone:              rc.cfg.TiDB.Location,
+		MaxRowSize:            rc.cfg.TikvImporter.MaxRowSize,
+	}
+	transformer, err := kv.NewRowTransformer(t.tableInfo.Core, rc.cfg.Transforms(t.tableMeta.DB, t.tableMeta.Name), sessionOptions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cr.transformer = transformer
+	encTable, err := t.encoderTable(cr.chunk.Key.Path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	kvEncoder := rc.backendNamed(t.backendName).NewEncoder(encTable, sessionOptions)
 	kvsCh := make(chan deliveredKVs, maxKVQueueSize)
 	deliverCompleteCh := make(chan deliverResult)
 
@@ -1768,6 +3465,7 @@ func (cr *chunkRestore) restore(
 	}()
 
 	go func() {
+		defer rc.revertTiKVModeOnPanic()
 		defer close(deliverCompleteCh)
 		dur, err := cr.deliverLoop(ctx, kvsCh, t, engineID, dataEngine, indexEngine, rc)
 		select {
@@ -1782,13 +3480,20 @@ func (cr *chunkRestore) restore(
 		zap.Stringer("path", &cr.chunk.Key),
 	).Begin(zap.InfoLevel, "restore file")
 
-	readTotalDur, encodeTotalDur, err := cr.encodeLoop(ctx, kvsCh, t, logTask.Logger, kvEncoder, deliverCompleteCh, rc.pauser)
+	readTotalDur, encodeTotalDur, err := cr.encodeLoop(ctx, kvsCh, t, logTask.Logger, kvEncoder, deliverCompleteCh, rc.pauser, rc.diskPauser)
+	rc.summary.addSkippedRows(t.tableName, cr.skippedRows)
 	if err != nil {
 		return err
 	}
 
 	select {
 	case deliverResult := <-deliverCompleteCh:
+		if deliverResult.err == nil {
+			metric.ChunkPhaseSecondsHistogram.WithLabelValues(metric.ChunkPhaseParse).Observe(readTotalDur.Seconds())
+			metric.ChunkPhaseSecondsHistogram.WithLabelValues(metric.ChunkPhaseEncode).Observe(encodeTotalDur.Seconds())
+			metric.ChunkPhaseSecondsHistogram.WithLabelValues(metric.ChunkPhaseIngest).Observe(deliverResult.totalDur.Seconds())
+			rc.summary.addPhaseDurations(t.tableName, readTotalDur, encodeTotalDur, deliverResult.totalDur)
+		}
 		logTask.End(zap.ErrorLevel, deliverResult.err,
 			zap.Duration("readDur", readTotalDur),
 			zap.Duration("encodeDur", encodeTotalDur),
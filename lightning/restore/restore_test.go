@@ -16,15 +16,20 @@ package restore
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	// "encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"path"
 	"sort"
+	"strings"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	gomysql "github.com/go-sql-driver/mysql"
 	"github.com/golang/mock/gomock"
 	. "github.com/pingcap/check"
 	"github.com/pingcap/errors"
@@ -41,9 +46,12 @@ import (
 	"github.com/pingcap/tidb-lightning/lightning/log"
 	"github.com/pingcap/tidb-lightning/lightning/mydump"
 	"github.com/pingcap/tidb-lightning/lightning/verification"
+	"github.com/pingcap/tidb-lightning/lightning/web"
 	"github.com/pingcap/tidb-lightning/lightning/worker"
 	"github.com/pingcap/tidb-lightning/mock"
 	"github.com/pingcap/tidb/ddl"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
 	"github.com/pingcap/tidb/util/kvencoder"
 	tmock "github.com/pingcap/tidb/util/mock"
 	"github.com/satori/go.uuid"
@@ -103,6 +111,139 @@ func (s *restoreSuite) TestNewTableRestoreFailure(c *C) {
 	c.Assert(err, ErrorMatches, `failed to tables\.TableFromMeta.*`)
 }
 
+func (s *restoreSuite) TestOrderTablesByForeignKeys(c *C) {
+	p := parser.New()
+	se := tmock.NewContext()
+
+	mockTables := func(createStmts map[string]string) (*TidbDBInfo, []*mydump.MDTableMeta) {
+		dbInfo := &TidbDBInfo{Name: "mockdb", Tables: map[string]*TidbTableInfo{}}
+		var tables []*mydump.MDTableMeta
+		for _, name := range []string{"orders", "customers", "regions"} {
+			createStmt, ok := createStmts[name]
+			if !ok {
+				continue
+			}
+			node, err := p.ParseOneStmt(createStmt, "utf8mb4", "utf8mb4_bin")
+			c.Assert(err, IsNil)
+			tableInfo, err := ddl.MockTableInfo(se, node.(*ast.CreateTableStmt), int64(len(tables)+1))
+			c.Assert(err, IsNil)
+			dbInfo.Tables[name] = &TidbTableInfo{Name: name, Core: tableInfo}
+			tables = append(tables, &mydump.MDTableMeta{DB: "mockdb", Name: name})
+		}
+		return dbInfo, tables
+	}
+	names := func(tables []*mydump.MDTableMeta) []string {
+		res := make([]string, 0, len(tables))
+		for _, t := range tables {
+			res = append(res, t.Name)
+		}
+		return res
+	}
+
+	// orders -> customers -> regions
+	dbInfo, tables := mockTables(map[string]string{
+		"orders":    "CREATE TABLE orders (id int primary key, cid int, FOREIGN KEY (cid) REFERENCES customers(id))",
+		"customers": "CREATE TABLE customers (id int primary key, rid int, FOREIGN KEY (rid) REFERENCES Regions(id))",
+		"regions":   "CREATE TABLE regions (id int primary key, parent int, FOREIGN KEY (parent) REFERENCES regions(id))",
+	})
+	c.Assert(names(orderTablesByForeignKeys(tables, dbInfo)), DeepEquals, []string{"regions", "customers", "orders"})
+
+	// references to tables not being imported are ignored.
+	dbInfo, tables = mockTables(map[string]string{
+		"orders":    "CREATE TABLE orders (id int primary key, cid int, FOREIGN KEY (cid) REFERENCES customers(id))",
+		"customers": "CREATE TABLE customers (id int primary key, rid int, FOREIGN KEY (rid) REFERENCES regions(id))",
+	})
+	c.Assert(names(orderTablesByForeignKeys(tables, dbInfo)), DeepEquals, []string{"customers", "orders"})
+
+	// cycles fall back to the original order.
+	dbInfo, tables = mockTables(map[string]string{
+		"orders":    "CREATE TABLE orders (id int primary key, cid int, FOREIGN KEY (cid) REFERENCES customers(id))",
+		"customers": "CREATE TABLE customers (id int primary key, rid int, FOREIGN KEY (rid) REFERENCES regions(id))",
+		"regions":   "CREATE TABLE regions (id int primary key, oid int, FOREIGN KEY (oid) REFERENCES orders(id))",
+	})
+	c.Assert(names(orderTablesByForeignKeys(tables, dbInfo)), DeepEquals, []string{"orders", "customers", "regions"})
+}
+
+func (s *restoreSuite) TestOrderTablesByPriority(c *C) {
+	p := parser.New()
+	se := tmock.NewContext()
+
+	// orders references customers.
+	dbMetas := []*mydump.MDDatabaseMeta{
+		{Name: "db1", Tables: []*mydump.MDTableMeta{{DB: "db1", Name: "orders"}, {DB: "db1", Name: "customers"}, {DB: "db1", Name: "items"}}},
+		{Name: "db2", Tables: []*mydump.MDTableMeta{{DB: "db2", Name: "logs"}, {DB: "db2", Name: "events"}}},
+	}
+	createStmts := map[string]string{
+		"orders":    "CREATE TABLE orders (id int primary key, cid int, FOREIGN KEY (cid) REFERENCES customers(id))",
+		"customers": "CREATE TABLE customers (id int primary key)",
+		"items":     "CREATE TABLE items (id int primary key)",
+		"logs":      "CREATE TABLE logs (id int primary key)",
+		"events":    "CREATE TABLE events (id int primary key)",
+	}
+	dbInfos := make(map[string]*TidbDBInfo)
+	for _, dbMeta := range dbMetas {
+		dbInfo := &TidbDBInfo{Name: dbMeta.Name, Tables: map[string]*TidbTableInfo{}}
+		for i, tableMeta := range dbMeta.Tables {
+			node, err := p.ParseOneStmt(createStmts[tableMeta.Name], "utf8mb4", "utf8mb4_bin")
+			c.Assert(err, IsNil)
+			tableInfo, err := ddl.MockTableInfo(se, node.(*ast.CreateTableStmt), int64(i+1))
+			c.Assert(err, IsNil)
+			dbInfo.Tables[tableMeta.Name] = &TidbTableInfo{Name: tableMeta.Name, Core: tableInfo}
+		}
+		dbInfos[dbMeta.Name] = dbInfo
+	}
+	names := func(tables []*mydump.MDTableMeta) []string {
+		res := make([]string, 0, len(tables))
+		for _, t := range tables {
+			res = append(res, t.DB+"."+t.Name)
+		}
+		return res
+	}
+
+	// without priorities, the databases are imported in order.
+	noPriority := func(string, string) int { return 0 }
+	c.Assert(names(orderTables(dbMetas, dbInfos, noPriority)), DeepEquals, []string{
+		"db1.customers", "db1.orders", "db1.items", "db2.logs", "db2.events",
+	})
+
+	// orders is prioritized together with the customers it references.
+	priorities := map[string]int{"db1.orders": 5, "db2.events": 10, "db1.items": -1}
+	c.Assert(names(orderTables(dbMetas, dbInfos, func(schema, table string) int {
+		return priorities[schema+"."+table]
+	})), DeepEquals, []string{
+		"db2.events", "db1.customers", "db1.orders", "db2.logs", "db1.items",
+	})
+}
+
+func (s *restoreSuite) TestTolerateSchemaErrors(c *C) {
+	cfg := config.NewConfig()
+	cfg.App.MaxSchemaErrors = 1
+	dbMetas := []*mydump.MDDatabaseMeta{
+		{Name: "db", Tables: []*mydump.MDTableMeta{{DB: "db", Name: "t1"}, {DB: "db", Name: "t2"}}},
+		{Name: "db2", Tables: []*mydump.MDTableMeta{{DB: "db2", Name: "t3"}}},
+	}
+	rc := &RestoreController{cfg: cfg, dbMetas: dbMetas, summary: newImportSummary(dbMetas)}
+
+	// the cancellation is not an error of the schema.
+	c.Assert(rc.tolerateSchemaError("`db`.`t1`", context.Canceled), Equals, context.Canceled)
+	c.Assert(rc.tolerateSchemaError("`db`.`t1`", errors.Annotate(context.DeadlineExceeded, "create table")), ErrorMatches, "create table: context deadline exceeded")
+	c.Assert(rc.tolerateSchemaError("`db`.`t2`", errors.New("reserved word")), IsNil)
+	// the same table failing again is not counted twice.
+	c.Assert(rc.tolerateSchemaError("`db`.`t2`", errors.New("reserved word")), IsNil)
+	c.Assert(rc.tolerateSchemaError("`db2`.`t3`", errors.New("syntax error")), ErrorMatches, "syntax error")
+
+	rc.skipSchemaErrorTables()
+	c.Assert(rc.dbMetas, HasLen, 2)
+	c.Assert(rc.dbMetas[0].Tables, DeepEquals, []*mydump.MDTableMeta{dbMetas[0].Tables[0]})
+	c.Assert(rc.dbMetas[1].Tables, HasLen, 1)
+	c.Assert(dbMetas[0].Tables, HasLen, 2)
+
+	report := rc.summary.report(nil)
+	c.Assert(report.Tables[2].Table, Equals, "`db`.`t2`")
+	c.Assert(report.Tables[2].Status, Equals, summaryStatusSkipped)
+	c.Assert(report.Tables[2].Error, Equals, "reserved word")
+}
+
 func (s *restoreSuite) TestErrorSummaries(c *C) {
 	logger, buffer := log.MakeTestLogger()
 
@@ -265,6 +406,251 @@ func (s *restoreSuite) TestDoChecksumWithErrorAndLongOriginalLifetime(c *C) {
 	c.Assert(mock.ExpectationsWereMet(), IsNil)
 }
 
+func (s *restoreSuite) TestCheckStoreDisk(c *C) {
+	var available string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/pd/api/v1/stores" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"count":2,"stores":[
+			{"store":{"id":1,"address":"127.0.0.1:20160","state_name":"Up"},"status":{"capacity":"100GiB","available":"50GiB"}},
+			{"store":{"id":2,"address":"127.0.0.1:20161","state_name":"Up"},"status":{"capacity":"100GiB","available":%q}}
+		]}`, available)
+	}))
+	defer server.Close()
+
+	cfg := config.NewConfig()
+	cfg.TiDB.PdURL = server.URL
+	cfg.TikvImporter.MinStoreAvailableRatio = 0.1
+	rc := &RestoreController{cfg: cfg, diskPauser: common.NewPauser()}
+
+	available = "20GiB"
+	rc.checkStoreDisk(context.Background())
+	c.Assert(rc.diskPauser.IsPaused(), IsFalse)
+
+	available = "5GiB"
+	rc.checkStoreDisk(context.Background())
+	c.Assert(rc.diskPauser.IsPaused(), IsTrue)
+	rc.checkStoreDisk(context.Background())
+	c.Assert(rc.diskPauser.IsPaused(), IsTrue)
+
+	available = "15GiB"
+	rc.checkStoreDisk(context.Background())
+	c.Assert(rc.diskPauser.IsPaused(), IsFalse)
+}
+
+func (s *restoreSuite) TestDisableSwitchMode(c *C) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, `{"count":0,"stores":[]}`)
+	}))
+	defer server.Close()
+
+	cfg := config.NewConfig()
+	cfg.TiDB.PdURL = server.URL
+	cfg.App.RevertModeOnExit = true
+	cfg.TikvImporter.DisableSwitchMode = true
+	rc := &RestoreController{cfg: cfg}
+	ctx := context.Background()
+
+	// neither switching nor reverting the mode contacts the cluster.
+	rc.switchToImportMode(ctx)
+	c.Assert(atomic.LoadInt32(&rc.importModeSwitched), Equals, int32(0))
+	rc.revertTiKVModeOnExit("test")
+	c.Assert(rc.switchToNormalMode(ctx), IsNil)
+	c.Assert(atomic.LoadInt32(&requests), Equals, int32(0))
+
+	cfg.TikvImporter.DisableSwitchMode = false
+	rc.switchToImportMode(ctx)
+	c.Assert(atomic.LoadInt32(&rc.importModeSwitched), Equals, int32(1))
+	c.Assert(atomic.LoadInt32(&requests), Greater, int32(0))
+}
+
+func (s *restoreSuite) TestRevertModeTimeout(c *C) {
+	cfg := config.NewConfig()
+	cfg.TiDB.PdRetryCount = 3
+	cfg.TiDB.PdRetryBackoff.Duration = time.Second
+	cfg.TiDB.StoreJitter.Duration = 5 * time.Second
+	cfg.TiDB.StoreStateGrace.Duration = 0
+	rc := &RestoreController{cfg: cfg}
+
+	// the store list is retried after 1s and 2s, then a store waits up to the jitter.
+	c.Assert(rc.revertModeTimeout(), Equals, revertModeBaseTimeout+3*time.Second+5*time.Second)
+
+	// a store excluded by its state waits for the grace period and is retried again.
+	cfg.TiDB.StoreStateGrace.Duration = 4 * time.Second
+	c.Assert(rc.revertModeTimeout(), Equals, revertModeBaseTimeout+3*time.Second+7*time.Second)
+}
+
+type countingCheckpointsDB struct {
+	*FileCheckpointsDB
+	updates int32
+}
+
+func (cpdb *countingCheckpointsDB) Update(checkpointDiffs map[string]*TableCheckpointDiff) {
+	atomic.AddInt32(&cpdb.updates, 1)
+	cpdb.FileCheckpointsDB.Update(checkpointDiffs)
+}
+
+func (s *restoreSuite) TestCheckpointFlushInterval(c *C) {
+	ctx := context.Background()
+	const tableName = "`db`.`t`"
+	key := ChunkCheckpointKey{Path: "/tmp/db.t.sql"}
+
+	cpdb := &countingCheckpointsDB{FileCheckpointsDB: NewFileCheckpointsDB(path.Join(c.MkDir(), "cp.pb"))}
+	c.Assert(cpdb.Initialize(ctx, map[string]*TidbDBInfo{"db": {
+		Name:   "db",
+		Tables: map[string]*TidbTableInfo{"t": {Name: "t"}},
+	}}), IsNil)
+	c.Assert(cpdb.InsertEngineCheckpoints(ctx, tableName, map[int32]*EngineCheckpoint{
+		0: {Status: CheckpointStatusLoaded, Chunks: []*ChunkCheckpoint{{Key: key}}},
+	}), IsNil)
+	cp, err := cpdb.Get(ctx, tableName)
+	c.Assert(err, IsNil)
+	web.BroadcastInitProgress([]*mydump.MDDatabaseMeta{{Name: "db", Tables: []*mydump.MDTableMeta{{DB: "db", Name: "t"}}}})
+	web.BroadcastTableCheckpoint(tableName, cp)
+
+	cfg := config.NewConfig()
+	cfg.Checkpoint.FlushInterval.Duration = time.Hour
+	rc := &RestoreController{
+		cfg:           cfg,
+		saveCpCh:      make(chan saveCp),
+		checkpointsDB: cpdb,
+	}
+	done := make(chan struct{})
+	go func() {
+		rc.listenCheckpointUpdates()
+		close(done)
+	}()
+
+	// the updates after the first write are held back until the interval
+	// passes or the listener stops.
+	for pos := int64(1); pos <= 10; pos++ {
+		rc.saveCpCh <- saveCp{
+			tableName: tableName,
+			merger:    &ChunkCheckpointMerger{EngineID: 0, Key: key, Pos: pos},
+		}
+	}
+	close(rc.saveCpCh)
+	<-done
+	rc.checkpointsWg.Wait()
+
+	c.Assert(atomic.LoadInt32(&cpdb.updates), LessEqual, int32(2))
+	cp, err = cpdb.Get(ctx, tableName)
+	c.Assert(err, IsNil)
+	c.Assert(cp.Engines[0].Chunks[0].Chunk.Offset, Equals, int64(10))
+}
+
+func (s *restoreSuite) TestCheckTablesEmpty(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	ctx := context.Background()
+
+	cfg := config.NewConfig()
+	cfg.TikvImporter.Backend = config.BackendImporter
+	cfg.TableConfigs = []*config.TableConfig{{Pattern: "db.by_tidb", Backend: config.BackendTiDB}}
+	cpdb := NewFileCheckpointsDB(path.Join(c.MkDir(), "cp.pb"))
+	c.Assert(cpdb.Initialize(ctx, map[string]*TidbDBInfo{
+		"db": {Name: "db", Tables: map[string]*TidbTableInfo{
+			"empty":    {Name: "empty"},
+			"full":     {Name: "full"},
+			"started":  {Name: "started"},
+			"by_tidb":  {Name: "by_tidb"},
+			"full_too": {Name: "full_too"},
+		}},
+	}), IsNil)
+	c.Assert(cpdb.InsertEngineCheckpoints(ctx, "`db`.`started`", map[int32]*EngineCheckpoint{
+		0: {Status: CheckpointStatusLoaded},
+	}), IsNil)
+	rc := &RestoreController{
+		cfg: cfg,
+		dbMetas: []*mydump.MDDatabaseMeta{{Name: "db", Tables: []*mydump.MDTableMeta{
+			{DB: "db", Name: "empty"},
+			{DB: "db", Name: "full"},
+			{DB: "db", Name: "started"},
+			{DB: "db", Name: "by_tidb"},
+			{DB: "db", Name: "full_too"},
+		}}},
+		checkpointsDB: cpdb,
+	}
+	tidbMgr := &TiDBManager{db: db}
+
+	// tables started in a previous run or imported by TiDB are not checked.
+	mock.ExpectQuery("\\QSELECT EXISTS (SELECT 1 FROM `db`.`empty` LIMIT 1)\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"EXISTS"}).AddRow(false))
+	mock.ExpectQuery("\\QSELECT EXISTS (SELECT 1 FROM `db`.`full` LIMIT 1)\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"EXISTS"}).AddRow(true))
+	mock.ExpectQuery("\\QSELECT EXISTS (SELECT 1 FROM `db`.`full_too` LIMIT 1)\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"EXISTS"}).AddRow(true))
+	err = rc.checkTablesEmpty(ctx, tidbMgr)
+	c.Assert(err, ErrorMatches, "target tables `db`.`full`, `db`.`full_too` are not empty.*")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// the index phase of a two-phase import runs on the tables filled by the
+	// data phase.
+	cfg.TikvImporter.KVKind = config.KVKindIndex
+	c.Assert(rc.checkTablesEmpty(ctx, tidbMgr), IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	cfg.TikvImporter.KVKind = config.KVKindAll
+	cfg.TikvImporter.IncrementalImport = true
+	c.Assert(rc.checkTablesEmpty(ctx, tidbMgr), IsNil)
+
+	mock.ExpectClose()
+	c.Assert(db.Close(), IsNil)
+}
+
+func (s *restoreSuite) TestCheckDatabasesExist(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	ctx := context.Background()
+
+	cfg := config.NewConfig()
+	rc := &RestoreController{
+		cfg: cfg,
+		dbMetas: []*mydump.MDDatabaseMeta{
+			{Name: "Existing"},
+			{Name: "missing"},
+			{Name: "missing_too"},
+		},
+	}
+	tidbMgr := &TiDBManager{db: db}
+	expectShowDatabases := func(lowerCaseTableNames int) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("\\QSELECT @@lower_case_table_names\\E").
+			WillReturnRows(sqlmock.NewRows([]string{"@@lower_case_table_names"}).AddRow(lowerCaseTableNames))
+		mock.ExpectQuery("SHOW DATABASES").
+			WillReturnRows(sqlmock.NewRows([]string{"Database"}).AddRow("mysql").AddRow("existing").AddRow("missing_too"))
+		mock.ExpectCommit()
+	}
+
+	// the databases are created by the data source unless with no-schema.
+	c.Assert(rc.checkDatabasesExist(ctx, tidbMgr), IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	cfg.Mydumper.NoSchema = true
+	expectShowDatabases(1)
+	err = rc.checkDatabasesExist(ctx, tidbMgr)
+	c.Assert(err, ErrorMatches, "target databases `missing` do not exist.*")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// names are case-sensitive with lower_case_table_names = 0.
+	expectShowDatabases(0)
+	err = rc.checkDatabasesExist(ctx, tidbMgr)
+	c.Assert(err, ErrorMatches, "target databases `Existing`, `missing` do not exist.*")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	rc.dbMetas = rc.dbMetas[2:]
+	expectShowDatabases(0)
+	c.Assert(rc.checkDatabasesExist(ctx, tidbMgr), IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	mock.ExpectClose()
+	c.Assert(db.Close(), IsNil)
+}
+
 func (s *restoreSuite) TestSetSessionConcurrencyVars(c *C) {
 	db, mock, err := sqlmock.New()
 	c.Assert(err, IsNil)
@@ -361,6 +747,134 @@ func (s *tableRestoreSuite) SetUpTest(c *C) {
 	s.cfg.App.TableConcurrency = 2
 }
 
+func (s *tableRestoreSuite) TestCheckSchemaChanges(c *C) {
+	ctx := context.Background()
+	cpdb := NewFileCheckpointsDB(path.Join(c.MkDir(), "cp.pb"))
+	c.Assert(cpdb.Initialize(ctx, map[string]*TidbDBInfo{"db": s.dbInfo}), IsNil)
+
+	alteredCore := *s.tableInfo.Core
+	alteredCore.Columns = append([]*model.ColumnInfo{}, alteredCore.Columns...)
+	alteredColumn := *alteredCore.Columns[2]
+	alteredColumn.Name = model.NewCIStr("d")
+	alteredCore.Columns[2] = &alteredColumn
+	alteredDBInfo := &TidbDBInfo{
+		Name:   "db",
+		Tables: map[string]*TidbTableInfo{"table": {Name: "table", Core: &alteredCore}},
+	}
+
+	rc := &RestoreController{
+		cfg:           s.cfg,
+		dbMetas:       []*mydump.MDDatabaseMeta{{Name: "db", Tables: []*mydump.MDTableMeta{s.tableMeta}}},
+		dbInfos:       map[string]*TidbDBInfo{"db": s.dbInfo},
+		checkpointsDB: cpdb,
+	}
+
+	// unchanged schema
+	reimport, err := rc.checkSchemaChanges(ctx, nil)
+	c.Assert(err, IsNil)
+	c.Assert(reimport, IsFalse)
+
+	// changed before anything is imported, the checkpoint is simply reset.
+	rc.dbInfos = map[string]*TidbDBInfo{"db": alteredDBInfo}
+	reimport, err = rc.checkSchemaChanges(ctx, nil)
+	c.Assert(err, IsNil)
+	c.Assert(reimport, IsTrue)
+	c.Assert(cpdb.Initialize(ctx, rc.dbInfos), IsNil)
+	cp, err := cpdb.Get(ctx, "`db`.`table`")
+	c.Assert(err, IsNil)
+	c.Assert(cp.Hash, DeepEquals, TableSchemaHash(&alteredCore))
+
+	// changed in the middle of the import
+	diff := NewTableCheckpointDiff()
+	(&StatusCheckpointMerger{EngineID: WholeTableEngineID, Status: CheckpointStatusAllWritten}).MergeInto(diff)
+	cpdb.Update(map[string]*TableCheckpointDiff{"`db`.`table`": diff})
+	rc.dbInfos = map[string]*TidbDBInfo{"db": s.dbInfo}
+	_, err = rc.checkSchemaChanges(ctx, nil)
+	c.Assert(err, ErrorMatches, "the schema of the tables `db`.`table` has been changed since the checkpoints were created.*")
+}
+
+func (s *tableRestoreSuite) TestCheckBackendChanges(c *C) {
+	ctx := context.Background()
+	cpdb := NewFileCheckpointsDB(path.Join(c.MkDir(), "cp.pb"))
+	c.Assert(cpdb.Initialize(ctx, map[string]*TidbDBInfo{"db": s.dbInfo}), IsNil)
+	insertEngines := func(backend string) {
+		err := cpdb.InsertEngineCheckpoints(ctx, "`db`.`table`", map[int32]*EngineCheckpoint{
+			0:  {Status: CheckpointStatusLoaded, Backend: backend},
+			-1: {Status: CheckpointStatusLoaded, Backend: backend},
+		})
+		c.Assert(err, IsNil)
+	}
+	setStatus := func(status CheckpointStatus) {
+		diff := NewTableCheckpointDiff()
+		(&StatusCheckpointMerger{EngineID: WholeTableEngineID, Status: status}).MergeInto(diff)
+		cpdb.Update(map[string]*TableCheckpointDiff{"`db`.`table`": diff})
+	}
+
+	s.cfg.TikvImporter.Backend = config.BackendImporter
+	rc := &RestoreController{
+		cfg:           s.cfg,
+		dbMetas:       []*mydump.MDDatabaseMeta{{Name: "db", Tables: []*mydump.MDTableMeta{s.tableMeta}}},
+		dbInfos:       map[string]*TidbDBInfo{"db": s.dbInfo},
+		checkpointsDB: cpdb,
+	}
+
+	// same backend
+	insertEngines(config.BackendImporter)
+	setStatus(CheckpointStatusAllWritten)
+	reimport, err := rc.checkBackendChanges(ctx, nil)
+	c.Assert(err, IsNil)
+	c.Assert(reimport, IsFalse)
+
+	// changed before anything is written, the checkpoint is simply reset.
+	c.Assert(cpdb.RemoveCheckpoint(ctx, "`db`.`table`"), IsNil)
+	c.Assert(cpdb.Initialize(ctx, rc.dbInfos), IsNil)
+	insertEngines(config.BackendTiDB)
+	reimport, err = rc.checkBackendChanges(ctx, nil)
+	c.Assert(err, IsNil)
+	c.Assert(reimport, IsTrue)
+	c.Assert(cpdb.Initialize(ctx, rc.dbInfos), IsNil)
+	cp, err := cpdb.Get(ctx, "`db`.`table`")
+	c.Assert(err, IsNil)
+	c.Assert(cp.Engines, HasLen, 0)
+
+	// changed in the middle of the import
+	insertEngines(config.BackendTiDB)
+	setStatus(CheckpointStatusAllWritten)
+	_, err = rc.checkBackendChanges(ctx, nil)
+	c.Assert(err, ErrorMatches, "the tables `db`.`table` \\(tidb\\) have been partially imported by another backend.*")
+
+	// changed in the middle of the import, the table is dropped together with
+	// its engines and imported again.
+	db, sqlMock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	sqlMock.
+		ExpectExec("\\QDROP TABLE `db`.`table`\\E").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	controller := gomock.NewController(c)
+	defer controller.Finish()
+	mockBackend := mock.NewMockBackend(controller)
+	mockBackend.EXPECT().CloseEngine(ctx, gomock.Any()).Return(nil).Times(2)
+	mockBackend.EXPECT().CleanupEngine(ctx, gomock.Any()).Return(nil).Times(2)
+	rc.backends = map[string]kv.Backend{config.BackendTiDB: kv.MakeBackend(mockBackend)}
+	s.cfg.Checkpoint.OnBackendChange = config.BackendChangeReimport
+	reimport, err = rc.checkBackendChanges(ctx, NewTiDBManagerWithDB(db, nil, mysql.ModeNone))
+	c.Assert(err, IsNil)
+	c.Assert(reimport, IsTrue)
+	c.Assert(sqlMock.ExpectationsWereMet(), IsNil)
+	c.Assert(cpdb.Initialize(ctx, rc.dbInfos), IsNil)
+	cp, err = cpdb.Get(ctx, "`db`.`table`")
+	c.Assert(err, IsNil)
+	c.Assert(cp.Status, Equals, CheckpointStatusLoaded)
+	c.Assert(cp.Engines, HasLen, 0)
+
+	// changed after all engines are imported
+	insertEngines(config.BackendTiDB)
+	setStatus(CheckpointStatusIndexImported)
+	reimport, err = rc.checkBackendChanges(ctx, nil)
+	c.Assert(err, IsNil)
+	c.Assert(reimport, IsFalse)
+}
+
 func (s *tableRestoreSuite) TestPopulateChunks(c *C) {
 	failpoint.Enable("github.com/pingcap/tidb-lightning/lightning/restore/PopulateChunkTimestamp", "return(1234567897)")
 	defer failpoint.Disable("github.com/pingcap/tidb-lightning/lightning/restore/PopulateChunkTimestamp")
@@ -368,7 +882,7 @@ func (s *tableRestoreSuite) TestPopulateChunks(c *C) {
 	cp := &TableCheckpoint{
 		Engines: make(map[int32]*EngineCheckpoint),
 	}
-	err := s.tr.populateChunks(s.cfg, cp)
+	err := s.tr.populateChunks(s.cfg, cp, worker.NewPool(context.Background(), 1, "io"))
 	c.Assert(err, IsNil)
 
 	c.Assert(cp.Engines, DeepEquals, map[int32]*EngineCheckpoint{
@@ -448,22 +962,235 @@ func (s *tableRestoreSuite) TestPopulateChunks(c *C) {
 	})
 }
 
+func (s *tableRestoreSuite) TestPreserveAutoIncrementAcrossChunks(c *C) {
+	p := parser.New()
+	se := tmock.NewContext()
+	node, err := p.ParseOneStmt("CREATE TABLE auto (id BIGINT PRIMARY KEY AUTO_INCREMENT, v INT)", "", "")
+	c.Assert(err, IsNil)
+	core, err := ddl.MockTableInfo(se, node.(*ast.CreateTableStmt), 0xabcdef)
+	c.Assert(err, IsNil)
+	core.State = model.StatePublic
+
+	// the largest explicit ID is in the second file, after the first file has
+	// rows without an ID.
+	dataDir := c.MkDir()
+	var dataFiles []string
+	for i, content := range []string{
+		"INSERT INTO `auto` VALUES (NULL, 1), (NULL, 2), (5, 3);",
+		"INSERT INTO `auto` VALUES (1000, 4), (NULL, 5);",
+		"INSERT INTO `auto` (v) VALUES (6);",
+		"INSERT INTO `auto` VALUES (300, 7), (NULL, 8);",
+	} {
+		dataPath := path.Join(dataDir, fmt.Sprintf("db.auto.%d.sql", i+1))
+		c.Assert(ioutil.WriteFile(dataPath, []byte(content), 0644), IsNil)
+		dataFiles = append(dataFiles, dataPath)
+	}
+	tableMeta := &mydump.MDTableMeta{DB: "db", Name: "auto", TotalSize: 200, DataFiles: dataFiles}
+	tr, err := NewTableRestore("`db`.`auto`", tableMeta, s.dbInfo, &TidbTableInfo{Name: "auto", Core: core}, &TableCheckpoint{})
+	c.Assert(err, IsNil)
+	web.BroadcastInitProgress([]*mydump.MDDatabaseMeta{{Name: "db", Tables: []*mydump.MDTableMeta{tableMeta}}})
+
+	// the first attempt stops after populating the chunks.
+	controller := gomock.NewController(c)
+	defer controller.Finish()
+	mockBackend := mock.NewMockBackend(controller)
+	mockBackend.EXPECT().OpenEngine(gomock.Any(), gomock.Any()).Return(errors.New("mock open failure"))
+
+	ctx := context.Background()
+	s.cfg.Mydumper.PreserveAutoIncrement = true
+	rc := &RestoreController{
+		cfg:           s.cfg,
+		backend:       kv.MakeBackend(mockBackend),
+		ioWorkers:     worker.NewPool(ctx, 1, "io"),
+		indexWorkers:  worker.NewPool(ctx, 1, "index"),
+		checkpointsDB: NewNullCheckpointsDB(),
+		saveCpCh:      make(chan saveCp, 16),
+	}
+	cp := &TableCheckpoint{Engines: make(map[int32]*EngineCheckpoint)}
+	c.Assert(tr.restoreTable(ctx, rc, cp), ErrorMatches, "mock open failure")
+	c.Assert(cp.AllocBase, Equals, int64(1000))
+	c.Assert((<-rc.saveCpCh).merger, DeepEquals, &RebaseCheckpointMerger{AllocBase: 1000})
+	c.Assert(tr.alloc.Base(), Equals, int64(1000))
+
+	// encoding the chunks in order allocates the IDs of the 5 rows without one
+	// after 1000, instead of reusing 1 or 2 before the row with ID 1000 is seen.
+	kvEncoder := kv.NewTableKVEncoder(tr.encTable, &kv.SessionOptions{
+		SQLMode:               s.cfg.TiDB.SQLMode,
+		Timestamp:             1234567890,
+		PreserveAutoIncrement: true,
+	})
+	engineIDs := make([]int, 0, len(cp.Engines))
+	for engineID := range cp.Engines {
+		engineIDs = append(engineIDs, int(engineID))
+	}
+	sort.Ints(engineIDs)
+	for _, engineID := range engineIDs {
+		for _, chunk := range cp.Engines[int32(engineID)].Chunks {
+			cr, err := newChunkRestore(ctx, 0, s.cfg, chunk, rc.ioWorkers)
+			c.Assert(err, IsNil)
+			kvsCh := make(chan deliveredKVs, 4)
+			_, _, err = cr.encodeLoop(ctx, kvsCh, tr, tr.logger, kvEncoder, make(chan deliverResult))
+			cr.close()
+			c.Assert(err, IsNil)
+		}
+	}
+	c.Assert(tr.alloc.Base(), Equals, int64(1005))
+}
+
 func (s *tableRestoreSuite) TestInitializeColumns(c *C) {
 	ccp := &ChunkCheckpoint{}
-	s.tr.initializeColumns(nil, ccp)
+	c.Assert(s.tr.initializeColumns(nil, ccp), IsNil)
 	c.Assert(ccp.ColumnPermutation, DeepEquals, []int{0, 1, 2, -1})
 
 	ccp.ColumnPermutation = nil
-	s.tr.initializeColumns([]string{"b", "c", "a"}, ccp)
+	c.Assert(s.tr.initializeColumns([]string{"b", "c", "a"}, ccp), IsNil)
 	c.Assert(ccp.ColumnPermutation, DeepEquals, []int{2, 0, 1, -1})
 
 	ccp.ColumnPermutation = nil
-	s.tr.initializeColumns([]string{"b"}, ccp)
+	c.Assert(s.tr.initializeColumns([]string{"b"}, ccp), IsNil)
 	c.Assert(ccp.ColumnPermutation, DeepEquals, []int{-1, 0, -1, -1})
 
 	ccp.ColumnPermutation = nil
-	s.tr.initializeColumns([]string{"_tidb_rowid", "b", "a", "c"}, ccp)
+	c.Assert(s.tr.initializeColumns([]string{"_tidb_rowid", "b", "a", "c"}, ccp), IsNil)
 	c.Assert(ccp.ColumnPermutation, DeepEquals, []int{2, 1, 3, 0})
+
+	ccp.ColumnPermutation = nil
+	ccp.Key.Path = "db.table.1.csv"
+	err := s.tr.initializeColumns([]string{"b", "x", "a", "y"}, ccp)
+	c.Assert(err, ErrorMatches, "column mismatch in file db.table.1.csv:0: table `db`.`table` has 3 columns \\(a, b, c\\), "+
+		"but the file provides 4 columns \\(b, x, a, y\\); extra columns: x, y; missing columns: c")
+	c.Assert(ccp.ColumnPermutation, IsNil)
+}
+
+func (s *tableRestoreSuite) TestColumnProjection(c *C) {
+	p := parser.New()
+	se := tmock.NewContext()
+	node, err := p.ParseOneStmt(`
+		CREATE TABLE wide (
+			id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+			c1 INT, c2 INT, c3 VARCHAR(10), c4 INT NOT NULL DEFAULT 4,
+			c5 INT, c6 INT, c7 INT, c8 INT,
+			c9 INT NOT NULL
+		)
+	`, "", "")
+	c.Assert(err, IsNil)
+	core, err := ddl.MockTableInfo(se, node.(*ast.CreateTableStmt), 0xabcdef)
+	c.Assert(err, IsNil)
+	core.State = model.StatePublic
+
+	c.Assert(checkColumnProjection(core, nil), IsNil)
+	c.Assert(checkColumnProjection(core, []string{"c1", "c3", "c9"}), IsNil)
+	c.Assert(checkColumnProjection(core, []string{"c1", "c3"}), ErrorMatches, "column c9 is NOT NULL without a default value and must be imported")
+	c.Assert(checkColumnProjection(core, []string{"c1", "x", "c9"}), ErrorMatches, "unknown column x")
+
+	tr, err := NewTableRestore("`db`.`wide`", s.tableMeta, s.dbInfo, &TidbTableInfo{Name: "wide", Core: core}, &TableCheckpoint{})
+	c.Assert(err, IsNil)
+	tr.projection = []string{"c1", "c3", "c9"}
+
+	// without column names, all 10 fields are present but only 3 are imported.
+	ccp := &ChunkCheckpoint{}
+	c.Assert(tr.initializeColumns(nil, ccp), IsNil)
+	c.Assert(ccp.ColumnPermutation, DeepEquals, []int{-1, 1, -1, 3, -1, -1, -1, -1, -1, 9})
+	projection := tr.projectFields(ccp.ColumnPermutation)
+	c.Assert(projection.columns, DeepEquals, []string{"c1", "c3", "c9"})
+	c.Assert(projection.permutation, DeepEquals, []int{-1, 0, -1, 1, -1, -1, -1, -1, -1, 2})
+	row := make([]types.Datum, 0, 10)
+	for i := 0; i < 10; i++ {
+		row = append(row, types.NewIntDatum(int64(i)))
+	}
+	c.Assert(projection.project(row), DeepEquals, []types.Datum{
+		types.NewIntDatum(1), types.NewIntDatum(3), types.NewIntDatum(9),
+	})
+
+	// projected-out columns in the data file are ignored.
+	ccp.ColumnPermutation = nil
+	c.Assert(tr.initializeColumns([]string{"c9", "c2", "c1", "c3"}, ccp), IsNil)
+	c.Assert(ccp.ColumnPermutation, DeepEquals, []int{-1, 2, -1, 3, -1, -1, -1, -1, -1, 0})
+	projection = tr.projectFields(ccp.ColumnPermutation)
+	c.Assert(projection.columns, DeepEquals, []string{"c9", "c1", "c3"})
+	c.Assert(projection.permutation, DeepEquals, []int{-1, 1, -1, 2, -1, -1, -1, -1, -1, 0})
+}
+
+func (s *tableRestoreSuite) TestPartitionDataFiles(c *C) {
+	p := parser.New()
+	se := tmock.NewContext()
+	node, err := p.ParseOneStmt("CREATE TABLE ranged (a INT, b INT)", "", "")
+	c.Assert(err, IsNil)
+	core, err := ddl.MockTableInfo(se, node.(*ast.CreateTableStmt), 0xabcdef)
+	c.Assert(err, IsNil)
+	core.State = model.StatePublic
+	// PARTITION BY RANGE (a) (PARTITION p0 VALUES LESS THAN (10), PARTITION p1 VALUES LESS THAN MAXVALUE)
+	const p1 = 0xabcdf1
+	core.Partition = &model.PartitionInfo{
+		Type:   model.PartitionTypeRange,
+		Expr:   "`a`",
+		Enable: true,
+		Definitions: []model.PartitionDefinition{
+			{ID: 0xabcdf0, Name: model.NewCIStr("p0"), LessThan: []string{"10"}},
+			{ID: p1, Name: model.NewCIStr("p1"), LessThan: []string{"MAXVALUE"}},
+		},
+	}
+
+	_, err = findFilePartition(s.tableInfo.Core, "db.table#p1.csv", "p1")
+	c.Assert(err, ErrorMatches, "data file db.table#p1.csv is of partition p1, but the table is not partitioned")
+	pid, err := findFilePartition(core, "db.ranged#P1.csv", "P1")
+	c.Assert(err, IsNil)
+	c.Assert(pid, Equals, int64(p1))
+	_, err = findFilePartition(core, "db.ranged#p2.csv", "p2")
+	c.Assert(err, ErrorMatches, "data file db.ranged#p2.csv is of partition p2, which does not exist in the table")
+
+	tableMeta := &mydump.MDTableMeta{
+		DB:                 "db",
+		Name:               "ranged",
+		DataFiles:          []string{"db.ranged#p1.csv", "db.ranged.csv"},
+		DataFilePartitions: map[string]string{"db.ranged#p1.csv": "p1"},
+	}
+	tr, err := NewTableRestore("`db`.`ranged`", tableMeta, s.dbInfo, &TidbTableInfo{Name: "ranged", Core: core}, &TableCheckpoint{})
+	c.Assert(err, IsNil)
+
+	// rows of a partition file are routed to the partition, and the others to
+	// the partitioned table.
+	encTable, err := tr.encoderTable("db.ranged#p1.csv")
+	c.Assert(err, IsNil)
+	c.Assert(encTable.(table.PhysicalTable).GetPhysicalID(), Equals, int64(p1))
+	encTable, err = tr.encoderTable("db.ranged.csv")
+	c.Assert(err, IsNil)
+	c.Assert(encTable, Equals, tr.encTable)
+}
+
+func (s *tableRestoreSuite) TestCheckNulBytes(c *C) {
+	p := parser.New()
+	se := tmock.NewContext()
+	node, err := p.ParseOneStmt("CREATE TABLE t (a INT, b VARCHAR(10), c VARBINARY(10), d TEXT)", "", "")
+	c.Assert(err, IsNil)
+	core, err := ddl.MockTableInfo(se, node.(*ast.CreateTableStmt), 1)
+	c.Assert(err, IsNil)
+	tr := &TableRestore{tableInfo: &TidbTableInfo{Core: core}}
+
+	// the data file has the columns (d, c, b), a is missing.
+	textFields := tr.textFields([]int{-1, 2, 1, 0, -1})
+	c.Assert(textFields, DeepEquals, []string{"d", "", "b"})
+
+	row := []types.Datum{
+		types.NewStringDatum("text"),
+		types.NewStringDatum("bin\x00ary"),
+		types.NewStringDatum("varchar"),
+	}
+	c.Assert(checkNulBytes(row, textFields), IsNil)
+	row[2] = types.NewStringDatum("var\x00char")
+	c.Assert(checkNulBytes(row, textFields), ErrorMatches, "field 3 contains a NUL byte, which is rejected for the text column `b`")
+	c.Assert(checkNulBytes(row, nil), IsNil)
+}
+
+func (s *tableRestoreSuite) TestCheckValueCount(c *C) {
+	ccp := &ChunkCheckpoint{
+		Key:   ChunkCheckpointKey{Path: "db.table.1.sql"},
+		Chunk: mydump.Chunk{Offset: 1234},
+	}
+	row := []types.Datum{types.NewIntDatum(1), types.NewIntDatum(2), types.NewIntDatum(3)}
+	c.Assert(s.tr.checkValueCount(row, ccp), IsNil)
+	c.Assert(s.tr.checkValueCount(row[:2], ccp), ErrorMatches,
+		"column count mismatch in file db.table.1.sql:0: table `db`.`table` has 3 columns \\(a, b, c\\), but the first row read from offset 1234 has 2 values")
 }
 
 func (s *tableRestoreSuite) TestCompareChecksumSuccess(c *C) {
@@ -521,6 +1248,72 @@ func (s *tableRestoreSuite) TestCompareChecksumFailure(c *C) {
 	c.Assert(mock.ExpectationsWereMet(), IsNil)
 }
 
+func (s *tableRestoreSuite) TestChecksumFallback(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+
+	cfg := config.NewConfig()
+	rc := &RestoreController{
+		cfg:             cfg,
+		tidbMgr:         &TiDBManager{db: db},
+		checksumWorkers: worker.NewPool(context.Background(), 1, "checksum"),
+		errorSummaries:  makeErrorSummaries(log.L()),
+	}
+	// 100 rows, each encoded into the row data and the index on b.
+	localChecksum := verification.MakeKVChecksum(1234567, 200, 1234567890)
+	expectChecksum := func(err error) {
+		mock.ExpectQuery("SELECT.*tikv_gc_life_time.*").
+			WillReturnRows(sqlmock.NewRows([]string{"VARIABLE_VALUE"}).AddRow("10m"))
+		mock.ExpectExec("UPDATE.*tikv_gc_life_time.*").
+			WithArgs("100h0m0s").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("ADMIN CHECKSUM.*").WillReturnError(err)
+		mock.ExpectExec("UPDATE.*tikv_gc_life_time.*").
+			WithArgs("10m").
+			WillReturnResult(sqlmock.NewResult(2, 1))
+	}
+	denied := &gomysql.MySQLError{Number: mysql.ErrTableaccessDenied, Message: "SELECT command denied"}
+
+	// by default an unavailable checksum fails the table.
+	expectChecksum(denied)
+	_, err = rc.runChecksum(MockDoChecksumCtx(), s.tr, localChecksum)
+	c.Assert(err, ErrorMatches, "ADMIN CHECKSUM is unavailable.*SELECT command denied")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	// other errors are never covered by the fallback.
+	cfg.PostRestore.ChecksumFallback = config.ChecksumFallbackWarn
+	expectChecksum(&gomysql.MySQLError{Number: mysql.ErrNoSuchTable, Message: "table doesn't exist"})
+	_, err = rc.runChecksum(MockDoChecksumCtx(), s.tr, localChecksum)
+	c.Assert(err, ErrorMatches, ".*table doesn't exist")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	expectChecksum(denied)
+	checksum, err := rc.runChecksum(MockDoChecksumCtx(), s.tr, localChecksum)
+	c.Assert(err, IsNil)
+	c.Assert(checksum, Equals, checksumUnavailable)
+	c.Assert(rc.errorSummaries.checksumSkipped, DeepEquals, []string{"`db`.`table`"})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	cfg.PostRestore.ChecksumFallback = config.ChecksumFallbackCount
+	expectChecksum(&gomysql.MySQLError{Number: mysql.ErrParse, Message: "syntax error"})
+	mock.ExpectQuery("\\QSELECT COUNT(*) FROM `db`.`table`\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(100))
+	checksum, err = rc.runChecksum(MockDoChecksumCtx(), s.tr, localChecksum)
+	c.Assert(err, IsNil)
+	c.Assert(checksum, Equals, checksumRowCount)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	expectChecksum(denied)
+	mock.ExpectQuery("\\QSELECT COUNT(*) FROM `db`.`table`\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(99))
+	_, err = rc.runChecksum(MockDoChecksumCtx(), s.tr, localChecksum)
+	c.Assert(err, ErrorMatches, "row count mismatched remote vs local => \\(99 vs 100\\)")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	mock.ExpectClose()
+	c.Assert(db.Close(), IsNil)
+}
+
 func (s *tableRestoreSuite) TestAnalyzeTable(c *C) {
 	db, mock, err := sqlmock.New()
 	c.Assert(err, IsNil)
@@ -584,6 +1377,238 @@ func (s *tableRestoreSuite) TestImportKVFailure(c *C) {
 	c.Assert(err, ErrorMatches, "fake import error.*")
 }
 
+func (s *tableRestoreSuite) TestPostProcessWithoutChecksum(c *C) {
+	controller := gomock.NewController(c)
+	defer controller.Finish()
+	mockBackend := mock.NewMockBackend(controller)
+	mockBackend.EXPECT().ShouldPostProcess().Return(true).AnyTimes()
+
+	db, sqlMock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+
+	cfg := config.NewConfig()
+	cfg.PostRestore.Checksum = false
+	cfg.PostRestore.Analyze = false
+	rc := &RestoreController{
+		cfg:            cfg,
+		backend:        kv.MakeBackend(mockBackend),
+		tidbMgr:        &TiDBManager{db: db},
+		saveCpCh:       make(chan saveCp, 8),
+		errorSummaries: makeErrorSummaries(log.L()),
+		summary:        newImportSummary(nil),
+	}
+	ctx := context.Background()
+	base := s.tr.alloc.Base()
+	s.tr.alloc.Rebase(s.tableInfo.ID, base+1000, false)
+	defer s.tr.alloc.Rebase(s.tableInfo.ID, base, false)
+
+	// the allocator is still rebased when checksum is skipped.
+	sqlMock.ExpectExec("SET.*").WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec(fmt.Sprintf("\\QALTER TABLE `db`.`table` AUTO_INCREMENT=%d\\E", s.tr.alloc.Base()+1)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	c.Assert(s.tr.postProcess(ctx, rc, &TableCheckpoint{Status: CheckpointStatusIndexImported}), IsNil)
+	c.Assert(sqlMock.ExpectationsWereMet(), IsNil)
+	c.Assert(rc.errorSummaries.checksumSkipped, DeepEquals, []string{"`db`.`table`"})
+	c.Assert((<-rc.saveCpCh).merger, DeepEquals, &StatusCheckpointMerger{Status: CheckpointStatusAlteredAutoInc, EngineID: WholeTableEngineID})
+	c.Assert((<-rc.saveCpCh).merger, DeepEquals, &StatusCheckpointMerger{Status: CheckpointStatusChecksumSkipped, EngineID: WholeTableEngineID})
+	c.Assert((<-rc.saveCpCh).merger, DeepEquals, &StatusCheckpointMerger{Status: CheckpointStatusAnalyzeSkipped, EngineID: WholeTableEngineID})
+
+	// rebasing can be disabled separately.
+	cfg.PostRestore.AlterAutoIncrement = false
+	sqlMock.ExpectExec("SET.*").WillReturnResult(sqlmock.NewResult(0, 0))
+	c.Assert(s.tr.postProcess(ctx, rc, &TableCheckpoint{Status: CheckpointStatusIndexImported}), IsNil)
+	c.Assert(sqlMock.ExpectationsWereMet(), IsNil)
+	c.Assert((<-rc.saveCpCh).merger, DeepEquals, &StatusCheckpointMerger{Status: CheckpointStatusAlteredAutoInc, EngineID: WholeTableEngineID})
+	c.Assert((<-rc.saveCpCh).merger, DeepEquals, &StatusCheckpointMerger{Status: CheckpointStatusChecksumSkipped, EngineID: WholeTableEngineID})
+	c.Assert((<-rc.saveCpCh).merger, DeepEquals, &StatusCheckpointMerger{Status: CheckpointStatusAnalyzeSkipped, EngineID: WholeTableEngineID})
+	c.Assert(rc.summary.get(s.tr.tableName).Checksum, Equals, checksumSkipped)
+
+	// a table resumed after its skipped checksum is still reported as skipped.
+	rc.summary = newImportSummary(nil)
+	sqlMock.ExpectExec("SET.*").WillReturnResult(sqlmock.NewResult(0, 0))
+	c.Assert(s.tr.postProcess(ctx, rc, &TableCheckpoint{Status: CheckpointStatusAnalyzeSkipped}), IsNil)
+	c.Assert(sqlMock.ExpectationsWereMet(), IsNil)
+	c.Assert((<-rc.saveCpCh).merger, DeepEquals, &StatusCheckpointMerger{Status: CheckpointStatusAnalyzeSkipped, EngineID: WholeTableEngineID})
+	c.Assert(rc.summary.get(s.tr.tableName).Checksum, Equals, checksumSkipped)
+
+	// the checksum skipped in the data-only phase is reported as well.
+	cfg.PostRestore.Checksum = true
+	cfg.TikvImporter.KVKind = config.KVKindData
+	rc.errorSummaries = makeErrorSummaries(log.L())
+	rc.summary = newImportSummary(nil)
+	sqlMock.ExpectExec("SET.*").WillReturnResult(sqlmock.NewResult(0, 0))
+	c.Assert(s.tr.postProcess(ctx, rc, &TableCheckpoint{Status: CheckpointStatusIndexImported}), IsNil)
+	c.Assert(sqlMock.ExpectationsWereMet(), IsNil)
+	c.Assert((<-rc.saveCpCh).merger, DeepEquals, &StatusCheckpointMerger{Status: CheckpointStatusAlteredAutoInc, EngineID: WholeTableEngineID})
+	c.Assert((<-rc.saveCpCh).merger, DeepEquals, &StatusCheckpointMerger{Status: CheckpointStatusChecksumSkipped, EngineID: WholeTableEngineID})
+	c.Assert((<-rc.saveCpCh).merger, DeepEquals, &StatusCheckpointMerger{Status: CheckpointStatusAnalyzeSkipped, EngineID: WholeTableEngineID})
+	c.Assert(rc.errorSummaries.checksumSkipped, DeepEquals, []string{"`db`.`table`"})
+	c.Assert(rc.summary.get(s.tr.tableName).Checksum, Equals, checksumSkipped)
+
+	sqlMock.ExpectClose()
+	c.Assert(db.Close(), IsNil)
+}
+
+func (s *tableRestoreSuite) TestPostProcessAnalyzeMinRows(c *C) {
+	controller := gomock.NewController(c)
+	defer controller.Finish()
+	mockBackend := mock.NewMockBackend(controller)
+	mockBackend.EXPECT().ShouldPostProcess().Return(true).AnyTimes()
+
+	db, sqlMock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+
+	cfg := config.NewConfig()
+	cfg.PostRestore.Analyze = true
+	cfg.PostRestore.AnalyzeMinRows = 10
+	rc := &RestoreController{
+		cfg:      cfg,
+		backend:  kv.MakeBackend(mockBackend),
+		tidbMgr:  &TiDBManager{db: db},
+		saveCpCh: make(chan saveCp, 8),
+	}
+	ctx := context.Background()
+
+	// 5 rows were imported, each encoded into a row KV and the index KVs.
+	kvs := uint64(5 * (len(s.tr.encTable.Indices()) + 1))
+	cp := &TableCheckpoint{
+		Status: CheckpointStatusChecksummed,
+		Engines: map[int32]*EngineCheckpoint{
+			0: {Chunks: []*ChunkCheckpoint{{Checksum: verification.MakeKVChecksum(1024, kvs, 0)}}},
+		},
+	}
+
+	sqlMock.ExpectExec("SET.*").WillReturnResult(sqlmock.NewResult(0, 0))
+	c.Assert(s.tr.postProcess(ctx, rc, cp), IsNil)
+	c.Assert(sqlMock.ExpectationsWereMet(), IsNil)
+	c.Assert((<-rc.saveCpCh).merger, DeepEquals, &StatusCheckpointMerger{Status: CheckpointStatusAnalyzeSkipped, EngineID: WholeTableEngineID})
+
+	// tables reaching the threshold are still analyzed.
+	cfg.PostRestore.AnalyzeMinRows = 5
+	sqlMock.ExpectExec("SET.*").WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec("\\QANALYZE TABLE `db`.`table`\\E").WillReturnResult(sqlmock.NewResult(0, 0))
+	c.Assert(s.tr.postProcess(ctx, rc, cp), IsNil)
+	c.Assert(sqlMock.ExpectationsWereMet(), IsNil)
+	c.Assert((<-rc.saveCpCh).merger, DeepEquals, &StatusCheckpointMerger{Status: CheckpointStatusAnalyzed, EngineID: WholeTableEngineID})
+
+	sqlMock.ExpectClose()
+	c.Assert(db.Close(), IsNil)
+}
+
+func (s *tableRestoreSuite) TestRestoreTableWithRetry(c *C) {
+	controller := gomock.NewController(c)
+	defer controller.Finish()
+	mockBackend := mock.NewMockBackend(controller)
+	mockBackend.EXPECT().RetryImportDelay().Return(time.Duration(0)).Times(2)
+
+	// populating the chunks always fails since the data file is missing.
+	tableMeta := *s.tableMeta
+	tableMeta.DataFiles = []string{path.Join(c.MkDir(), "db.table.1.sql")}
+	tr, err := NewTableRestore("`db`.`table`", &tableMeta, s.dbInfo, s.tableInfo, &TableCheckpoint{})
+	c.Assert(err, IsNil)
+
+	s.cfg.App.MaxTableRetry = 2
+	rc := &RestoreController{
+		cfg:       s.cfg,
+		backend:   kv.MakeBackend(mockBackend),
+		ioWorkers: worker.NewPool(context.Background(), 1, "io"),
+		summary:   newImportSummary(nil),
+	}
+	err = tr.restoreTableWithRetry(context.Background(), rc, &TableCheckpoint{Engines: make(map[int32]*EngineCheckpoint)})
+	c.Assert(err, NotNil)
+	c.Assert(rc.summary.get(tr.tableName).Retries, Equals, 2)
+}
+
+func (s *tableRestoreSuite) TestRestoreTableSucceedOnRetry(c *C) {
+	controller := gomock.NewController(c)
+	defer controller.Finish()
+	mockBackend := mock.NewMockBackend(controller)
+	mockBackend.EXPECT().ShouldPostProcess().Return(true).AnyTimes()
+	mockBackend.EXPECT().RetryImportDelay().Return(time.Duration(0)).Times(1)
+
+	db, sqlMock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+
+	cfg := config.NewConfig()
+	cfg.App.MaxTableRetry = 2
+	cfg.PostRestore.Checksum = false
+	cfg.PostRestore.Analyze = false
+	rc := &RestoreController{
+		cfg:            cfg,
+		backend:        kv.MakeBackend(mockBackend),
+		tidbMgr:        &TiDBManager{db: db},
+		saveCpCh:       make(chan saveCp, 16),
+		errorSummaries: makeErrorSummaries(log.L()),
+		summary:        newImportSummary(nil),
+	}
+
+	// the engines are all imported, so the first attempt fails in
+	// post-processing, long after the chunks are populated.
+	cp := &TableCheckpoint{
+		Status:  CheckpointStatusIndexImported,
+		Engines: map[int32]*EngineCheckpoint{indexEngineID: {Status: CheckpointStatusImported}},
+	}
+	alterAutoInc := fmt.Sprintf("\\QALTER TABLE `db`.`table` AUTO_INCREMENT=%d\\E", s.tr.alloc.Base()+1)
+	sqlMock.ExpectExec("SET.*").WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec(alterAutoInc).WillReturnError(&gomysql.MySQLError{Number: mysql.ErrTableaccessDenied, Message: "mock alter failure"})
+	sqlMock.ExpectExec("SET.*").WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec(alterAutoInc).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	c.Assert(s.tr.restoreTableWithRetry(context.Background(), rc, cp), IsNil)
+	c.Assert(sqlMock.ExpectationsWereMet(), IsNil)
+	c.Assert(rc.summary.get(s.tr.tableName).Retries, Equals, 1)
+	c.Assert(rc.errorSummaries.summary, HasLen, 0)
+	c.Assert(rc.errorSummaries.checksumSkipped, DeepEquals, []string{"`db`.`table`"})
+
+	sqlMock.ExpectClose()
+	c.Assert(db.Close(), IsNil)
+}
+
+func (s *tableRestoreSuite) TestRestoreTableRetryAfterEngineClosed(c *C) {
+	controller := gomock.NewController(c)
+	defer controller.Finish()
+	mockBackend := mock.NewMockBackend(controller)
+	mockBackend.EXPECT().ShouldPostProcess().Return(false).AnyTimes()
+	mockBackend.EXPECT().RetryImportDelay().Return(time.Duration(0)).Times(1)
+
+	// the first attempt fails importing the data engine after closing it, so
+	// the retry must only reopen the index engine.
+	mockBackend.EXPECT().OpenEngine(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+	mockBackend.EXPECT().CloseEngine(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+	mockBackend.EXPECT().ImportEngine(gomock.Any(), gomock.Any()).
+		Return(&gomysql.MySQLError{Number: mysql.ErrTableaccessDenied, Message: "mock import failure"}).
+		Times(1)
+	mockBackend.EXPECT().ImportEngine(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	mockBackend.EXPECT().CleanupEngine(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	ctx := context.Background()
+	cfg := config.NewConfig()
+	cfg.App.MaxTableRetry = 2
+	rc := &RestoreController{
+		cfg:               cfg,
+		backend:           kv.MakeBackend(mockBackend),
+		tableWorkers:      worker.NewPool(ctx, 1, "table"),
+		indexWorkers:      worker.NewPool(ctx, 1, "index"),
+		closedEngineLimit: worker.NewPool(ctx, 2, "closed-engine"),
+		saveCpCh:          make(chan saveCp, 16),
+		errorSummaries:    makeErrorSummaries(log.L()),
+		summary:           newImportSummary(nil),
+	}
+
+	cp := &TableCheckpoint{
+		Status: CheckpointStatusLoaded,
+		Engines: map[int32]*EngineCheckpoint{
+			indexEngineID: {Status: CheckpointStatusLoaded},
+			0:             {Status: CheckpointStatusLoaded},
+		},
+	}
+	c.Assert(s.tr.restoreTableWithRetry(ctx, rc, cp), IsNil)
+	c.Assert(rc.summary.get(s.tr.tableName).Retries, Equals, 1)
+	c.Assert(cp.Status, Equals, CheckpointStatusIndexImported)
+	c.Assert(cp.Engines[0].Status, Equals, CheckpointStatusImported)
+	c.Assert(cp.Engines[indexEngineID].Status, Equals, CheckpointStatusImported)
+}
+
 var _ = Suite(&chunkRestoreSuite{})
 
 type chunkRestoreSuite struct {
@@ -608,7 +1633,7 @@ func (s *chunkRestoreSuite) SetUpTest(c *C) {
 	}
 
 	var err error
-	s.cr, err = newChunkRestore(1, s.cfg, &chunk, w)
+	s.cr, err = newChunkRestore(context.Background(), 1, s.cfg, &chunk, w)
 	c.Assert(err, IsNil)
 }
 
@@ -647,7 +1672,7 @@ func (s *chunkRestoreSuite) TestDeliverLoopEmptyData(c *C) {
 
 	// Deliver nothing.
 
-	rc := &RestoreController{backend: importer}
+	rc := &RestoreController{cfg: s.cfg, backend: importer}
 
 	kvsCh := make(chan deliveredKVs, 1)
 	kvsCh <- deliveredKVs{}
@@ -731,7 +1756,7 @@ func (s *chunkRestoreSuite) TestDeliverLoop(c *C) {
 		close(kvsCh)
 	}()
 
-	rc := &RestoreController{saveCpCh: saveCpCh, backend: importer}
+	rc := &RestoreController{cfg: s.cfg, saveCpCh: saveCpCh, backend: importer}
 
 	_, err = s.cr.deliverLoop(ctx, kvsCh, s.tr, 0, dataEngine, indexEngine, rc)
 	c.Assert(err, IsNil)
@@ -741,11 +1766,71 @@ func (s *chunkRestoreSuite) TestDeliverLoop(c *C) {
 	c.Assert(s.cr.chunk.Checksum.SumKVS(), Equals, uint64(3))
 }
 
+func (s *chunkRestoreSuite) TestDeliverLoopIndexOnly(c *C) {
+	ctx := context.Background()
+	kvsCh := make(chan deliveredKVs)
+	mockCols := []string{"c1", "c2"}
+
+	controller := gomock.NewController(c)
+	defer controller.Finish()
+	mockBackend := mock.NewMockBackend(controller)
+	importer := kv.MakeBackend(mockBackend)
+
+	mockBackend.EXPECT().OpenEngine(ctx, gomock.Any()).Return(nil).Times(2)
+	mockBackend.EXPECT().MakeEmptyRows().Return(kv.MakeRowsFromKvPairs(nil)).AnyTimes()
+	mockBackend.EXPECT().MaxChunkSize().Return(10000).AnyTimes()
+
+	dataEngine, err := importer.OpenEngine(ctx, s.tr.tableName, 0)
+	c.Assert(err, IsNil)
+	indexEngine, err := importer.OpenEngine(ctx, s.tr.tableName, -1)
+	c.Assert(err, IsNil)
+
+	// Only the index KVs are written. (The data engine receives no rows so
+	// WriteRows is never called on the backend.)
+	mockBackend.EXPECT().
+		WriteRows(ctx, gomock.Any(), s.tr.tableName, mockCols, gomock.Any(), kv.MakeRowsFromKvPairs([]kvenc.KvPair{
+			{
+				Key: []byte("txxxxxxxx_izzzzzzzz"),
+				Val: []byte("index1"),
+			},
+		})).
+		Return(nil)
+
+	go func() {
+		kvsCh <- deliveredKVs{
+			kvs: kv.MakeRowFromKvPairs([]kvenc.KvPair{
+				{
+					Key: []byte("txxxxxxxx_ryyyyyyyy"),
+					Val: []byte("value1"),
+				},
+				{
+					Key: []byte("txxxxxxxx_izzzzzzzz"),
+					Val: []byte("index1"),
+				},
+			}),
+			columns: mockCols,
+			offset:  12,
+			rowID:   76,
+		}
+		kvsCh <- deliveredKVs{}
+		close(kvsCh)
+	}()
+
+	s.cfg.TikvImporter.KVKind = config.KVKindIndex
+	saveCpCh := make(chan saveCp, 2)
+	rc := &RestoreController{cfg: s.cfg, saveCpCh: saveCpCh, backend: importer}
+
+	_, err = s.cr.deliverLoop(ctx, kvsCh, s.tr, 0, dataEngine, indexEngine, rc)
+	c.Assert(err, IsNil)
+	// the row data are still counted towards the checksum of the whole table.
+	c.Assert(s.cr.chunk.Checksum.SumKVS(), Equals, uint64(2))
+}
+
 func (s *chunkRestoreSuite) TestEncodeLoop(c *C) {
 	ctx := context.Background()
 	kvsCh := make(chan deliveredKVs, 2)
 	deliverCompleteCh := make(chan deliverResult)
-	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, s.cfg.TiDB.SQLMode, 1234567895)
+	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{SQLMode: s.cfg.TiDB.SQLMode, Timestamp: 1234567895})
 
 	_, _, err := s.cr.encodeLoop(ctx, kvsCh, s.tr, s.tr.logger, kvEncoder, deliverCompleteCh, DeliverPauser)
 	c.Assert(err, IsNil)
@@ -760,11 +1845,34 @@ func (s *chunkRestoreSuite) TestEncodeLoop(c *C) {
 	c.Assert(secondKVs.kvs, IsNil)
 }
 
+func (s *chunkRestoreSuite) TestEncodeLoopOversizedRow(c *C) {
+	ctx := context.Background()
+	kvsCh := make(chan deliveredKVs, 2)
+	deliverCompleteCh := make(chan deliverResult)
+	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{SQLMode: s.cfg.TiDB.SQLMode, Timestamp: 1234567895, MaxRowSize: 10})
+
+	_, _, err := s.cr.encodeLoop(ctx, kvsCh, s.tr, s.tr.logger, kvEncoder, deliverCompleteCh, DeliverPauser)
+	c.Assert(err, ErrorMatches, "in file .* at offset 36: the row with _tidb_rowid 19 is encoded into \\d+ bytes, exceeding the max row size 10: row too large")
+	c.Assert(errors.Cause(err), Equals, kv.ErrRowTooLarge)
+	c.Assert(kvsCh, HasLen, 0)
+
+	// restart the chunk, skipping the oversized rows this time.
+	s.cr.close()
+	s.cfg.TikvImporter.OnOversizedRow = config.OversizedRowSkip
+	s.cr, err = newChunkRestore(ctx, 1, s.cfg, s.cr.chunk, worker.NewPool(ctx, 1, "io"))
+	c.Assert(err, IsNil)
+	_, _, err = s.cr.encodeLoop(ctx, kvsCh, s.tr, s.tr.logger, kvEncoder, deliverCompleteCh, DeliverPauser)
+	c.Assert(err, IsNil)
+	c.Assert(s.cr.skippedRows, Equals, int64(1))
+	c.Assert(kvsCh, HasLen, 1)
+	c.Assert((<-kvsCh).kvs, IsNil)
+}
+
 func (s *chunkRestoreSuite) TestEncodeLoopCanceled(c *C) {
 	ctx, cancel := context.WithCancel(context.Background())
 	kvsCh := make(chan deliveredKVs)
 	deliverCompleteCh := make(chan deliverResult)
-	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, s.cfg.TiDB.SQLMode, 1234567896)
+	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{SQLMode: s.cfg.TiDB.SQLMode, Timestamp: 1234567896})
 
 	go cancel()
 	_, _, err := s.cr.encodeLoop(ctx, kvsCh, s.tr, s.tr.logger, kvEncoder, deliverCompleteCh, DeliverPauser)
@@ -776,7 +1884,7 @@ func (s *chunkRestoreSuite) TestEncodeLoopForcedError(c *C) {
 	ctx := context.Background()
 	kvsCh := make(chan deliveredKVs, 2)
 	deliverCompleteCh := make(chan deliverResult)
-	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, s.cfg.TiDB.SQLMode, 1234567897)
+	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{SQLMode: s.cfg.TiDB.SQLMode, Timestamp: 1234567897})
 
 	// close the chunk so reading it will result in the "file already closed" error.
 	s.cr.parser.Close()
@@ -790,7 +1898,7 @@ func (s *chunkRestoreSuite) TestEncodeLoopDeliverErrored(c *C) {
 	ctx := context.Background()
 	kvsCh := make(chan deliveredKVs)
 	deliverCompleteCh := make(chan deliverResult)
-	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, s.cfg.TiDB.SQLMode, 1234567898)
+	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{SQLMode: s.cfg.TiDB.SQLMode, Timestamp: 1234567898})
 
 	go func() {
 		deliverCompleteCh <- deliverResult{
@@ -802,6 +1910,47 @@ func (s *chunkRestoreSuite) TestEncodeLoopDeliverErrored(c *C) {
 	c.Assert(kvsCh, HasLen, 0)
 }
 
+func (s *chunkRestoreSuite) TestEncodeLoopEmptyFile(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	emptyFile := path.Join(dir, "db.table.1.sql")
+	c.Assert(ioutil.WriteFile(emptyFile, nil, 0644), IsNil)
+	headerOnlyFile := path.Join(dir, "db.table.2.csv")
+	c.Assert(ioutil.WriteFile(headerOnlyFile, []byte("a,b,c\n"), 0644), IsNil)
+
+	cfg := config.NewConfig()
+	cfg.Mydumper.CSV.Header = true
+	w := worker.NewPool(ctx, 1, "io")
+	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{SQLMode: s.cfg.TiDB.SQLMode, Timestamp: 1234567899})
+
+	for _, onEmptyFile := range []string{config.EmptyFileIgnore, config.EmptyFileError} {
+		cfg.Mydumper.OnEmptyFile = onEmptyFile
+		for _, fileName := range []string{emptyFile, headerOnlyFile} {
+			size := int64(0)
+			if fileName == headerOnlyFile {
+				size = 6
+			}
+			cr, err := newChunkRestore(context.Background(), 0, cfg, &ChunkCheckpoint{
+				Key:   ChunkCheckpointKey{Path: fileName, Offset: 0},
+				Chunk: mydump.Chunk{Offset: 0, EndOffset: size},
+			}, w)
+			c.Assert(err, IsNil)
+
+			kvsCh := make(chan deliveredKVs, 1)
+			_, _, err = cr.encodeLoop(ctx, kvsCh, s.tr, s.tr.logger, kvEncoder, make(chan deliverResult), DeliverPauser)
+			if onEmptyFile == config.EmptyFileError {
+				c.Assert(err, ErrorMatches, "data file .* contains no rows")
+				c.Assert(kvsCh, HasLen, 0)
+			} else {
+				c.Assert(err, IsNil)
+				c.Assert(kvsCh, HasLen, 1)
+				c.Assert((<-kvsCh).kvs, IsNil)
+			}
+			cr.close()
+		}
+	}
+}
+
 func (s *chunkRestoreSuite) TestRestore(c *C) {
 	ctx := context.Background()
 
@@ -853,3 +2002,62 @@ func (s *chunkRestoreSuite) TestRestore(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(saveCpCh, HasLen, 2)
 }
+
+func (s *chunkRestoreSuite) TestNewChunkRestoreReadsCSVHeader(c *C) {
+	fileName := path.Join(c.MkDir(), "db.t.csv")
+	err := ioutil.WriteFile(fileName, []byte("b,a\n1,2\n3,4\n"), 0644)
+	c.Assert(err, IsNil)
+
+	cfg := config.NewConfig()
+	cfg.Mydumper.CSV.Header = true
+	chunk := ChunkCheckpoint{
+		Key: ChunkCheckpointKey{Path: fileName, Offset: 8},
+		Chunk: mydump.Chunk{
+			Offset:       8,
+			EndOffset:    12,
+			PrevRowIDMax: 1,
+			RowIDMax:     2,
+		},
+	}
+	cr, err := newChunkRestore(context.Background(), 0, cfg, &chunk, worker.NewPool(context.Background(), 1, "io"))
+	c.Assert(err, IsNil)
+	defer cr.close()
+
+	c.Assert(cr.parser.ReadRow(), IsNil)
+	c.Assert(cr.parser.Columns(), DeepEquals, []string{"b", "a"})
+	c.Assert(cr.parser.LastRow().RowID, Equals, int64(2))
+	c.Assert(cr.parser.LastRow().Row, HasLen, 2)
+}
+
+func (s *chunkRestoreSuite) TestNewChunkRestoreResumesSQLStatement(c *C) {
+	fileName := path.Join(c.MkDir(), "db.t.sql")
+	data := "INSERT INTO `t` (`a`,`b`) VALUES (1,2),(3,4);\nINSERT INTO `t` (`b`,`a`) VALUES (5,6),(7,8);\n"
+	err := ioutil.WriteFile(fileName, []byte(data), 0644)
+	c.Assert(err, IsNil)
+
+	// the chunk stopped after the row (5,6), in the statement with a column
+	// list different from the first statement.
+	offset := int64(strings.Index(data, ",(7,8)"))
+	chunk := ChunkCheckpoint{
+		Key: ChunkCheckpointKey{Path: fileName, Offset: 0},
+		Chunk: mydump.Chunk{
+			Offset:       offset,
+			EndOffset:    int64(len(data)),
+			PrevRowIDMax: 3,
+			RowIDMax:     4,
+		},
+	}
+	cr, err := newChunkRestore(context.Background(), 0, config.NewConfig(), &chunk, worker.NewPool(context.Background(), 1, "io"))
+	c.Assert(err, IsNil)
+	defer cr.close()
+
+	c.Assert(cr.parser.ReadRow(), IsNil)
+	c.Assert(cr.parser.Columns(), DeepEquals, []string{"b", "a"})
+	c.Assert(cr.parser.LastRow().RowID, Equals, int64(4))
+	c.Assert(cr.parser.LastRow().Row, DeepEquals, []types.Datum{types.NewUintDatum(7), types.NewUintDatum(8)})
+
+	// the chunk cannot be resumed from the middle of a row.
+	chunk.Chunk.Offset++
+	_, err = newChunkRestore(context.Background(), 0, config.NewConfig(), &chunk, worker.NewPool(context.Background(), 1, "io"))
+	c.Assert(err, ErrorMatches, "failed to resume .*: offset .* is not at the end of a row.*")
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/pingcap/tidb-lightning/lightning/metric"
+)
+
+// storeLimiter limits how many tables are checksummed at the same time on
+// every TiKV store, since ADMIN CHECKSUM sends the coprocessor requests to the
+// leaders of the regions of the table. A nil limiter never blocks.
+//
+// The tables waiting for a store are served in FIFO order. A waiting table
+// also holds back the tables queued after it sharing any of its stores, so a
+// table spanning many stores cannot be starved by smaller tables taking the
+// slots one store at a time.
+type storeLimiter struct {
+	limit int
+
+	mu       sync.Mutex
+	inflight map[uint64]int
+	// the tables waiting for slots, in the order they started to wait.
+	waiters []*storeWaiter
+	// closed whenever some slots are released or a waiter leaves the queue,
+	// to wake up the waiters.
+	released chan struct{}
+}
+
+type storeWaiter struct {
+	stores []uint64
+}
+
+func newStoreLimiter(limit int) *storeLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &storeLimiter{
+		limit:    limit,
+		inflight: make(map[uint64]int),
+		released: make(chan struct{}),
+	}
+}
+
+// acquire blocks until a slot on every store is available, and takes them
+// all at once, so that tables waiting for overlapping stores cannot deadlock.
+func (l *storeLimiter) acquire(ctx context.Context, stores []uint64) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.canAcquire(stores, len(l.waiters)) {
+		l.take(stores)
+		l.mu.Unlock()
+		return nil
+	}
+	waiter := &storeWaiter{stores: stores}
+	l.waiters = append(l.waiters, waiter)
+	for {
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.dequeue(waiter)
+			l.mu.Unlock()
+			return ctx.Err()
+		}
+
+		l.mu.Lock()
+		if l.canAcquire(stores, l.position(waiter)) {
+			l.dequeue(waiter)
+			l.take(stores)
+			l.mu.Unlock()
+			return nil
+		}
+	}
+}
+
+// canAcquire returns whether a slot on every store is available, and none of
+// the first `queued` waiters is waiting for any of the stores.
+func (l *storeLimiter) canAcquire(stores []uint64, queued int) bool {
+	for _, store := range stores {
+		if l.inflight[store] >= l.limit {
+			return false
+		}
+		for _, waiter := range l.waiters[:queued] {
+			for _, waiting := range waiter.stores {
+				if waiting == store {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func (l *storeLimiter) take(stores []uint64) {
+	for _, store := range stores {
+		l.inflight[store]++
+		metric.ChecksumStoreInflightGauge.WithLabelValues(strconv.FormatUint(store, 10)).Inc()
+	}
+}
+
+func (l *storeLimiter) position(waiter *storeWaiter) int {
+	for i, w := range l.waiters {
+		if w == waiter {
+			return i
+		}
+	}
+	return len(l.waiters)
+}
+
+// dequeue removes the waiter from the queue, which may let the waiters behind
+// it acquire their slots.
+func (l *storeLimiter) dequeue(waiter *storeWaiter) {
+	i := l.position(waiter)
+	l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+	l.wake()
+}
+
+func (l *storeLimiter) wake() {
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// release gives back the slots taken by acquire.
+func (l *storeLimiter) release(stores []uint64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, store := range stores {
+		l.inflight[store]--
+		metric.ChecksumStoreInflightGauge.WithLabelValues(strconv.FormatUint(store, 10)).Dec()
+	}
+	l.wake()
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&storeLimiterSuite{})
+
+type storeLimiterSuite struct{}
+
+func (s *storeLimiterSuite) TestStoreLimiter(c *C) {
+	c.Assert(newStoreLimiter(0), IsNil)
+	var unlimited *storeLimiter
+	c.Assert(unlimited.acquire(context.Background(), []uint64{1}), IsNil)
+	unlimited.release([]uint64{1})
+
+	l := newStoreLimiter(1)
+	ctx := context.Background()
+	c.Assert(l.acquire(ctx, []uint64{1, 2}), IsNil)
+	// a table on other stores is not blocked.
+	c.Assert(l.acquire(ctx, []uint64{3}), IsNil)
+	c.Assert(l.inflight, DeepEquals, map[uint64]int{1: 1, 2: 1, 3: 1})
+
+	// a table sharing a store waits until it is released.
+	acquired := make(chan error)
+	go func() {
+		acquired <- l.acquire(ctx, []uint64{2, 4})
+	}()
+	select {
+	case <-acquired:
+		c.Fatal("acquired a store at its limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release([]uint64{1, 2})
+	c.Assert(<-acquired, IsNil)
+	c.Assert(l.inflight, DeepEquals, map[uint64]int{1: 0, 2: 1, 3: 1, 4: 1})
+
+	// cancellation unblocks the waiters.
+	cancelCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		acquired <- l.acquire(cancelCtx, []uint64{3})
+	}()
+	cancel()
+	c.Assert(<-acquired, Equals, context.Canceled)
+	c.Assert(l.inflight[3], Equals, 1)
+}
+
+func (s *storeLimiterSuite) TestStoreLimiterFairness(c *C) {
+	l := newStoreLimiter(1)
+	ctx := context.Background()
+	c.Assert(l.acquire(ctx, []uint64{1}), IsNil)
+
+	// a table spanning all stores waits for store 1.
+	wideAcquired := make(chan error)
+	go func() {
+		wideAcquired <- l.acquire(ctx, []uint64{1, 2, 3})
+	}()
+	for {
+		l.mu.Lock()
+		queued := len(l.waiters)
+		l.mu.Unlock()
+		if queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// a smaller table queued later cannot take store 2 before it...
+	narrowAcquired := make(chan error)
+	go func() {
+		narrowAcquired <- l.acquire(ctx, []uint64{2})
+	}()
+	select {
+	case <-narrowAcquired:
+		c.Fatal("acquired a store before an earlier waiter")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// ... while a table on other stores is not held back.
+	c.Assert(l.acquire(ctx, []uint64{4}), IsNil)
+
+	l.release([]uint64{1})
+	c.Assert(<-wideAcquired, IsNil)
+	select {
+	case <-narrowAcquired:
+		c.Fatal("acquired a store at its limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release([]uint64{1, 2, 3})
+	c.Assert(<-narrowAcquired, IsNil)
+	c.Assert(l.inflight, DeepEquals, map[uint64]int{1: 0, 2: 1, 3: 0, 4: 1})
+	c.Assert(l.waiters, HasLen, 0)
+}
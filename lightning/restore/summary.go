@@ -0,0 +1,389 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/tablecodec"
+
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/metric"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+const (
+	summaryStatusPending   = "pending"
+	summaryStatusRunning   = "running"
+	summaryStatusCompleted = "completed"
+	summaryStatusFailed    = "failed"
+	// the table is not imported since its schema cannot be created.
+	summaryStatusSkipped = "skipped"
+
+	checksumPassed  = "passed"
+	checksumFailed  = "failed"
+	checksumSkipped = "skipped"
+	// verified by the row count only, since ADMIN CHECKSUM is unavailable.
+	checksumRowCount = "row-count-only"
+	// not verified, since ADMIN CHECKSUM is unavailable.
+	checksumUnavailable = "unavailable"
+)
+
+// tableSummary is the outcome of restoring a table, written into the summary
+// report.
+type tableSummary struct {
+	Table  string `json:"table"`
+	Status string `json:"status"`
+	// Order is the position of the table in the order dispatched for import,
+	// starting from 1.
+	Order    int           `json:"order,omitempty"`
+	Backend  string        `json:"backend,omitempty"`
+	Rows     int64         `json:"rows"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"-"`
+	Seconds  float64       `json:"duration-seconds"`
+	Checksum string        `json:"checksum,omitempty"`
+	Retries  int           `json:"retries,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// SkippedRows are the rows skipped for exceeding the max row size.
+	SkippedRows int64 `json:"skipped-rows,omitempty"`
+	// KeyRanges are the ranges of the keys written for the table, one for
+	// every physical table (i.e. the partitions of a partitioned table).
+	KeyRanges []keyRange `json:"key-ranges,omitempty"`
+	// PhaseSeconds is the time spent in parsing, encoding and ingesting (i.e.
+	// writing into the backend) the chunks, summed over all chunks. The
+	// phases run concurrently, so the sum may exceed the duration.
+	PhaseSeconds map[string]float64 `json:"phase-seconds,omitempty"`
+
+	start  time.Time
+	phases map[string]time.Duration
+}
+
+// keyRange is a range of TiDB keys, in hex.
+type keyRange struct {
+	StartKey string `json:"start-key"`
+	EndKey   string `json:"end-key"`
+}
+
+// physicalTableIDs returns the IDs the keys of the table are encoded with,
+// i.e. the IDs of the partitions of a partitioned table.
+func physicalTableIDs(tableInfo *model.TableInfo) []int64 {
+	if tableInfo.GetPartitionInfo() == nil {
+		return []int64{tableInfo.ID}
+	}
+	ids := make([]int64, 0, len(tableInfo.Partition.Definitions))
+	for _, def := range tableInfo.Partition.Definitions {
+		ids = append(ids, def.ID)
+	}
+	return ids
+}
+
+// tableKeyRanges computes the key ranges of the table, which cover both the
+// row data and the indices of every physical table.
+func tableKeyRanges(tableInfo *model.TableInfo) []keyRange {
+	ids := physicalTableIDs(tableInfo)
+	ranges := make([]keyRange, 0, len(ids))
+	for _, id := range ids {
+		ranges = append(ranges, keyRange{
+			StartKey: hex.EncodeToString(tablecodec.EncodeTablePrefix(id)),
+			EndKey:   hex.EncodeToString(tablecodec.EncodeTablePrefix(id + 1)),
+		})
+	}
+	return ranges
+}
+
+// importSummary collects the outcome of every table for the summary report
+// written to `lightning.summary-file` when the task ends. A nil summary records
+// nothing.
+type importSummary struct {
+	mu     sync.Mutex
+	start  time.Time
+	tables map[string]*tableSummary
+}
+
+type importSummaryReport struct {
+	Status   string          `json:"status"`
+	Start    time.Time       `json:"start-time"`
+	Seconds  float64         `json:"duration-seconds"`
+	Error    string          `json:"error,omitempty"`
+	Tables   []*tableSummary `json:"tables"`
+	duration time.Duration
+}
+
+func newImportSummary(dbMetas []*mydump.MDDatabaseMeta) *importSummary {
+	s := &importSummary{
+		start:  time.Now(),
+		tables: make(map[string]*tableSummary),
+	}
+	for _, dbMeta := range dbMetas {
+		for _, tableMeta := range dbMeta.Tables {
+			name := common.UniqueTable(dbMeta.Name, tableMeta.Name)
+			s.tables[name] = &tableSummary{Table: name, Status: summaryStatusPending}
+		}
+	}
+	return s
+}
+
+func (s *importSummary) get(tableName string) *tableSummary {
+	ts, ok := s.tables[tableName]
+	if !ok {
+		ts = &tableSummary{Table: tableName, Status: summaryStatusPending}
+		s.tables[tableName] = ts
+	}
+	return ts
+}
+
+func (s *importSummary) startTable(tableName string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts := s.get(tableName)
+	ts.Status = summaryStatusRunning
+	ts.start = time.Now()
+}
+
+// endTable records the result of the table. The bytes are the size of the
+// source data restored so far, including those from previous runs.
+func (s *importSummary) endTable(tableName string, cp *checkpoints.TableCheckpoint, err error) {
+	if s == nil {
+		return
+	}
+	var bytes int64
+	for _, engine := range cp.Engines {
+		for _, chunk := range engine.Chunks {
+			bytes += chunk.Chunk.Offset - chunk.Key.Offset
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts := s.get(tableName)
+	ts.Duration = time.Since(ts.start)
+	ts.Bytes = bytes
+	if err != nil {
+		ts.Status = summaryStatusFailed
+		ts.Error = err.Error()
+	} else {
+		ts.Status = summaryStatusCompleted
+	}
+}
+
+// skipTable records that the table is not imported due to the error.
+func (s *importSummary) skipTable(tableName string, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts := s.get(tableName)
+	ts.Status = summaryStatusSkipped
+	ts.Error = err.Error()
+}
+
+// addRows counts the rows delivered during this run.
+func (s *importSummary) addRows(tableName string, rows int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(tableName).Rows += rows
+}
+
+// addSkippedRows counts the rows skipped during this run.
+func (s *importSummary) addSkippedRows(tableName string, rows int64) {
+	if s == nil || rows == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(tableName).SkippedRows += rows
+}
+
+// addPhaseDurations accumulates the time spent in each phase of restoring a
+// chunk of the table.
+func (s *importSummary) addPhaseDurations(tableName string, parse, encode, ingest time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts := s.get(tableName)
+	if ts.phases == nil {
+		ts.phases = make(map[string]time.Duration, 3)
+	}
+	ts.phases[metric.ChunkPhaseParse] += parse
+	ts.phases[metric.ChunkPhaseEncode] += encode
+	ts.phases[metric.ChunkPhaseIngest] += ingest
+}
+
+func (s *importSummary) addRetry(tableName string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(tableName).Retries++
+}
+
+func (s *importSummary) setBackend(tableName string, backend string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(tableName).Backend = backend
+}
+
+func (s *importSummary) setOrder(tableName string, order int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(tableName).Order = order
+}
+
+func (s *importSummary) setKeyRanges(tableName string, ranges []keyRange) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(tableName).KeyRanges = ranges
+}
+
+func (s *importSummary) setChecksum(tableName string, checksum string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(tableName).Checksum = checksum
+}
+
+func (s *importSummary) report(taskErr error) *importSummaryReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := &importSummaryReport{
+		Status:   summaryStatusCompleted,
+		Start:    s.start,
+		duration: time.Since(s.start),
+		Tables:   make([]*tableSummary, 0, len(s.tables)),
+	}
+	report.Seconds = report.duration.Seconds()
+	if taskErr != nil {
+		report.Status = summaryStatusFailed
+		report.Error = taskErr.Error()
+	}
+
+	for _, ts := range s.tables {
+		copied := *ts
+		if copied.Status == summaryStatusRunning {
+			copied.Duration = time.Since(copied.start)
+		}
+		copied.Seconds = copied.Duration.Seconds()
+		if len(copied.phases) > 0 {
+			copied.PhaseSeconds = make(map[string]float64, len(copied.phases))
+			for phase, dur := range copied.phases {
+				copied.PhaseSeconds[phase] = dur.Seconds()
+			}
+		}
+		report.Tables = append(report.Tables, &copied)
+		if copied.Status != summaryStatusCompleted && report.Status == summaryStatusCompleted {
+			// e.g. interrupted by the user before all tables are done.
+			report.Status = summaryStatusFailed
+		}
+	}
+	sort.Slice(report.Tables, func(i, j int) bool {
+		return report.Tables[i].Table < report.Tables[j].Table
+	})
+	return report
+}
+
+func (r *importSummaryReport) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	return errors.Trace(encoder.Encode(r))
+}
+
+func (r *importSummaryReport) writeText(w io.Writer) error {
+	fmt.Fprintf(w, "Status:     %s\n", r.Status)
+	fmt.Fprintf(w, "Start time: %s\n", r.Start.Format(time.RFC3339))
+	fmt.Fprintf(w, "Duration:   %s\n", r.duration.Round(time.Millisecond))
+	if len(r.Error) > 0 {
+		fmt.Fprintf(w, "Error:      %s\n", r.Error)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tORDER\tSTATUS\tBACKEND\tROWS\tBYTES\tDURATION\tCHECKSUM\tERROR")
+	for _, ts := range r.Tables {
+		order := "-"
+		if ts.Order > 0 {
+			order = strconv.Itoa(ts.Order)
+		}
+		backend := ts.Backend
+		if len(backend) == 0 {
+			backend = "-"
+		}
+		checksum := ts.Checksum
+		if len(checksum) == 0 {
+			checksum = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
+			ts.Table, order, ts.Status, backend, ts.Rows, ts.Bytes, ts.Duration.Round(time.Millisecond), checksum, ts.Error)
+	}
+	return errors.Trace(tw.Flush())
+}
+
+// writeFile writes the report into the path. The file is replaced atomically,
+// so a reader never sees a partially written report.
+func (r *importSummaryReport) writeFile(path string, format string) error {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if format == config.SummaryFormatText {
+		err = r.writeText(file)
+	} else {
+		err = r.writeJSON(file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = errors.Trace(closeErr)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return errors.Trace(os.Rename(tmpPath, path))
+}
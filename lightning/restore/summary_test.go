@@ -0,0 +1,188 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/model"
+
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/metric"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&summarySuite{})
+
+type summarySuite struct{}
+
+func (s *summarySuite) newSummary() *importSummary {
+	return newImportSummary([]*mydump.MDDatabaseMeta{
+		{
+			Name: "db",
+			Tables: []*mydump.MDTableMeta{
+				{DB: "db", Name: "t2"},
+				{DB: "db", Name: "t1"},
+				{DB: "db", Name: "t3"},
+			},
+		},
+	})
+}
+
+func (s *summarySuite) tableCheckpoint(restored int64) *TableCheckpoint {
+	return &TableCheckpoint{
+		Engines: map[int32]*EngineCheckpoint{
+			0: {Chunks: []*ChunkCheckpoint{
+				{Key: ChunkCheckpointKey{Path: "db.t.1.sql"}, Chunk: mydump.Chunk{Offset: restored, EndOffset: 1000}},
+				{Key: ChunkCheckpointKey{Path: "db.t.2.sql", Offset: 1000}, Chunk: mydump.Chunk{Offset: 1000 + restored, EndOffset: 2000}},
+			}},
+		},
+	}
+}
+
+func (s *summarySuite) TestReport(c *C) {
+	summary := s.newSummary()
+
+	summary.startTable("`db`.`t1`")
+	summary.setBackend("`db`.`t1`", config.BackendTiDB)
+	summary.addRows("`db`.`t1`", 10)
+	summary.addRows("`db`.`t1`", 5)
+	summary.addPhaseDurations("`db`.`t1`", time.Second, 2*time.Second, 3*time.Second)
+	summary.addPhaseDurations("`db`.`t1`", time.Second, time.Second, time.Second)
+	summary.setChecksum("`db`.`t1`", checksumPassed)
+	summary.endTable("`db`.`t1`", s.tableCheckpoint(1000), nil)
+
+	summary.startTable("`db`.`t2`")
+	summary.addRows("`db`.`t2`", 3)
+	summary.setChecksum("`db`.`t2`", checksumFailed)
+	summary.endTable("`db`.`t2`", s.tableCheckpoint(400), errors.New("checksum mismatched"))
+
+	report := summary.report(errors.New("checksum mismatched"))
+	c.Assert(report.Status, Equals, summaryStatusFailed)
+	c.Assert(report.Error, Equals, "checksum mismatched")
+	c.Assert(report.Tables, HasLen, 3)
+
+	t1, t2, t3 := report.Tables[0], report.Tables[1], report.Tables[2]
+	c.Assert(t1.Table, Equals, "`db`.`t1`")
+	c.Assert(t1.Status, Equals, summaryStatusCompleted)
+	c.Assert(t1.Backend, Equals, config.BackendTiDB)
+	c.Assert(t1.Rows, Equals, int64(15))
+	c.Assert(t1.Bytes, Equals, int64(2000))
+	c.Assert(t1.Checksum, Equals, checksumPassed)
+	c.Assert(t1.PhaseSeconds, DeepEquals, map[string]float64{
+		metric.ChunkPhaseParse:  2,
+		metric.ChunkPhaseEncode: 3,
+		metric.ChunkPhaseIngest: 4,
+	})
+
+	c.Assert(t2.Table, Equals, "`db`.`t2`")
+	c.Assert(t2.Status, Equals, summaryStatusFailed)
+	c.Assert(t2.Rows, Equals, int64(3))
+	c.Assert(t2.Bytes, Equals, int64(800))
+	c.Assert(t2.Checksum, Equals, checksumFailed)
+	c.Assert(t2.Error, Equals, "checksum mismatched")
+	c.Assert(t2.PhaseSeconds, IsNil)
+
+	c.Assert(t3.Table, Equals, "`db`.`t3`")
+	c.Assert(t3.Status, Equals, summaryStatusPending)
+
+	// an interrupted task is not reported as completed even without error.
+	c.Assert(summary.report(nil).Status, Equals, summaryStatusFailed)
+
+	// a nil summary records nothing
+	var nilSummary *importSummary
+	nilSummary.startTable("`db`.`t1`")
+	nilSummary.addRows("`db`.`t1`", 1)
+	nilSummary.addPhaseDurations("`db`.`t1`", time.Second, time.Second, time.Second)
+}
+
+func (s *summarySuite) TestKeyRanges(c *C) {
+	c.Assert(tableKeyRanges(&model.TableInfo{ID: 42}), DeepEquals, []keyRange{
+		{StartKey: "74800000000000002a", EndKey: "74800000000000002b"},
+	})
+
+	partitioned := &model.TableInfo{
+		ID: 42,
+		Partition: &model.PartitionInfo{
+			Enable:      true,
+			Definitions: []model.PartitionDefinition{{ID: 43}, {ID: 45}},
+		},
+	}
+	c.Assert(tableKeyRanges(partitioned), DeepEquals, []keyRange{
+		{StartKey: "74800000000000002b", EndKey: "74800000000000002c"},
+		{StartKey: "74800000000000002d", EndKey: "74800000000000002e"},
+	})
+
+	summary := s.newSummary()
+	summary.setKeyRanges("`db`.`t1`", tableKeyRanges(&model.TableInfo{ID: 42}))
+	var sb strings.Builder
+	c.Assert(summary.report(nil).writeJSON(&sb), IsNil)
+	var decoded struct {
+		Tables []struct {
+			KeyRanges []map[string]string `json:"key-ranges"`
+		} `json:"tables"`
+	}
+	c.Assert(json.Unmarshal([]byte(sb.String()), &decoded), IsNil)
+	c.Assert(decoded.Tables[0].KeyRanges, DeepEquals, []map[string]string{
+		{"start-key": "74800000000000002a", "end-key": "74800000000000002b"},
+	})
+	c.Assert(decoded.Tables[1].KeyRanges, IsNil)
+}
+
+func (s *summarySuite) TestWriteFile(c *C) {
+	summary := s.newSummary()
+	for i, name := range []string{"`db`.`t1`", "`db`.`t2`", "`db`.`t3`"} {
+		summary.setOrder(name, i+1)
+		summary.startTable(name)
+		summary.addRows(name, 7)
+		summary.endTable(name, s.tableCheckpoint(1000), nil)
+	}
+	report := summary.report(nil)
+	c.Assert(report.Status, Equals, summaryStatusCompleted)
+
+	path := filepath.Join(c.MkDir(), "summary")
+	c.Assert(report.writeFile(path, config.SummaryFormatJSON), IsNil)
+	content, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	var parsed struct {
+		Status string `json:"status"`
+		Tables []struct {
+			Table string `json:"table"`
+			Rows  int64  `json:"rows"`
+			Bytes int64  `json:"bytes"`
+		} `json:"tables"`
+	}
+	c.Assert(json.Unmarshal(content, &parsed), IsNil)
+	c.Assert(parsed.Status, Equals, summaryStatusCompleted)
+	c.Assert(parsed.Tables, HasLen, 3)
+	c.Assert(parsed.Tables[0].Table, Equals, "`db`.`t1`")
+	c.Assert(parsed.Tables[0].Rows, Equals, int64(7))
+	c.Assert(parsed.Tables[0].Bytes, Equals, int64(2000))
+
+	c.Assert(report.writeFile(path, config.SummaryFormatText), IsNil)
+	content, err = ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	c.Assert(lines[0], Equals, "Status:     completed")
+	c.Assert(lines[4], Matches, "TABLE +ORDER +STATUS +BACKEND +ROWS +BYTES +DURATION +CHECKSUM +ERROR")
+	c.Assert(lines[5], Matches, "`db`.`t1` +1 +completed +- +7 +2000 +[0-9.]+m?s +- *")
+	c.Assert(lines, HasLen, 8)
+}
@@ -14,8 +14,10 @@
 package restore
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -44,7 +46,7 @@ type TiDBManager struct {
 }
 
 func NewTiDBManager(dsn config.DBStore) (*TiDBManager, error) {
-	db, err := common.ConnectDB(dsn.Host, dsn.Port, dsn.User, dsn.Psw, dsn.StrSQLMode, dsn.MaxAllowedPacket)
+	db, err := common.ConnectDB(dsn.Host, dsn.Port, dsn.User, dsn.Psw, dsn.StrSQLMode, dsn.MaxAllowedPacket, dsn.TimeZone)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -75,7 +77,13 @@ func (timgr *TiDBManager) Close() {
 	timgr.db.Close()
 }
 
-func (timgr *TiDBManager) InitSchema(ctx context.Context, database string, tablesSchema map[string]string) error {
+// InitSchema creates the database and its tables if they do not exist yet. If
+// `charset` is not empty, the database is created with the charset and the
+// collation, which must have been validated. If the schema of a table cannot
+// be parsed or is rejected by TiDB, and `onTableError` is not nil, it decides
+// whether to continue with the other tables by returning nil, or to stop with
+// an error. The other failures, e.g. since TiDB is unreachable, always stop.
+func (timgr *TiDBManager) InitSchema(ctx context.Context, database string, charset string, collation string, tablesSchema map[string]string, onTableError func(table string, err error) error) error {
 	sql := common.SQLWithRetry{
 		DB:     timgr.db,
 		Logger: log.With(zap.String("db", database)),
@@ -84,6 +92,9 @@ func (timgr *TiDBManager) InitSchema(ctx context.Context, database string, table
 	var createDatabase strings.Builder
 	createDatabase.WriteString("CREATE DATABASE IF NOT EXISTS ")
 	common.WriteMySQLIdentifier(&createDatabase, database)
+	if len(charset) > 0 {
+		fmt.Fprintf(&createDatabase, " CHARACTER SET %s COLLATE %s", charset, collation)
+	}
 	err := sql.Exec(ctx, "create database", createDatabase.String())
 	if err != nil {
 		return errors.Trace(err)
@@ -101,15 +112,20 @@ func (timgr *TiDBManager) InitSchema(ctx context.Context, database string, table
 		task.Debug("create table", zap.String("schema", sqlCreateTable))
 
 		sqlCreateTable, err = timgr.createTableIfNotExistsStmt(sqlCreateTable, tbl)
-		if err != nil {
-			break
+		if err == nil {
+			sql2 := common.SQLWithRetry{
+				DB:           timgr.db,
+				Logger:       sql.Logger.With(zap.String("table", common.UniqueTable(database, tbl))),
+				HideQueryLog: true,
+			}
+			err = sql2.Exec(ctx, "create table", sqlCreateTable)
+			if common.IsRetryableError(err) {
+				break
+			}
 		}
-		sql2 := common.SQLWithRetry{
-			DB:           timgr.db,
-			Logger:       sql.Logger.With(zap.String("table", common.UniqueTable(database, tbl))),
-			HideQueryLog: true,
+		if err != nil && onTableError != nil {
+			err = onTableError(tbl, err)
 		}
-		err = sql2.Exec(ctx, "create table", sqlCreateTable)
 		if err != nil {
 			break
 		}
@@ -119,6 +135,48 @@ func (timgr *TiDBManager) InitSchema(ctx context.Context, database string, table
 	return errors.Trace(err)
 }
 
+// listDatabases returns whether a database exists in the target cluster. The
+// names are compared case-insensitively unless `lower_case_table_names` is 0.
+func (timgr *TiDBManager) listDatabases(ctx context.Context) (func(name string) bool, error) {
+	var caseSensitive bool
+	databases := make(map[string]struct{})
+	err := common.SQLWithRetry{DB: timgr.db, Logger: log.L()}.
+		Transact(ctx, "list databases", func(c context.Context, tx *sql.Tx) error {
+			var lowerCaseTableNames int
+			if err := tx.QueryRowContext(c, "SELECT @@lower_case_table_names").Scan(&lowerCaseTableNames); err != nil {
+				return errors.Trace(err)
+			}
+			caseSensitive = lowerCaseTableNames == 0
+
+			rows, err := tx.QueryContext(c, "SHOW DATABASES")
+			if err != nil {
+				return errors.Trace(err)
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var name string
+				if err := rows.Scan(&name); err != nil {
+					return errors.Trace(err)
+				}
+				if !caseSensitive {
+					name = strings.ToLower(name)
+				}
+				databases[name] = struct{}{}
+			}
+			return errors.Trace(rows.Err())
+		})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return func(name string) bool {
+		if !caseSensitive {
+			name = strings.ToLower(name)
+		}
+		_, ok := databases[name]
+		return ok
+	}, nil
+}
+
 func (timgr *TiDBManager) createTableIfNotExistsStmt(createTable, tblName string) (string, error) {
 	stmts, _, err := timgr.parser.Parse(createTable, "", "")
 	if err != nil {
@@ -156,6 +214,91 @@ func (timgr *TiDBManager) getTables(schema string) ([]*model.TableInfo, error) {
 	return tables, nil
 }
 
+// tableRegions is the reply of the TiDB status API listing the regions of a
+// table.
+type tableRegions struct {
+	RecordRegions []regionMeta `json:"record_regions"`
+	Indices       []struct {
+		Regions []regionMeta `json:"regions"`
+	} `json:"indices"`
+}
+
+type regionMeta struct {
+	ID     uint64 `json:"region_id"`
+	Leader *struct {
+		StoreID uint64 `json:"store_id"`
+	} `json:"leader"`
+}
+
+func (timgr *TiDBManager) getTableRegions(schema string, table string) ([]regionMeta, error) {
+	baseURL := *timgr.baseURL
+	baseURL.Path = fmt.Sprintf("tables/%s/%s/regions", schema, table)
+
+	var reply json.RawMessage
+	err := common.GetJSON(timgr.client, baseURL.String(), &reply)
+	if err != nil {
+		return nil, errors.Annotatef(err, "get regions of table %s", common.UniqueTable(schema, table))
+	}
+
+	// a partitioned table is replied with the regions of every partition.
+	var partitions []tableRegions
+	if bytes.HasPrefix(bytes.TrimSpace(reply), []byte("[")) {
+		err = json.Unmarshal(reply, &partitions)
+	} else {
+		partitions = make([]tableRegions, 1)
+		err = json.Unmarshal(reply, &partitions[0])
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "get regions of table %s", common.UniqueTable(schema, table))
+	}
+
+	var all []regionMeta
+	for _, regions := range partitions {
+		all = append(all, regions.RecordRegions...)
+		for _, index := range regions.Indices {
+			all = append(all, index.Regions...)
+		}
+	}
+	return all, nil
+}
+
+// getTableLeaderStores returns the ID of the stores holding the leaders of the
+// regions of the table, from both the row data and the indexes.
+func (timgr *TiDBManager) getTableLeaderStores(schema string, table string) ([]uint64, error) {
+	regions, err := timgr.getTableRegions(schema, table)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uint64]struct{})
+	var stores []uint64
+	for _, region := range regions {
+		if region.Leader == nil {
+			continue
+		}
+		if _, ok := seen[region.Leader.StoreID]; !ok {
+			seen[region.Leader.StoreID] = struct{}{}
+			stores = append(stores, region.Leader.StoreID)
+		}
+	}
+	return stores, nil
+}
+
+// countTableRegions returns the number of regions of the table. A region
+// holding both the row data and some indexes is counted once.
+func (timgr *TiDBManager) countTableRegions(schema string, table string) (int, error) {
+	regions, err := timgr.getTableRegions(schema, table)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[uint64]struct{}, len(regions))
+	for _, region := range regions {
+		seen[region.ID] = struct{}{}
+	}
+	return len(seen), nil
+}
+
 func (timgr *TiDBManager) DropTable(ctx context.Context, tableName string) error {
 	sql := common.SQLWithRetry{
 		DB:     timgr.db,
@@ -28,6 +28,8 @@ import (
 	"github.com/pingcap/parser/model"
 	tmysql "github.com/pingcap/parser/mysql"
 	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
 	"github.com/pingcap/tidb-lightning/lightning/mydump"
 	"github.com/pingcap/tidb/ddl"
 	"github.com/pingcap/tidb/util/mock"
@@ -147,6 +149,30 @@ func (s *tidbSuite) TestCreateTableIfNotExistsStmt(c *C) {
 		Equals,
 		"SET NAMES 'binary';SET @@SESSION.`FOREIGN_KEY_CHECKS`=0;CREATE TABLE IF NOT EXISTS `m` (`z` DOUBLE) ENGINE = InnoDB AUTO_INCREMENT = 8343230 DEFAULT CHARACTER SET = UTF8;",
 	)
+
+	// column attributes and storage options are kept
+	restored := createTableIfNotExistsStmt(`
+		CREATE TABLE t (
+			a INT NOT NULL DEFAULT 1 COMMENT 'x',
+			b VARCHAR(10) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin,
+			c TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			d INT AS (a + 1) VIRTUAL,
+			e INT GENERATED ALWAYS AS (a * 2) STORED,
+			f INT AUTO_INCREMENT UNIQUE KEY,
+			g ENUM('x', 'y') DEFAULT 'y'
+		) COMPRESSION='zlib' ROW_FORMAT=COMPRESSED;
+	`, "t")
+	c.Assert(restored, Equals, "CREATE TABLE IF NOT EXISTS `t` ("+
+		"`a` INT NOT NULL DEFAULT 1 COMMENT 'x',"+
+		"`b` VARCHAR(10) CHARACTER SET UTF8MB4 COLLATE utf8mb4_bin,"+
+		"`c` TIMESTAMP DEFAULT CURRENT_TIMESTAMP() ON UPDATE CURRENT_TIMESTAMP(),"+
+		"`d` INT GENERATED ALWAYS AS(`a`+1) VIRTUAL,"+
+		"`e` INT GENERATED ALWAYS AS(`a`*2) STORED,"+
+		"`f` INT AUTO_INCREMENT UNIQUE KEY,"+
+		"`g` ENUM('x','y') DEFAULT 'y'"+
+		") COMPRESSION = 'zlib' ROW_FORMAT = COMPRESSED;")
+	// restoring the statement again changes nothing
+	c.Assert(createTableIfNotExistsStmt(restored, "t"), Equals, restored)
 }
 
 func (s *tidbSuite) TestInitSchema(c *C) {
@@ -168,14 +194,35 @@ func (s *tidbSuite) TestInitSchema(c *C) {
 		ExpectClose()
 
 	s.mockDB.MatchExpectationsInOrder(false) // maps are unordered.
-	err := s.timgr.InitSchema(ctx, "db", map[string]string{
+	err := s.timgr.InitSchema(ctx, "db", "", "", map[string]string{
 		"t1": "create table t1 (a int primary key, b varchar(200));",
 		"t2": "/*!40014 SET FOREIGN_KEY_CHECKS=0*/;CREATE TABLE `db`.`t2` (xx TEXT) AUTO_INCREMENT=11203;",
-	})
+	}, nil)
 	s.mockDB.MatchExpectationsInOrder(true)
 	c.Assert(err, IsNil)
 }
 
+func (s *tidbSuite) TestInitSchemaWithCharset(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.
+		ExpectExec("\\QCREATE DATABASE IF NOT EXISTS `db` CHARACTER SET latin1 COLLATE latin1_bin\\E").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.
+		ExpectExec("USE `db`").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mockDB.
+		ExpectExec("\\QCREATE TABLE IF NOT EXISTS `t1` (`a` INT PRIMARY KEY);\\E").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	s.mockDB.
+		ExpectClose()
+
+	err := s.timgr.InitSchema(ctx, "db", "latin1", "latin1_bin", map[string]string{
+		"t1": "create table t1 (a int primary key);",
+	}, nil)
+	c.Assert(err, IsNil)
+}
+
 func (s *tidbSuite) TestInitSchemaSyntaxError(c *C) {
 	ctx := context.Background()
 
@@ -188,9 +235,9 @@ func (s *tidbSuite) TestInitSchemaSyntaxError(c *C) {
 	s.mockDB.
 		ExpectClose()
 
-	err := s.timgr.InitSchema(ctx, "db", map[string]string{
+	err := s.timgr.InitSchema(ctx, "db", "", "", map[string]string{
 		"t1": "create table `t1` with invalid syntax;",
-	})
+	}, nil)
 	c.Assert(err, NotNil)
 }
 
@@ -212,12 +259,69 @@ func (s *tidbSuite) TestInitSchemaUnsupportedSchemaError(c *C) {
 	s.mockDB.
 		ExpectClose()
 
-	err := s.timgr.InitSchema(ctx, "db", map[string]string{
+	err := s.timgr.InitSchema(ctx, "db", "", "", map[string]string{
 		"t1": "create table `t1` (a VARCHAR(999999999));",
-	})
+	}, nil)
 	c.Assert(err, ErrorMatches, ".*Column length too big.*")
 }
 
+func (s *tidbSuite) TestInitSchemaTableErrorTolerated(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.
+		ExpectExec("CREATE DATABASE IF NOT EXISTS `db`").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.
+		ExpectExec("USE `db`").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mockDB.
+		ExpectExec("\\QCREATE TABLE IF NOT EXISTS `t2` (`a` INT);\\E").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	s.mockDB.
+		ExpectClose()
+
+	var failedTables []string
+	s.mockDB.MatchExpectationsInOrder(false) // maps are unordered.
+	err := s.timgr.InitSchema(ctx, "db", "", "", map[string]string{
+		"t1": "create table `t1` with invalid syntax;",
+		"t2": "create table `t2` (a int);",
+	}, func(table string, err error) error {
+		failedTables = append(failedTables, table)
+		return nil
+	})
+	s.mockDB.MatchExpectationsInOrder(true)
+	c.Assert(err, IsNil)
+	c.Assert(failedTables, DeepEquals, []string{"t1"})
+}
+
+func (s *tidbSuite) TestInitSchemaRetryableErrorNotTolerated(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.
+		ExpectExec("CREATE DATABASE IF NOT EXISTS `db`").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.
+		ExpectExec("USE `db`").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	busy := &mysql.MySQLError{Number: tmysql.ErrTiKVServerBusy, Message: "server is busy"}
+	for i := 0; i < 3; i++ {
+		s.mockDB.
+			ExpectExec("CREATE TABLE IF NOT EXISTS `t1`.*").
+			WillReturnError(busy)
+	}
+	s.mockDB.
+		ExpectClose()
+
+	// the table failing since TiKV is busy is not up to onTableError.
+	err := s.timgr.InitSchema(ctx, "db", "", "", map[string]string{
+		"t1": "create table `t1` (a int);",
+	}, func(table string, err error) error {
+		c.Errorf("unexpected table error: %v", err)
+		return nil
+	})
+	c.Assert(err, ErrorMatches, ".*server is busy.*")
+}
+
 func (s *tidbSuite) TestDropTable(c *C) {
 	ctx := context.Background()
 
@@ -281,6 +385,91 @@ func (s *tidbSuite) TestLoadSchemaInfo(c *C) {
 	})
 }
 
+func (s *tidbSuite) TestGetTableLeaderStores(c *C) {
+	s.handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Assert(req.URL.Path, Equals, "/tables/db/t/regions")
+		w.Write([]byte(`{
+			"name": "t",
+			"id": 100,
+			"record_regions": [
+				{"region_id": 2, "leader": {"id": 3, "store_id": 1}, "peers": [{"id": 3, "store_id": 1}, {"id": 4, "store_id": 2}]},
+				{"region_id": 5, "leader": {"id": 6, "store_id": 2}, "peers": [{"id": 6, "store_id": 2}]}
+			],
+			"indices": [
+				{"name": "b", "id": 1, "regions": [{"region_id": 7, "leader": {"id": 8, "store_id": 5}}]},
+				{"name": "c", "id": 2, "regions": [{"region_id": 2, "leader": {"id": 3, "store_id": 1}}]}
+			]
+		}`))
+	})
+
+	stores, err := s.timgr.getTableLeaderStores("db", "t")
+	c.Assert(err, IsNil)
+	c.Assert(stores, DeepEquals, []uint64{1, 2, 5})
+
+	// region 2 holds both rows and the index c.
+	count, err := s.timgr.countTableRegions("db", "t")
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 3)
+}
+
+func (s *tidbSuite) TestGetPartitionedTableRegions(c *C) {
+	s.handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Assert(req.URL.Path, Equals, "/tables/db/t/regions")
+		w.Write([]byte(`[
+			{"name": "p0", "id": 101, "record_regions": [{"region_id": 2, "leader": {"id": 3, "store_id": 1}}], "indices": []},
+			{"name": "p1", "id": 102, "record_regions": [{"region_id": 5, "leader": {"id": 6, "store_id": 2}}], "indices": [
+				{"name": "b", "id": 1, "regions": [{"region_id": 7, "leader": {"id": 8, "store_id": 5}}]}
+			]}
+		]`))
+	})
+
+	regions, err := s.timgr.getTableRegions("db", "t")
+	c.Assert(err, IsNil)
+	ids := make([]uint64, 0, len(regions))
+	for _, region := range regions {
+		ids = append(ids, region.ID)
+	}
+	c.Assert(ids, DeepEquals, []uint64{2, 5, 7})
+}
+
+func (s *tidbSuite) TestScatterRegions(c *C) {
+	s.handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{
+			"record_regions": [{"region_id": 2}, {"region_id": 5}],
+			"indices": [{"name": "b", "id": 1, "regions": [{"region_id": 2}, {"region_id": 7}]}]
+		}`))
+	})
+	var scattered []uint64
+	pdServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Assert(req.URL.Path, Equals, "/pd/api/v1/operators")
+		var input struct {
+			Name     string `json:"name"`
+			RegionID uint64 `json:"region_id"`
+		}
+		c.Assert(json.NewDecoder(req.Body).Decode(&input), IsNil)
+		c.Assert(input.Name, Equals, "scatter-region")
+		scattered = append(scattered, input.RegionID)
+		if input.RegionID == 5 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer pdServer.Close()
+
+	cfg := config.NewConfig()
+	cfg.TiDB.PdURL = pdServer.URL
+	rc := &RestoreController{cfg: cfg, tidbMgr: s.timgr}
+	tr := &TableRestore{
+		dbInfo:    &checkpoints.TidbDBInfo{Name: "db"},
+		tableInfo: &checkpoints.TidbTableInfo{Name: "t"},
+		logger:    log.L(),
+	}
+
+	// every region is scattered once with a one-off operator, even if some
+	// of them failed.
+	tr.scatterRegions(context.Background(), rc)
+	c.Assert(scattered, DeepEquals, []uint64{2, 5, 7})
+}
+
 func (s *tidbSuite) TestLoadSchemaInfoMissing(c *C) {
 	ctx := context.Background()
 
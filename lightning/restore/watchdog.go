@@ -0,0 +1,147 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// stallWatchdog tracks the progress of the import and the step every worker
+// is running, so that an import making no progress can be diagnosed from the
+// log. It only reports the stall and never interrupts the import. A nil
+// watchdog tracks nothing.
+type stallWatchdog struct {
+	// the UnixNano time of the last progress, accessed atomically.
+	lastProgress int64
+
+	logger log.Logger
+
+	mu     sync.Mutex
+	nextID uint64
+	steps  map[uint64]*workerStep
+}
+
+// workerStep is the step being run by a worker.
+type workerStep struct {
+	table  string
+	engine int32
+	step   string
+	// the server the step is waiting on, e.g. "tikv-importer 127.0.0.1:8287".
+	store string
+	start time.Time
+	// the time the step is reported at, only used for logging.
+	now time.Time
+}
+
+// MarshalLogObject implements the zapcore.ObjectMarshaler interface.
+func (s *workerStep) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
+	encoder.AddString("table", s.table)
+	encoder.AddInt32("engine", s.engine)
+	encoder.AddString("step", s.step)
+	encoder.AddString("store", s.store)
+	encoder.AddDuration("running", s.now.Sub(s.start).Round(time.Second))
+	return nil
+}
+
+type workerSteps []workerStep
+
+// MarshalLogArray implements the zapcore.ArrayMarshaler interface.
+func (steps workerSteps) MarshalLogArray(encoder zapcore.ArrayEncoder) error {
+	for i := range steps {
+		if err := encoder.AppendObject(&steps[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newStallWatchdog() *stallWatchdog {
+	return &stallWatchdog{
+		lastProgress: time.Now().UnixNano(),
+		logger:       log.L(),
+		steps:        make(map[uint64]*workerStep),
+	}
+}
+
+// progress records that some data has been written.
+func (w *stallWatchdog) progress() {
+	if w == nil {
+		return
+	}
+	atomic.StoreInt64(&w.lastProgress, time.Now().UnixNano())
+}
+
+// begin registers a step run by the current worker, waiting on `store`. The
+// returned function must be called when the step ends, which also counts as
+// progress.
+func (w *stallWatchdog) begin(table string, engine int32, step string, store string) (end func()) {
+	if w == nil {
+		return func() {}
+	}
+	w.mu.Lock()
+	id := w.nextID
+	w.nextID++
+	w.steps[id] = &workerStep{table: table, engine: engine, step: step, store: store, start: time.Now()}
+	w.mu.Unlock()
+
+	return func() {
+		w.mu.Lock()
+		delete(w.steps, id)
+		w.mu.Unlock()
+		w.progress()
+	}
+}
+
+// check warns with the steps of all workers if there has been no progress
+// for at least `timeout` before `now`. Returns whether the import is stalled.
+// A paused import is not stalled, and the idle time restarts after resuming.
+func (w *stallWatchdog) check(now time.Time, timeout time.Duration, paused bool) bool {
+	if w == nil {
+		return false
+	}
+	if paused {
+		atomic.StoreInt64(&w.lastProgress, now.UnixNano())
+		return false
+	}
+	idle := now.Sub(time.Unix(0, atomic.LoadInt64(&w.lastProgress)))
+	if idle < timeout {
+		return false
+	}
+
+	w.mu.Lock()
+	steps := make(workerSteps, 0, len(w.steps))
+	for _, step := range w.steps {
+		step := *step
+		step.now = now
+		steps = append(steps, step)
+	}
+	w.mu.Unlock()
+	// the longest running steps are the most likely to be stuck.
+	sort.Slice(steps, func(i, j int) bool { return steps[i].start.Before(steps[j].start) })
+
+	w.logger.Warn("no progress for a long time, the import may be stuck",
+		zap.Duration("idle", idle.Round(time.Second)),
+		zap.Int("runningSteps", len(steps)),
+		zap.Array("steps", steps),
+	)
+	return true
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"time"
+
+	. "github.com/pingcap/check"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+var _ = Suite(&watchdogSuite{})
+
+type watchdogSuite struct{}
+
+func (s *watchdogSuite) TestCheckStall(c *C) {
+	w := newStallWatchdog()
+	var buffer *zaptest.Buffer
+	w.logger, buffer = log.MakeTestLogger()
+	start := time.Now()
+	c.Assert(w.check(start, time.Minute, false), IsFalse)
+
+	endChunk := w.begin("`db`.`t`", 0, "restore chunk db.t.1.csv:0", "tidb 127.0.0.1:4000")
+	endImport := w.begin("`db`.`t`", -1, "import engine", "tikv-importer 127.0.0.1:8287")
+	c.Assert(w.steps, HasLen, 2)
+	c.Assert(w.check(start.Add(2*time.Minute), time.Minute, false), IsTrue)
+
+	// the steps are reported in a single warning, the longest running first.
+	c.Assert(w.logger.Sync(), IsNil)
+	lines := buffer.Lines()
+	c.Assert(lines, HasLen, 1)
+	c.Assert(lines[0], Matches, `\{"\$lvl":"WARN","\$msg":"no progress for a long time, the import may be stuck","idle":"2m0s","runningSteps":2,"steps":\[`+
+		`\{"table":"\x60db\x60.\x60t\x60","engine":0,"step":"restore chunk db.t.1.csv:0","store":"tidb 127.0.0.1:4000","running":"2m0s"\},`+
+		`\{"table":"\x60db\x60.\x60t\x60","engine":-1,"step":"import engine","store":"tikv-importer 127.0.0.1:8287","running":"2m0s"\}\]\}`)
+
+	// ending a step counts as progress.
+	endChunk()
+	c.Assert(w.steps, HasLen, 1)
+	c.Assert(w.check(time.Now().Add(30*time.Second), time.Minute, false), IsFalse)
+	c.Assert(w.check(time.Now().Add(2*time.Minute), time.Minute, false), IsTrue)
+
+	w.progress()
+	c.Assert(w.check(time.Now().Add(30*time.Second), time.Minute, false), IsFalse)
+
+	// a paused import is not stalled, and the idle time restarts from the
+	// last check while paused.
+	paused := time.Now().Add(2 * time.Minute)
+	c.Assert(w.check(paused, time.Minute, true), IsFalse)
+	c.Assert(w.check(paused.Add(30*time.Second), time.Minute, false), IsFalse)
+	c.Assert(w.check(paused.Add(2*time.Minute), time.Minute, false), IsTrue)
+	endImport()
+	c.Assert(w.steps, HasLen, 0)
+
+	// a nil watchdog tracks nothing.
+	var nilWatchdog *stallWatchdog
+	nilWatchdog.progress()
+	nilWatchdog.begin("`db`.`t`", 0, "checksum", "tidb 127.0.0.1:4000")()
+	c.Assert(nilWatchdog.check(time.Now(), 0, false), IsFalse)
+}
@@ -0,0 +1,34 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import "sync/atomic"
+
+// ready records whether Lightning has finished starting up the current task,
+// reported by the /readyz endpoint.
+var ready int32
+
+// BroadcastReady marks Lightning as ready (or not ready).
+func BroadcastReady(isReady bool) {
+	var value int32
+	if isReady {
+		value = 1
+	}
+	atomic.StoreInt32(&ready, value)
+}
+
+// IsReady returns whether Lightning is ready.
+func IsReady() bool {
+	return atomic.LoadInt32(&ready) != 0
+}
@@ -62,7 +62,8 @@ func (cpm *checkpointsMap) update(diffs map[string]*checkpoints.TableCheckpointD
 		tw := int64(0)
 		for _, engine := range cp.Engines {
 			for _, chunk := range engine.Chunks {
-				if engine.Status >= checkpoints.CheckpointStatusAllWritten {
+				// the end of stdin is unknown, so only the consumed part counts.
+				if engine.Status >= checkpoints.CheckpointStatusAllWritten && chunk.Chunk.EndOffset != mydump.UnknownSourceFileSize {
 					tw += chunk.Chunk.EndOffset - chunk.Key.Offset
 				} else {
 					tw += chunk.Chunk.Offset - chunk.Key.Offset
@@ -114,6 +115,8 @@ var currentProgress = taskProgress{
 }
 
 func BroadcastStartTask() {
+	BroadcastReady(false)
+
 	currentProgress.mu.Lock()
 	currentProgress.Status = taskStatusRunning
 	currentProgress.mu.Unlock()